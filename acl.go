@@ -0,0 +1,153 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrAccessDenied is returned (wrapped) by Bot.CanRun when a user may not run a command.
+var ErrAccessDenied = errors.New("matrix: access denied")
+
+// Access restricts where and by whom a command may be run. Deny lists take
+// precedence over allow lists; empty allow lists allow everyone, and users
+// listed in AllowUsers or on a server in AllowServers are allowed. Set it with
+// the chainable Command methods (RequireAdmin, AllowRooms, ...) or, for
+// operators, in Config.CommandAccess.
+type Access struct {
+	AllowUsers    []id.UserID `yaml:"allow_users,omitempty" toml:"allow_users"`
+	DenyUsers     []id.UserID `yaml:"deny_users,omitempty" toml:"deny_users"`
+	AllowServers  []string    `yaml:"allow_servers,omitempty" toml:"allow_servers"` // Homeservers of users, e.g. "example.com"
+	DenyServers   []string    `yaml:"deny_servers,omitempty" toml:"deny_servers"`
+	AllowRooms    []id.RoomID `yaml:"allow_rooms,omitempty" toml:"allow_rooms"`
+	DenyRooms     []id.RoomID `yaml:"deny_rooms,omitempty" toml:"deny_rooms"`
+	MinPowerLevel int         `yaml:"min_power_level,omitempty" toml:"min_power_level"` // In the room (0 = everyone)
+	AdminOnly     bool        `yaml:"admin_only,omitempty" toml:"admin_only"`           // Only Config.Admins
+}
+
+// allowsRoom reports whether the command is available in a room at all.
+func (a Access) allowsRoom(roomID id.RoomID) bool {
+	return !slices.Contains(a.DenyRooms, roomID) &&
+		(len(a.AllowRooms) == 0 || slices.Contains(a.AllowRooms, roomID))
+}
+
+// RequireAdmin limits the command to the bot operators in Config.Admins.
+func (c *Command) RequireAdmin() *Command {
+	c.Access.AdminOnly = true
+	return c
+}
+
+// RequirePowerLevel limits the command to users with at least this power
+// level in the room, e.g. 50 for moderators.
+func (c *Command) RequirePowerLevel(level int) *Command {
+	c.Access.MinPowerLevel = level
+	return c
+}
+
+// AllowUsers limits the command to the given users.
+func (c *Command) AllowUsers(userIDs ...id.UserID) *Command {
+	c.Access.AllowUsers = append(c.Access.AllowUsers, userIDs...)
+	return c
+}
+
+// DenyUsers keeps the given users from running the command.
+func (c *Command) DenyUsers(userIDs ...id.UserID) *Command {
+	c.Access.DenyUsers = append(c.Access.DenyUsers, userIDs...)
+	return c
+}
+
+// AllowServers limits the command to users of the given homeservers, e.g. "example.com".
+func (c *Command) AllowServers(servers ...string) *Command {
+	c.Access.AllowServers = append(c.Access.AllowServers, servers...)
+	return c
+}
+
+// DenyServers keeps users of the given homeservers from running the command.
+func (c *Command) DenyServers(servers ...string) *Command {
+	c.Access.DenyServers = append(c.Access.DenyServers, servers...)
+	return c
+}
+
+// AllowRooms makes the command available in the given rooms only. Elsewhere
+// it is ignored, like an unknown command.
+func (c *Command) AllowRooms(roomIDs ...id.RoomID) *Command {
+	c.Access.AllowRooms = append(c.Access.AllowRooms, roomIDs...)
+	return c
+}
+
+// DenyRooms makes the command unavailable in the given rooms.
+func (c *Command) DenyRooms(roomIDs ...id.RoomID) *Command {
+	c.Access.DenyRooms = append(c.Access.DenyRooms, roomIDs...)
+	return c
+}
+
+// commandAccess returns the restrictions of a command: those set in code and
+// those of Config.CommandAccess, which both apply.
+func (b *Bot) commandAccess(cmd *Command) []Access {
+	access := []Access{cmd.Access}
+	if configured, ok := b.config.CommandAccess[cmd.Name]; ok {
+		access = append(access, configured)
+	}
+	return access
+}
+
+// commandAvailable reports whether a command may be run in a room by anyone.
+func (b *Bot) commandAvailable(roomID id.RoomID, cmd *Command) bool {
+	for _, access := range b.commandAccess(cmd) {
+		if !access.allowsRoom(roomID) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanRun checks whether a user may run a command in a room. The error wraps
+// ErrAccessDenied and says why, e.g. for a help command listing only what the
+// user can run.
+func (b *Bot) CanRun(ctx context.Context, cmd *Command, roomID id.RoomID, userID id.UserID) error {
+	if !b.commandAvailable(roomID, cmd) {
+		return fmt.Errorf("%w: %s", ErrAccessDenied, b.Translate(DefaultLanguage, "access.room"))
+	}
+	if d := b.denyReason(ctx, cmd, roomID, userID); d != nil {
+		return fmt.Errorf("%w: %s", ErrAccessDenied, d.text(b, DefaultLanguage))
+	}
+	return nil
+}
+
+// denial is why a user may not run a command, as a message key and its arguments.
+type denial struct {
+	key  string
+	args []any
+}
+
+// text returns the reason in a language.
+func (d *denial) text(b *Bot, language string) string {
+	return b.Translate(language, d.key, d.args...)
+}
+
+// denyReason returns why a user may not run a command in a room where it is
+// available, or nil if they may.
+func (b *Bot) denyReason(ctx context.Context, cmd *Command, roomID id.RoomID, userID id.UserID) *denial {
+	server := userID.Homeserver()
+	for _, access := range b.commandAccess(cmd) {
+		switch {
+		case access.AdminOnly && !slices.Contains(b.config.Admins, userID):
+			return &denial{key: "access.admin_only"}
+		case slices.Contains(access.DenyUsers, userID), slices.Contains(access.DenyServers, server):
+			return &denial{key: "access.not_allowed"}
+		case (len(access.AllowUsers) > 0 || len(access.AllowServers) > 0) &&
+			!slices.Contains(access.AllowUsers, userID) && !slices.Contains(access.AllowServers, server):
+			return &denial{key: "access.not_allowed"}
+		}
+		if access.MinPowerLevel > 0 {
+			levels, err := b.client.StateStore.GetPowerLevels(ctx, roomID)
+			if err != nil || levels == nil || levels.GetUserLevel(userID) < access.MinPowerLevel {
+				return &denial{key: "access.power_level", args: []any{access.MinPowerLevel}}
+			}
+		}
+	}
+	return nil
+}
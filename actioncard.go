@@ -0,0 +1,128 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// CardAction maps a reaction on an action card to a command invocation.
+type CardAction struct {
+	Key           string `json:"key"`                       // Reaction emoji, e.g. "🔁"
+	Label         string `json:"label"`                     // Shown next to the emoji on the card, e.g. "Retry pipeline"
+	Command       string `json:"command"`                   // Command name without prefix, e.g. "retry"
+	Args          string `json:"args,omitempty"`            // Preset arguments, e.g. "build 1234"
+	MinPowerLevel int    `json:"min_power_level,omitempty"` // Required power level of the reactor (0 = everyone)
+}
+
+// actionCardKey is the store key of the actions of a card.
+func actionCardKey(eventID id.EventID) string {
+	return "actioncard." + eventID.String()
+}
+
+// SendActionCard posts a markdown message with a legend of its actions and
+// pre-reacts with each action's emoji. When a room member adds one of these
+// reactions, the mapped command runs as if they had sent
+// "!<command> <args>", subject to the action's MinPowerLevel, room muting
+// and AllowedUsers. Replies of the command go to the card. The mapping is kept
+// in the store, so cards keep working after a restart.
+func (b *Bot) SendActionCard(ctx context.Context, roomID id.RoomID, md string, actions ...CardAction) (id.EventID, error) {
+	var sb strings.Builder
+	sb.WriteString(md)
+	sb.WriteString("\n\n")
+	for _, action := range actions {
+		sb.WriteString(fmt.Sprintf("%s %s  \n", action.Key, action.Label))
+	}
+	text := strings.TrimSuffix(sb.String(), "  \n")
+
+	eventID, err := b.SendMessage(ctx, roomID, &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          text,
+		Format:        event.FormatHTML,
+		FormattedBody: MarkdownToHTML(text),
+	})
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(actions)
+	if err != nil {
+		return eventID, err
+	}
+	if err = b.store.Set(ctx, actionCardKey(eventID), string(data)); err != nil {
+		return eventID, fmt.Errorf("matrix: failed to save action card: %w", err)
+	}
+	for _, action := range actions {
+		if err = b.React(ctx, roomID, eventID, action.Key); err != nil {
+			return eventID, err
+		}
+	}
+	return eventID, nil
+}
+
+// RemoveActionCard stops reacting to the reactions on a card, e.g. once the
+// pipeline it was about has been retried successfully.
+func (b *Bot) RemoveActionCard(ctx context.Context, eventID id.EventID) error {
+	if err := b.store.Delete(ctx, actionCardKey(eventID)); err != nil {
+		return fmt.Errorf("matrix: failed to remove action card: %w", err)
+	}
+	return nil
+}
+
+// handleCardReaction queues the command mapped to a reaction on an action card.
+func (b *Bot) handleCardReaction(ctx context.Context, evt *event.Event) {
+	if evt.Sender == b.client.UserID || b.initialSync.Load() || !b.config.allowed(evt.RoomID, evt.Sender) || b.isBacklog(evt) {
+		return
+	}
+	relates := evt.Content.AsReaction().GetRelatesTo()
+	data, err := b.store.Get(ctx, actionCardKey(relates.EventID))
+	if err != nil || data == "" {
+		return
+	}
+	var actions []CardAction
+	if err = json.Unmarshal([]byte(data), &actions); err != nil {
+		b.log.Warn().Err(err).Str("room_id", evt.RoomID.String()).Msg("Invalid action card")
+		return
+	}
+	for _, action := range actions {
+		if action.Key != relates.Key {
+			continue
+		}
+		if action.MinPowerLevel > 0 {
+			levels, err := b.client.StateStore.GetPowerLevels(ctx, evt.RoomID)
+			if err != nil || levels == nil || levels.GetUserLevel(evt.Sender) < action.MinPowerLevel {
+				b.log.Info().
+					Str("room_id", evt.RoomID.String()).
+					Str("sender", evt.Sender.String()).
+					Str("command", action.Command).
+					Msg("Ignoring action card reaction from a user without the required power level")
+				return
+			}
+		}
+		b.enqueueMessage(ctx, b.newCardMessageContext(ctx, evt, relates.EventID, action))
+		return
+	}
+}
+
+// newCardMessageContext builds the command message for a card reaction. The
+// message has the card's event ID, so replies and reactions go to the card.
+func (b *Bot) newCardMessageContext(ctx context.Context, reaction *event.Event, cardID id.EventID, action CardAction) *MessageContext {
+	body := b.CommandPrefixes(ctx, reaction.RoomID)[0] + action.Command
+	if action.Args != "" {
+		body += " " + action.Args
+	}
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: body}
+	msg := b.newMessageContext(&event.Event{
+		Type:      event.EventMessage,
+		ID:        cardID,
+		RoomID:    reaction.RoomID,
+		Sender:    reaction.Sender,
+		Timestamp: reaction.Timestamp,
+		Content:   event.Content{Parsed: content},
+	})
+	msg.Reaction = reaction
+	return msg
+}
@@ -0,0 +1,118 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// adminDedupWindow is how long repeats of a reported error are counted
+	// instead of posted again.
+	adminDedupWindow = time.Hour
+	// adminRateLimit is how many error reports are posted per adminRateWindow.
+	adminRateLimit  = 10
+	adminRateWindow = 10 * time.Minute
+)
+
+// adminReports rate-limits and deduplicates the errors posted to Config.AdminRoom.
+type adminReports struct {
+	mu      sync.Mutex
+	seen    map[string]*adminReport // By source and error message
+	posted  []time.Time             // Recent posts, for the rate limit
+	dropped int                     // Reports dropped by the rate limit since the last post
+}
+
+// adminReport tracks an error that was posted.
+type adminReport struct {
+	postedAt time.Time
+	repeats  int // Occurrences since then that weren't posted
+}
+
+// NotifyAdmin posts an operator notice to Config.AdminRoom, if configured,
+// e.g. a message flagged by moderation. Failures are logged, as the notice is
+// usually about a failure already.
+func (b *Bot) NotifyAdmin(ctx context.Context, md string) {
+	if b.config.AdminRoom == "" {
+		return
+	}
+	if err := b.SendHTML(ctx, b.config.AdminRoom, md, MarkdownToHTML(md)); err != nil {
+		b.log.Warn().Err(err).Str("room_id", b.config.AdminRoom.String()).Msg("Failed to notify admin room")
+	}
+}
+
+// ReportError logs an error and posts it to Config.AdminRoom, so operators
+// notice problems without tailing logs. source says what failed, e.g.
+// "Gitea sync failed". Repeats of the same error are posted at most once an
+// hour, with their count, and at most 10 reports are posted per 10 minutes.
+// The bot reports handler panics, sync errors, decryption failures and failed
+// report deliveries itself; modules use it for errors of their integrations.
+func (b *Bot) ReportError(ctx context.Context, source string, err error) {
+	b.log.Error().Err(err).Str("source", source).Msg(source)
+	b.reportAdmin(ctx, source, err)
+}
+
+// reportAdmin is ReportError for errors that have been logged already.
+func (b *Bot) reportAdmin(ctx context.Context, source string, err error) {
+	if b.config.AdminRoom == "" {
+		return
+	}
+	md, ok := b.admin.add(source, err, time.Now())
+	if !ok {
+		return
+	}
+	// Post in the background: errors are often reported from the sync loop.
+	go b.NotifyAdmin(context.WithoutCancel(ctx), md)
+}
+
+// add records an error and returns the notice to post, or false if the
+// error is a repeat or the rate limit is reached.
+func (r *adminReports) add(source string, err error, now time.Time) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen == nil {
+		r.seen = make(map[string]*adminReport)
+	}
+	for key, report := range r.seen {
+		if age := now.Sub(report.postedAt); age >= adminDedupWindow && report.repeats == 0 || age >= 24*time.Hour {
+			delete(r.seen, key)
+		}
+	}
+	key := source + "\x00" + err.Error()
+	previous := r.seen[key]
+	if previous != nil && now.Sub(previous.postedAt) < adminDedupWindow {
+		previous.repeats++
+		return "", false
+	}
+	for len(r.posted) > 0 && now.Sub(r.posted[0]) >= adminRateWindow {
+		r.posted = r.posted[1:]
+	}
+	if len(r.posted) >= adminRateLimit {
+		r.dropped++
+		return "", false
+	}
+
+	md := fmt.Sprintf("⚠️ **%s**: `%v`", source, err)
+	if previous != nil && previous.repeats > 0 {
+		md += fmt.Sprintf("\n\nThis happened %d more times since %s.", previous.repeats, previous.postedAt.Format(time.Kitchen))
+	}
+	if r.dropped > 0 {
+		md += fmt.Sprintf("\n\n%d more errors weren't posted because of the rate limit; see the logs.", r.dropped)
+		r.dropped = 0
+	}
+	r.seen[key] = &adminReport{postedAt: now}
+	r.posted = append(r.posted, now)
+	return md, true
+}
+
+// handleDecryptError reports messages that couldn't be decrypted, except
+// old ones (e.g. history from before the bot joined) and those of the initial sync.
+func (b *Bot) handleDecryptError(evt *event.Event, err error) {
+	if b.initialSync.Load() || b.isBacklog(evt) {
+		return
+	}
+	b.reportAdmin(context.Background(), fmt.Sprintf("Decryption failed in %s", evt.RoomID), err)
+}
@@ -0,0 +1,140 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// auditBodyLength is the number of characters of a message body kept in the audit log.
+const auditBodyLength = 500
+
+// AuditDirection tells whether an audit log entry is a handled command or a sent message.
+type AuditDirection string
+
+const (
+	AuditInbound  AuditDirection = "in"  // A command handled by the bot
+	AuditOutbound AuditDirection = "out" // A message sent by the bot
+)
+
+// AuditEntry is one record of the audit log, see Config.AuditLog.
+type AuditEntry struct {
+	Direction AuditDirection
+	RoomID    id.RoomID
+	EventID   id.EventID
+	Sender    id.UserID
+	Command   string // Command name for inbound entries
+	Body      string // Message body, truncated
+	Time      time.Time
+}
+
+// AuditQuery filters the audit log. Zero fields match everything.
+type AuditQuery struct {
+	RoomID    id.RoomID
+	Sender    id.UserID
+	Direction AuditDirection
+	Since     time.Time
+	Until     time.Time
+	Limit     int // Maximum number of entries (default: 100)
+}
+
+// auditSecretKey marks contexts whose outgoing message bodies must not be audited.
+type auditSecretKey struct{}
+
+// withoutAuditBody keeps the body of messages sent with ctx out of the audit log.
+func withoutAuditBody(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditSecretKey{}, true)
+}
+
+// audit records an entry if Config.AuditLog is enabled. Failures are logged
+// rather than returned, so auditing never breaks message handling.
+func (b *Bot) audit(ctx context.Context, entry AuditEntry) {
+	if !b.config.AuditLog || b.db == nil {
+		return
+	}
+	if secret, _ := ctx.Value(auditSecretKey{}).(bool); secret {
+		entry.Body = "[secret]"
+	}
+	if runes := []rune(entry.Body); len(runes) > auditBodyLength {
+		entry.Body = string(runes[:auditBodyLength]) + "…"
+	}
+	_, err := b.db.Exec(ctx, `INSERT INTO bot_audit_log (direction, room_id, event_id, sender, command, body, ts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		entry.Direction, entry.RoomID, entry.EventID, entry.Sender, entry.Command, entry.Body, entry.Time.UnixMilli())
+	if err != nil {
+		b.log.Warn().Err(err).Str("room_id", entry.RoomID.String()).Msg("Failed to write audit log")
+	}
+}
+
+// AuditLog returns audit log entries matching the query, newest first, e.g.
+// to find out why the bot said something in a room.
+func (b *Bot) AuditLog(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	if b.db == nil {
+		return nil, ErrNoDatabase
+	}
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if query.RoomID != "" {
+		where("room_id=$%d", query.RoomID)
+	}
+	if query.Sender != "" {
+		where("sender=$%d", query.Sender)
+	}
+	if query.Direction != "" {
+		where("direction=$%d", query.Direction)
+	}
+	if !query.Since.IsZero() {
+		where("ts>=$%d", query.Since.UnixMilli())
+	}
+	if !query.Until.IsZero() {
+		where("ts<$%d", query.Until.UnixMilli())
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	sql := "SELECT direction, room_id, event_id, sender, command, body, ts FROM bot_audit_log"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	sql += fmt.Sprintf(" ORDER BY ts DESC LIMIT $%d", len(args))
+
+	rows, err := b.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var ts int64
+		err = rows.Scan(&entry.Direction, &entry.RoomID, &entry.EventID, &entry.Sender, &entry.Command, &entry.Body, &ts)
+		if err != nil {
+			return nil, fmt.Errorf("matrix: failed to read audit log: %w", err)
+		}
+		entry.Time = time.UnixMilli(ts)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// PruneAuditLog deletes audit log entries older than before and returns how many were removed.
+func (b *Bot) PruneAuditLog(ctx context.Context, before time.Time) (int64, error) {
+	if b.db == nil {
+		return 0, ErrNoDatabase
+	}
+	res, err := b.db.Exec(ctx, "DELETE FROM bot_audit_log WHERE ts<$1", before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("matrix: failed to prune audit log: %w", err)
+	}
+	return res.RowsAffected()
+}
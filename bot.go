@@ -2,24 +2,50 @@
 // It supports encrypted rooms, message handling, and formatted (HTML) responses.
 //
 // Environment variables:
-//   - MATRIX_API_URL: Matrix homeserver URL
+//   - MATRIX_API_URL: Matrix homeserver URL or server name (resolved via .well-known)
 //   - MATRIX_API_USER: Matrix username (localpart)
 //   - MATRIX_API_PASS: Matrix password
+//   - MATRIX_API_TOKEN: Access token of an existing session (instead of a password)
+//   - MATRIX_API_DEVICE_ID: Device ID belonging to the access token
+//   - MATRIX_RECOVERY_KEY: Recovery key to restore and back up encryption keys
+//   - MATRIX_AUTO_LEAVE_DAYS: Leave rooms where the bot has been alone for this many days
+//   - MATRIX_LOGOUT_ON_STOP: Log out and delete the device when the bot stops ("true")
+//   - MATRIX_DATABASE_URI: PostgreSQL connection URI (postgres://...) instead of SQLite
+//   - MATRIX_DATABASE_KEY: Passphrase encrypting the SQLite database with SQLCipher
+//   - MATRIX_PROXY_URL: HTTP or SOCKS5 proxy (HTTPS_PROXY is honored otherwise)
+//   - MATRIX_SYNC_MODE: "resume" (default) or "latest" to skip events missed while offline
+//   - MATRIX_READ_ONLY: Observe rooms without ever sending anything ("true")
+//   - MATRIX_HANDLER_TIMEOUT: Cancel handlers running longer than this (e.g. "2m")
+//   - MATRIX_MESSAGE_CUTOFF: Ignore messages older than this (e.g. "10m"; "-1s" handles the backlog)
+//   - MATRIX_IGNORE_OWN_MESSAGES: "false" to pass the bot's own messages to handlers
+//   - MATRIX_DISABLED_MODULES: Comma-separated modules that Use skips
+//   - MATRIX_ADMINS: Comma-separated user IDs of the bot operators
+//   - MATRIX_LANGUAGE: Language of the built-in responses, e.g. "de" (default: "en")
+//   - MATRIX_MENTION_TRIGGER: Also run commands after a mention of the bot ("true")
 package matrix
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/mattn/go-sqlite3" // SQLite driver; PostgreSQL is registered in db.go
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exzerolog"
 
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
 	"maunium.net/go/mautrix/crypto/cryptohelper"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -28,52 +54,473 @@ import (
 // Config holds the configuration for the Matrix bot.
 // All fields can be populated from environment variables using GetEnvironmentConfig().
 type Config struct {
-	Homeserver string // Matrix homeserver URL (e.g. https://matrix.org)
-	Username   string // Username localpart (e.g. "mybot")
+	Homeserver string // Homeserver URL (e.g. https://matrix.org) or server name resolved via .well-known (e.g. example.com)
+	Username   string // Username localpart (e.g. "mybot") or full user ID (e.g. "@mybot:example.com")
 	Password   string // Password for authentication
-	Database   string // SQLite database path for crypto state (default: "matrix-bot.db")
-	Debug      bool   // Enable debug logging
+
+	// AccessToken reuses an existing session instead of logging in with a password,
+	// so no new device is created. DeviceID should be the device the token belongs to;
+	// it is looked up via /whoami when empty.
+	AccessToken string
+	DeviceID    string
+
+	// RecoveryKey unlocks secure secret storage (the key shown when setting up
+	// key backup in Element). The bot then cross-signs its device, restores room
+	// keys from the server-side key backup and uploads new keys to it, so
+	// encrypted history stays readable after the database is lost.
+	RecoveryKey string
+
+	Database string // SQLite database path for crypto state and bot data (default: "matrix-bot.db"; MemoryDatabase for no file)
+
+	// DatabaseURI stores crypto state and bot data in PostgreSQL instead of
+	// SQLite, e.g. "postgres://bot:secret@db:5432/matrix?sslmode=disable".
+	// Database is ignored when it is set. Use it to run in containers or HA
+	// setups with an external database, or to build without cgo.
+	DatabaseURI string
+
+	// DatabaseKey encrypts the SQLite database at rest with SQLCipher, so the
+	// olm account and megolm sessions aren't stored in plaintext. go-sqlite3
+	// must be built against SQLCipher; the bot refuses to start otherwise.
+	// Existing unencrypted databases can't be opened with a key.
+	DatabaseKey string
+
+	// Store replaces the database with another persistence layer for the sync
+	// token, room state, crypto material and key-value data, see Store.
+	// Database and DatabaseURI are ignored when it is set.
+	Store Store
+
+	Debug bool // Enable debug logging
+
+	// Logger receives the bot's logs, e.g. as JSON for a log pipeline, or
+	// forwarded to log/slog with NewSlogWriter. Nil logs to stderr in console
+	// format, at debug level if Debug is set; otherwise Debug is ignored.
+	Logger *zerolog.Logger
+
+	// ProxyURL routes all HTTP traffic through a proxy, e.g. "http://proxy:3128" or
+	// "socks5h://127.0.0.1:9050" for Tor. When empty, HTTPS_PROXY/HTTP_PROXY/NO_PROXY apply.
+	ProxyURL string
+
+	// HTTPClient is used for the homeserver and all outbound requests, e.g. to
+	// trust a private CA, use mTLS, change timeouts or add instrumentation.
+	// ProxyURL is ignored when it is set. Keep the timeout above the sync
+	// timeout (30s) or leave it at zero.
+	HTTPClient *http.Client
+
+	// CommandPrefix starts commands registered with Bot.Command (default: "!").
+	// CommandPrefixes are accepted as well, e.g. "/" or "bot:". Rooms shared
+	// with other bots can replace both with the CommandPrefixSetting.
+	CommandPrefix   string
+	CommandPrefixes []string
+
+	// CommandAliases maps additional names to commands, e.g. {"i": "issues"},
+	// on top of those registered with Command.Alias.
+	CommandAliases map[string]string
+
+	// MentionTrigger also runs commands after a mention of the bot at the
+	// start of a message, e.g. "@bot summarize repo X" or "Bot: help", which
+	// is more natural in busy rooms than a prefix.
+	MentionTrigger bool
+
+	// SuggestCommands replies to unknown commands with the closest ones the
+	// user can run ("Did you mean !issues?"). AutoCorrectCommands runs the
+	// command instead if it is the only one within a single typo. Both are
+	// off by default, as other bots may share the prefix.
+	SuggestCommands     bool
+	AutoCorrectCommands bool
+
+	// ReadOnly makes the bot a pure observer for analytics and compliance
+	// deployments: it syncs and decrypts, but every request that would post or
+	// change something in a room fails with ErrReadOnly. Invites are still accepted.
+	ReadOnly bool
+
+	// AllowedRooms and AllowedUsers restrict which rooms the bot joins and which
+	// messages reach the handlers. Empty lists allow everything.
+	AllowedRooms []id.RoomID
+	AllowedUsers []id.UserID
+
+	// Admins are the bot operators: only they may run commands that
+	// RequireAdmin, or have AdminOnly in CommandAccess.
+	Admins []id.UserID
+
+	// CommandAccess restricts commands by name, in addition to the
+	// restrictions they were registered with, e.g. to limit "create-task" to
+	// some users or rooms without changing code.
+	CommandAccess map[string]Access
+
+	// CommandLimits overrides the rate limits of commands by name, see
+	// Command.WithCooldown. Admins aren't limited.
+	CommandLimits map[string]RateLimit
+
+	// Integrations holds free-form settings for integrations, see Config.Integration.
+	Integrations map[string]map[string]string
+
+	// Modules holds the config sections of modules implementing Configurable,
+	// keyed by module name, as read from the "modules" section of the config file.
+	Modules map[string]map[string]any
+
+	// DisabledModules lists modules that Use skips, so a binary can ship
+	// modules that each deployment turns on or off in its config file.
+	DisabledModules []string
+
+	// FeatureFlags are the default feature flags, see Bot.FeatureEnabled.
+	// Flags set at runtime with Bot.SetFeatureFlag take precedence.
+	FeatureFlags map[string]FeatureFlag
+
+	// Rules route inbound webhooks and watched room messages to targets, see Bot.Route.
+	Rules []Rule
+
+	// AnnounceSettingChanges posts changes of per-room settings to the room,
+	// e.g. "ai.model changed from llama3.2 to qwen by @alice", for accountability.
+	AnnounceSettingChanges bool
+
+	// AdminRoom receives operator notices and error reports (see ReportError), e.g. when encryption had to be
+	// disabled or a handler panicked.
+	AdminRoom id.RoomID
+
+	// RecoverCorruptStore replaces an SQLite database that fails the integrity
+	// check on startup with its backup (see Bot.BackupDatabase) or an empty
+	// one, instead of refusing to start with a StoreCorruptError. Keys are then
+	// restored from the key backup if Config.RecoveryKey is set; otherwise the
+	// encrypted rooms are told that their earlier history is unreadable.
+	RecoverCorruptStore bool
+
+	// CryptoFallback keeps the bot running without end-to-end encryption when
+	// the crypto setup fails (corrupt store, unsupported homeserver) instead of
+	// refusing to start. It logs a prominent warning, notifies AdminRoom and
+	// refuses to send to encrypted rooms; see Bot.CryptoError.
+	CryptoFallback bool
+
+	// Outbox saves outgoing messages in the database until the homeserver has
+	// accepted them. Messages that fail to send because the homeserver is down
+	// or overloaded are retried in order by a background worker (for up to a
+	// day, also after restarts) and SendMessage returns an error wrapping ErrQueued.
+	// Messages to encrypted rooms bypass the outbox, so that they are never
+	// stored in plaintext.
+	Outbox bool
+
+	// MaxMessageLength splits text messages with longer bodies (in bytes) into
+	// several, preferably between paragraphs and code blocks, as clients
+	// render very long messages slowly. Zero (default) only splits messages
+	// exceeding the homeserver's event size limit; otherwise it must be at
+	// least MinMessageLength.
+	MaxMessageLength int
+
+	// MaxMessageParts uploads text messages that would be split into more
+	// parts as a Markdown file instead, captioned with their beginning. Zero
+	// (default) always splits.
+	MaxMessageParts int
+
+	// AuditLog records every handled command and every message sent by the bot
+	// (room, sender, event ID, truncated body) in the database, see Bot.AuditLog.
+	AuditLog bool
+
+	// QueueLimit caps the number of incoming messages waiting for handlers (default: 1000).
+	// When full, passive messages are dropped first, then lower-priority commands.
+	QueueLimit int
+	// HandlerWorkers is the number of messages handled concurrently (default: 4),
+	// so one slow handler doesn't stall all rooms. Messages of the same room
+	// are still handled one at a time, in order within a priority lane.
+	HandlerWorkers int
+	// DisableOverloadNotice suppresses the "bot is overloaded" reply to dropped commands.
+	DisableOverloadNotice bool
+
+	// Language is the language of the bot's built-in responses, e.g. "de"
+	// (default: "en"). Rooms choose their own with the LanguageSetting.
+	// Catalogs add or override translations by language, see Catalog.
+	Language string
+	Catalogs map[string]Catalog
+
+	// DisableHelp keeps the bot from registering the built-in !help command,
+	// which lists the commands grouped by module, see Bot.Help.
+	DisableHelp bool
+
+	// AutoLeaveAfter makes the bot leave and forget rooms where it has been the only
+	// member for this long, to avoid accumulating dead encrypted sessions. Zero disables it.
+	AutoLeaveAfter time.Duration
+
+	// LogoutOnStop invalidates the access token and deletes the device when Stop is called,
+	// so ephemeral bots (e.g. in CI) don't leave hundreds of stale encrypted devices behind.
+	LogoutOnStop bool
+
+	// SyncMode chooses between catching up on events missed while the bot was
+	// offline (SyncResume, default) and skipping them (SyncLatest). Messages
+	// from the initial sync of a new bot are never handled as commands.
+	SyncMode SyncMode
+
+	// MessageCutoff keeps old messages from handlers, so the bot doesn't answer
+	// hours-old commands after downtime. Zero (default) ignores messages sent
+	// before the bot started; a positive value ignores messages received more
+	// than this long after they were sent (e.g. 10 minutes to still handle
+	// those sent during a quick restart); a negative value handles the whole
+	// backlog. Config.ClockSkewTolerance is allowed for.
+	MessageCutoff time.Duration
+
+	// SyncFilter filters what the homeserver sends with each sync. Nil uses
+	// DefaultSyncFilter, which loads members lazily and drops presence; an
+	// empty filter syncs everything.
+	SyncFilter *mautrix.Filter
+
+	// BreakerThreshold is how many homeserver requests in a row may fail
+	// (unreachable, or 502-504) before the circuit breaker opens: requests
+	// then fail with ErrHomeserverUnavailable without being sent, messages are
+	// buffered in the outbox, and OnDegraded handlers are called (default: 5).
+	// Negative values disable the breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is how often a single request is let through to check
+	// whether the homeserver is back while the circuit breaker is open
+	// (default: 30 seconds).
+	BreakerCooldown time.Duration
+
+	// SyncStallTimeout is how long the bot may go without a sync response
+	// before the watchdog restarts the sync loop on a fresh connection
+	// (default: 5 minutes, at least MinSyncStallTimeout). Negative values
+	// disable the watchdog.
+	SyncStallTimeout time.Duration
+
+	// ClockSkewTolerance is how far event timestamps (origin_server_ts) may
+	// deviate from the bot's clock before they are distrusted, see
+	// MessageContext.Time and MessageContext.OlderThan (default: 5 minutes).
+	ClockSkewTolerance time.Duration
+
+	// HandlerTimeout is how long a message handler or command may run before
+	// its context is cancelled and, for commands, the timeout is reported to
+	// the room. The other handlers of the message run meanwhile; the room's
+	// next message waits until the handler returns. Zero (default) disables
+	// it; commands can override it with Command.WithTimeout.
+	HandlerTimeout time.Duration
+
+	// DialogTimeout is how long a dialog waits for the user's answer before
+	// it is cancelled, see Bot.StartDialog (default: 5m).
+	DialogTimeout time.Duration
+
+	// ScheduleCatchUp is how late a scheduled run missed while the bot was
+	// down may still happen when it starts, see Bot.Schedule (default: 1h).
+	// Negative values skip missed runs.
+	ScheduleCatchUp time.Duration
+
+	// ShutdownTimeout is how long Stop waits for running handlers to finish,
+	// and for queued messages to be handled, before cancelling them
+	// (default: 30 seconds). Negative values cancel them right away.
+	ShutdownTimeout time.Duration
+
+	// IgnoreOwnMessages drops messages sent by the bot itself (e.g. the echo of
+	// SendText) before they reach handlers, so replying to every message can't
+	// loop. Nil means true; set it to a false value to handle them too, and
+	// check MessageContext.FromSelf in the handlers.
+	IgnoreOwnMessages *bool
 }
 
 // GetEnvironmentConfig creates a Config from environment variables.
+// Use LoadConfig to read a config file with environment overrides instead.
 func GetEnvironmentConfig() Config {
 	return Config{
 		Homeserver: os.Getenv("MATRIX_API_URL"),
 		Username:   os.Getenv("MATRIX_API_USER"),
 		Password:   os.Getenv("MATRIX_API_PASS"),
-		Database:   "matrix-bot.db",
-		Debug:      os.Getenv("MATRIX_DEBUG") == "true",
+
+		AccessToken: os.Getenv("MATRIX_API_TOKEN"),
+		DeviceID:    os.Getenv("MATRIX_API_DEVICE_ID"),
+		RecoveryKey: os.Getenv("MATRIX_RECOVERY_KEY"),
+		Database:    "matrix-bot.db",
+		DatabaseURI: os.Getenv("MATRIX_DATABASE_URI"),
+		DatabaseKey: os.Getenv("MATRIX_DATABASE_KEY"),
+		Language:    os.Getenv("MATRIX_LANGUAGE"),
+		Debug:       os.Getenv("MATRIX_DEBUG") == "true",
+		ProxyURL:    os.Getenv("MATRIX_PROXY_URL"),
+		ReadOnly:    os.Getenv("MATRIX_READ_ONLY") == "true",
+
+		AutoLeaveAfter: envDays("MATRIX_AUTO_LEAVE_DAYS"),
+		LogoutOnStop:   os.Getenv("MATRIX_LOGOUT_ON_STOP") == "true",
+		MentionTrigger: os.Getenv("MATRIX_MENTION_TRIGGER") == "true",
+		AdminRoom:      id.RoomID(os.Getenv("MATRIX_ADMIN_ROOM")),
+		CryptoFallback: os.Getenv("MATRIX_CRYPTO_FALLBACK") == "true",
+		Outbox:         os.Getenv("MATRIX_OUTBOX") == "true",
+
+		MaxMessageLength: envInt("MATRIX_MAX_MESSAGE_LENGTH"),
+		MaxMessageParts:  envInt("MATRIX_MAX_MESSAGE_PARTS"),
+
+		RecoverCorruptStore: os.Getenv("MATRIX_RECOVER_CORRUPT_STORE") == "true",
+		SyncMode:            SyncMode(os.Getenv("MATRIX_SYNC_MODE")),
+		DisabledModules:     envList("MATRIX_DISABLED_MODULES"),
+		IgnoreOwnMessages:   envFlag("MATRIX_IGNORE_OWN_MESSAGES"),
+		MessageCutoff:       envDuration("MATRIX_MESSAGE_CUTOFF"),
+		HandlerTimeout:      envDuration("MATRIX_HANDLER_TIMEOUT"),
+		Admins:              envUsers("MATRIX_ADMINS"),
 	}
 }
 
+// envUsers parses a comma-separated list of user IDs; missing yields nil.
+func envUsers(key string) []id.UserID {
+	var users []id.UserID
+	for _, user := range envList(key) {
+		users = append(users, id.UserID(user))
+	}
+	return users
+}
+
+// envDuration parses an environment variable holding a duration like "10m".
+// Missing or invalid values yield zero.
+func envDuration(key string) time.Duration {
+	d, _ := time.ParseDuration(os.Getenv(key))
+	return d
+}
+
+// envFlag parses an optional boolean environment variable ("true" or "false");
+// missing yields nil.
+func envFlag(key string) *bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	flag := value == "true"
+	return &flag
+}
+
+// envList parses a comma-separated environment variable; missing yields nil.
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envInt parses an environment variable holding a positive number.
+// Missing or invalid values yield zero.
+func envInt(key string) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// envDays parses an environment variable holding a number of days.
+// Missing or invalid values yield zero.
+func envDays(key string) time.Duration {
+	days, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || days < 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
 // Validate checks that required fields are set.
+// Either an access token or a username and password are required. The
+// homeserver may be omitted if Username is a full user ID to discover it from.
 func (c Config) Validate() error {
-	if c.Homeserver == "" {
+	if c.Homeserver == "" && !strings.HasPrefix(c.Username, "@") {
 		return fmt.Errorf("matrix: homeserver URL is required")
 	}
+	if c.SyncMode != "" && c.SyncMode != SyncResume && c.SyncMode != SyncLatest {
+		return fmt.Errorf("matrix: unknown sync mode %q", c.SyncMode)
+	}
+	if c.SyncStallTimeout > 0 && c.SyncStallTimeout < MinSyncStallTimeout {
+		return fmt.Errorf("matrix: sync stall timeout must be at least %s", MinSyncStallTimeout)
+	}
+	if c.MaxMessageLength < 0 || c.MaxMessageLength > 0 && c.MaxMessageLength < MinMessageLength {
+		return fmt.Errorf("matrix: max message length must be 0 or at least %d", MinMessageLength)
+	}
+	if c.DatabaseURI != "" && !isPostgresURI(c.DatabaseURI) {
+		return fmt.Errorf("matrix: database URI must start with postgres:// or postgresql://")
+	}
+	if c.AccessToken != "" {
+		return nil
+	}
 	if c.Username == "" {
 		return fmt.Errorf("matrix: username is required")
 	}
 	if c.Password == "" {
-		return fmt.Errorf("matrix: password is required")
+		return fmt.Errorf("matrix: password or access token is required")
 	}
 	return nil
 }
 
+// allowed reports whether a room and user pass AllowedRooms and AllowedUsers.
+func (c Config) allowed(roomID id.RoomID, userID id.UserID) bool {
+	return (len(c.AllowedRooms) == 0 || slices.Contains(c.AllowedRooms, roomID)) &&
+		(len(c.AllowedUsers) == 0 || slices.Contains(c.AllowedUsers, userID))
+}
+
 // MessageHandler is called when the bot receives a message.
 // The handler receives the context, the room ID, the sender, and the message event.
 type MessageHandler func(ctx context.Context, roomID id.RoomID, sender id.UserID, message *event.MessageEventContent)
 
 // Bot is a Matrix bot that can join rooms, receive messages, and send responses.
 type Bot struct {
-	config   Config
-	client   *mautrix.Client
-	crypto   *cryptohelper.CryptoHelper
-	log      zerolog.Logger
-	handlers []MessageHandler
+	config    Config
+	client    *mautrix.Client
+	crypto    *cryptohelper.CryptoHelper
+	cryptoErr error // Why encryption is disabled, see Config.CryptoFallback
+	store     Store
+	db        *dbutil.Database        // Database of an SQLStore, nil for other stores
+	backupKey *backup.MegolmBackupKey // Set when key backup is enabled, see Config.RecoveryKey
+	recovery  *storeRecovery          // Set when a corrupt database was replaced on startup
+	http      *http.Client
+	log       zerolog.Logger
+	handlers  []MessageContextHandler
+
+	handlerModules  []string // Module that registered each handler, if any, see DisableModule
+	filters         []MessageFilter
+	filterModules   []string // Module that registered each filter, if any
+	receiptHandlers []ReceiptHandler
+	sentHandlers    []SentHandler
+
+	mu             sync.RWMutex
+	roomTemplates  map[string]RoomTemplate
+	modules        []Module
+	initModule     string // Module currently running Init, used to attribute commands
+	commands       map[string]*Command
+	commandHandler int // Index of dispatchCommand in handlers, -1 until a command is registered
+	timezones      map[id.UserID]string
+	reports        []scheduledReport
+	schedules      []*scheduledJob
+	scheduleCtx    context.Context // Context of the running bot, for jobs scheduled after Run
+	classifier     PriorityClassifier
+	dispatch       *dispatcher
+	configSections map[string]ConfigSection
+	rooms          *roomCache
+	reactions      *reactionCache
+	roomConfigs    *roomConfigCache
+	settings       *roomSettingsCache
+	flags          *featureFlags
+	renders        renderMetrics
+	sends          *sendQueue
+	breaker        *circuitBreaker
+	admin          adminReports
+	cooldowns      cooldowns
+	dialogs        map[dialogKey]*Dialog // Dialogs waiting for an answer
+	catalogs       map[string]Catalog    // Translations by language, see RegisterCatalog
+	dmMu           sync.Mutex
+	menuMu         sync.Mutex // Serializes page turns of menus
 
-	cancelSync func()
-	syncWait   sync.WaitGroup
+	overloadNotified map[id.RoomID]time.Time // Last overload notice per room
+	redactWake       chan struct{}           // Wakes runRedactions when a redaction is scheduled
+	outboxWake       chan struct{}           // Wakes runOutbox when a message is queued
+	sendAtWake       chan struct{}           // Wakes runScheduledSends when a message is scheduled
+	initialSync      atomic.Bool             // Set while the events of an initial sync are dispatched
+	lastSync         atomic.Int64            // Unix nanoseconds of the last sync response, for the watchdog
+	syncRestarts     atomic.Int32
+	syncCount        atomic.Int64       // Sync responses received since start
+	restartSync      func()             // Cancels the current sync loop
+	stallHandlers    []SyncStallHandler // Called when the watchdog restarts the sync loop
+
+	readyHandlers     []ReadyHandler
+	syncErrorHandlers []SyncErrorHandler
+	joinHandlers      []RoomJoinedHandler
+	inviteHandlers    []InviteHandler
+	protectedSettings map[string]bool // Room settings only admins may change, see ProtectRoomSetting
+
+	startedAt      time.Time     // When Run was called, for Config.MessageCutoff
+	cancelRun      func()        // Stops the background loops and modules
+	cancelSync     func()        // Stops the sync loop
+	cancelHandlers func()        // Cancels running handlers when draining times out
+	syncDone       chan struct{} // Closed when the sync loop has stopped
+	syncWait       sync.WaitGroup
+	handlerWait    sync.WaitGroup // Dispatcher workers
 }
 
 // NewBot creates a new Matrix bot with the given configuration.
@@ -87,26 +534,82 @@ func NewBot(config Config) (*Bot, error) {
 		config.Database = "matrix-bot.db"
 	}
 
-	return &Bot{
-		config: config,
-	}, nil
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		transport, err := config.proxyTransport()
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{Transport: transport, Timeout: 180 * time.Second}
+	}
+
+	b := &Bot{
+		config:      config,
+		http:        httpClient,
+		rooms:       newRoomCache(),
+		reactions:   newReactionCache(),
+		roomConfigs: newRoomConfigCache(),
+		settings:    newRoomSettingsCache(),
+		flags:       &featureFlags{},
+		sends:       newSendQueue(),
+		breaker:     newCircuitBreaker(config),
+		log:         newLogger(config),
+		dispatch:    newDispatcher(config.QueueLimit, config.HandlerWorkers),
+
+		commandHandler:    -1,
+		protectedSettings: map[string]bool{DisabledModulesSetting: true},
+
+		redactWake: make(chan struct{}, 1),
+		outboxWake: make(chan struct{}, 1),
+		sendAtWake: make(chan struct{}, 1),
+	}
+	b.RegisterConfigSection("rooms", roomSettingsSection{bot: b})
+	for language, catalog := range config.Catalogs {
+		b.RegisterCatalog(language, catalog)
+	}
+	return b, nil
+}
+
+// SetLogger replaces the logger, see Config.Logger. It must be called before Run.
+func (b *Bot) SetLogger(log zerolog.Logger) {
+	b.config.Logger = &log
+	b.log = log
+}
+
+// SetHTTPClient replaces the HTTP client used for the homeserver and outbound
+// requests, see Config.HTTPClient. It must be called before Run.
+func (b *Bot) SetHTTPClient(client *http.Client) {
+	b.http = client
 }
 
 // OnMessage registers a handler for incoming messages.
 // Multiple handlers can be registered and all will be called.
 func (b *Bot) OnMessage(handler MessageHandler) {
+	b.OnMessageContext(func(ctx context.Context, msg *MessageContext) {
+		handler(ctx, msg.RoomID, msg.Sender, msg.Message)
+	})
+}
+
+// OnMessageContext registers a handler receiving a MessageContext, which carries
+// the raw event, thread information and reply/edit helpers.
+// Handlers registered with OnMessage and OnMessageContext run in registration order.
+func (b *Bot) OnMessageContext(handler MessageContextHandler) {
 	b.handlers = append(b.handlers, handler)
+	b.handlerModules = append(b.handlerModules, b.initModule)
 }
 
 // SendText sends a plain text message to the given room.
 func (b *Bot) SendText(ctx context.Context, roomID id.RoomID, text string) error {
-	_, err := b.client.SendText(ctx, roomID, text)
+	_, err := b.SendMessage(ctx, roomID, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	})
 	return err
 }
 
 // SendHTML sends a formatted message with both plain text and HTML body.
 func (b *Bot) SendHTML(ctx context.Context, roomID id.RoomID, text string, html string) error {
-	_, err := b.client.SendMessageEvent(ctx, roomID, event.EventMessage, &event.MessageEventContent{
+	_, err := b.SendMessage(ctx, roomID, &event.MessageEventContent{
 		MsgType:       event.MsgText,
 		Body:          text,
 		Format:        event.FormatHTML,
@@ -131,10 +634,105 @@ func (b *Bot) SendReply(ctx context.Context, roomID id.RoomID, text string, html
 		}
 	}
 
-	_, err := b.client.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+	_, err := b.SendMessage(ctx, roomID, content)
+	return err
+}
+
+// SendMessage sends arbitrary message content and returns the event ID.
+// All Send* helpers go through here. Sends to a room are queued in order and
+// retried when the homeserver rate-limits them; with Config.Outbox they also
+// survive homeserver outages and restarts. Text messages too large for one
+// event or longer than Config.MaxMessageLength are split into several; the ID
+// of the first part is returned then. Beyond Config.MaxMessageParts, they are
+// uploaded as a Markdown file instead.
+func (b *Bot) SendMessage(ctx context.Context, roomID id.RoomID, content *event.MessageEventContent) (id.EventID, error) {
+	if b.config.ReadOnly {
+		return "", ErrReadOnly
+	}
+	if err := b.checkCrypto(ctx, roomID); err != nil {
+		return "", err
+	}
+	parts := b.fitContent(roomID, content)
+	if b.config.MaxMessageParts > 0 && len(parts) > b.config.MaxMessageParts {
+		return b.sendAsFile(ctx, roomID, content)
+	}
+	var first id.EventID
+	for _, part := range parts {
+		eventID, err := b.sendPart(ctx, roomID, part)
+		if err != nil {
+			return first, err
+		}
+		if first == "" {
+			first = eventID
+		}
+	}
+	return first, nil
+}
+
+// EditMessage replaces the content of an earlier message sent by the bot.
+func (b *Bot) EditMessage(ctx context.Context, roomID id.RoomID, eventID id.EventID, text string, html string) error {
+	content := &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          text,
+		Format:        event.FormatHTML,
+		FormattedBody: html,
+	}
+	content.SetEdit(eventID)
+	_, err := b.SendMessage(ctx, roomID, content)
 	return err
 }
 
+// React adds an emoji reaction to an event.
+func (b *Bot) React(ctx context.Context, roomID id.RoomID, eventID id.EventID, key string) error {
+	if err := b.checkCrypto(ctx, roomID); err != nil {
+		return err
+	}
+	return b.enqueueSend(ctx, roomID, func(ctx context.Context) error {
+		_, err := b.client.SendReaction(ctx, roomID, eventID, key)
+		return err
+	})
+}
+
+// FetchEvent loads a single event from the homeserver, decrypting it if needed.
+func (b *Bot) FetchEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	evt, err := b.client.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to fetch event: %w", err)
+	}
+	return b.parseEvent(ctx, evt)
+}
+
+// parseEvent parses the content of an event fetched outside of sync and decrypts it if needed.
+func (b *Bot) parseEvent(ctx context.Context, evt *event.Event) (*event.Event, error) {
+	if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, fmt.Errorf("matrix: failed to parse event: %w", err)
+	}
+	if evt.Type != event.EventEncrypted {
+		return evt, nil
+	}
+	if b.crypto == nil {
+		return nil, fmt.Errorf("matrix: can't decrypt event without crypto")
+	}
+	decrypted, err := b.crypto.Decrypt(ctx, evt)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to decrypt event: %w", err)
+	}
+	return decrypted, nil
+}
+
+// UserID returns the bot's user ID once Run has logged in.
+func (b *Bot) UserID() id.UserID {
+	if b.client == nil {
+		return ""
+	}
+	return b.client.UserID
+}
+
+// ignoreOwnMessages reports whether the bot's own messages are kept from handlers.
+func (b *Bot) ignoreOwnMessages() bool {
+	return b.config.IgnoreOwnMessages == nil || *b.config.IgnoreOwnMessages
+}
+
 // Client returns the underlying mautrix client for advanced usage.
 func (b *Bot) Client() *mautrix.Client {
 	return b.client
@@ -143,101 +741,305 @@ func (b *Bot) Client() *mautrix.Client {
 // Run starts the bot: connects to the homeserver, sets up encryption,
 // and begins syncing. This blocks until Stop() is called or an error occurs.
 func (b *Bot) Run(ctx context.Context) error {
-	client, err := mautrix.NewClient(b.config.Homeserver, "", "")
+	b.startedAt = time.Now()
+	homeserver, err := b.homeserverURL(ctx)
+	if err != nil {
+		return err
+	}
+	client, err := mautrix.NewClient(homeserver, "", "")
 	if err != nil {
 		return fmt.Errorf("matrix: failed to create client: %w", err)
 	}
 	b.client = client
+	b.client.Client = b.breakerClient(b.http)
+	if b.config.ReadOnly {
+		b.client.Client = readOnlyClient(b.client.Client)
+	}
 
 	// Set up logging
-	log := zerolog.New(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) {
-		w.Out = os.Stderr
-		w.TimeFormat = time.Stamp
-	})).With().Timestamp().Logger()
-
-	if !b.config.Debug {
-		log = log.Level(zerolog.InfoLevel)
-	}
-	exzerolog.SetupDefaults(&log)
-	b.log = log
-	b.client.Log = log
+	exzerolog.SetupDefaults(&b.log)
+	b.client.Log = b.log
 
 	// Register event handlers
-	syncer := b.client.Syncer.(*mautrix.DefaultSyncer)
+	syncer := &botSyncer{DefaultSyncer: b.client.Syncer.(*mautrix.DefaultSyncer), bot: b}
+	b.client.Syncer = syncer
 
 	// Handle incoming messages
+	syncer.OnSync(b.trackInitialSync)
+	syncer.OnSync(b.markSynced)
 	syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
-		msg := evt.Content.AsMessage()
-		for _, handler := range b.handlers {
-			handler(ctx, evt.RoomID, evt.Sender, msg)
+		if b.initialSync.Load() || !b.config.allowed(evt.RoomID, evt.Sender) {
+			return
 		}
+		if evt.Sender == b.client.UserID && b.ignoreOwnMessages() {
+			return
+		}
+		if b.isBacklog(evt) {
+			b.log.Debug().Str("room_id", evt.RoomID.String()).Str("event_id", evt.ID.String()).
+				Msg("Ignoring message sent before the cutoff")
+			return
+		}
+		b.enqueueMessage(ctx, b.newMessageContext(evt))
 	})
 
-	// Auto-join rooms on invite
-	syncer.OnEventType(event.StateMember, func(ctx context.Context, evt *event.Event) {
-		if evt.GetStateKey() == b.client.UserID.String() && evt.Content.AsMember().Membership == event.MembershipInvite {
-			_, joinErr := b.client.JoinRoomByID(ctx, evt.RoomID)
-			if joinErr != nil {
-				b.log.Error().Err(joinErr).
-					Str("room_id", evt.RoomID.String()).
-					Str("inviter", evt.Sender.String()).
-					Msg("Failed to join room after invite")
-			} else {
-				b.log.Info().
-					Str("room_id", evt.RoomID.String()).
-					Str("inviter", evt.Sender.String()).
-					Msg("Joined room after invite")
-			}
+	// Keep room metadata cached for JoinedRooms
+	syncer.OnSync(b.rooms.handleSync)
+	syncer.OnEvent(b.rooms.handleEvent)
+
+	// Keep reactions of events queried with GetReactions up to date
+	syncer.OnEventType(event.EventReaction, b.reactions.handleEvent)
+	syncer.OnEventType(event.EventRedaction, b.reactions.handleEvent)
+
+	// Keep room configs and the settings in them up to date with changes by other replicas
+	syncer.OnEventType(AccountDataRoomConfig, b.handleRoomConfigEvent)
+	syncer.OnEventType(AccountDataFeatureFlags, b.flags.handleEvent)
+
+	// Run commands mapped to reactions on action cards and menus
+	syncer.OnEventType(event.EventReaction, b.handleCardReaction)
+	syncer.OnEventType(event.EventReaction, b.handleMenuReaction)
+
+	// Join rooms on invite and report joins, see OnInvite and OnRoomJoined
+	syncer.OnEventType(event.StateMember, b.handleMembership)
+
+	// Redact secrets once they have been read, and tell OnReceipt handlers
+	syncer.OnEventType(event.EphemeralEventReceipt, b.handleReceipt)
+	syncer.OnEventType(event.EphemeralEventReceipt, b.dispatchReceipt)
+
+	// Apply room settings sent as state events
+	syncer.OnEventType(StateRoomSetting, b.handleSettingEvent)
+
+	// Set up storage and encryption
+	if err = b.openStore(ctx); err != nil {
+		return err
+	}
+	b.client.Store = b.store
+	b.client.StateStore = b.store.StateStore()
+	syncer.OnEvent(b.client.StateStoreSyncHandler)
+	if err = b.setupCrypto(ctx); err != nil {
+		if !b.config.CryptoFallback {
+			return err
 		}
-	})
+		if err = b.disableCrypto(ctx, err); err != nil {
+			return err
+		}
+	}
+	if b.config.RecoveryKey != "" && b.crypto != nil {
+		if err = b.setupKeyBackup(ctx); err != nil {
+			return err
+		}
+	}
+
+	b.log.Info().Str("user", b.client.UserID.String()).Msg("Matrix bot is running")
+	if b.config.ReadOnly {
+		b.log.Info().Msg("Read-only mode: the bot won't send anything to rooms")
+	}
+
+	// Start syncing
+	if err = b.prepareSync(ctx); err != nil {
+		return err
+	}
+	// Background loops stop with runCtx. Handlers get a context of their own,
+	// so that they can finish while the bot shuts down, see drainHandlers.
+	runCtx, cancelRun := context.WithCancel(ctx)
+	syncCtx, cancelSync := context.WithCancel(runCtx)
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(runCtx))
+	b.cancelRun, b.cancelSync, b.cancelHandlers = cancelRun, cancelSync, cancelHandlers
+	b.syncDone = make(chan struct{})
+	syncer.ctx = runCtx
+	b.syncWait.Add(1)
+
+	go func() {
+		defer b.syncWait.Done()
+		defer close(b.syncDone)
+		defer cancelSync() // Stops the watchdog when syncing fails
+		b.runSync(syncCtx)
+	}()
+	if b.config.SyncStallTimeout >= 0 {
+		b.goBackground(func() { b.runSyncWatchdog(syncCtx) })
+	}
+
+	b.handlerWait.Add(1)
+	go func() {
+		defer b.handlerWait.Done()
+		b.runDispatcher(handlerCtx)
+	}()
+	if b.db != nil {
+		b.goBackground(func() { b.runRedactions(runCtx) })
+	}
+	if b.db != nil {
+		b.goBackground(func() { b.runOutbox(runCtx) })
+	}
+	if b.db != nil {
+		b.goBackground(func() { b.runScheduledSends(runCtx) })
+	}
+	b.goBackground(func() { b.runMenuCleanup(runCtx) })
+	if b.backupKey != nil {
+		b.goBackground(func() { b.runKeyBackup(runCtx) })
+	}
+	if b.recovery != nil {
+		b.goBackground(func() {
+			if b.waitFirstSync(runCtx) {
+				b.announceRecovery(runCtx)
+			}
+		})
+	}
+	if b.config.AutoLeaveAfter > 0 {
+		b.goBackground(func() { b.autoLeaveLoop(runCtx) })
+	}
+	b.startModules(runCtx)
+	b.runReports(runCtx)
+	b.runSchedules(runCtx)
+	b.goBackground(func() { b.runReady(runCtx) })
 
-	// Set up encryption
-	cryptoHelper, err := cryptohelper.NewCryptoHelper(b.client, []byte("meow"), b.config.Database)
+	// Wait for context cancellation or Stop
+	<-syncCtx.Done()
+	<-b.syncDone
+	b.drainHandlers()
+	return nil
+}
+
+// setupCrypto logs in and initializes end-to-end encryption.
+func (b *Bot) setupCrypto(ctx context.Context) error {
+	cryptoHelper, err := cryptohelper.NewCryptoHelper(b.client, pickleKey, b.store.CryptoStore())
 	if err != nil {
 		return fmt.Errorf("matrix: failed to create crypto helper: %w", err)
 	}
 
-	cryptoHelper.LoginAs = &mautrix.ReqLogin{
-		Type:             mautrix.AuthTypePassword,
-		Identifier:       mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: b.config.Username},
-		Password:         b.config.Password,
-		StoreCredentials: true,
+	// The crypto helper finds the device of an SQL crypto store and logs in
+	// with it; other stores remember their device in the key-value store.
+	sqlCrypto, isSQL := b.store.CryptoStore().(*crypto.SQLCryptoStore)
+	switch {
+	case b.config.AccessToken != "":
+		if err = b.useAccessToken(ctx); err != nil {
+			return err
+		}
+		if isSQL {
+			if err = checkStoredDevice(ctx, sqlCrypto, b.client.DeviceID); err != nil {
+				return err
+			}
+		}
+	case isSQL:
+		cryptoHelper.LoginAs = b.loginRequest()
+	default:
+		if err = b.login(ctx); err != nil {
+			return err
+		}
 	}
 
 	if err = cryptoHelper.Init(ctx); err != nil {
 		return fmt.Errorf("matrix: failed to init crypto: %w", err)
 	}
+	cryptoHelper.DecryptErrorCallback = b.handleDecryptError
 	b.crypto = cryptoHelper
 	b.client.Crypto = cryptoHelper
+	return nil
+}
 
-	b.log.Info().Str("user", b.config.Username).Msg("Matrix bot is running")
+// useAccessToken configures the client with an existing session, skipping the login flow.
+// The user ID, and the device ID if not configured, are resolved via /whoami.
+func (b *Bot) useAccessToken(ctx context.Context) error {
+	b.client.AccessToken = b.config.AccessToken
+	whoami, err := b.client.Whoami(ctx)
+	if err != nil {
+		return fmt.Errorf("matrix: access token rejected: %w", err)
+	}
+	b.client.UserID = whoami.UserID
+	b.client.DeviceID = id.DeviceID(b.config.DeviceID)
+	if b.client.DeviceID == "" {
+		b.client.DeviceID = whoami.DeviceID
+	}
+	if b.client.DeviceID == "" {
+		return fmt.Errorf("matrix: device ID is required with an access token")
+	}
+	return nil
+}
 
-	// Start syncing
-	syncCtx, cancelSync := context.WithCancel(ctx)
-	b.cancelSync = cancelSync
-	b.syncWait.Add(1)
+// loginRequest returns the password login for Config.Username.
+func (b *Bot) loginRequest() *mautrix.ReqLogin {
+	return &mautrix.ReqLogin{
+		Type:             mautrix.AuthTypePassword,
+		Identifier:       mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: b.config.Username},
+		Password:         b.config.Password,
+		StoreCredentials: true,
+	}
+}
 
-	go func() {
-		defer b.syncWait.Done()
-		if syncErr := b.client.SyncWithContext(syncCtx); syncErr != nil && !errors.Is(syncErr, context.Canceled) {
-			b.log.Error().Err(syncErr).Msg("Sync error")
-		}
-	}()
+// deviceKey is the key-value store key of the device used with non-SQL stores.
+const deviceKey = "matrix.device_id"
 
-	// Wait for context cancellation
-	<-syncCtx.Done()
+// login logs in with the password, reusing the device remembered in the store
+// so that it matches the stored crypto material.
+func (b *Bot) login(ctx context.Context) error {
+	deviceID, err := b.store.Get(ctx, deviceKey)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to load device ID: %w", err)
+	}
+	req := b.loginRequest()
+	req.DeviceID = id.DeviceID(deviceID)
+	resp, err := b.client.Login(ctx, req)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to log in: %w", err)
+	}
+	if err = b.store.Set(ctx, deviceKey, resp.DeviceID.String()); err != nil {
+		return fmt.Errorf("matrix: failed to save device ID: %w", err)
+	}
+	return nil
+}
+
+// checkStoredDevice makes sure an SQL crypto store belongs to deviceID and binds it to the device.
+func checkStoredDevice(ctx context.Context, store *crypto.SQLCryptoStore, deviceID id.DeviceID) error {
+	stored, err := store.FindDeviceID(ctx)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to find stored device ID: %w", err)
+	}
+	if stored != "" && stored != deviceID {
+		return fmt.Errorf("matrix: the database belongs to device %s, not %s", stored, deviceID)
+	}
+	store.DeviceID = deviceID
 	return nil
 }
 
-// Stop gracefully stops the bot.
+// Store returns the bot's store, e.g. to keep module data in its key-value store.
+// It is available once Run has started.
+func (b *Bot) Store() Store {
+	return b.store
+}
+
+// Stop gracefully stops the bot: it stops syncing, lets running handlers
+// finish and deliver their replies (for up to Config.ShutdownTimeout), and
+// then stops the background loops and modules.
+// With Config.LogoutOnStop the session is logged out as well, see Logout.
 func (b *Bot) Stop() error {
 	if b.cancelSync != nil {
 		b.cancelSync()
+		<-b.syncDone
+		b.drainHandlers()
+		b.cancelRun()
 	}
 	b.syncWait.Wait()
 
-	if b.crypto != nil {
-		return b.crypto.Close()
+	var errs []error
+	if b.config.LogoutOnStop {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		errs = append(errs, b.Logout(ctx))
+		cancel()
+	}
+	if closer, ok := b.store.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Logout invalidates the access token. The homeserver deletes the device along
+// with its encryption keys, so the bot can't be used afterwards.
+func (b *Bot) Logout(ctx context.Context) error {
+	if b.client == nil || b.client.AccessToken == "" {
+		return nil
+	}
+	if _, err := b.client.Logout(ctx); err != nil {
+		return fmt.Errorf("matrix: failed to log out: %w", err)
 	}
+	b.log.Info().Str("device_id", b.client.DeviceID.String()).Msg("Logged out and removed device")
+	b.client.AccessToken = ""
 	return nil
 }
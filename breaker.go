@@ -0,0 +1,228 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrHomeserverUnavailable is returned (wrapped) for homeserver requests while
+// the circuit breaker is open, see Config.BreakerThreshold. Messages sent
+// meanwhile are kept in the outbox if the bot has a database.
+var ErrHomeserverUnavailable = errors.New("matrix: homeserver unavailable, circuit breaker is open")
+
+const (
+	// DefaultBreakerThreshold is used when Config.BreakerThreshold is zero.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is used when Config.BreakerCooldown is zero.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// DegradedHandler is called when the circuit breaker opens, with the error of
+// the request that opened it.
+type DegradedHandler func(ctx context.Context, err error)
+
+// RecoveredHandler is called when the homeserver answers again after the
+// circuit breaker opened, with how long it was unavailable.
+type RecoveredHandler func(ctx context.Context, downFor time.Duration)
+
+// circuitBreaker tracks the health of the homeserver. After threshold
+// consecutive failures it opens: requests fail right away, except for one
+// probe per cooldown, whose success closes it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // Consecutive failed requests
+	openSince time.Time // Zero while closed
+	probeAt   time.Time // When the next probe may go through while open
+	probing   bool      // A probe request is in flight
+
+	degraded  []DegradedHandler
+	recovered []RecoveredHandler
+}
+
+func newCircuitBreaker(config Config) *circuitBreaker {
+	c := &circuitBreaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown}
+	if c.threshold == 0 {
+		c.threshold = DefaultBreakerThreshold
+	}
+	if c.cooldown <= 0 {
+		c.cooldown = DefaultBreakerCooldown
+	}
+	return c
+}
+
+// allow reports whether a request may be sent, and whether it is the probe.
+func (c *circuitBreaker) allow() (ok, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.openSince.IsZero() {
+		return true, false
+	}
+	if c.probing || time.Now().Before(c.probeAt) {
+		return false, false
+	}
+	c.probing = true
+	return true, true
+}
+
+// breakerFailure reports whether a request failed because the homeserver is
+// unreachable or overloaded, as opposed to rejecting the request itself.
+func breakerFailure(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// breakerTransport sends homeserver requests through the circuit breaker.
+type breakerTransport struct {
+	next http.RoundTripper
+	bot  *Bot
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.bot.breaker
+	ok, probe := c.allow()
+	if !ok {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, ErrHomeserverUnavailable
+	}
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case breakerFailure(req, resp, err):
+		cause := err
+		if cause == nil {
+			cause = errors.New(resp.Status)
+		}
+		t.bot.breakerFailed(req.Context(), cause)
+	case err == nil:
+		t.bot.breakerSucceeded(req.Context())
+	case probe:
+		// The probe was cancelled by its caller; let the next request probe.
+		c.mu.Lock()
+		c.probing = false
+		c.mu.Unlock()
+	}
+	return resp, err
+}
+
+// CloseIdleConnections forwards to the wrapped transport, see http.Client.CloseIdleConnections.
+func (t breakerTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// breakerClient wraps client so that homeserver requests go through the
+// circuit breaker. Negative thresholds disable it.
+func (b *Bot) breakerClient(client *http.Client) *http.Client {
+	if b.breaker.threshold < 0 {
+		return client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = breakerTransport{next: next, bot: b}
+	return &wrapped
+}
+
+// breakerFailed counts a failed request and opens the breaker at the threshold.
+func (b *Bot) breakerFailed(ctx context.Context, err error) {
+	c := b.breaker
+	c.mu.Lock()
+	c.failures++
+	c.probing = false
+	opened := c.openSince.IsZero() && c.failures >= c.threshold
+	if opened {
+		c.openSince = time.Now()
+	}
+	if !c.openSince.IsZero() {
+		c.probeAt = time.Now().Add(c.cooldown)
+	}
+	failures := c.failures
+	handlers := append([]DegradedHandler(nil), c.degraded...)
+	c.mu.Unlock()
+	if !opened {
+		return
+	}
+
+	b.log.Warn().Err(err).Int("failures", failures).Dur("retry_in", c.cooldown).
+		Msg("Homeserver unavailable, pausing requests")
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, handler := range handlers {
+			handler(ctx, err)
+		}
+	}()
+}
+
+// breakerSucceeded resets the failure count and closes an open breaker.
+func (b *Bot) breakerSucceeded(ctx context.Context) {
+	c := b.breaker
+	c.mu.Lock()
+	c.failures = 0
+	c.probing = false
+	openSince := c.openSince
+	c.openSince = time.Time{}
+	handlers := append([]RecoveredHandler(nil), c.recovered...)
+	c.mu.Unlock()
+	if openSince.IsZero() {
+		return
+	}
+
+	downFor := time.Since(openSince)
+	b.log.Info().Dur("down_for", downFor).Msg("Homeserver available again, resuming requests")
+	if b.db != nil {
+		// Deliver buffered messages now rather than after their backoff.
+		_, _ = b.db.Exec(context.WithoutCancel(ctx), "UPDATE bot_outbox SET next_attempt=$1", time.Now().UnixMilli())
+		b.wakeOutbox()
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, handler := range handlers {
+			handler(ctx, downFor)
+		}
+	}()
+}
+
+// OnDegraded registers a handler that is called when the circuit breaker
+// opens because the homeserver stopped answering, e.g. to mark the process
+// unhealthy. Handlers run in their own goroutine.
+func (b *Bot) OnDegraded(handler DegradedHandler) {
+	c := b.breaker
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.degraded = append(c.degraded, handler)
+}
+
+// OnRecovered registers a handler that is called when the homeserver answers
+// again after OnDegraded. Handlers run in their own goroutine.
+func (b *Bot) OnRecovered(handler RecoveredHandler) {
+	c := b.breaker
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recovered = append(c.recovered, handler)
+}
+
+// Degraded reports whether the circuit breaker is open, i.e. the homeserver
+// is considered unavailable and requests fail with ErrHomeserverUnavailable.
+func (b *Bot) Degraded() bool {
+	c := b.breaker
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.openSince.IsZero()
+}
@@ -0,0 +1,178 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// bundleVersion is the format version written to exported configuration bundles.
+const bundleVersion = 1
+
+// ConfigSection is a piece of configuration that is part of exported bundles,
+// e.g. per-room settings or a module's schedules and subscriptions.
+// Modules implementing ConfigSection are included automatically under their name.
+type ConfigSection interface {
+	// ExportConfig returns the current settings. The value is encoded as YAML.
+	ExportConfig(ctx context.Context) (any, error)
+	// ImportConfig replaces the settings with a previously exported value.
+	ImportConfig(ctx context.Context, value *yaml.Node) error
+}
+
+// bundle is the complete effective configuration of a bot, used to promote a
+// setup from staging to production.
+type bundle struct {
+	Version    int                  `yaml:"version"`
+	ExportedAt time.Time            `yaml:"exported_at"`
+	Config     fileConfig           `yaml:"config"`
+	Sections   map[string]yaml.Node `yaml:"sections,omitempty"`
+}
+
+// RegisterConfigSection adds a named section to exported bundles.
+// Registering the same name again replaces the section.
+func (b *Bot) RegisterConfigSection(name string, section ConfigSection) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.configSections == nil {
+		b.configSections = make(map[string]ConfigSection)
+	}
+	b.configSections[name] = section
+}
+
+// allConfigSections returns registered sections and modules implementing ConfigSection.
+func (b *Bot) allConfigSections() map[string]ConfigSection {
+	sections := make(map[string]ConfigSection)
+	for _, m := range b.Modules() {
+		if section, ok := m.(ConfigSection); ok {
+			sections[m.Name()] = section
+		}
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for name, section := range b.configSections {
+		sections[name] = section
+	}
+	return sections
+}
+
+// ExportConfig writes the effective configuration and all config sections as a
+// YAML bundle. Passwords, tokens and integration secrets are left out unless
+// includeSecrets is set.
+func (b *Bot) ExportConfig(ctx context.Context, w io.Writer, includeSecrets bool) error {
+	bundle := bundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Config:     newFileConfig(b.config),
+		Sections:   make(map[string]yaml.Node),
+	}
+	bundle.Config.Rules = b.Rules()
+	if !includeSecrets {
+		bundle.Config.redactSecrets()
+	}
+
+	sections := b.allConfigSections()
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := sections[name].ExportConfig(ctx)
+		if err != nil {
+			return fmt.Errorf("matrix: failed to export %s: %w", name, err)
+		}
+		var node yaml.Node
+		if err = node.Encode(value); err != nil {
+			return fmt.Errorf("matrix: failed to encode %s: %w", name, err)
+		}
+		bundle.Sections[name] = node
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&bundle); err != nil {
+		return fmt.Errorf("matrix: failed to write bundle: %w", err)
+	}
+	return enc.Close()
+}
+
+// ImportConfig reads a bundle written by ExportConfig and applies it: routing
+// rules and all known sections are replaced at runtime. Sections without a
+// registered counterpart are skipped with a warning.
+//
+// Connection settings can't change while running, so the bundle's Config is
+// returned for the caller to persist (with environment overrides applied).
+func (b *Bot) ImportConfig(ctx context.Context, r io.Reader) (Config, error) {
+	var bundle bundle
+	if err := yaml.NewDecoder(r).Decode(&bundle); err != nil {
+		return Config{}, fmt.Errorf("matrix: invalid bundle: %w", err)
+	}
+	if bundle.Version != bundleVersion {
+		return Config{}, fmt.Errorf("matrix: unsupported bundle version %d", bundle.Version)
+	}
+
+	sections := b.allConfigSections()
+	for name, node := range bundle.Sections {
+		section, ok := sections[name]
+		if !ok {
+			b.log.Warn().Str("section", name).Msg("Skipping unknown config section")
+			continue
+		}
+		if err := section.ImportConfig(ctx, &node); err != nil {
+			return Config{}, fmt.Errorf("matrix: failed to import %s: %w", name, err)
+		}
+	}
+	b.SetRules(bundle.Config.Rules)
+
+	config := bundle.Config.config()
+	config.applyEnvironment()
+	return config, nil
+}
+
+// redactSecrets clears credentials and integration and module settings that look
+// like secrets. Those must not be shared with the bot config, see newFileConfig.
+func (f *fileConfig) redactSecrets() {
+	f.Auth.Password = ""
+	f.Auth.AccessToken = ""
+	f.Auth.RecoveryKey = ""
+	f.DatabaseKey = ""
+	if proxyURL, err := url.Parse(f.ProxyURL); err == nil && proxyURL.User != nil {
+		proxyURL.User = nil
+		f.ProxyURL = proxyURL.String()
+	}
+	if dbURI, err := url.Parse(f.DatabaseURI); err == nil && dbURI.User != nil {
+		dbURI.User = url.User(dbURI.User.Username())
+		f.DatabaseURI = dbURI.String()
+	}
+	for _, settings := range f.Integrations {
+		for key := range settings {
+			if isSecretKey(key) {
+				settings[key] = ""
+			}
+		}
+	}
+	for _, section := range f.Modules {
+		for key := range section {
+			if isSecretKey(key) {
+				section[key] = ""
+			}
+		}
+	}
+}
+
+// isSecretKey reports whether an integration setting holds a credential.
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"token", "pass", "secret", "key"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a small TTL cache for integration reads, so repeated listings like
+// !repos or !projects within a few minutes don't hammer upstream APIs.
+// Entries live in memory and, if a CacheStore is set, survive restarts.
+type Cache struct {
+	ttl   time.Duration
+	store CacheStore
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// CacheStore persists cache entries as JSON. Implementations must be safe for concurrent use.
+type CacheStore interface {
+	Load(ctx context.Context, key string) (data []byte, expires time.Time, ok bool, err error)
+	Save(ctx context.Context, key string, data []byte, expires time.Time) error
+	Delete(ctx context.Context, prefix string) error
+}
+
+// NewCache creates a cache whose entries expire after ttl. store may be nil for a memory-only cache.
+func NewCache(ttl time.Duration, store CacheStore) *Cache {
+	return &Cache{ttl: ttl, store: store, entries: make(map[string]cacheEntry)}
+}
+
+// Cached returns the cached value for key, or calls fetch and caches its result.
+// Errors are not cached. With a store, T must round-trip through JSON.
+//
+//	repos, err := matrix.Cached(ctx, cache, "gitea:repos", func(ctx context.Context) ([]*Repo, error) {
+//	    return git.GetAllRepos(owner)
+//	})
+func Cached[T any](ctx context.Context, c *Cache, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if value, ok := entry.value.(T); ok {
+			return value, nil
+		}
+	}
+
+	if c.store != nil {
+		data, expires, ok, err := c.store.Load(ctx, key)
+		if err == nil && ok && now.Before(expires) {
+			var value T
+			if json.Unmarshal(data, &value) == nil {
+				c.set(key, value, expires)
+				return value, nil
+			}
+		}
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return value, err
+	}
+	expires := now.Add(c.ttl)
+	c.set(key, value, expires)
+	if c.store != nil {
+		if data, err := json.Marshal(value); err == nil {
+			_ = c.store.Save(ctx, key, data, expires)
+		}
+	}
+	return value, nil
+}
+
+func (c *Cache) set(key string, value any, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{value: value, expires: expires}
+}
+
+// Invalidate drops all entries whose key starts with prefix; an empty prefix
+// clears the whole cache. Use it for a !refresh command.
+func (c *Cache) Invalidate(ctx context.Context, prefix string) error {
+	c.mu.Lock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.store != nil {
+		return c.store.Delete(ctx, prefix)
+	}
+	return nil
+}
+
+// FileCacheStore is a CacheStore keeping all entries in a single JSON file.
+// Suited for the handful of listings a bot caches, not for large data sets.
+type FileCacheStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileCacheStore creates a store backed by the JSON file at path.
+// The file is created on the first save.
+func NewFileCacheStore(path string) *FileCacheStore {
+	return &FileCacheStore{path: path}
+}
+
+type fileCacheEntry struct {
+	Data    json.RawMessage `json:"data"`
+	Expires time.Time       `json:"expires"`
+}
+
+// Load implements CacheStore.
+func (s *FileCacheStore) Load(_ context.Context, key string) ([]byte, time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	entry, ok := entries[key]
+	return entry.Data, entry.Expires, ok, nil
+}
+
+// Save implements CacheStore. Expired entries are pruned on every save.
+func (s *FileCacheStore) Save(_ context.Context, key string, data []byte, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for k, entry := range entries {
+		if !now.Before(entry.Expires) {
+			delete(entries, k)
+		}
+	}
+	entries[key] = fileCacheEntry{Data: data, Expires: expires}
+	return s.write(entries)
+}
+
+// Delete implements CacheStore.
+func (s *FileCacheStore) Delete(_ context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	for key := range entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(entries, key)
+		}
+	}
+	return s.write(entries)
+}
+
+func (s *FileCacheStore) read() (map[string]fileCacheEntry, error) {
+	entries := make(map[string]fileCacheEntry)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read cache: %w", err)
+	}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("matrix: invalid cache file %s: %w", s.path, err)
+	}
+	return entries, nil
+}
+
+func (s *FileCacheStore) write(entries map[string]fileCacheEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to encode cache: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("matrix: failed to write cache: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
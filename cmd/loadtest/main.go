@@ -0,0 +1,402 @@
+// Command loadtest measures how fast the bot's message pipeline keeps up with
+// incoming traffic. It runs a bot in-process against a local homeserver
+// (e.g. Dendrite or Conduit), has a second account create N rooms with the
+// bot and send a configurable volume of messages into them, and reports the
+// latency percentiles of the messages reaching the bot's handlers.
+//
+// Both accounts must exist and the sender should be exempt from rate limits:
+//
+//	go run -tags goolm ./cmd/loadtest \
+//	  -homeserver http://localhost:8008 \
+//	  -bot-user loadbot -bot-pass secret \
+//	  -sender-user loadsender -sender-pass secret \
+//	  -rooms 10 -messages 200 -rate 100 -e2ee -max-p99 2s
+//
+// Latency is measured from sending a message to its handler starting (sync,
+// decryption and dispatch), and separately the time it waited in the dispatch
+// queue. With -max-p99 the command exits with status 1 if the 99th percentile
+// exceeds the limit, so regressions can fail a release pipeline.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// bodyPrefix marks the generated messages; the body is "<prefix> <seq> <unix nanos>".
+const bodyPrefix = "loadtest"
+
+type options struct {
+	homeserver string
+	botUser    string
+	botPass    string
+	senderUser string
+	senderPass string
+	rooms      int
+	messages   int
+	rate       float64
+	e2ee       bool
+	timeout    time.Duration
+	maxP99     time.Duration
+}
+
+// results collects the measurements of the handler.
+type results struct {
+	mu        sync.Mutex
+	latencies []time.Duration // Send to handler start
+	queued    []time.Duration // Receive from sync to handler start
+	seen      map[int]bool
+	done      chan struct{}
+	expected  int
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.homeserver, "homeserver", "http://localhost:8008", "homeserver URL")
+	flag.StringVar(&opts.botUser, "bot-user", "", "username of the bot account")
+	flag.StringVar(&opts.botPass, "bot-pass", "", "password of the bot account")
+	flag.StringVar(&opts.senderUser, "sender-user", "", "username of the account sending the load")
+	flag.StringVar(&opts.senderPass, "sender-pass", "", "password of the sender account")
+	flag.IntVar(&opts.rooms, "rooms", 5, "number of rooms to spread the messages over")
+	flag.IntVar(&opts.messages, "messages", 100, "messages per room")
+	flag.Float64Var(&opts.rate, "rate", 50, "messages per second over all rooms (0 = as fast as possible)")
+	flag.BoolVar(&opts.e2ee, "e2ee", false, "use encrypted rooms")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "how long to wait for outstanding messages after the last send")
+	flag.DurationVar(&opts.maxP99, "max-p99", 0, "fail if the 99th percentile latency exceeds this (0 = no limit)")
+	flag.Parse()
+	if err := opts.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ok, err := run(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Load test failed: %v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// validate checks the flags.
+func (o *options) validate() error {
+	switch {
+	case o.botUser == "" || o.botPass == "" || o.senderUser == "" || o.senderPass == "":
+		return errors.New("-bot-user, -bot-pass, -sender-user and -sender-pass are required")
+	case o.rooms < 1 || o.messages < 1:
+		return errors.New("-rooms and -messages must be at least 1")
+	case o.rate < 0 || o.rate > 0 && o.interval() <= 0:
+		// Above a billion messages per second, the interval rounds to 0 and
+		// time.NewTicker panics
+		return fmt.Errorf("-rate must be between 0 and %d", int(time.Second))
+	}
+	return nil
+}
+
+// interval is the time between two messages at the rate.
+func (o *options) interval() time.Duration {
+	return time.Duration(float64(time.Second) / o.rate)
+}
+
+// run performs the load test and reports whether the results are within the limits.
+func run(ctx context.Context, opts options) (bool, error) {
+	total := opts.rooms * opts.messages
+	res := &results{seen: make(map[int]bool), done: make(chan struct{}), expected: total}
+
+	bot, err := matrix.NewBot(matrix.Config{
+		Homeserver: opts.homeserver,
+		Username:   opts.botUser,
+		Password:   opts.botPass,
+		Database:   matrix.MemoryDatabase,
+		SyncMode:   matrix.SyncLatest,
+		QueueLimit: total + 1,
+	})
+	if err != nil {
+		return false, err
+	}
+	bot.OnMessageContext(res.handle)
+	botCtx, stopBot := context.WithCancel(ctx)
+	botErr := make(chan error, 1)
+	go func() { botErr <- bot.Run(botCtx) }()
+	defer func() {
+		stopBot()
+		_ = bot.Stop()
+	}()
+
+	sender, err := newSender(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+	defer sender.close()
+
+	botID, err := waitForLogin(ctx, bot, botErr)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Creating %d rooms with %s...\n", opts.rooms, botID)
+	rooms, err := sender.createRooms(ctx, opts.rooms, botID, opts.e2ee)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Sending %d messages...\n", total)
+	start := time.Now()
+	var tick <-chan time.Time
+	if opts.rate > 0 {
+		ticker := time.NewTicker(opts.interval())
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	sendErrors := 0
+	for seq := 0; seq < total; seq++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		}
+		body := fmt.Sprintf("%s %d %d", bodyPrefix, seq, time.Now().UnixNano())
+		if _, err = sender.client.SendText(ctx, rooms[seq%len(rooms)], body); err != nil {
+			sendErrors++
+			res.expect(-1)
+		}
+	}
+	sendDuration := time.Since(start)
+
+	select {
+	case <-res.done:
+	case <-time.After(opts.timeout):
+	case <-ctx.Done():
+	}
+	return res.report(opts, total, sendErrors, sendDuration, bot.DispatchStats()), nil
+}
+
+// handle records the latency of a generated message.
+func (r *results) handle(_ context.Context, msg *matrix.MessageContext) {
+	fields := strings.Fields(msg.Message.Body)
+	if len(fields) != 3 || fields[0] != bodyPrefix {
+		return
+	}
+	seq, err1 := strconv.Atoi(fields[1])
+	sentNanos, err2 := strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen[seq] {
+		return
+	}
+	r.seen[seq] = true
+	r.latencies = append(r.latencies, now.Sub(time.Unix(0, sentNanos)))
+	r.queued = append(r.queued, now.Sub(msg.ReceivedAt))
+	r.checkDone()
+}
+
+// expect adjusts the number of expected messages, e.g. for failed sends.
+func (r *results) expect(delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expected += delta
+	r.checkDone()
+}
+
+// checkDone closes done once all expected messages arrived. r.mu must be held.
+func (r *results) checkDone() {
+	if len(r.latencies) == r.expected {
+		select {
+		case <-r.done:
+		default:
+			close(r.done)
+		}
+	}
+}
+
+// report prints the results and reports whether they are within the limits.
+func (r *results) report(opts options, total, sendErrors int, sendDuration time.Duration, stats matrix.DispatchStats) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	received := len(r.latencies)
+	fmt.Printf("\nRooms: %d, encrypted: %v\n", opts.rooms, opts.e2ee)
+	fmt.Printf("Sent: %d in %s (%.1f msg/s), send errors: %d\n",
+		total-sendErrors, sendDuration.Round(time.Millisecond), float64(total-sendErrors)/sendDuration.Seconds(), sendErrors)
+	fmt.Printf("Handled: %d, missing: %d, shed by the dispatcher: %d\n",
+		received, total-sendErrors-received, stats.Dropped[0]+stats.Dropped[1]+stats.Dropped[2])
+	if received == 0 {
+		return false
+	}
+	fmt.Printf("\n%-22s %10s %10s %10s %10s\n", "", "p50", "p90", "p99", "max")
+	printPercentiles("Send to handler", r.latencies)
+	printPercentiles("Dispatch queue wait", r.queued)
+
+	ok := received == total-sendErrors
+	if opts.maxP99 > 0 {
+		if p99 := percentile(r.latencies, 99); p99 > opts.maxP99 {
+			fmt.Printf("\nFAIL: p99 latency %s exceeds %s\n", p99.Round(time.Millisecond), opts.maxP99)
+			ok = false
+		}
+	}
+	if !ok && received != total-sendErrors {
+		fmt.Println("\nFAIL: not all messages reached the handler")
+	}
+	return ok
+}
+
+func printPercentiles(label string, values []time.Duration) {
+	slices.Sort(values)
+	fmt.Printf("%-22s %10s %10s %10s %10s\n", label,
+		percentile(values, 50).Round(time.Millisecond),
+		percentile(values, 90).Round(time.Millisecond),
+		percentile(values, 99).Round(time.Millisecond),
+		values[len(values)-1].Round(time.Millisecond))
+}
+
+// percentile returns the p-th percentile of sorted values (nearest rank).
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// waitForLogin waits until the bot has logged in and returns its user ID.
+func waitForLogin(ctx context.Context, bot *matrix.Bot, botErr <-chan error) (id.UserID, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if userID := bot.UserID(); userID != "" {
+			return userID, nil
+		}
+		select {
+		case err := <-botErr:
+			return "", fmt.Errorf("bot stopped: %w", err)
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sender is the account generating the load.
+type sender struct {
+	client *mautrix.Client
+	crypto *cryptohelper.CryptoHelper
+	dir    string
+	cancel context.CancelFunc
+}
+
+// newSender logs in the sender account, with encryption if requested, and starts syncing it.
+func newSender(ctx context.Context, opts options) (*sender, error) {
+	client, err := mautrix.NewClient(opts.homeserver, "", "")
+	if err != nil {
+		return nil, err
+	}
+	s := &sender{client: client}
+	login := &mautrix.ReqLogin{
+		Type:             mautrix.AuthTypePassword,
+		Identifier:       mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: opts.senderUser},
+		Password:         opts.senderPass,
+		StoreCredentials: true,
+	}
+	if opts.e2ee {
+		if s.dir, err = os.MkdirTemp("", "loadtest"); err != nil {
+			return nil, err
+		}
+		s.crypto, err = cryptohelper.NewCryptoHelper(client, []byte("loadtest"), filepath.Join(s.dir, "sender.db"))
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.crypto.LoginAs = login
+		if err = s.crypto.Init(ctx); err != nil {
+			s.close()
+			return nil, fmt.Errorf("sender crypto setup: %w", err)
+		}
+		client.Crypto = s.crypto
+	} else {
+		client.StateStore = mautrix.NewMemoryStateStore()
+		if _, err = client.Login(ctx, login); err != nil {
+			return nil, fmt.Errorf("sender login: %w", err)
+		}
+	}
+	client.Syncer.(*mautrix.DefaultSyncer).OnEvent(client.StateStoreSyncHandler)
+
+	syncCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	go func() { _ = client.SyncWithContext(syncCtx) }()
+	return s, nil
+}
+
+// createRooms creates rooms with the bot and waits until it has joined them all.
+func (s *sender) createRooms(ctx context.Context, n int, botID id.UserID, encrypted bool) ([]id.RoomID, error) {
+	rooms := make([]id.RoomID, 0, n)
+	for i := 0; i < n; i++ {
+		req := &mautrix.ReqCreateRoom{
+			Name:   fmt.Sprintf("Load test %d", i+1),
+			Preset: "private_chat",
+			Invite: []id.UserID{botID},
+		}
+		if encrypted {
+			req.InitialState = []*event.Event{{
+				Type:    event.StateEncryption,
+				Content: event.Content{Parsed: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}},
+			}}
+		}
+		resp, err := s.client.CreateRoom(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("create room: %w", err)
+		}
+		rooms = append(rooms, resp.RoomID)
+	}
+
+	// The sender's state store must see the bot as a member, so that room
+	// keys are shared with it.
+	deadline := time.Now().Add(time.Minute)
+	for _, roomID := range rooms {
+		for !s.client.StateStore.IsMembership(ctx, roomID, botID, event.MembershipJoin) {
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("bot didn't join %s", roomID)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+	}
+	return rooms, nil
+}
+
+func (s *sender) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.crypto != nil {
+		_ = s.crypto.Close()
+	}
+	if s.dir != "" {
+		_ = os.RemoveAll(s.dir)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestOptionsValidate(t *testing.T) {
+	valid := options{botUser: "bot", botPass: "pass", senderUser: "sender", senderPass: "pass", rooms: 5, messages: 100, rate: 50}
+	tests := []struct {
+		name   string
+		modify func(*options)
+		ok     bool
+	}{
+		{"valid", func(o *options) {}, true},
+		{"unlimited rate", func(o *options) { o.rate = 0 }, true},
+		{"highest rate", func(o *options) { o.rate = 1e9 }, true},
+		{"rate too high", func(o *options) { o.rate = 3e9 }, false},
+		{"negative rate", func(o *options) { o.rate = -1 }, false},
+		{"no rooms", func(o *options) { o.rooms = 0 }, false},
+		{"no messages", func(o *options) { o.messages = 0 }, false},
+		{"no sender", func(o *options) { o.senderUser = "" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := valid
+			tt.modify(&opts)
+			if err := opts.validate(); (err == nil) != tt.ok {
+				t.Errorf("validate() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package matrix
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+)
+
+// DefaultCommandPrefix is used when Config.CommandPrefix is empty.
+const DefaultCommandPrefix = "!"
+
+// CommandHandler handles a single command invocation.
+type CommandHandler func(ctx context.Context, cmd *CommandContext)
+
+// CommandContext describes a parsed command invocation.
+// The embedded MessageContext gives access to the raw event and reply helpers.
+type CommandContext struct {
+	*MessageContext
+	Name    string // Command name without prefix, lowercased; the real name when run by an alias
+	Args    string // Everything after the command name, trimmed
+	Command *Command
+}
+
+// Fields splits the arguments on whitespace.
+func (c *CommandContext) Fields() []string {
+	return strings.Fields(c.Args)
+}
+
+// Command is a registered bot command. Configure it with the chainable methods
+// returned by Bot.Command.
+type Command struct {
+	Name        string // Name without prefix, e.g. "meet"
+	Description string
+	Usage       string   // e.g. "!meet <when> <duration> <title>"
+	Aliases     []string // Other names, e.g. "i" for "issues", see Alias
+	Module      string   // Name of the module that registered the command, if any
+	Priority    Priority
+	AlwaysOn    bool          // Can't be muted per room, see Bot.MuteCommand
+	Timeout     time.Duration // Overrides Config.HandlerTimeout; negative disables it
+	Access      Access        // Who may run the command, see RequireAdmin
+	Limit       RateLimit     // How often it may run, see WithCooldown
+	Examples    []string      // Shown by "!help <name>", see WithExamples
+	Flags       []CommandFlag // Documented options, see WithFlag
+	Tool        bool          // Language models may run it, see AsTool
+	Handler     CommandHandler
+}
+
+// Describe sets the help text of the command.
+func (c *Command) Describe(description, usage string) *Command {
+	c.Description = description
+	c.Usage = usage
+	return c
+}
+
+// WithPriority sets the dispatch lane of the command, e.g. PriorityControl
+// for commands that must stay responsive under load. Priorities outside the
+// lanes are clamped to PriorityPassive or PriorityControl.
+func (c *Command) WithPriority(p Priority) *Command {
+	c.Priority = p.clamp()
+	return c
+}
+
+// WithTimeout sets how long the command may run before its context is
+// cancelled, overriding Config.HandlerTimeout, e.g. longer for slow AI
+// generations. A negative timeout lets the command run indefinitely.
+func (c *Command) WithTimeout(timeout time.Duration) *Command {
+	c.Timeout = timeout
+	return c
+}
+
+// Unmutable keeps the command available in rooms that muted it or its
+// module, e.g. for the command that unmutes commands.
+func (c *Command) Unmutable() *Command {
+	c.AlwaysOn = true
+	return c
+}
+
+// Command registers a command handler, e.g. bot.Command("ping", handler) for "!ping".
+// Commands registered from a module's Init are attributed to that module.
+// Registering the same name again replaces the previous command. The first
+// command also registers the built-in !help, unless Config.DisableHelp is set;
+// registering "help" replaces it.
+func (b *Bot) Command(name string, handler CommandHandler) *Command {
+	cmd := &Command{
+		Name:     strings.ToLower(strings.TrimPrefix(name, b.commandPrefix())),
+		Handler:  handler,
+		Module:   b.initModule,
+		Priority: PriorityInteractive,
+	}
+
+	b.mu.Lock()
+	first := b.commands == nil
+	if first {
+		b.commands = make(map[string]*Command)
+	}
+	b.commands[cmd.Name] = cmd
+	b.mu.Unlock()
+
+	if first {
+		b.commandHandler = len(b.handlers)
+		b.OnMessageContext(b.dispatchCommand)
+		if !b.config.DisableHelp {
+			b.registerHelp()
+		}
+	}
+	return cmd
+}
+
+// Commands returns all registered commands.
+func (b *Bot) Commands() []*Command {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	commands := make([]*Command, 0, len(b.commands))
+	for _, cmd := range b.commands {
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+// lookupCommand returns a registered command by name.
+func (b *Bot) lookupCommand(name string) *Command {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.commands[name]
+}
+
+func (b *Bot) commandPrefix() string {
+	if b.config.CommandPrefix != "" {
+		return b.config.CommandPrefix
+	}
+	return DefaultCommandPrefix
+}
+
+// parseCommand splits a message body into command name and arguments.
+// ok is false if the body doesn't start with one of the prefixes; the
+// longest matching prefix is used.
+func parseCommand(prefixes []string, body string) (name, args string, ok bool) {
+	body = strings.TrimSpace(body)
+	for _, prefix := range longestFirst(prefixes) {
+		if prefix == "" || !strings.HasPrefix(body, prefix) || len(body) == len(prefix) {
+			continue
+		}
+		name, args = splitCommand(body[len(prefix):])
+		return name, args, true
+	}
+	return "", "", false
+}
+
+// splitCommand splits the text after a prefix into the lowercased command
+// name and the trimmed arguments.
+func splitCommand(text string) (name, args string) {
+	parts := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	name = strings.ToLower(parts[0])
+	if len(parts) > 1 {
+		args = strings.TrimSpace(parts[1])
+	}
+	return name, args
+}
+
+// dispatchCommand is the message handler that routes commands to their handlers.
+// Messages that aren't commands, and unknown commands, are ignored so
+// applications can keep handling them with their own OnMessage handlers.
+func (b *Bot) dispatchCommand(ctx context.Context, msg *MessageContext) {
+	if (msg.Message.MsgType != event.MsgText && msg.Message.MsgType != event.MsgNotice) || msg.IsEdit() {
+		return
+	}
+	name, args, ok := b.parseMessageCommand(ctx, msg)
+	if !ok {
+		return
+	}
+	cmd := b.resolveCommand(ctx, msg.RoomID, name)
+	if cmd == nil {
+		if cmd = b.unknownCommand(ctx, msg, name); cmd == nil {
+			return
+		}
+	}
+	name = cmd.Name
+	if b.commandMuted(ctx, msg.RoomID, cmd) {
+		msg.Log.Debug().Str("command", name).Msg("Ignoring command muted in this room")
+		return
+	}
+	if !b.commandAvailable(msg.RoomID, cmd) {
+		msg.Log.Debug().Str("command", name).Msg("Ignoring command that isn't available in this room")
+		return
+	}
+	if d := b.denyReason(ctx, cmd, msg.RoomID, msg.Sender); d != nil {
+		msg.Log.Info().Str("command", name).Str("reason", d.text(b, DefaultLanguage)).Msg("Denied command")
+		language := b.Language(ctx, msg.RoomID)
+		if err := msg.Reply(ctx, b.Translate(language, "command.denied", d.text(b, language))); err != nil {
+			msg.Log.Warn().Err(err).Msg("Failed to reply to denied command")
+		}
+		return
+	}
+	if !slices.Contains(b.config.Admins, msg.Sender) {
+		wait, notify := b.cooldowns.take(cmd.Name, b.commandLimit(cmd), msg.RoomID, msg.Sender, time.Now())
+		if wait > 0 {
+			msg.Log.Debug().Str("command", name).Dur("wait", wait).Msg("Ignoring rate-limited command")
+			if notify {
+				reply := b.T(ctx, msg.RoomID, "command.cooldown", b.CommandPrefixes(ctx, msg.RoomID)[0]+name, formatWait(wait))
+				if err := msg.Reply(ctx, reply); err != nil {
+					msg.Log.Warn().Err(err).Msg("Failed to reply to rate-limited command")
+				}
+			}
+			return
+		}
+	}
+
+	msg.Log.Debug().Str("command", name).Msg("Handling command")
+	b.audit(ctx, AuditEntry{
+		Direction: AuditInbound,
+		RoomID:    msg.RoomID,
+		EventID:   msg.EventID(),
+		Sender:    msg.Sender,
+		Command:   name,
+		Body:      msg.Message.Body,
+		Time:      msg.ReceivedAt,
+	})
+	timeout := b.config.HandlerTimeout
+	if cmd.Timeout != 0 {
+		timeout = cmd.Timeout
+	}
+	b.timedHandle(ctx, msg, timeout, true, func(ctx context.Context, msg *MessageContext) {
+		cmd.Handler(ctx, &CommandContext{
+			MessageContext: msg,
+			Name:           name,
+			Args:           args,
+			Command:        cmd,
+		})
+	})
+}
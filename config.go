@@ -0,0 +1,393 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// fileConfig is the on-disk layout read by LoadConfig:
+//
+//	homeserver: https://matrix.example.com
+//	auth:
+//	  username: mybot
+//	  password: secret         # or access_token + device_id
+//	database: matrix-bot.db     # or database_uri: postgres://...
+//	proxy_url: socks5h://127.0.0.1:9050
+//	logging:
+//	  debug: false
+//	command_prefix: "!"
+//	allowed:
+//	  rooms: ["!ops:example.com"]
+//	  users: ["@alice:example.com"]
+//	auto_leave_days: 30
+//	sync_filter:                # see mautrix.Filter
+//	  room:
+//	    timeline: {limit: 20, lazy_load_members: true}
+//	integrations:
+//	  gitea:
+//	    url: https://gitea.example.com
+//	    token: ...
+//	modules:                   # see Configurable
+//	  maildigest:
+//	    max_items: 50
+//	rules:                     # see Rule
+//	  - match: {source: alertmanager, severity: critical}
+//	    rooms: ["#ops:example.com"]
+type fileConfig struct {
+	Homeserver string `yaml:"homeserver,omitempty" toml:"homeserver"`
+	Auth       struct {
+		Username    string `yaml:"username,omitempty" toml:"username"`
+		Password    string `yaml:"password,omitempty" toml:"password"`
+		AccessToken string `yaml:"access_token,omitempty" toml:"access_token"`
+		DeviceID    string `yaml:"device_id,omitempty" toml:"device_id"`
+		RecoveryKey string `yaml:"recovery_key,omitempty" toml:"recovery_key"`
+	} `yaml:"auth,omitempty" toml:"auth"`
+	Database    string `yaml:"database,omitempty" toml:"database"`
+	DatabaseURI string `yaml:"database_uri,omitempty" toml:"database_uri"`
+	DatabaseKey string `yaml:"database_key,omitempty" toml:"database_key"`
+	ProxyURL    string `yaml:"proxy_url,omitempty" toml:"proxy_url"`
+	Language    string `yaml:"language,omitempty" toml:"language"`
+	ReadOnly    bool   `yaml:"read_only,omitempty" toml:"read_only"`
+	Logging     struct {
+		Debug bool `yaml:"debug,omitempty" toml:"debug"`
+	} `yaml:"logging,omitempty" toml:"logging"`
+	CommandPrefix string `yaml:"command_prefix,omitempty" toml:"command_prefix"`
+	Allowed       struct {
+		Rooms []id.RoomID `yaml:"rooms,omitempty" toml:"rooms"`
+		Users []id.UserID `yaml:"users,omitempty" toml:"users"`
+	} `yaml:"allowed,omitempty" toml:"allowed"`
+	QueueLimit             int           `yaml:"queue_limit,omitempty" toml:"queue_limit"`
+	HandlerWorkers         int           `yaml:"handler_workers,omitempty" toml:"handler_workers"`
+	AnnounceSettingChanges bool          `yaml:"announce_setting_changes,omitempty" toml:"announce_setting_changes"`
+	AuditLog               bool          `yaml:"audit_log,omitempty" toml:"audit_log"`
+	Outbox                 bool          `yaml:"outbox,omitempty" toml:"outbox"`
+	MaxMessageLength       int           `yaml:"max_message_length,omitempty" toml:"max_message_length"`
+	MaxMessageParts        int           `yaml:"max_message_parts,omitempty" toml:"max_message_parts"`
+	AdminRoom              id.RoomID     `yaml:"admin_room,omitempty" toml:"admin_room"`
+	CryptoFallback         bool          `yaml:"crypto_fallback,omitempty" toml:"crypto_fallback"`
+	RecoverCorruptStore    bool          `yaml:"recover_corrupt_store,omitempty" toml:"recover_corrupt_store"`
+	AutoLeaveDays          int           `yaml:"auto_leave_days,omitempty" toml:"auto_leave_days"`
+	LogoutOnStop           bool          `yaml:"logout_on_stop,omitempty" toml:"logout_on_stop"`
+	MentionTrigger         bool          `yaml:"mention_trigger,omitempty" toml:"mention_trigger"`
+	SuggestCommands        bool          `yaml:"suggest_commands,omitempty" toml:"suggest_commands"`
+	AutoCorrectCommands    bool          `yaml:"auto_correct_commands,omitempty" toml:"auto_correct_commands"`
+	SyncMode               SyncMode      `yaml:"sync_mode,omitempty" toml:"sync_mode"`
+	IgnoreOwnMessages      *bool         `yaml:"ignore_own_messages,omitempty" toml:"ignore_own_messages"`
+	MessageCutoff          time.Duration `yaml:"message_cutoff,omitempty" toml:"message_cutoff"`
+	HandlerTimeout         time.Duration `yaml:"handler_timeout,omitempty" toml:"handler_timeout"`
+	DialogTimeout          time.Duration `yaml:"dialog_timeout,omitempty" toml:"dialog_timeout"`
+	ScheduleCatchUp        time.Duration `yaml:"schedule_catch_up,omitempty" toml:"schedule_catch_up"`
+	ShutdownTimeout        time.Duration `yaml:"shutdown_timeout,omitempty" toml:"shutdown_timeout"`
+	BreakerThreshold       int           `yaml:"breaker_threshold,omitempty" toml:"breaker_threshold"`
+	BreakerCooldown        time.Duration `yaml:"breaker_cooldown,omitempty" toml:"breaker_cooldown"`
+
+	Admins          []id.UserID                  `yaml:"admins,omitempty" toml:"admins"`
+	CommandAccess   map[string]Access            `yaml:"command_access,omitempty" toml:"command_access"`
+	CommandLimits   map[string]RateLimit         `yaml:"command_limits,omitempty" toml:"command_limits"`
+	CommandPrefixes []string                     `yaml:"command_prefixes,omitempty" toml:"command_prefixes"`
+	CommandAliases  map[string]string            `yaml:"command_aliases,omitempty" toml:"command_aliases"`
+	Catalogs        map[string]Catalog           `yaml:"catalogs,omitempty" toml:"catalogs"`
+	Integrations    map[string]map[string]string `yaml:"integrations,omitempty" toml:"integrations"`
+	Modules         map[string]map[string]any    `yaml:"modules,omitempty" toml:"modules"`
+	DisabledModules []string                     `yaml:"disabled_modules,omitempty" toml:"disabled_modules"`
+	FeatureFlags    map[string]FeatureFlag       `yaml:"feature_flags,omitempty" toml:"feature_flags"`
+	SyncFilter      map[string]any               `yaml:"sync_filter,omitempty" toml:"sync_filter"` // See mautrix.Filter
+	Rules           []Rule                       `yaml:"rules,omitempty" toml:"rules"`
+}
+
+// LoadConfig reads a YAML (.yaml, .yml) or TOML (.toml) config file.
+// Environment variables take precedence over the file, so secrets can stay
+// out of it: the MATRIX_* variables of GetEnvironmentConfig override the Matrix
+// settings, and <INTEGRATION>_<KEY> (e.g. GITEA_TOKEN) overrides integrations.gitea.token.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("matrix: failed to read config: %w", err)
+	}
+
+	var file fileConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	case ".toml":
+		err = toml.Unmarshal(data, &file)
+	default:
+		return Config{}, fmt.Errorf("matrix: unsupported config format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("matrix: invalid config %s: %w", path, err)
+	}
+
+	config := file.config()
+	if config.SyncFilter, err = decodeSyncFilter(file.SyncFilter); err != nil {
+		return Config{}, fmt.Errorf("matrix: invalid sync_filter in %s: %w", path, err)
+	}
+	config.applyEnvironment()
+	return config, nil
+}
+
+// config converts the file layout to a Config, applying defaults.
+func (f fileConfig) config() Config {
+	config := Config{
+		Homeserver:             f.Homeserver,
+		Username:               f.Auth.Username,
+		Password:               f.Auth.Password,
+		AccessToken:            f.Auth.AccessToken,
+		DeviceID:               f.Auth.DeviceID,
+		RecoveryKey:            f.Auth.RecoveryKey,
+		Database:               f.Database,
+		DatabaseURI:            f.DatabaseURI,
+		DatabaseKey:            f.DatabaseKey,
+		ProxyURL:               f.ProxyURL,
+		Debug:                  f.Logging.Debug,
+		CommandPrefix:          f.CommandPrefix,
+		AllowedRooms:           f.Allowed.Rooms,
+		AllowedUsers:           f.Allowed.Users,
+		Admins:                 f.Admins,
+		CommandAccess:          f.CommandAccess,
+		CommandLimits:          f.CommandLimits,
+		CommandPrefixes:        f.CommandPrefixes,
+		CommandAliases:         f.CommandAliases,
+		Language:               f.Language,
+		Catalogs:               f.Catalogs,
+		QueueLimit:             f.QueueLimit,
+		HandlerWorkers:         f.HandlerWorkers,
+		AnnounceSettingChanges: f.AnnounceSettingChanges,
+		AuditLog:               f.AuditLog,
+		Outbox:                 f.Outbox,
+		MaxMessageLength:       f.MaxMessageLength,
+		MaxMessageParts:        f.MaxMessageParts,
+		AdminRoom:              f.AdminRoom,
+		CryptoFallback:         f.CryptoFallback,
+		RecoverCorruptStore:    f.RecoverCorruptStore,
+		LogoutOnStop:           f.LogoutOnStop,
+		MentionTrigger:         f.MentionTrigger,
+		SuggestCommands:        f.SuggestCommands,
+		AutoCorrectCommands:    f.AutoCorrectCommands,
+		SyncMode:               f.SyncMode,
+		IgnoreOwnMessages:      f.IgnoreOwnMessages,
+		MessageCutoff:          f.MessageCutoff,
+		HandlerTimeout:         f.HandlerTimeout,
+		DialogTimeout:          f.DialogTimeout,
+		ScheduleCatchUp:        f.ScheduleCatchUp,
+		ShutdownTimeout:        f.ShutdownTimeout,
+		BreakerThreshold:       f.BreakerThreshold,
+		BreakerCooldown:        f.BreakerCooldown,
+		Integrations:           f.Integrations,
+		Modules:                f.Modules,
+		DisabledModules:        f.DisabledModules,
+		FeatureFlags:           f.FeatureFlags,
+		Rules:                  f.Rules,
+	}
+	if f.AutoLeaveDays > 0 {
+		config.AutoLeaveAfter = time.Duration(f.AutoLeaveDays) * 24 * time.Hour
+	}
+	if config.Database == "" {
+		config.Database = "matrix-bot.db"
+	}
+	return config
+}
+
+// newFileConfig converts a Config to the file layout. Integration and module settings are copied.
+func newFileConfig(c Config) fileConfig {
+	var f fileConfig
+	f.Homeserver = c.Homeserver
+	f.Auth.Username = c.Username
+	f.Auth.Password = c.Password
+	f.Auth.AccessToken = c.AccessToken
+	f.Auth.DeviceID = c.DeviceID
+	f.Auth.RecoveryKey = c.RecoveryKey
+	f.Database = c.Database
+	f.DatabaseURI = c.DatabaseURI
+	f.DatabaseKey = c.DatabaseKey
+	f.ProxyURL = c.ProxyURL
+	f.ReadOnly = c.ReadOnly
+	f.Logging.Debug = c.Debug
+	f.CommandPrefix = c.CommandPrefix
+	f.Allowed.Rooms = c.AllowedRooms
+	f.Allowed.Users = c.AllowedUsers
+	f.Admins = c.Admins
+	f.CommandAccess = maps.Clone(c.CommandAccess)
+	f.CommandLimits = maps.Clone(c.CommandLimits)
+	f.CommandPrefixes = c.CommandPrefixes
+	f.CommandAliases = maps.Clone(c.CommandAliases)
+	f.Language = c.Language
+	f.Catalogs = maps.Clone(c.Catalogs)
+	f.QueueLimit = c.QueueLimit
+	f.HandlerWorkers = c.HandlerWorkers
+	f.AnnounceSettingChanges = c.AnnounceSettingChanges
+	f.AuditLog = c.AuditLog
+	f.Outbox = c.Outbox
+	f.MaxMessageLength = c.MaxMessageLength
+	f.MaxMessageParts = c.MaxMessageParts
+	f.AdminRoom = c.AdminRoom
+	f.CryptoFallback = c.CryptoFallback
+	f.RecoverCorruptStore = c.RecoverCorruptStore
+	f.AutoLeaveDays = int(c.AutoLeaveAfter / (24 * time.Hour))
+	f.LogoutOnStop = c.LogoutOnStop
+	f.MentionTrigger = c.MentionTrigger
+	f.SuggestCommands = c.SuggestCommands
+	f.AutoCorrectCommands = c.AutoCorrectCommands
+	f.SyncMode = c.SyncMode
+	f.IgnoreOwnMessages = c.IgnoreOwnMessages
+	f.MessageCutoff = c.MessageCutoff
+	f.HandlerTimeout = c.HandlerTimeout
+	f.DialogTimeout = c.DialogTimeout
+	f.ScheduleCatchUp = c.ScheduleCatchUp
+	f.ShutdownTimeout = c.ShutdownTimeout
+	f.BreakerThreshold = c.BreakerThreshold
+	f.BreakerCooldown = c.BreakerCooldown
+	f.SyncFilter = encodeSyncFilter(c.SyncFilter)
+	f.Rules = c.Rules
+	if c.Integrations != nil {
+		f.Integrations = make(map[string]map[string]string, len(c.Integrations))
+		for name, settings := range c.Integrations {
+			f.Integrations[name] = maps.Clone(settings)
+		}
+	}
+	f.DisabledModules = c.DisabledModules
+	f.FeatureFlags = maps.Clone(c.FeatureFlags)
+	if c.Modules != nil {
+		f.Modules = make(map[string]map[string]any, len(c.Modules))
+		for name, section := range c.Modules {
+			f.Modules[name] = maps.Clone(section)
+		}
+	}
+	return f
+}
+
+// decodeSyncFilter converts the sync_filter section to a filter; nil stays nil.
+func decodeSyncFilter(section map[string]any) (*mautrix.Filter, error) {
+	if section == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(section)
+	if err != nil {
+		return nil, err
+	}
+	var filter mautrix.Filter
+	if err = json.Unmarshal(data, &filter); err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// encodeSyncFilter converts a filter to the sync_filter section.
+func encodeSyncFilter(filter *mautrix.Filter) map[string]any {
+	if filter == nil {
+		return nil
+	}
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return nil
+	}
+	section := make(map[string]any)
+	if err = json.Unmarshal(data, &section); err != nil {
+		return nil
+	}
+	return section
+}
+
+// applyEnvironment overrides fields with the environment variables that are set.
+func (c *Config) applyEnvironment() {
+	setString := func(field *string, key string) {
+		if value := os.Getenv(key); value != "" {
+			*field = value
+		}
+	}
+	setString(&c.Homeserver, "MATRIX_API_URL")
+	setString(&c.Username, "MATRIX_API_USER")
+	setString(&c.Password, "MATRIX_API_PASS")
+	setString(&c.AccessToken, "MATRIX_API_TOKEN")
+	setString(&c.DeviceID, "MATRIX_API_DEVICE_ID")
+	setString(&c.RecoveryKey, "MATRIX_RECOVERY_KEY")
+	setString(&c.DatabaseURI, "MATRIX_DATABASE_URI")
+	setString(&c.DatabaseKey, "MATRIX_DATABASE_KEY")
+	setString(&c.ProxyURL, "MATRIX_PROXY_URL")
+	setString(&c.Language, "MATRIX_LANGUAGE")
+
+	if value := os.Getenv("MATRIX_DEBUG"); value != "" {
+		c.Debug = value == "true"
+	}
+	if value := os.Getenv("MATRIX_READ_ONLY"); value != "" {
+		c.ReadOnly = value == "true"
+	}
+	if value := os.Getenv("MATRIX_LOGOUT_ON_STOP"); value != "" {
+		c.LogoutOnStop = value == "true"
+	}
+	if value := os.Getenv("MATRIX_MENTION_TRIGGER"); value != "" {
+		c.MentionTrigger = value == "true"
+	}
+	if value := os.Getenv("MATRIX_ADMIN_ROOM"); value != "" {
+		c.AdminRoom = id.RoomID(value)
+	}
+	if value := os.Getenv("MATRIX_OUTBOX"); value != "" {
+		c.Outbox = value == "true"
+	}
+	if length := envInt("MATRIX_MAX_MESSAGE_LENGTH"); length > 0 {
+		c.MaxMessageLength = length
+	}
+	if parts := envInt("MATRIX_MAX_MESSAGE_PARTS"); parts > 0 {
+		c.MaxMessageParts = parts
+	}
+	if value := os.Getenv("MATRIX_CRYPTO_FALLBACK"); value != "" {
+		c.CryptoFallback = value == "true"
+	}
+	if value := os.Getenv("MATRIX_RECOVER_CORRUPT_STORE"); value != "" {
+		c.RecoverCorruptStore = value == "true"
+	}
+	if admins := envUsers("MATRIX_ADMINS"); admins != nil {
+		c.Admins = admins
+	}
+	if modules := envList("MATRIX_DISABLED_MODULES"); modules != nil {
+		c.DisabledModules = modules
+	}
+	if timeout := envDuration("MATRIX_HANDLER_TIMEOUT"); timeout > 0 {
+		c.HandlerTimeout = timeout
+	}
+	if os.Getenv("MATRIX_MESSAGE_CUTOFF") != "" {
+		c.MessageCutoff = envDuration("MATRIX_MESSAGE_CUTOFF")
+	}
+	if ignore := envFlag("MATRIX_IGNORE_OWN_MESSAGES"); ignore != nil {
+		c.IgnoreOwnMessages = ignore
+	}
+	if value := os.Getenv("MATRIX_SYNC_MODE"); value != "" {
+		c.SyncMode = SyncMode(value)
+	}
+	if days := envDays("MATRIX_AUTO_LEAVE_DAYS"); days > 0 {
+		c.AutoLeaveAfter = days
+	}
+
+	for name, settings := range c.Integrations {
+		for key := range settings {
+			if value := os.Getenv(envKey(name, key)); value != "" {
+				settings[key] = value
+			}
+		}
+	}
+}
+
+// envKey returns the environment variable overriding an integration setting,
+// e.g. ("gitea", "token") -> "GITEA_TOKEN".
+func envKey(name, key string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name + "_" + key))
+}
+
+// Integration returns the settings of an integration, e.g. config.Integration("gitea")["url"].
+// The result is never nil.
+func (c Config) Integration(name string) map[string]string {
+	if settings, ok := c.Integrations[name]; ok {
+		return settings
+	}
+	return map[string]string{}
+}
@@ -0,0 +1,173 @@
+package matrix
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// MessageContextHandler is the rich alternative to MessageHandler.
+// New fields are added to MessageContext instead of changing the signature.
+type MessageContextHandler func(ctx context.Context, msg *MessageContext)
+
+// MessageContext describes an incoming message and offers helpers to respond to it.
+type MessageContext struct {
+	Bot     *Bot
+	Event   *event.Event // Raw (decrypted) event
+	RoomID  id.RoomID
+	Sender  id.UserID
+	Message *event.MessageEventContent
+	Log     zerolog.Logger // Logger with room, sender and event ID fields
+
+	Priority Priority // Dispatch lane the message was queued in
+
+	SentAt     time.Time // origin_server_ts, as claimed by the sender's homeserver
+	ReceivedAt time.Time // When the bot received the message from sync
+
+	// Reaction is set when the message was invoked by a reaction on an action
+	// card, see SendActionCard. Event is then the card, sent by the reactor.
+	Reaction *event.Event
+
+	capture *capturedOutput // Collects the responses instead of sending them, see RunCommand
+}
+
+// newMessageContext builds the context for a message event.
+func (b *Bot) newMessageContext(evt *event.Event) *MessageContext {
+	return &MessageContext{
+		Bot:     b,
+		Event:   evt,
+		RoomID:  evt.RoomID,
+		Sender:  evt.Sender,
+		Message: evt.Content.AsMessage(),
+
+		SentAt:     time.UnixMilli(evt.Timestamp),
+		ReceivedAt: time.Now(),
+
+		Log: b.log.With().
+			Str("room_id", evt.RoomID.String()).
+			Str("sender", evt.Sender.String()).
+			Str("event_id", evt.ID.String()).
+			Logger(),
+	}
+}
+
+// FromSelf reports whether the bot sent the message itself. Such messages only
+// reach handlers if Config.IgnoreOwnMessages is false.
+func (m *MessageContext) FromSelf() bool {
+	return m.Sender == m.Bot.UserID()
+}
+
+// EventID returns the ID of the message.
+func (m *MessageContext) EventID() id.EventID {
+	return m.Event.ID
+}
+
+// Time returns when the message was sent, for scheduling relative to it
+// (e.g. "in 2h"). The sender's timestamp is used unless it lies further in
+// the future than Config.ClockSkewTolerance, as happens with federated servers
+// whose clocks run ahead; the receive time is used then.
+func (m *MessageContext) Time() time.Time {
+	if m.SentAt.After(m.ReceivedAt.Add(m.Bot.clockSkewTolerance())) {
+		return m.ReceivedAt
+	}
+	return m.SentAt
+}
+
+// OlderThan reports whether the message was sent more than age before it was
+// received. Config.ClockSkewTolerance is added to age, so fresh messages from
+// servers with clocks running behind aren't mistaken for historical ones.
+func (m *MessageContext) OlderThan(age time.Duration) bool {
+	return m.ReceivedAt.Sub(m.SentAt) > age+m.Bot.clockSkewTolerance()
+}
+
+// RelatesTo returns the relation of the message, or nil.
+func (m *MessageContext) RelatesTo() *event.RelatesTo {
+	return m.Message.OptionalGetRelatesTo()
+}
+
+// ThreadRoot returns the root event of the thread the message is in, or "".
+func (m *MessageContext) ThreadRoot() id.EventID {
+	return m.RelatesTo().GetThreadParent()
+}
+
+// InThread reports whether the message was sent in a thread.
+func (m *MessageContext) InThread() bool {
+	return m.ThreadRoot() != ""
+}
+
+// ReplyTo returns the event the message replies to, or "".
+func (m *MessageContext) ReplyTo() id.EventID {
+	return m.RelatesTo().GetNonFallbackReplyTo()
+}
+
+// IsEdit reports whether the message is an edit of an earlier message.
+func (m *MessageContext) IsEdit() bool {
+	return m.RelatesTo().GetReplaceID() != ""
+}
+
+// Room returns cached metadata of the room the message was sent in.
+func (m *MessageContext) Room() RoomInfo {
+	info, _ := m.Bot.RoomInfo(m.RoomID)
+	return info
+}
+
+// State reads a room state event into out, e.g.
+// msg.State(ctx, event.StatePowerLevels, "", &event.PowerLevelsEventContent{}).
+func (m *MessageContext) State(ctx context.Context, eventType event.Type, stateKey string, out any) error {
+	return m.Bot.client.StateEvent(ctx, m.RoomID, eventType, stateKey, out)
+}
+
+// FetchEvent loads an event of the room, e.g. the one a message replies to.
+// Encrypted events are decrypted.
+func (m *MessageContext) FetchEvent(ctx context.Context, eventID id.EventID) (*event.Event, error) {
+	return m.Bot.FetchEvent(ctx, m.RoomID, eventID)
+}
+
+// Reply sends a markdown reply to the message, mentioning the sender.
+// Replies to messages in a thread stay in that thread.
+func (m *MessageContext) Reply(ctx context.Context, md string) error {
+	_, err := m.Respond(ctx, md)
+	return err
+}
+
+// Respond is like Reply but returns the event ID of the response, e.g. to Edit it later.
+func (m *MessageContext) Respond(ctx context.Context, md string) (id.EventID, error) {
+	if m.capture != nil {
+		m.capture.add(md)
+		return "", nil
+	}
+	content := &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          md,
+		Format:        event.FormatHTML,
+		FormattedBody: MarkdownToHTML(md),
+	}
+	if m.InThread() {
+		content.SetThread(m.Event)
+		content.Mentions = &event.Mentions{UserIDs: []id.UserID{m.Sender}}
+	} else {
+		content.SetReply(m.Event)
+	}
+	return m.Bot.SendMessage(ctx, m.RoomID, content)
+}
+
+// Edit replaces the content of an earlier bot message with new markdown.
+func (m *MessageContext) Edit(ctx context.Context, eventID id.EventID, md string) error {
+	if m.capture != nil {
+		m.capture.replaceLast(md)
+		return nil
+	}
+	return m.Bot.EditMessage(ctx, m.RoomID, eventID, md, MarkdownToHTML(md))
+}
+
+// React adds an emoji reaction to the message.
+func (m *MessageContext) React(ctx context.Context, key string) error {
+	if m.capture != nil {
+		m.capture.add(key)
+		return nil
+	}
+	return m.Bot.React(ctx, m.RoomID, m.Event.ID, key)
+}
@@ -0,0 +1,129 @@
+package matrix
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// cooldownPruneSize is the number of tracked users and rooms above which
+// expired entries are dropped.
+const cooldownPruneSize = 1000
+
+// RateLimit limits how often a command runs, so that one user can't
+// monopolize a slow or costly command such as an LLM call.
+type RateLimit struct {
+	// UserCooldown is the time a user must wait between two runs of the command.
+	UserCooldown time.Duration `yaml:"user_cooldown,omitempty" toml:"user_cooldown"`
+	// RoomBurst is how many runs a room gets per RoomWindow (0 = unlimited).
+	RoomBurst  int           `yaml:"room_burst,omitempty" toml:"room_burst"`
+	RoomWindow time.Duration `yaml:"room_window,omitempty" toml:"room_window"`
+}
+
+// WithCooldown makes users wait this long between two runs of the command.
+func (c *Command) WithCooldown(d time.Duration) *Command {
+	c.Limit.UserCooldown = d
+	return c
+}
+
+// WithRoomBurst allows the command to run at most burst times per window in
+// each room.
+func (c *Command) WithRoomBurst(burst int, window time.Duration) *Command {
+	c.Limit.RoomBurst = burst
+	c.Limit.RoomWindow = window
+	return c
+}
+
+// commandLimit returns the rate limit of a command: Config.CommandLimits
+// overrides the limit it was registered with.
+func (b *Bot) commandLimit(cmd *Command) RateLimit {
+	if limit, ok := b.config.CommandLimits[cmd.Name]; ok {
+		return limit
+	}
+	return cmd.Limit
+}
+
+// cooldowns tracks recent command runs for the rate limits.
+type cooldowns struct {
+	mu       sync.Mutex
+	users    map[string]time.Time   // Last run per command and user
+	rooms    map[string][]time.Time // Runs within the window per command and room
+	notified map[string]time.Time   // Until when a user has been told to wait, per command
+}
+
+// take records a run of a command if the limit allows it. Otherwise it
+// returns how long to wait, and whether the user should be told: only once
+// per wait, so that retrying doesn't flood the room.
+func (c *cooldowns) take(name string, limit RateLimit, roomID id.RoomID, userID id.UserID, now time.Time) (wait time.Duration, notify bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users == nil {
+		c.users = make(map[string]time.Time)
+		c.rooms = make(map[string][]time.Time)
+		c.notified = make(map[string]time.Time)
+	}
+	c.prune(now)
+
+	userKey := name + "\x00" + userID.String()
+	roomKey := name + "\x00" + roomID.String()
+	if limit.UserCooldown > 0 {
+		if last, ok := c.users[userKey]; ok {
+			wait = max(wait, last.Add(limit.UserCooldown).Sub(now))
+		}
+	}
+	var runs []time.Time
+	if limit.RoomBurst > 0 && limit.RoomWindow > 0 {
+		runs = slices.DeleteFunc(c.rooms[roomKey], func(t time.Time) bool {
+			return now.Sub(t) >= limit.RoomWindow
+		})
+		if len(runs) >= limit.RoomBurst {
+			wait = max(wait, runs[0].Add(limit.RoomWindow).Sub(now))
+		}
+	}
+	if wait > 0 {
+		notify = !now.Before(c.notified[userKey])
+		if notify {
+			c.notified[userKey] = now.Add(wait)
+		}
+		return wait, notify
+	}
+
+	if limit.UserCooldown > 0 {
+		c.users[userKey] = now
+	}
+	if limit.RoomBurst > 0 && limit.RoomWindow > 0 {
+		c.rooms[roomKey] = append(runs, now)
+	}
+	return 0, false
+}
+
+// prune drops expired entries once many users or rooms are tracked. Without
+// knowing each command's limit, entries older than a day count as expired.
+func (c *cooldowns) prune(now time.Time) {
+	if len(c.users)+len(c.rooms)+len(c.notified) < cooldownPruneSize {
+		return
+	}
+	for key, last := range c.users {
+		if now.Sub(last) > 24*time.Hour {
+			delete(c.users, key)
+		}
+	}
+	for key, runs := range c.rooms {
+		if len(runs) == 0 || now.Sub(runs[len(runs)-1]) > 24*time.Hour {
+			delete(c.rooms, key)
+		}
+	}
+	for key, until := range c.notified {
+		if now.After(until) {
+			delete(c.notified, key)
+		}
+	}
+}
+
+// formatWait rounds a wait up to whole seconds for users.
+func formatWait(wait time.Duration) string {
+	return (time.Duration(math.Ceil(wait.Seconds())) * time.Second).String()
+}
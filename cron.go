@@ -0,0 +1,176 @@
+package matrix
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronDescriptors are the shorthands accepted instead of the five fields.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// CronSchedule is a parsed cron expression, see ParseCron.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of the matching values
+	domAny, dowAny                bool   // The day fields start with "*"
+	location                      *time.Location
+}
+
+// ParseCron parses a cron expression with the five standard fields
+//
+//	minute hour day-of-month month day-of-week
+//
+// e.g. "0 9 * * mon-fri" for 09:00 on weekdays or "*/15 * * * *" for every
+// quarter of an hour. Fields take lists, ranges, steps and the names of months
+// and days. The shorthands @hourly, @daily, @weekly, @monthly and @yearly are
+// accepted too. Times are local unless the expression starts with a time
+// zone, e.g. "CRON_TZ=Europe/Berlin 0 9 * * 1".
+func ParseCron(spec string) (*CronSchedule, error) {
+	s := &CronSchedule{location: time.Local}
+	fields := strings.Fields(spec)
+	if len(fields) > 0 {
+		if name, ok := strings.CutPrefix(fields[0], "CRON_TZ="); ok || strings.HasPrefix(fields[0], "TZ=") {
+			if !ok {
+				name = strings.TrimPrefix(fields[0], "TZ=")
+			}
+			location, err := time.LoadLocation(name)
+			if err != nil {
+				return nil, fmt.Errorf("matrix: unknown time zone %q in cron expression", name)
+			}
+			s.location = location
+			fields = fields[1:]
+		}
+	}
+	if len(fields) == 1 {
+		if expanded, ok := cronDescriptors[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("matrix: cron expression %q needs 5 fields", spec)
+	}
+
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	s.domAny, s.dowAny = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges ("1-5") and
+// steps ("*/15", "10-50/20") into a bit set. names, if any, are accepted for
+// the values from low on, e.g. "jan" for 1.
+func parseCronField(field string, low, high int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("matrix: invalid step %q in cron field %q", stepText, field)
+			}
+		}
+		first, last := low, high
+		if span != "*" {
+			from, to, isRange := strings.Cut(span, "-")
+			var err error
+			if first, err = parseCronValue(from, low, high, names); err != nil {
+				return 0, fmt.Errorf("matrix: %w in cron field %q", err, field)
+			}
+			last = first
+			if isRange {
+				if last, err = parseCronValue(to, low, high, names); err != nil {
+					return 0, fmt.Errorf("matrix: %w in cron field %q", err, field)
+				}
+			} else if hasStep {
+				last = high // "5/10" means from 5 on
+			}
+			if last < first {
+				return 0, fmt.Errorf("matrix: invalid range %q in cron field %q", span, field)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a number or name of a cron field.
+func parseCronValue(s string, low, high int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return low + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < low || v > high {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t matching the schedule, or the zero
+// time if there is none within five years (e.g. "0 0 30 2 *").
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case s.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, s.location)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(year, month, day, t.Hour()+1, 0, 0, 0, s.location)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule for the day fields: if both are
+// restricted, either may match.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,137 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"go.mau.fi/util/dbutil"
+)
+
+// schema creates the bot's own tables next to the crypto and state stores.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS bot_kv (
+		key   TEXT NOT NULL PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_room_settings (
+		room_id TEXT NOT NULL,
+		key     TEXT NOT NULL,
+		value   TEXT NOT NULL,
+		PRIMARY KEY (room_id, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_room_settings_history (
+		room_id    TEXT    NOT NULL,
+		version    INTEGER NOT NULL,
+		key        TEXT    NOT NULL,
+		old_value  TEXT    NOT NULL,
+		new_value  TEXT    NOT NULL,
+		changed_by TEXT    NOT NULL,
+		changed_at BIGINT  NOT NULL,
+		PRIMARY KEY (room_id, version)
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_pending_redactions (
+		room_id   TEXT   NOT NULL,
+		event_id  TEXT   NOT NULL,
+		redact_at BIGINT NOT NULL,
+		PRIMARY KEY (room_id, event_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_pending_secrets (
+		room_id   TEXT NOT NULL,
+		event_id  TEXT NOT NULL,
+		recipient TEXT NOT NULL,
+		PRIMARY KEY (room_id, event_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_audit_log (
+		direction TEXT   NOT NULL,
+		room_id   TEXT   NOT NULL,
+		event_id  TEXT   NOT NULL,
+		sender    TEXT   NOT NULL,
+		command   TEXT   NOT NULL,
+		body      TEXT   NOT NULL,
+		ts        BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS bot_audit_log_room_ts_idx ON bot_audit_log (room_id, ts)`,
+	`CREATE TABLE IF NOT EXISTS bot_outbox (
+		txn_id       TEXT    NOT NULL PRIMARY KEY,
+		room_id      TEXT    NOT NULL,
+		content      TEXT    NOT NULL,
+		created_at   BIGINT  NOT NULL,
+		attempts     INTEGER NOT NULL,
+		next_attempt BIGINT  NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_scheduled_messages (
+		id           TEXT    NOT NULL PRIMARY KEY,
+		room_id      TEXT    NOT NULL,
+		content      TEXT    NOT NULL,
+		send_at      BIGINT  NOT NULL,
+		attempts     INTEGER NOT NULL,
+		next_attempt BIGINT  NOT NULL
+	)`,
+}
+
+// MemoryDatabase as Config.Database keeps the SQLite database, including the
+// crypto store, in memory. Nothing is written to disk, which suits tests and
+// short-lived bots. Encryption keys are lost when the process exits: a
+// password login creates a new device on every start, while with
+// Config.AccessToken the token's device is reused with new keys.
+const MemoryDatabase = ":memory:"
+
+// isPostgresURI reports whether uri selects the PostgreSQL backend.
+func isPostgresURI(uri string) bool {
+	return strings.HasPrefix(uri, "postgres://") || strings.HasPrefix(uri, "postgresql://")
+}
+
+// openStore sets up Config.Store, or an SQLStore on the PostgreSQL database at
+// Config.DatabaseURI or the SQLite database at Config.Database. Tables of SQL
+// stores are created or upgraded.
+func (b *Bot) openStore(ctx context.Context) error {
+	b.store = b.config.Store
+	if b.store == nil {
+		db, err := b.checkedDatabase(ctx)
+		if err != nil {
+			return err
+		}
+		b.store = NewSQLStore(db)
+	}
+	sqlStore, ok := b.store.(*SQLStore)
+	if !ok {
+		return nil
+	}
+	if err := sqlStore.Upgrade(ctx); err != nil {
+		if b.config.Store == nil {
+			_ = sqlStore.Close()
+		}
+		return err
+	}
+	b.db = sqlStore.DB
+	return nil
+}
+
+// openDatabase opens the database configured by Config.DatabaseURI or Config.Database.
+func (b *Bot) openDatabase() (*dbutil.Database, error) {
+	uri, dialect := fmt.Sprintf("file:%s?_txlock=immediate", b.config.Database), "sqlite3-fk-wal"
+	if b.config.DatabaseURI != "" {
+		uri, dialect = b.config.DatabaseURI, "postgres"
+	}
+	var db *dbutil.Database
+	var err error
+	if b.config.DatabaseKey != "" && b.config.DatabaseURI == "" {
+		db, err = openSQLCipher(uri, b.config.DatabaseKey)
+	} else {
+		db, err = dbutil.NewWithDialect(uri, dialect)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to open database: %w", err)
+	}
+	db.Log = dbutil.ZeroLogger(b.log.With().Str("component", "database").Logger())
+	if b.config.DatabaseURI == "" && b.config.Database == MemoryDatabase {
+		// Every connection to :memory: opens a new empty database, so keep exactly one.
+		db.RawDB.SetMaxOpenConns(1)
+		db.RawDB.SetMaxIdleConns(1)
+		db.RawDB.SetConnMaxLifetime(0)
+		db.RawDB.SetConnMaxIdleTime(0)
+	}
+	return db, nil
+}
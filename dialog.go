@@ -0,0 +1,167 @@
+package matrix
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultDialogTimeout is used when Config.DialogTimeout is zero.
+const DefaultDialogTimeout = 5 * time.Minute
+
+// DialogStep handles the answer to a dialog question. It continues the dialog
+// by calling Dialog.Ask again; returning without asking ends it.
+type DialogStep func(ctx context.Context, d *Dialog, answer *MessageContext)
+
+// Dialog is a conversation with one user in one room, e.g. a wizard asking
+// for a project, then a title, then a description. While it waits for an
+// answer, the user's next message in the room that isn't a command goes to
+// the dialog instead of the other handlers. "cancel" (or "!cancel") ends it,
+// as does no answer within Config.DialogTimeout. Dialogs are kept in memory
+// and don't survive restarts.
+type Dialog struct {
+	RoomID id.RoomID
+	UserID id.UserID
+	Values map[string]string // Answers collected so far, for the steps to share
+
+	bot   *Bot
+	msg   *MessageContext // Latest message of the user, questions reply to it
+	next  DialogStep
+	timer *time.Timer
+}
+
+// dialogKey identifies the dialog of a user in a room.
+type dialogKey struct {
+	roomID id.RoomID
+	userID id.UserID
+}
+
+// StartDialog starts a dialog with the sender of a message, typically a
+// command, replacing any dialog they have in the room. Ask the first question
+// with Dialog.Ask.
+func (b *Bot) StartDialog(msg *MessageContext) *Dialog {
+	b.CancelDialog(msg.RoomID, msg.Sender)
+	return &Dialog{
+		RoomID: msg.RoomID,
+		UserID: msg.Sender,
+		Values: make(map[string]string),
+		bot:    b,
+		msg:    msg,
+	}
+}
+
+// Ask replies to the user's latest message with a question and routes their
+// answer to next.
+func (d *Dialog) Ask(ctx context.Context, question string, next DialogStep) error {
+	b := d.bot
+	timeout := b.config.DialogTimeout
+	if timeout <= 0 {
+		timeout = DefaultDialogTimeout
+	}
+	key := dialogKey{d.RoomID, d.UserID}
+
+	b.mu.Lock()
+	if b.dialogs == nil {
+		b.dialogs = make(map[dialogKey]*Dialog)
+	}
+	if previous := b.dialogs[key]; previous != nil && previous != d {
+		previous.timer.Stop()
+	}
+	d.next = next
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	notifyCtx := context.WithoutCancel(ctx)
+	d.timer = time.AfterFunc(timeout, func() {
+		if d.end() {
+			d.notify(notifyCtx, b.T(notifyCtx, d.RoomID, "dialog.timeout", timeout))
+		}
+	})
+	b.dialogs[key] = d
+	b.mu.Unlock()
+
+	if err := d.msg.Reply(ctx, question); err != nil {
+		d.end()
+		return err
+	}
+	return nil
+}
+
+// Cancel ends the dialog without waiting for further answers.
+func (d *Dialog) Cancel() {
+	d.end()
+}
+
+// end removes the dialog if it is still waiting, reporting whether it was.
+func (d *Dialog) end() bool {
+	b := d.bot
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := dialogKey{d.RoomID, d.UserID}
+	if b.dialogs[key] != d {
+		return false
+	}
+	delete(b.dialogs, key)
+	d.timer.Stop()
+	return true
+}
+
+// notify replies to the user's latest message, logging failures.
+func (d *Dialog) notify(ctx context.Context, md string) {
+	if err := d.msg.Reply(ctx, md); err != nil {
+		d.msg.Log.Warn().Err(err).Msg("Failed to send dialog notice")
+	}
+}
+
+// CancelDialog ends the dialog of a user in a room, reporting whether there was one.
+func (b *Bot) CancelDialog(roomID id.RoomID, userID id.UserID) bool {
+	b.mu.RLock()
+	d := b.dialogs[dialogKey{roomID, userID}]
+	b.mu.RUnlock()
+	return d != nil && d.end()
+}
+
+// InDialog reports whether a dialog is waiting for an answer of a user in a room.
+func (b *Bot) InDialog(roomID id.RoomID, userID id.UserID) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.dialogs[dialogKey{roomID, userID}] != nil
+}
+
+// dialogAnswer returns the dialog a message answers: a message of a user with
+// a waiting dialog that isn't a command. Commands keep working during dialogs.
+func (b *Bot) dialogAnswer(ctx context.Context, msg *MessageContext) *Dialog {
+	if msg.Reaction != nil || msg.IsEdit() {
+		return nil
+	}
+	b.mu.RLock()
+	d := b.dialogs[dialogKey{msg.RoomID, msg.Sender}]
+	b.mu.RUnlock()
+	if d == nil {
+		return nil
+	}
+	if name, _, ok := b.parseMessageCommand(ctx, msg); ok && name != "cancel" &&
+		b.resolveCommand(ctx, msg.RoomID, name) != nil {
+		return nil
+	}
+	return d
+}
+
+// handleDialogAnswer passes an answer to the dialog's next step, or cancels
+// the dialog if the user asked to.
+func (b *Bot) handleDialogAnswer(ctx context.Context, d *Dialog, msg *MessageContext) {
+	if !d.end() {
+		return // Timed out or cancelled meanwhile
+	}
+	d.msg = msg
+	answer := strings.ToLower(strings.TrimSpace(msg.Message.Body))
+	if b.trimCommandPrefix(ctx, msg.RoomID, answer) == "cancel" {
+		d.notify(ctx, b.T(ctx, msg.RoomID, "dialog.cancelled"))
+		return
+	}
+	b.timedHandle(ctx, msg, b.config.HandlerTimeout, false, func(ctx context.Context, msg *MessageContext) {
+		d.next(ctx, d, msg)
+	})
+}
@@ -0,0 +1,34 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// homeserverURL resolves Config.Homeserver to the client-server API URL.
+// Full URLs are used as they are. A bare server name ("example.com"), or the
+// server of a full user ID in Username when Homeserver is empty, is looked up
+// via /.well-known/matrix/client like regular Matrix clients do, falling back
+// to https://<server name> if the server publishes no .well-known file.
+func (b *Bot) homeserverURL(ctx context.Context) (string, error) {
+	server := b.config.Homeserver
+	if server == "" {
+		_, server, _ = id.UserID(b.config.Username).ParseAndValidateRelaxed()
+	}
+	if strings.Contains(server, "://") {
+		return server, nil
+	}
+
+	wellKnown, err := mautrix.DiscoverClientAPIWithClient(ctx, b.http, server)
+	if err != nil {
+		return "", fmt.Errorf("matrix: .well-known discovery for %s failed: %w", server, err)
+	}
+	if wellKnown == nil || wellKnown.Homeserver.BaseURL == "" {
+		return "https://" + server, nil
+	}
+	return strings.TrimSuffix(wellKnown.Homeserver.BaseURL, "/"), nil
+}
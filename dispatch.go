@@ -0,0 +1,444 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultQueueLimit is used when Config.QueueLimit is zero.
+const DefaultQueueLimit = 1000
+
+// DefaultHandlerWorkers is used when Config.HandlerWorkers is zero.
+const DefaultHandlerWorkers = 4
+
+// overloadNoticeInterval limits overload notices to one per room per interval.
+const overloadNoticeInterval = time.Minute
+
+// Priority is the dispatch lane of an incoming message. Higher lanes are always
+// served first, so control commands stay responsive during a flood of traffic.
+type Priority int
+
+const (
+	// PriorityPassive is for plain messages only seen by watchers and passive handlers.
+	PriorityPassive Priority = iota
+	// PriorityInteractive is for regular commands.
+	PriorityInteractive
+	// PriorityControl is for admin/control commands like !stop or !maintenance.
+	PriorityControl
+
+	priorityLanes = int(PriorityControl) + 1
+)
+
+// String returns the lane name used in logs.
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "control"
+	case PriorityInteractive:
+		return "interactive"
+	default:
+		return "passive"
+	}
+}
+
+// clamp limits p to the lanes, from PriorityPassive to PriorityControl.
+func (p Priority) clamp() Priority {
+	return min(max(p, PriorityPassive), PriorityControl)
+}
+
+// PriorityClassifier assigns a dispatch lane to an incoming message.
+type PriorityClassifier func(msg *MessageContext) Priority
+
+// SetPriorityClassifier replaces the default classification, which puts known
+// commands in their configured lane (see Command.WithPriority), other messages
+// starting with the command prefix in the interactive lane, and everything else
+// in the passive lane.
+func (b *Bot) SetPriorityClassifier(classifier PriorityClassifier) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.classifier = classifier
+}
+
+// classify returns the dispatch lane for a message.
+func (b *Bot) classify(ctx context.Context, msg *MessageContext) Priority {
+	if b.dialogAnswer(ctx, msg) != nil {
+		return PriorityInteractive
+	}
+	b.mu.RLock()
+	classifier := b.classifier
+	b.mu.RUnlock()
+	if classifier != nil {
+		return classifier(msg)
+	}
+
+	name, _, ok := b.parseMessageCommand(ctx, msg)
+	if !ok {
+		return PriorityPassive
+	}
+	if cmd := b.resolveCommand(ctx, msg.RoomID, name); cmd != nil {
+		return cmd.Priority
+	}
+	return PriorityInteractive
+}
+
+// DispatchStats is a snapshot of the dispatch queue, for saturation metrics.
+type DispatchStats struct {
+	Queued   [priorityLanes]int    // Messages waiting per lane, indexed by Priority
+	Dropped  [priorityLanes]uint64 // Messages shed per lane since start
+	Capacity int                   // Maximum number of queued messages
+	Active   int                   // Messages being handled by workers
+	Workers  int                   // Size of the worker pool
+}
+
+// Saturation returns the queue fill level between 0 and 1.
+func (s DispatchStats) Saturation() float64 {
+	total := 0
+	for _, n := range s.Queued {
+		total += n
+	}
+	return float64(total) / float64(s.Capacity)
+}
+
+// dispatcher queues incoming messages in priority lanes and hands them to a
+// pool of workers outside of the sync loop. The queue is bounded: when it is
+// full, passive messages are shed first so commands keep working. A room's
+// messages are handled one at a time: in order within a lane, while a command
+// may overtake passive messages of its room that are still queued.
+type dispatcher struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    [priorityLanes][]*MessageContext
+	queued   int
+	capacity int
+	workers  int
+	dropped  [priorityLanes]uint64
+	busy     map[id.RoomID]bool // Rooms with a message being handled
+	held     map[id.RoomID]int  // Handlers per room still running after their timeout, see hold
+	draining bool               // Workers exit once the queue is empty
+	closed   bool
+}
+
+func newDispatcher(capacity, workers int) *dispatcher {
+	if capacity <= 0 {
+		capacity = DefaultQueueLimit
+	}
+	if workers <= 0 {
+		workers = DefaultHandlerWorkers
+	}
+	d := &dispatcher{capacity: capacity, workers: workers, busy: make(map[id.RoomID]bool), held: make(map[id.RoomID]int)}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// push queues a message in its lane and sets its Priority; priorities outside
+// the lanes are clamped. If the queue is full, the oldest message of the
+// lowest lane below p is evicted to make room; if there is none, msg itself is
+// rejected. The shed message, if any, is returned.
+func (d *dispatcher) push(p Priority, msg *MessageContext) (shed *MessageContext) {
+	p = p.clamp()
+	msg.Priority = p
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	if d.queued >= d.capacity {
+		victim := Priority(-1)
+		for lane := PriorityPassive; lane < p; lane++ {
+			if len(d.lanes[lane]) > 0 {
+				victim = lane
+				break
+			}
+		}
+		if victim < 0 {
+			d.dropped[p]++
+			return msg
+		}
+		shed = d.lanes[victim][0]
+		d.lanes[victim][0] = nil
+		d.lanes[victim] = d.lanes[victim][1:]
+		d.queued--
+		d.dropped[victim]++
+	}
+	d.lanes[p] = append(d.lanes[p], msg)
+	d.queued++
+	d.cond.Signal()
+	return shed
+}
+
+// stats returns a snapshot of the queue.
+func (d *dispatcher) stats() DispatchStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := DispatchStats{Dropped: d.dropped, Capacity: d.capacity, Active: len(d.busy), Workers: d.workers}
+	for p := range d.lanes {
+		s.Queued[p] = len(d.lanes[p])
+	}
+	return s
+}
+
+// pop blocks until a message is available and returns the oldest one from the
+// highest lane whose room isn't busy or held, marking the room busy until done
+// is called. Lanes are served by priority, so a room's messages keep their
+// order only within a lane. ok is false once the dispatcher is closed.
+func (d *dispatcher) pop() (msg *MessageContext, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		if d.closed || (d.draining && d.queued == 0) {
+			return nil, false
+		}
+		for p := priorityLanes - 1; p >= 0; p-- {
+			for i, queued := range d.lanes[p] {
+				if d.busy[queued.RoomID] || d.held[queued.RoomID] > 0 {
+					continue
+				}
+				d.lanes[p] = slices.Delete(d.lanes[p], i, i+1)
+				d.queued--
+				d.busy[queued.RoomID] = true
+				return queued, true
+			}
+		}
+		d.cond.Wait()
+	}
+}
+
+// done marks a message's room as idle again, so its next message can be handled.
+func (d *dispatcher) done(msg *MessageContext) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.busy, msg.RoomID)
+	d.cond.Broadcast()
+}
+
+// hold keeps a room busy beyond done, for a handler that still runs after its
+// timeout, until release is called.
+func (d *dispatcher) hold(roomID id.RoomID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.held[roomID]++
+}
+
+// release ends a hold of a room.
+func (d *dispatcher) release(roomID id.RoomID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held[roomID]--; d.held[roomID] <= 0 {
+		delete(d.held, roomID)
+	}
+	d.cond.Broadcast()
+}
+
+// drain makes workers exit once all queued messages have been handled.
+func (d *dispatcher) drain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.draining = true
+	d.cond.Broadcast()
+}
+
+// close stops the dispatcher and wakes up waiting workers.
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	d.cond.Broadcast()
+}
+
+// enqueueMessage classifies an incoming message and queues it for the handlers.
+func (b *Bot) enqueueMessage(ctx context.Context, msg *MessageContext) {
+	if shed := b.dispatch.push(b.classify(ctx, msg), msg); shed != nil {
+		b.shedMessage(shed)
+	}
+}
+
+// shedMessage logs a message dropped due to overload. Commands are answered
+// with an overload notice, rate-limited per room; passive messages are dropped silently.
+func (b *Bot) shedMessage(msg *MessageContext) {
+	msg.Log.Warn().Stringer("lane", msg.Priority).Msg("Dispatch queue full, dropping message")
+	if msg.Priority == PriorityPassive || b.config.DisableOverloadNotice {
+		return
+	}
+
+	b.mu.Lock()
+	if b.overloadNotified == nil {
+		b.overloadNotified = make(map[id.RoomID]time.Time)
+	}
+	last := b.overloadNotified[msg.RoomID]
+	notify := time.Since(last) >= overloadNoticeInterval
+	if notify {
+		b.overloadNotified[msg.RoomID] = time.Now()
+	}
+	b.mu.Unlock()
+
+	if notify {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			_ = msg.Reply(ctx, b.T(ctx, msg.RoomID, "dispatch.overloaded"))
+		}()
+	}
+}
+
+// DispatchStats returns queue saturation metrics.
+func (b *Bot) DispatchStats() DispatchStats {
+	return b.dispatch.stats()
+}
+
+// DefaultShutdownTimeout is used when Config.ShutdownTimeout is zero.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// drainHandlers waits for the workers to handle the queued messages, for up
+// to Config.ShutdownTimeout, and then cancels the handlers still running.
+func (b *Bot) drainHandlers() {
+	if b.cancelHandlers == nil {
+		return
+	}
+	b.dispatch.drain()
+	done := make(chan struct{})
+	go func() {
+		b.handlerWait.Wait()
+		close(done)
+	}()
+	timeout := b.config.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	timer := time.NewTimer(max(timeout, 0))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+	stats := b.dispatch.stats()
+	b.log.Warn().
+		Int("active", stats.Active).
+		Int("queued", stats.Queued[PriorityPassive]+stats.Queued[PriorityInteractive]+stats.Queued[PriorityControl]).
+		Msg("Shutdown timeout reached, cancelling running handlers")
+	b.cancelHandlers()
+}
+
+// runDispatcher runs the handlers for queued messages on the worker pool
+// until ctx is cancelled.
+func (b *Bot) runDispatcher(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		b.dispatch.close()
+	}()
+	var workers sync.WaitGroup
+	for range b.dispatch.workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				msg, ok := b.dispatch.pop()
+				if !ok {
+					return
+				}
+				b.handleMessage(ctx, msg)
+				b.dispatch.done(msg)
+			}
+		}()
+	}
+	workers.Wait()
+}
+
+// handleMessage routes a message through the rules and runs all registered handlers.
+func (b *Bot) handleMessage(ctx context.Context, msg *MessageContext) {
+	if msg.Reaction != nil {
+		b.safeHandle(ctx, msg, b.dispatchCommand) // Action card reactions only run their command
+		return
+	}
+	var disabled []string
+	if slices.ContainsFunc(b.handlerModules, func(module string) bool { return module != "" }) ||
+		slices.ContainsFunc(b.filterModules, func(module string) bool { return module != "" }) {
+		disabled, _ = b.DisabledModules(ctx, msg.RoomID)
+	}
+	if !b.passesFilters(ctx, msg, disabled) {
+		return
+	}
+	if d := b.dialogAnswer(ctx, msg); d != nil {
+		b.handleDialogAnswer(ctx, d, msg) // Answers only go to their dialog
+		return
+	}
+	b.safeHandle(ctx, msg, b.routeMessage)
+	for i, handler := range b.handlers {
+		if module := b.handlerModules[i]; module != "" && slices.Contains(disabled, module) {
+			continue
+		}
+		if i == b.commandHandler {
+			b.safeHandle(ctx, msg, handler) // Applies the timeout of the command
+			continue
+		}
+		b.timedHandle(ctx, msg, b.config.HandlerTimeout, false, handler)
+	}
+}
+
+// timedHandle runs a handler with a timeout (none if timeout <= 0). When it
+// expires, the handler's context is cancelled, the timeout is reported to the
+// room if reply is set, i.e. for commands, and the next handler runs, even if
+// the handler ignores the cancellation. The room's next message waits for
+// such a handler to return, so a room's messages are still handled one at a time.
+func (b *Bot) timedHandle(ctx context.Context, msg *MessageContext, timeout time.Duration, reply bool, handler MessageContextHandler) {
+	if timeout <= 0 {
+		b.safeHandle(ctx, msg, handler)
+		return
+	}
+	handlerCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.safeHandle(handlerCtx, msg, handler)
+	}()
+	select {
+	case <-done:
+		return
+	case <-handlerCtx.Done():
+	}
+	select {
+	case <-done:
+	default:
+		b.dispatch.hold(msg.RoomID)
+		go func() {
+			<-done
+			b.dispatch.release(msg.RoomID)
+		}()
+	}
+	if ctx.Err() != nil {
+		return // Shutting down
+	}
+	msg.Log.Warn().Dur("timeout", timeout).Msg("Handler timed out")
+	if !reply {
+		return
+	}
+	if err := msg.Reply(ctx, b.T(ctx, msg.RoomID, "dispatch.timeout", timeout)); err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to report handler timeout")
+	}
+}
+
+// safeHandle runs a handler, recovering from panics so that one faulty
+// handler neither stops the others nor crashes the bot. Panics are logged
+// with their stack and reported to Config.AdminRoom.
+func (b *Bot) safeHandle(ctx context.Context, msg *MessageContext, handler MessageContextHandler) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		msg.Log.Error().
+			Str("panic", fmt.Sprint(value)).
+			Bytes("stack", debug.Stack()).
+			Msg("Handler panicked")
+		b.reportAdmin(ctx, fmt.Sprintf("A handler panicked in %s (stack trace in the logs)", msg.RoomID),
+			fmt.Errorf("%v", value))
+	}()
+	handler(ctx, msg)
+}
@@ -0,0 +1,128 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestDispatcherLanes(t *testing.T) {
+	d := newDispatcher(10, 1)
+	passive := &MessageContext{RoomID: "!a:example.com"}
+	interactive := &MessageContext{RoomID: "!b:example.com"}
+	control := &MessageContext{RoomID: "!c:example.com"}
+	d.push(PriorityPassive, passive)
+	d.push(PriorityInteractive, interactive)
+	d.push(PriorityControl, control)
+	for _, want := range []*MessageContext{control, interactive, passive} {
+		msg, ok := d.pop()
+		if !ok || msg != want {
+			t.Fatalf("pop() = %v, want the %s message", msg, want.Priority)
+		}
+		d.done(msg)
+	}
+}
+
+func TestDispatcherBusyRoom(t *testing.T) {
+	d := newDispatcher(10, 2)
+	first := &MessageContext{RoomID: "!a:example.com"}
+	second := &MessageContext{RoomID: "!a:example.com"}
+	other := &MessageContext{RoomID: "!b:example.com"}
+	d.push(PriorityControl, first)
+	d.push(PriorityControl, second)
+	d.push(PriorityPassive, other)
+	if msg, _ := d.pop(); msg != first {
+		t.Fatal("first message of the room not popped first")
+	}
+	// The room is busy, so its second message waits for done
+	if msg, _ := d.pop(); msg != other {
+		t.Fatal("message of a busy room popped")
+	}
+	d.done(first)
+	if msg, _ := d.pop(); msg != second {
+		t.Fatal("second message of the room not popped after done")
+	}
+}
+
+func TestDispatcherShedding(t *testing.T) {
+	d := newDispatcher(2, 1)
+	oldest := &MessageContext{RoomID: "!a:example.com"}
+	d.push(PriorityPassive, oldest)
+	d.push(PriorityPassive, &MessageContext{RoomID: "!a:example.com"})
+
+	// A full queue rejects messages without a lower lane to evict
+	msg := &MessageContext{RoomID: "!b:example.com"}
+	if shed := d.push(PriorityPassive, msg); shed != msg {
+		t.Errorf("push to a full queue shed %v, want the pushed message", shed)
+	}
+	// and evicts the oldest message of the lowest lane for higher ones
+	if shed := d.push(PriorityControl, &MessageContext{RoomID: "!b:example.com"}); shed != oldest {
+		t.Errorf("push of a control message shed %v, want the oldest passive message", shed)
+	}
+	stats := d.stats()
+	if stats.Queued != [priorityLanes]int{1, 0, 1} || stats.Dropped != [priorityLanes]uint64{2, 0, 0} {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestDispatcherClampsPriority(t *testing.T) {
+	d := newDispatcher(10, 1)
+	for _, tt := range []struct {
+		p, want Priority
+	}{
+		{-5, PriorityPassive},
+		{PriorityInteractive, PriorityInteractive},
+		{42, PriorityControl},
+	} {
+		msg := &MessageContext{RoomID: "!a:example.com"}
+		d.push(tt.p, msg)
+		if msg.Priority != tt.want {
+			t.Errorf("push(%d) queued in lane %s, want %s", tt.p, msg.Priority, tt.want)
+		}
+	}
+	if stats := d.stats(); stats.Queued != [priorityLanes]int{1, 1, 1} {
+		t.Errorf("queued = %v", stats.Queued)
+	}
+}
+
+func TestWithPriorityClamps(t *testing.T) {
+	if p := (&Command{}).WithPriority(7).Priority; p != PriorityControl {
+		t.Errorf("WithPriority(7) = %s, want control", p)
+	}
+	if p := (&Command{}).WithPriority(-1).Priority; p != PriorityPassive {
+		t.Errorf("WithPriority(-1) = %s, want passive", p)
+	}
+}
+
+func TestTimedHandleHoldsRoom(t *testing.T) {
+	b := newTestBot(t, Config{})
+	msg := &MessageContext{Bot: b, RoomID: "!a:example.com", Log: zerolog.Nop()}
+	release := make(chan struct{})
+	b.timedHandle(context.Background(), msg, 10*time.Millisecond, false, func(ctx context.Context, msg *MessageContext) {
+		<-release // Ignores the cancellation
+	})
+
+	next := &MessageContext{RoomID: "!a:example.com"}
+	other := &MessageContext{RoomID: "!b:example.com"}
+	b.dispatch.push(PriorityControl, next)
+	b.dispatch.push(PriorityPassive, other)
+	if popped, _ := b.dispatch.pop(); popped != other {
+		t.Fatal("message of a room with a timed out handler popped while the handler runs")
+	}
+	popped := make(chan *MessageContext)
+	go func() {
+		msg, _ := b.dispatch.pop()
+		popped <- msg
+	}()
+	close(release)
+	select {
+	case msg := <-popped:
+		if msg != next {
+			t.Fatal("wrong message popped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("room still held after the handler returned")
+	}
+}
@@ -0,0 +1,118 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// EnsureDM returns a direct message room with the given user, creating an
+// encrypted one if none exists. Existing DMs are looked up in the bot's
+// m.direct account data and reused as long as both parties are still in them,
+// so bots can notify users privately (reminders, on-call pages) without
+// opening a new room every time.
+func (b *Bot) EnsureDM(ctx context.Context, userID id.UserID) (id.RoomID, error) {
+	return b.ensureDM(ctx, userID, b.isActiveDM)
+}
+
+// EnsurePrivateDM is like EnsureDM, but only reuses DM rooms whose joined and
+// invited members are exactly the bot and the user, so nobody else can read
+// what is sent there, e.g. secrets. Otherwise a new DM room is created.
+func (b *Bot) EnsurePrivateDM(ctx context.Context, userID id.UserID) (id.RoomID, error) {
+	return b.ensureDM(ctx, userID, b.isPrivateDM)
+}
+
+// ensureDM returns the first DM room with a user that passes usable, or a new one.
+func (b *Bot) ensureDM(ctx context.Context, userID id.UserID, usable func(ctx context.Context, roomID id.RoomID, userID id.UserID) bool) (id.RoomID, error) {
+	b.dmMu.Lock()
+	defer b.dmMu.Unlock()
+
+	direct := event.DirectChatsEventContent{}
+	err := b.client.GetAccountData(ctx, event.AccountDataDirectChats.Type, &direct)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return "", fmt.Errorf("matrix: failed to read m.direct: %w", err)
+	}
+
+	for _, roomID := range direct[userID] {
+		if usable(ctx, roomID, userID) {
+			return roomID, nil
+		}
+	}
+
+	resp, err := b.client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Preset:   "trusted_private_chat",
+		Invite:   []id.UserID{userID},
+		IsDirect: true,
+		InitialState: []*event.Event{{
+			Type: event.StateEncryption,
+			Content: event.Content{Parsed: &event.EncryptionEventContent{
+				Algorithm: id.AlgorithmMegolmV1,
+			}},
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to create DM room: %w", err)
+	}
+
+	if direct == nil {
+		direct = event.DirectChatsEventContent{}
+	}
+	direct[userID] = append(direct[userID], resp.RoomID)
+	if err = b.client.SetAccountData(ctx, event.AccountDataDirectChats.Type, direct); err != nil {
+		// The room is usable; it just won't be found again after a restart.
+		b.log.Warn().Err(err).Str("room_id", resp.RoomID.String()).Msg("Failed to update m.direct")
+	}
+
+	b.log.Info().
+		Str("room_id", resp.RoomID.String()).
+		Str("user_id", userID.String()).
+		Msg("Created DM room")
+	return resp.RoomID, nil
+}
+
+// isActiveDM reports whether the bot is joined to a DM room and the other user is joined or invited.
+func (b *Bot) isActiveDM(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	members, err := b.dmMembers(ctx, roomID)
+	if err != nil {
+		return false
+	}
+	return members[b.client.UserID] == event.MembershipJoin &&
+		(members[userID] == event.MembershipJoin || members[userID] == event.MembershipInvite)
+}
+
+// isPrivateDM reports whether a DM room is active and nobody but the bot and
+// the other user is joined or invited.
+func (b *Bot) isPrivateDM(ctx context.Context, roomID id.RoomID, userID id.UserID) bool {
+	members, err := b.dmMembers(ctx, roomID)
+	if err != nil || members[b.client.UserID] != event.MembershipJoin {
+		return false
+	}
+	present := 0
+	for memberID, membership := range members {
+		if membership != event.MembershipJoin && membership != event.MembershipInvite {
+			continue
+		}
+		if memberID != b.client.UserID && memberID != userID {
+			return false
+		}
+		present++
+	}
+	return present == 2
+}
+
+// dmMembers returns the membership of every user who has one in a room.
+func (b *Bot) dmMembers(ctx context.Context, roomID id.RoomID) (map[id.UserID]event.Membership, error) {
+	resp, err := b.client.Members(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[id.UserID]event.Membership, len(resp.Chunk))
+	for _, evt := range resp.Chunk {
+		members[id.UserID(evt.GetStateKey())] = evt.Content.AsMember().Membership
+	}
+	return members, nil
+}
@@ -0,0 +1,82 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// newDMBot creates a bot whose homeserver lists one DM room with alice, with
+// the given members (user ID to membership), and answers room creation with
+// !new:example.com.
+func newDMBot(t *testing.T, members map[string]string) *Bot {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/account_data/m.direct") && r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`{"@alice:example.com":["!dm:example.com"]}`))
+		case strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+			_, _ = w.Write([]byte(`{}`))
+		case strings.HasSuffix(r.URL.Path, "/rooms/!dm:example.com/members"):
+			var chunk []string
+			for userID, membership := range members {
+				chunk = append(chunk, fmt.Sprintf(`{"type":"m.room.member","state_key":%q,"sender":%q,"content":{"membership":%q}}`,
+					userID, userID, membership))
+			}
+			_, _ = w.Write([]byte(`{"chunk":[` + strings.Join(chunk, ",") + `]}`))
+		case strings.HasSuffix(r.URL.Path, "/createRoom"):
+			_ = json.NewEncoder(w).Encode(map[string]string{"room_id": "!new:example.com"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	b := newTestBot(t, Config{})
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.StateStore = mautrix.NewMemoryStateStore()
+	b.client = client
+	return b
+}
+
+func TestEnsurePrivateDM(t *testing.T) {
+	tests := []struct {
+		name          string
+		members       map[string]string
+		active, alone bool // Reused by EnsureDM and EnsurePrivateDM
+	}{
+		{"private", map[string]string{"@bot:example.com": "join", "@alice:example.com": "join"}, true, true},
+		{"invited", map[string]string{"@bot:example.com": "join", "@alice:example.com": "invite"}, true, true},
+		{"former member", map[string]string{"@bot:example.com": "join", "@alice:example.com": "join", "@eve:example.com": "leave"}, true, true},
+		{"third party joined", map[string]string{"@bot:example.com": "join", "@alice:example.com": "join", "@eve:example.com": "join"}, true, false},
+		{"third party invited", map[string]string{"@bot:example.com": "join", "@alice:example.com": "join", "@eve:example.com": "invite"}, true, false},
+		{"user left", map[string]string{"@bot:example.com": "join", "@alice:example.com": "leave"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := newDMBot(t, tt.members)
+			for _, check := range []struct {
+				ensure func(context.Context, id.UserID) (id.RoomID, error)
+				reuse  bool
+			}{{b.EnsureDM, tt.active}, {b.EnsurePrivateDM, tt.alone}} {
+				roomID, err := check.ensure(ctx, "@alice:example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := map[bool]id.RoomID{true: "!dm:example.com", false: "!new:example.com"}[check.reuse]; roomID != want {
+					t.Errorf("room = %s, want %s", roomID, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// MessageVersion is one version of an edited message.
+type MessageVersion struct {
+	EventID   id.EventID // The original message for the first version, the edit event otherwise
+	Sender    id.UserID
+	Timestamp time.Time
+	Body      string
+}
+
+// EditHistory returns every version of a message, oldest first: the original
+// followed by each edit. Edits by anyone but the original sender are ignored,
+// as clients don't apply them either. Redacted edits are left out.
+func (b *Bot) EditHistory(ctx context.Context, roomID id.RoomID, eventID id.EventID) ([]MessageVersion, error) {
+	original, err := b.FetchEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if original.Type != event.EventMessage {
+		return nil, fmt.Errorf("matrix: %s is not a message", eventID)
+	}
+	edits, err := b.GetRelations(ctx, roomID, eventID, event.RelReplace)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []MessageVersion{{
+		EventID:   original.ID,
+		Sender:    original.Sender,
+		Timestamp: time.UnixMilli(original.Timestamp),
+		Body:      original.Content.AsMessage().Body,
+	}}
+	for _, edit := range edits {
+		content := edit.Content.AsMessage()
+		if edit.Sender != original.Sender || content.NewContent == nil {
+			continue
+		}
+		versions = append(versions, MessageVersion{
+			EventID:   edit.ID,
+			Sender:    edit.Sender,
+			Timestamp: time.UnixMilli(edit.Timestamp),
+			Body:      content.NewContent.Body,
+		})
+	}
+	return versions, nil
+}
+
+// FormatEditHistory renders the versions of a message as Markdown, one quoted
+// block per version, for moderation tooling.
+func FormatEditHistory(versions []MessageVersion) string {
+	if len(versions) == 0 {
+		return "No history."
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**Edit history** (%d edits) of a message by %s:\n\n", len(versions)-1, versions[0].Sender))
+	for i, version := range versions {
+		label := "Original"
+		if i > 0 {
+			label = fmt.Sprintf("Edit %d", i)
+		}
+		sb.WriteString(fmt.Sprintf("%s, %s:\n", label, version.Timestamp.UTC().Format("2006-01-02 15:04:05 UTC")))
+		for _, line := range strings.Split(version.Body, "\n") {
+			sb.WriteString("> " + line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,164 @@
+// Package email provides a small pluggable email sender used by bot modules
+// that deliver notifications outside of Matrix.
+//
+// Environment variables (see GetEnvironmentConfig):
+//   - SMTP_ADDR: SMTP server address (host:port)
+//   - SMTP_USER: SMTP username (optional)
+//   - SMTP_PASS: SMTP password (optional)
+//   - SMTP_FROM: Sender address
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is an outgoing email with a plain text and an optional HTML body.
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers emails. Implement it to use an API-based provider or a test double.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SenderFunc adapts a plain function to the Sender interface.
+type SenderFunc func(ctx context.Context, msg *Message) error
+
+// Send calls f(ctx, msg).
+func (f SenderFunc) Send(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Config holds SMTP settings.
+type Config struct {
+	Addr     string // SMTP server address (host:port)
+	Username string // Optional, enables PLAIN auth
+	Password string
+	From     string // Sender address
+}
+
+// GetEnvironmentConfig creates a Config from SMTP_* environment variables.
+func GetEnvironmentConfig() Config {
+	return Config{
+		Addr:     os.Getenv("SMTP_ADDR"),
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASS"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// SMTPSender sends emails through an SMTP server using net/smtp.
+type SMTPSender struct {
+	config Config
+}
+
+// NewSMTPSender creates a sender for the given SMTP configuration.
+func NewSMTPSender(config Config) *SMTPSender {
+	return &SMTPSender{config: config}
+}
+
+// Send implements Sender. The context is only checked before sending,
+// as net/smtp does not support cancellation.
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(msg.To) == 0 {
+		return fmt.Errorf("email: no recipients")
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		host, _, err := net.SplitHostPort(s.config.Addr)
+		if err != nil {
+			return fmt.Errorf("email: invalid SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, host)
+	}
+
+	data, err := Compose(s.config.From, msg)
+	if err != nil {
+		return err
+	}
+	if err = smtp.SendMail(s.config.Addr, auth, s.config.From, msg.To, data); err != nil {
+		return fmt.Errorf("email: failed to send: %w", err)
+	}
+	return nil
+}
+
+// Compose renders a message as RFC 5322 bytes. Messages with an HTML body
+// are sent as multipart/alternative.
+func Compose(from string, msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	writeHeader("From", from)
+	writeHeader("To", strings.Join(msg.To, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+	writeHeader("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		buf.WriteString("--" + boundary + "\r\n")
+		writeHeader("Content-Type", part.contentType+"; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err = writeQuotedPrintable(&buf, part.body); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--" + boundary + "--\r\n")
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, text string) error {
+	w := quotedprintable.NewWriter(buf)
+	if _, err := w.Write([]byte(text)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func randomBoundary() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("email: failed to generate boundary: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestComposePlainText(t *testing.T) {
+	text := "Hello Alice,\nyou were mentioned in #ops — twice. " + strings.Repeat("long line ", 20)
+	data, err := Compose("bot@example.com", &Message{To: []string{"alice@example.com"}, Subject: "Grüße", Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("To"); got != "alice@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Grüße" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != text { // Line breaks are sent as CRLF
+		t.Errorf("body = %q, want %q", got, text)
+	}
+}
+
+func TestComposeAlternative(t *testing.T) {
+	data, err := Compose("bot@example.com", &Message{To: []string{"a@example.com"}, Subject: "s", Text: "plain", HTML: "<b>html</b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", mediaType, err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct{ contentType, body string }{{"text/plain", "plain"}, {"text/html", "<b>html</b>"}}
+	for _, w := range want {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := part.Header.Get("Content-Type"); !strings.HasPrefix(ct, w.contentType) {
+			t.Errorf("Content-Type = %q, want %s", ct, w.contentType)
+		}
+		body, _ := io.ReadAll(part) // multipart decodes quoted-printable
+		if got := strings.TrimRight(string(body), "\r\n"); got != w.body {
+			t.Errorf("%s body = %q, want %q", w.contentType, got, w.body)
+		}
+	}
+	if _, err = reader.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got %v", err)
+	}
+}
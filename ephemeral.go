@@ -0,0 +1,117 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// redactionRetry is the delay before retrying a redaction that failed temporarily.
+const redactionRetry = time.Minute
+
+// SendEphemeral posts a text message and redacts it after ttl, e.g. for one-time
+// codes or noisy transient status lines. The pending redaction is stored in the
+// database, so it still happens after a restart.
+func (b *Bot) SendEphemeral(ctx context.Context, roomID id.RoomID, text string, ttl time.Duration) (id.EventID, error) {
+	if b.db == nil {
+		return "", ErrNoDatabase
+	}
+	// The event ID is needed right away, so ephemeral messages bypass the outbox.
+	eventID, err := b.SendMessage(withoutOutbox(ctx), roomID, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = b.RedactAfter(ctx, roomID, eventID, ttl); err != nil {
+		return eventID, err
+	}
+	return eventID, nil
+}
+
+// RedactAfter schedules the redaction of an event after ttl. Scheduling an event
+// again replaces the earlier deadline.
+func (b *Bot) RedactAfter(ctx context.Context, roomID id.RoomID, eventID id.EventID, ttl time.Duration) error {
+	if b.db == nil {
+		return ErrNoDatabase
+	}
+	_, err := b.db.Exec(ctx, `INSERT INTO bot_pending_redactions (room_id, event_id, redact_at) VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, event_id) DO UPDATE SET redact_at=excluded.redact_at`,
+		roomID, eventID, time.Now().Add(ttl).UnixMilli())
+	if err != nil {
+		return fmt.Errorf("matrix: failed to schedule redaction: %w", err)
+	}
+	select {
+	case b.redactWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runRedactions redacts scheduled events when they are due until ctx is cancelled.
+func (b *Bot) runRedactions(ctx context.Context) {
+	for {
+		wait := b.redactDue(ctx)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-b.redactWake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// redactDue redacts all due events and returns how long to wait for the next one.
+func (b *Bot) redactDue(ctx context.Context) time.Duration {
+	now := time.Now()
+	rows, err := b.db.Query(ctx, "SELECT room_id, event_id FROM bot_pending_redactions WHERE redact_at<=$1", now.UnixMilli())
+	if err != nil {
+		b.log.Warn().Err(err).Msg("Failed to load pending redactions")
+		return redactionRetry
+	}
+	type pending struct {
+		roomID  id.RoomID
+		eventID id.EventID
+	}
+	var due []pending
+	for rows.Next() {
+		var p pending
+		if err = rows.Scan(&p.roomID, &p.eventID); err == nil {
+			due = append(due, p)
+		}
+	}
+	_ = rows.Close()
+
+	for _, p := range due {
+		_, err = b.client.RedactEvent(ctx, p.roomID, p.eventID, mautrix.ReqRedact{Reason: "Expired"})
+		var respErr mautrix.RespError
+		if err != nil && !errors.As(err, &respErr) {
+			// Network error: keep the redaction and retry later.
+			b.log.Warn().Err(err).Str("room_id", p.roomID.String()).Msg("Failed to redact expired message")
+			_, _ = b.db.Exec(ctx, "UPDATE bot_pending_redactions SET redact_at=$1 WHERE room_id=$2 AND event_id=$3",
+				now.Add(redactionRetry).UnixMilli(), p.roomID, p.eventID)
+			continue
+		} else if err != nil {
+			// Rejected by the server (already redacted, left the room, ...): give up.
+			b.log.Warn().Err(err).Str("room_id", p.roomID.String()).Msg("Dropping redaction rejected by the server")
+		}
+		_, _ = b.db.Exec(ctx, "DELETE FROM bot_pending_redactions WHERE room_id=$1 AND event_id=$2", p.roomID, p.eventID)
+		_, _ = b.db.Exec(ctx, "DELETE FROM bot_pending_secrets WHERE room_id=$1 AND event_id=$2", p.roomID, p.eventID)
+	}
+
+	var next int64
+	err = b.db.QueryRow(ctx, "SELECT COALESCE(MIN(redact_at), 0) FROM bot_pending_redactions").Scan(&next)
+	if err != nil || next == 0 {
+		return time.Hour
+	}
+	return max(time.Until(time.UnixMilli(next)), time.Second)
+}
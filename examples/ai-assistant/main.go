@@ -2,7 +2,8 @@
 //
 // The bot listens for messages starting with "::" and forwards the prompt
 // to an Ollama/Open WebUI instance. The AI response is rendered as markdown
-// and sent back to the room with user mentions.
+// and streamed into the room: the message is edited as the tokens arrive.
+// Bot admins pick the model per room with !model.
 //
 // Set environment variables before running:
 //
@@ -11,6 +12,7 @@
 //	export MATRIX_API_PASS="botpassword"
 //	export OPEN_WEB_API_GENERATE_URL="http://localhost:11434/api/generate"
 //	export OPEN_WEB_API_TOKEN="your-ollama-token"
+//	export AI_MODEL="llama3.2:3b"  # optional default model
 //	go run ./examples/ai-assistant/
 package main
 
@@ -22,19 +24,12 @@ import (
 	"strings"
 
 	matrix "github.com/eslider/go-matrix-bot"
-	ollama "github.com/eslider/go-ollama"
-	"maunium.net/go/mautrix/event"
-	"maunium.net/go/mautrix/id"
+	"github.com/eslider/go-matrix-bot/modules/ai"
 )
 
-const (
-	// commandPrefix is the trigger prefix for AI queries.
-	// Users type "::what is Go?" to get an AI response.
-	commandPrefix = "::"
-
-	// model is the Ollama model to use for generation.
-	model = "llama3.2:3b"
-)
+// commandPrefix is the trigger prefix for AI queries.
+// Users type "::what is Go?" to get an AI response.
+const commandPrefix = "::"
 
 func main() {
 	// --- Matrix bot setup ---
@@ -52,62 +47,53 @@ func main() {
 		os.Exit(1)
 	}
 
-	// --- Ollama AI client setup ---
-	aiURL := os.Getenv("OPEN_WEB_API_GENERATE_URL")
-	aiToken := os.Getenv("OPEN_WEB_API_TOKEN")
-
-	if aiURL == "" {
+	// --- AI module: the model and temperature can be changed per room with !model ---
+	aiConfig := ai.GetEnvironmentConfig()
+	if aiConfig.URL == "" {
 		fmt.Fprintln(os.Stderr, "OPEN_WEB_API_GENERATE_URL is not set")
 		os.Exit(1)
 	}
+	assistant := ai.New(aiConfig)
+	if err = bot.Use(assistant); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up AI: %v\n", err)
+		os.Exit(1)
+	}
 
-	ai := ollama.NewOpenWebUiClient(&ollama.DSN{
-		URL:   aiURL,
-		Token: aiToken,
-	})
-
-	// --- Message handler: forward "::" messages to Ollama ---
-	bot.OnMessage(func(ctx context.Context, roomID id.RoomID, sender id.UserID, msg *event.MessageEventContent) {
+	// --- Message handler: forward "::" messages to the AI ---
+	bot.OnMessageContext(func(ctx context.Context, msg *matrix.MessageContext) {
 		// Ignore messages that don't start with the command prefix
-		if len(msg.Body) <= len(commandPrefix) || msg.Body[:len(commandPrefix)] != commandPrefix {
+		body := msg.Message.Body
+		if msg.FromSelf() || !strings.HasPrefix(body, commandPrefix) {
 			return
 		}
 
-		prompt := strings.TrimSpace(msg.Body[len(commandPrefix):])
+		prompt := strings.TrimSpace(body[len(commandPrefix):])
 		if prompt == "" {
 			return
 		}
 
-		fmt.Printf("[%s] %s asked: %s\n", roomID, sender, prompt)
-
-		// Collect streaming response from Ollama
-		var chunks []string
-		queryErr := ai.Query(ollama.Request{
-			Model:  model,
-			Prompt: prompt,
-			Options: &ollama.RequestOptions{
-				Temperature: ollama.Float(0.7),
-			},
-			OnJson: func(res ollama.Response) error {
-				if res.Response != nil {
-					chunks = append(chunks, *res.Response)
-				}
-				return nil
-			},
-		})
+		fmt.Printf("[%s] %s asked: %s\n", msg.RoomID, msg.Sender, prompt)
+
+		// Stream the response into a message that grows as tokens arrive
+		tokens := make(chan string)
+		var queryErr error
+		go func() {
+			defer close(tokens)
+			_, queryErr = assistant.Ask(ctx, msg, prompt, func(token string) {
+				tokens <- token
+			})
+		}()
+		_, sendErr := msg.StreamReply(ctx, tokens)
+		for range tokens {
+			// Drain the rest if sending failed
+		}
 
 		if queryErr != nil {
-			fmt.Fprintf(os.Stderr, "Ollama error: %v\n", queryErr)
-			_ = bot.SendText(ctx, roomID, "Sorry, I encountered an error generating a response.")
+			fmt.Fprintf(os.Stderr, "AI error: %v\n", queryErr)
+			_ = msg.Reply(ctx, "Sorry, I encountered an error generating a response.")
 			return
 		}
-
-		// Join all chunks and convert markdown to HTML
-		response := strings.Join(chunks, "")
-		html := matrix.MarkdownToHTML(response)
-
-		// Send formatted reply with user mention
-		if sendErr := bot.SendReply(ctx, roomID, response, html, sender); sendErr != nil {
+		if sendErr != nil {
 			fmt.Fprintf(os.Stderr, "Failed to send reply: %v\n", sendErr)
 		}
 	})
@@ -116,7 +102,7 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	fmt.Printf("AI Assistant bot starting (model: %s)...\n", model)
+	fmt.Println("AI Assistant bot starting...")
 	fmt.Println("Users can ask questions with: ::your question here")
 	fmt.Println("Press Ctrl+C to stop.")
 
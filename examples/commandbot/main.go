@@ -7,6 +7,7 @@
 //	!time       - Show current server time
 //	!ai <prompt> - Generate AI response using Ollama (if configured)
 //	!code <prompt> - Generate code and extract code blocks
+//	!model       - Show or change the AI model of the room (bot admins)
 //
 // Set environment variables before running:
 //
@@ -27,19 +28,10 @@ import (
 	"time"
 
 	matrix "github.com/eslider/go-matrix-bot"
+	"github.com/eslider/go-matrix-bot/modules/ai"
 	ollama "github.com/eslider/go-ollama"
-	"maunium.net/go/mautrix/event"
-	"maunium.net/go/mautrix/id"
 )
 
-// command represents a bot command with its handler.
-type command struct {
-	Name        string
-	Description string
-	Usage       string
-	Handler     func(ctx context.Context, bot *matrix.Bot, roomID id.RoomID, sender id.UserID, args string)
-}
-
 func main() {
 	// --- Matrix bot ---
 	botConfig := matrix.GetEnvironmentConfig()
@@ -56,49 +48,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	// --- Ollama AI client (optional) ---
-	var ai *ollama.Client
-	if aiURL := os.Getenv("OPEN_WEB_API_GENERATE_URL"); aiURL != "" {
-		ai = ollama.NewOpenWebUiClient(&ollama.DSN{
-			URL:   aiURL,
-			Token: os.Getenv("OPEN_WEB_API_TOKEN"),
+	// --- AI module and Ollama client for !code (optional) ---
+	var client *ollama.Client
+	var assistant *ai.Module
+	if aiConfig := ai.GetEnvironmentConfig(); aiConfig.URL != "" {
+		// The module provides !ai, !forget and !model, which sets the model per room
+		assistant = ai.New(aiConfig)
+		if err = bot.Use(assistant); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set up AI: %v\n", err)
+			os.Exit(1)
+		}
+		client = ollama.NewOpenWebUiClient(&ollama.DSN{
+			URL:   aiConfig.URL,
+			Token: aiConfig.Token,
 		})
 		fmt.Println("Ollama AI enabled")
 	} else {
 		fmt.Println("Ollama AI disabled (OPEN_WEB_API_GENERATE_URL not set)")
 	}
 
-	// --- Define commands ---
-	commands := buildCommands(bot, ai)
-
-	// --- Register message handler ---
-	bot.OnMessage(func(ctx context.Context, roomID id.RoomID, sender id.UserID, msg *event.MessageEventContent) {
-		body := strings.TrimSpace(msg.Body)
-		if !strings.HasPrefix(body, "!") {
-			return
-		}
-
-		// Parse command name and arguments
-		parts := strings.SplitN(body, " ", 2)
-		cmdName := strings.ToLower(parts[0])
-		args := ""
-		if len(parts) > 1 {
-			args = strings.TrimSpace(parts[1])
-		}
-
-		fmt.Printf("[%s] %s: %s\n", roomID, sender, body)
-
-		// Find and execute matching command
-		for _, cmd := range commands {
-			if cmd.Name == cmdName {
-				cmd.Handler(ctx, bot, roomID, sender, args)
-				return
-			}
-		}
-
-		// Unknown command
-		_ = bot.SendText(ctx, roomID, fmt.Sprintf("Unknown command: %s. Type !help for available commands.", cmdName))
-	})
+	// --- Define commands; !help is generated from them ---
+	registerCommands(bot, client, assistant)
 
 	// --- Start ---
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
@@ -121,77 +91,35 @@ func main() {
 	}
 }
 
-// buildCommands returns all available bot commands.
-func buildCommands(bot *matrix.Bot, ai *ollama.Client) []command {
-	commands := []command{
-		{
-			Name:        "!ping",
-			Description: "Check if the bot is alive",
-			Usage:       "!ping",
-			Handler: func(ctx context.Context, bot *matrix.Bot, roomID id.RoomID, _ id.UserID, _ string) {
-				_ = bot.SendText(ctx, roomID, "pong!")
-			},
-		},
-		{
-			Name:        "!time",
-			Description: "Show current server time",
-			Usage:       "!time",
-			Handler: func(ctx context.Context, bot *matrix.Bot, roomID id.RoomID, _ id.UserID, _ string) {
-				now := time.Now().Format("2006-01-02 15:04:05 MST")
-				md := fmt.Sprintf("Server time: **%s**", now)
-				_ = bot.SendHTML(ctx, roomID, now, matrix.MarkdownToHTML(md))
-			},
-		},
-	}
+// registerCommands registers all available bot commands.
+func registerCommands(bot *matrix.Bot, client *ollama.Client, assistant *ai.Module) {
+	bot.Command("ping", func(ctx context.Context, cmd *matrix.CommandContext) {
+		_ = cmd.Reply(ctx, "pong!")
+	}).Describe("Check if the bot is alive", "!ping")
 
-	// AI-powered commands (only available when Ollama is configured)
-	if ai != nil {
-		commands = append(commands,
-			command{
-				Name:        "!ai",
-				Description: "Ask the AI a question",
-				Usage:       "!ai <your question>",
-				Handler:     makeAIHandler(ai, "llama3.2:3b", false),
-			},
-			command{
-				Name:        "!code",
-				Description: "Generate code with the AI and extract code blocks",
-				Usage:       "!code <describe what you need>",
-				Handler:     makeAIHandler(ai, "llama3.2:3b", true),
-			},
-		)
-	}
+	bot.Command("time", func(ctx context.Context, cmd *matrix.CommandContext) {
+		now := time.Now().Format("2006-01-02 15:04:05 MST")
+		_ = cmd.Reply(ctx, fmt.Sprintf("Server time: **%s**", now))
+	}).Describe("Show current server time", "!time").
+		AsTool() // The AI may run it when asked "what time is it?"
 
-	// Help command (needs access to the full commands list)
-	helpCmd := command{
-		Name:        "!help",
-		Description: "Show available commands",
-		Usage:       "!help",
-	}
-	// We'll set the handler after appending, so it captures the final list
-	allCommands := append([]command{helpCmd}, commands...)
-
-	allCommands[0].Handler = func(ctx context.Context, bot *matrix.Bot, roomID id.RoomID, sender id.UserID, _ string) {
-		var sb strings.Builder
-		sb.WriteString("**Available commands:**\n\n")
-		for _, cmd := range allCommands {
-			sb.WriteString(fmt.Sprintf("- `%s` — %s\n", cmd.Usage, cmd.Description))
-		}
-		md := sb.String()
-		_ = bot.SendReply(ctx, roomID, md, matrix.MarkdownToHTML(md), sender)
+	// AI-powered commands (only available when Ollama is configured)
+	if client != nil {
+		bot.Command("code", makeCodeHandler(client, assistant)).
+			Describe("Generate code with the AI and extract code blocks", "!code <describe what you need>").
+			WithExamples("!code a Go function reversing a string")
 	}
-
-	return allCommands
 }
 
-// makeAIHandler creates a message handler that queries Ollama.
-// If extractCode is true, it also extracts and displays code blocks.
-func makeAIHandler(ai *ollama.Client, model string, extractCode bool) func(ctx context.Context, bot *matrix.Bot, roomID id.RoomID, sender id.UserID, args string) {
-	return func(ctx context.Context, bot *matrix.Bot, roomID id.RoomID, sender id.UserID, args string) {
-		if args == "" {
-			_ = bot.SendText(ctx, roomID, "Please provide a prompt. Example: !ai What is Go?")
+// makeCodeHandler creates a message handler that queries Ollama with the
+// room's model and extracts and displays code blocks.
+func makeCodeHandler(client *ollama.Client, assistant *ai.Module) matrix.CommandHandler {
+	return func(ctx context.Context, cmd *matrix.CommandContext) {
+		if cmd.Args == "" {
+			_ = cmd.Reply(ctx, "Please provide a prompt. Example: !code a Go function reversing a string")
 			return
 		}
+		model, _ := assistant.Settings(ctx, cmd.RoomID)
 
 		// Collect streaming tokens
 		var chunks []string
@@ -199,9 +127,9 @@ func makeAIHandler(ai *ollama.Client, model string, extractCode bool) func(ctx c
 
 		req := ollama.Request{
 			Model:  model,
-			Prompt: args,
+			Prompt: cmd.Args,
 			Options: &ollama.RequestOptions{
-				Temperature: ollama.Float(0.7),
+				Temperature: ollama.Float(0), // deterministic for code
 			},
 			OnJson: func(res ollama.Response) error {
 				if res.Response != nil {
@@ -209,30 +137,25 @@ func makeAIHandler(ai *ollama.Client, model string, extractCode bool) func(ctx c
 				}
 				return nil
 			},
-		}
-
-		if extractCode {
-			req.Options.Temperature = ollama.Float(0) // deterministic for code
-			req.OnCodeBlock = func(blocks []*ollama.CodeBlock) error {
+			OnCodeBlock: func(blocks []*ollama.CodeBlock) error {
 				codeBlocks = append(codeBlocks, blocks...)
 				return nil
-			}
+			},
 		}
 
-		if queryErr := ai.Query(req); queryErr != nil {
+		if queryErr := client.Query(req); queryErr != nil {
 			fmt.Fprintf(os.Stderr, "Ollama error: %v\n", queryErr)
-			_ = bot.SendText(ctx, roomID, "Sorry, AI query failed: "+queryErr.Error())
+			_ = cmd.Reply(ctx, "Sorry, AI query failed: "+queryErr.Error())
 			return
 		}
 
 		response := strings.Join(chunks, "")
 
 		// Append extracted code block summary
-		if extractCode && len(codeBlocks) > 0 {
+		if len(codeBlocks) > 0 {
 			response += fmt.Sprintf("\n\n---\n*Extracted %d code block(s)*", len(codeBlocks))
 		}
 
-		html := matrix.MarkdownToHTML(response)
-		_ = bot.SendReply(ctx, roomID, response, html, sender)
+		_ = cmd.Reply(ctx, response)
 	}
 }
@@ -11,6 +11,7 @@
 //	                          - Create a new OnlyOffice task
 //	!summarize <repo>         - AI summary of open issues
 //	!ai <prompt>              - Ask the AI anything
+//	!refresh                  - Drop cached listings, reload changed prompt templates
 //
 // Environment variables:
 //
@@ -25,6 +26,7 @@
 //	export ONLYOFFICE_PASS="password"
 //	export OPEN_WEB_API_GENERATE_URL="http://localhost:11434/api/generate"
 //	export OPEN_WEB_API_TOKEN="your-ollama-token"
+//	export AI_TEMPLATES="./prompts"  # optional, e.g. summarize-issues.tmpl
 //	go run ./examples/project-manager/
 package main
 
@@ -34,8 +36,11 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	matrix "github.com/eslider/go-matrix-bot"
+	"github.com/eslider/go-matrix-bot/modules/ai"
+	sdk "code.gitea.io/sdk/gitea"
 	gitea "github.com/eslider/go-gitea-helpers"
 	ollama "github.com/eslider/go-ollama"
 	onlyoffice "github.com/eslider/go-onlyoffice"
@@ -50,9 +55,18 @@ type services struct {
 	git *gitea.Client          // optional
 	oo  *onlyoffice.Client     // optional
 
+	// cache keeps upstream listings for a few minutes; !refresh clears it.
+	cache      *matrix.Cache
 	giteaOwner string
 }
 
+// summarizeIssuesPrompt is the default "summarize-issues" template; a
+// summarize-issues.tmpl in AI_TEMPLATES replaces it.
+const summarizeIssuesPrompt = `Summarize these {{len .Issues}} open issues for the repository '{{.Repo}}'. Group them by theme, highlight priorities, and suggest next steps:
+
+{{range .Issues}}- #{{.Index}}: {{.Title}}
+{{end}}`
+
 func main() {
 	// --- Matrix bot (required) ---
 	botConfig := matrix.GetEnvironmentConfig()
@@ -69,7 +83,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	svc := &services{bot: bot}
+	svc := &services{bot: bot, cache: matrix.NewCache(5*time.Minute, nil)}
 
 	// --- Ollama (optional) ---
 	if url := os.Getenv("OPEN_WEB_API_GENERATE_URL"); url != "" {
@@ -79,6 +93,17 @@ func main() {
 		})
 		fmt.Println("[+] Ollama AI connected")
 	}
+	if err = ai.Templates.Register("summarize-issues", summarizeIssuesPrompt); err != nil {
+		fmt.Fprintf(os.Stderr, "Template error: %v\n", err)
+		os.Exit(1)
+	}
+	if dir := os.Getenv("AI_TEMPLATES"); dir != "" {
+		if err = ai.Templates.LoadDir(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Template error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("[+] Prompt templates loaded:", dir)
+	}
 
 	// --- Gitea (optional) ---
 	giteaCfg := gitea.GetEnvironmentConfig()
@@ -130,6 +155,8 @@ func main() {
 			svc.cmdSummarize(ctx, roomID, sender, args)
 		case "!ai":
 			svc.cmdAI(ctx, roomID, sender, args)
+		case "!refresh":
+			svc.cmdRefresh(ctx, roomID, sender)
 		default:
 			_ = bot.SendText(ctx, roomID, "Unknown command. Type !help")
 		}
@@ -169,6 +196,7 @@ func (s *services) cmdHelp(ctx context.Context, roomID id.RoomID, sender id.User
 | ` + "`!create-task <project> \\| <title> \\| <description>`" + ` | Create an OnlyOffice task |
 | ` + "`!summarize <repo>`" + ` | AI summary of open issues |
 | ` + "`!ai <prompt>`" + ` | Ask the AI anything |
+| ` + "`!refresh`" + ` | Drop cached listings, reload changed prompt templates |
 
 **Services:** ` + s.statusLine()
 	_ = s.bot.SendReply(ctx, roomID, md, matrix.MarkdownToHTML(md), sender)
@@ -197,7 +225,9 @@ func (s *services) cmdRepos(ctx context.Context, roomID id.RoomID, sender id.Use
 		return
 	}
 
-	repos, err := s.git.GetAllRepos(s.giteaOwner)
+	repos, err := matrix.Cached(ctx, s.cache, "gitea:repos", func(context.Context) ([]*sdk.Repository, error) {
+		return s.git.GetAllRepos(s.giteaOwner)
+	})
 	if err != nil {
 		_ = s.bot.SendText(ctx, roomID, "Error: "+err.Error())
 		return
@@ -257,7 +287,7 @@ func (s *services) cmdProjects(ctx context.Context, roomID id.RoomID, sender id.
 		return
 	}
 
-	projects, err := s.oo.GetProjects()
+	projects, err := s.projects(ctx)
 	if err != nil {
 		_ = s.bot.SendText(ctx, roomID, "Error: "+err.Error())
 		return
@@ -277,6 +307,13 @@ func (s *services) cmdProjects(ctx context.Context, roomID id.RoomID, sender id.
 	_ = s.bot.SendReply(ctx, roomID, md, matrix.MarkdownToHTML(md), sender)
 }
 
+// projects returns the OnlyOffice projects, cached for a few minutes.
+func (s *services) projects(ctx context.Context) (onlyoffice.Projects, error) {
+	return matrix.Cached(ctx, s.cache, "onlyoffice:projects", func(context.Context) (onlyoffice.Projects, error) {
+		return s.oo.GetProjects()
+	})
+}
+
 func (s *services) cmdTasks(ctx context.Context, roomID id.RoomID, sender id.UserID, projectName string) {
 	if s.oo == nil {
 		_ = s.bot.SendText(ctx, roomID, "OnlyOffice is not configured.")
@@ -287,7 +324,7 @@ func (s *services) cmdTasks(ctx context.Context, roomID id.RoomID, sender id.Use
 		return
 	}
 
-	projects, err := s.oo.GetProjects()
+	projects, err := s.projects(ctx)
 	if err != nil {
 		_ = s.bot.SendText(ctx, roomID, "Error: "+err.Error())
 		return
@@ -343,7 +380,7 @@ func (s *services) cmdCreateTask(ctx context.Context, roomID id.RoomID, sender i
 		description = strings.TrimSpace(parts[2])
 	}
 
-	projects, err := s.oo.GetProjects()
+	projects, err := s.projects(ctx)
 	if err != nil {
 		_ = s.bot.SendText(ctx, roomID, "Error: "+err.Error())
 		return
@@ -369,6 +406,20 @@ func (s *services) cmdCreateTask(ctx context.Context, roomID id.RoomID, sender i
 	_ = s.bot.SendReply(ctx, roomID, md, matrix.MarkdownToHTML(md), sender)
 }
 
+func (s *services) cmdRefresh(ctx context.Context, roomID id.RoomID, sender id.UserID) {
+	if err := s.cache.Invalidate(ctx, ""); err != nil {
+		_ = s.bot.SendText(ctx, roomID, "Error: "+err.Error())
+		return
+	}
+	md := "Cache cleared, the next listing is fetched fresh."
+	if reloaded, err := ai.Templates.Reload(); err != nil {
+		md += " Prompt templates not reloaded: " + err.Error()
+	} else if reloaded {
+		md += " Prompt templates reloaded."
+	}
+	_ = s.bot.SendReply(ctx, roomID, md, matrix.MarkdownToHTML(md), sender)
+}
+
 func (s *services) cmdSummarize(ctx context.Context, roomID id.RoomID, sender id.UserID, repo string) {
 	if s.git == nil || s.ai == nil {
 		_ = s.bot.SendText(ctx, roomID, "Requires both Gitea and Ollama to be configured.")
@@ -385,27 +436,24 @@ func (s *services) cmdSummarize(ctx context.Context, roomID id.RoomID, sender id
 		return
 	}
 
-	// Build issue list for AI
-	var issueSummary strings.Builder
-	openCount := 0
+	var open []*sdk.Issue
 	for _, iss := range issues {
-		if iss.State == "closed" {
-			continue
+		if iss.State != "closed" {
+			open = append(open, iss)
 		}
-		openCount++
-		issueSummary.WriteString(fmt.Sprintf("- #%d: %s\n", iss.Index, iss.Title))
 	}
+	openCount := len(open)
 
 	if openCount == 0 {
 		_ = s.bot.SendText(ctx, roomID, fmt.Sprintf("No open issues in %s.", repo))
 		return
 	}
 
-	prompt := fmt.Sprintf(
-		"Summarize these %d open issues for the repository '%s'. "+
-			"Group them by theme, highlight priorities, and suggest next steps:\n\n%s",
-		openCount, repo, issueSummary.String(),
-	)
+	prompt, err := ai.Templates.Execute("summarize-issues", map[string]any{"Repo": repo, "Issues": open, "User": sender})
+	if err != nil {
+		_ = s.bot.SendText(ctx, roomID, "Error: "+err.Error())
+		return
+	}
 
 	var chunks []string
 	queryErr := s.ai.Query(ollama.Request{
@@ -0,0 +1,54 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+)
+
+// MessageFilter decides whether a message is handled at all; returning false
+// drops it.
+type MessageFilter func(ctx context.Context, msg *MessageContext) bool
+
+// OnMessageFilter registers a filter that runs before all handlers,
+// commands, dialogs and rules, e.g. to moderate messages. The first filter
+// returning false drops the message. Filters run in registration order; those
+// of modules disabled in a room are skipped there. A panicking filter lets the
+// message pass.
+func (b *Bot) OnMessageFilter(filter MessageFilter) {
+	b.filters = append(b.filters, filter)
+	b.filterModules = append(b.filterModules, b.initModule)
+}
+
+// passesFilters runs the filters on a message and reports whether all passed it.
+func (b *Bot) passesFilters(ctx context.Context, msg *MessageContext, disabled []string) bool {
+	for i, filter := range b.filters {
+		if module := b.filterModules[i]; module != "" && slices.Contains(disabled, module) {
+			continue
+		}
+		if !b.safeFilter(ctx, msg, filter) {
+			msg.Log.Debug().Msg("Message dropped by filter")
+			return false
+		}
+	}
+	return true
+}
+
+// safeFilter runs a filter, recovering from panics like safeHandle.
+func (b *Bot) safeFilter(ctx context.Context, msg *MessageContext, filter MessageFilter) (pass bool) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		msg.Log.Error().
+			Str("panic", fmt.Sprint(value)).
+			Bytes("stack", debug.Stack()).
+			Msg("Filter panicked")
+		b.reportAdmin(ctx, fmt.Sprintf("A message filter panicked in %s (stack trace in the logs)", msg.RoomID),
+			fmt.Errorf("%v", value))
+		pass = true
+	}()
+	return filter(ctx, msg)
+}
@@ -0,0 +1,149 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// AccountDataFeatureFlags is the global account data event holding the
+// feature flags: a JSON object mapping flag names to FeatureFlags.
+var AccountDataFeatureFlags = event.Type{Type: "com.github.eslider.matrix-bot.flags", Class: event.AccountDataEventType}
+
+// FeatureFlag rolls a feature out to some rooms: those listed in Rooms, plus
+// Percent percent of all others. Which rooms fall into the percentage is
+// derived from a hash of the flag name and room ID, so a room stays enabled
+// while the percentage is raised.
+type FeatureFlag struct {
+	Percent int         `json:"percent,omitempty" yaml:"percent,omitempty" toml:"percent"` // 0-100
+	Rooms   []id.RoomID `json:"rooms,omitempty" yaml:"rooms,omitempty" toml:"rooms"`
+}
+
+// EnabledIn reports whether the flag is on in a room.
+func (f FeatureFlag) EnabledIn(name string, roomID id.RoomID) bool {
+	if slices.Contains(f.Rooms, roomID) {
+		return true
+	}
+	if f.Percent <= 0 {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name + "\x00" + roomID.String()))
+	return int(hash.Sum32()%100) < f.Percent
+}
+
+// featureFlags caches the flags from account data, updated from sync so that
+// changes made by other replicas are picked up.
+type featureFlags struct {
+	mu     sync.RWMutex
+	flags  map[string]FeatureFlag // nil until fetched or received
+	writes sync.Mutex             // Serializes read-modify-write cycles of SetFeatureFlag
+}
+
+// handleEvent caches feature flag account data received from sync.
+func (c *featureFlags) handleEvent(_ context.Context, evt *event.Event) {
+	flags := make(map[string]FeatureFlag)
+	if len(evt.Content.VeryRaw) > 0 {
+		if err := json.Unmarshal(evt.Content.VeryRaw, &flags); err != nil {
+			return
+		}
+	}
+	c.mu.Lock()
+	c.flags = flags
+	c.mu.Unlock()
+}
+
+// FeatureFlags returns all feature flags: those set with SetFeatureFlag,
+// which are shared by all replicas of the bot, and the defaults from
+// Config.FeatureFlags for flags not set that way.
+func (b *Bot) FeatureFlags(ctx context.Context) (map[string]FeatureFlag, error) {
+	flags := maps.Clone(b.config.FeatureFlags)
+	if flags == nil {
+		flags = make(map[string]FeatureFlag)
+	}
+	if b.client == nil || b.client.AccessToken == "" {
+		return flags, nil
+	}
+	stored, err := b.storedFeatureFlags(ctx)
+	if err != nil {
+		return flags, err
+	}
+	maps.Copy(flags, stored)
+	return flags, nil
+}
+
+// FeatureEnabled reports whether a feature flag is on in a room. Unknown flags
+// are off. If the flags can't be read from the homeserver, the defaults from
+// Config.FeatureFlags apply.
+func (b *Bot) FeatureEnabled(ctx context.Context, name string, roomID id.RoomID) bool {
+	flags, err := b.FeatureFlags(ctx)
+	if err != nil {
+		b.log.Warn().Err(err).Str("flag", name).Msg("Failed to read feature flags")
+	}
+	flag, ok := flags[name]
+	return ok && flag.EnabledIn(name, roomID)
+}
+
+// SetFeatureFlag sets a feature flag for all replicas; nil removes it, so the
+// default from Config.FeatureFlags (if any) applies again.
+func (b *Bot) SetFeatureFlag(ctx context.Context, name string, flag *FeatureFlag) error {
+	cache := b.flags
+	cache.writes.Lock()
+	defer cache.writes.Unlock()
+	flags, err := b.fetchFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
+	if flag == nil {
+		delete(flags, name)
+	} else {
+		flags[name] = *flag
+	}
+	if err = b.client.SetAccountData(ctx, AccountDataFeatureFlags.Type, flags); err != nil {
+		return fmt.Errorf("matrix: failed to save feature flags: %w", err)
+	}
+	cache.mu.Lock()
+	cache.flags = flags
+	cache.mu.Unlock()
+	return nil
+}
+
+// storedFeatureFlags returns the flags from account data, fetching them if they aren't cached.
+func (b *Bot) storedFeatureFlags(ctx context.Context) (map[string]FeatureFlag, error) {
+	cache := b.flags
+	cache.mu.RLock()
+	flags := cache.flags
+	cache.mu.RUnlock()
+	if flags != nil {
+		return flags, nil
+	}
+	flags, err := b.fetchFeatureFlags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.flags = flags
+	cache.mu.Unlock()
+	return flags, nil
+}
+
+// fetchFeatureFlags reads the flags from the homeserver.
+func (b *Bot) fetchFeatureFlags(ctx context.Context) (map[string]FeatureFlag, error) {
+	flags := make(map[string]FeatureFlag)
+	err := b.client.GetAccountData(ctx, AccountDataFeatureFlags.Type, &flags)
+	if errors.Is(err, mautrix.MNotFound) {
+		return make(map[string]FeatureFlag), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read feature flags: %w", err)
+	}
+	return flags, nil
+}
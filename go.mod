@@ -3,18 +3,23 @@ module github.com/eslider/go-matrix-bot
 go 1.24.0
 
 require (
+	code.gitea.io/sdk/gitea v0.23.2
+	github.com/BurntSushi/toml v1.6.0
 	github.com/eslider/go-gitea-helpers v0.1.0
 	github.com/eslider/go-ollama v0.1.0
 	github.com/eslider/go-onlyoffice v0.1.0
 	github.com/gomarkdown/markdown v0.0.0-20250810172220-2e2c11897d1a
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.34
 	github.com/rs/zerolog v1.34.0
 	go.mau.fi/util v0.9.5
+	golang.org/x/term v0.39.0
+	golang.org/x/text v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
 	maunium.net/go/mautrix v0.26.2
 )
 
 require (
-	code.gitea.io/sdk/gitea v0.23.2 // indirect
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/42wim/httpsig v1.2.3 // indirect
 	github.com/davidmz/go-pageant v1.0.2 // indirect
@@ -32,5 +37,4 @@ require (
 	golang.org/x/exp v0.0.0-20260112195511-716be5621a96 // indirect
 	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
-	golang.org/x/text v0.33.0 // indirect
 )
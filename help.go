@@ -0,0 +1,183 @@
+package matrix
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// CommandFlag documents an option of a command for !help, e.g. "--all".
+type CommandFlag struct {
+	Name        string
+	Description string
+}
+
+// WithExamples adds example invocations shown by "!help <command>", e.g.
+// "!meet tomorrow 10:00 30m Standup".
+func (c *Command) WithExamples(examples ...string) *Command {
+	c.Examples = append(c.Examples, examples...)
+	return c
+}
+
+// WithFlag documents an option of the command for "!help <command>". The
+// handler parses it from the arguments itself.
+func (c *Command) WithFlag(name, description string) *Command {
+	c.Flags = append(c.Flags, CommandFlag{Name: name, Description: description})
+	return c
+}
+
+// registerHelp adds the built-in !help command, unless the application has
+// its own. See Config.DisableHelp.
+func (b *Bot) registerHelp() {
+	cmd := &Command{
+		Name:        "help",
+		Description: b.Translate(DefaultLanguage, "command.help.description"),
+		Usage:       b.commandPrefix() + "help [<command>]",
+		Priority:    PriorityInteractive,
+		AlwaysOn:    true,
+		Handler:     b.cmdHelp,
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.commands[cmd.Name]; !ok {
+		b.commands[cmd.Name] = cmd
+	}
+}
+
+// cmdHelp handles "!help [<command>]".
+func (b *Bot) cmdHelp(ctx context.Context, cmd *CommandContext) {
+	prefix := b.CommandPrefixes(ctx, cmd.RoomID)[0]
+	var md string
+	if name := strings.ToLower(strings.TrimSpace(cmd.Args)); name != "" {
+		md = b.CommandHelp(ctx, cmd.RoomID, cmd.Sender, b.trimCommandPrefix(ctx, cmd.RoomID, name))
+		if md == "" {
+			md = b.T(ctx, cmd.RoomID, "help.unknown", name, prefix+"help")
+		}
+	} else {
+		md = b.Help(ctx, cmd.RoomID, cmd.Sender)
+	}
+	if err := cmd.Reply(ctx, md); err != nil {
+		cmd.Log.Warn().Err(err).Msg("Failed to send help")
+	}
+}
+
+// Help renders the list of commands a user can run in a room as markdown,
+// grouped by module, in the room's language. Muted commands and those the
+// user may not run are left out.
+func (b *Bot) Help(ctx context.Context, roomID id.RoomID, userID id.UserID) string {
+	prefix := b.CommandPrefixes(ctx, roomID)[0]
+	language := b.Language(ctx, roomID)
+	groups := make(map[string][]*Command)
+	for _, cmd := range b.Commands() {
+		if b.commandMuted(ctx, roomID, cmd) || b.CanRun(ctx, cmd, roomID, userID) != nil {
+			continue
+		}
+		groups[cmd.Module] = append(groups[cmd.Module], cmd)
+	}
+	modules := slices.Sorted(maps.Keys(groups)) // "" (no module) comes first
+
+	var sb strings.Builder
+	sb.WriteString(b.Translate(language, "help.title") + "\n")
+	for _, module := range modules {
+		title := module
+		if title == "" {
+			title = b.Translate(language, "help.general")
+		}
+		sb.WriteString("\n**" + title + "**\n\n")
+		commands := groups[module]
+		slices.SortFunc(commands, func(a, b *Command) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+		for _, cmd := range commands {
+			sb.WriteString("- `" + b.commandUsage(cmd, prefix) + "`")
+			if description := b.commandDescription(language, cmd); description != "" {
+				sb.WriteString(" — " + description)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString("\n" + b.Translate(language, "help.more", prefix+"help <command>"))
+	return sb.String()
+}
+
+// CommandHelp renders the details of a command as markdown: usage, aliases,
+// options, examples and limits. It is empty if there is no such command in
+// the room, e.g. because it is muted.
+func (b *Bot) CommandHelp(ctx context.Context, roomID id.RoomID, userID id.UserID, name string) string {
+	cmd := b.resolveCommand(ctx, roomID, name)
+	if cmd == nil || b.commandMuted(ctx, roomID, cmd) || !b.commandAvailable(roomID, cmd) {
+		return ""
+	}
+	prefix := b.CommandPrefixes(ctx, roomID)[0]
+	language := b.Language(ctx, roomID)
+
+	var sb strings.Builder
+	sb.WriteString("**" + prefix + cmd.Name + "**")
+	if description := b.commandDescription(language, cmd); description != "" {
+		sb.WriteString(" — " + description)
+	}
+	sb.WriteString("\n\n" + b.Translate(language, "help.usage", "`"+b.commandUsage(cmd, prefix)+"`"))
+	if len(cmd.Aliases) > 0 {
+		aliases := make([]string, len(cmd.Aliases))
+		for i, alias := range cmd.Aliases {
+			aliases[i] = "`" + prefix + alias + "`"
+		}
+		sb.WriteString("  \n" + b.Translate(language, "help.aliases", strings.Join(aliases, ", ")))
+	}
+	if cmd.Module != "" {
+		sb.WriteString("  \n" + b.Translate(language, "help.module", cmd.Module))
+	}
+	limit := b.commandLimit(cmd)
+	if limit.UserCooldown > 0 {
+		sb.WriteString("  \n" + b.Translate(language, "help.cooldown", limit.UserCooldown))
+	}
+	if limit.RoomBurst > 0 && limit.RoomWindow > 0 {
+		sb.WriteString("  \n" + b.Translate(language, "help.limit", limit.RoomBurst, limit.RoomWindow))
+	}
+	if len(cmd.Flags) > 0 {
+		sb.WriteString("\n\n" + b.Translate(language, "help.options") + "\n")
+		for _, flag := range cmd.Flags {
+			sb.WriteString("\n- `" + flag.Name + "` — " + flag.Description)
+		}
+	}
+	if len(cmd.Examples) > 0 {
+		sb.WriteString("\n\n" + b.Translate(language, "help.examples") + "\n")
+		for _, example := range cmd.Examples {
+			sb.WriteString("\n- `" + b.withPrefix(example, prefix) + "`")
+		}
+	}
+	if d := b.denyReason(ctx, cmd, roomID, userID); d != nil {
+		sb.WriteString("\n\n" + b.Translate(language, "command.denied", d.text(b, language)))
+	}
+	return sb.String()
+}
+
+// commandDescription returns the description of a command in a language, if
+// a catalog translates it, see Catalog.
+func (b *Bot) commandDescription(language string, cmd *Command) string {
+	if description, ok := b.lookupMessage(language, "command."+cmd.Name+".description"); ok {
+		return description
+	}
+	return cmd.Description
+}
+
+// commandUsage returns the usage of a command with the room's prefix.
+func (b *Bot) commandUsage(cmd *Command, prefix string) string {
+	if cmd.Usage == "" {
+		return prefix + cmd.Name
+	}
+	return b.withPrefix(cmd.Usage, prefix)
+}
+
+// withPrefix replaces the configured command prefix at the start of a usage
+// or example with the one of the room.
+func (b *Bot) withPrefix(s, prefix string) string {
+	if rest, ok := strings.CutPrefix(s, b.commandPrefix()); ok {
+		return prefix + rest
+	}
+	return s
+}
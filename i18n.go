@@ -0,0 +1,204 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// LanguageSetting is the room setting choosing the language of the bot's
+// built-in responses in a room, e.g. "de". It overrides Config.Language.
+const LanguageSetting = "language"
+
+// DefaultLanguage is used when neither the room nor Config.Language sets one.
+const DefaultLanguage = "en"
+
+// Catalog maps message keys to translations, which are fmt format strings
+// taking the same arguments as the English message, e.g.
+// "command.cooldown": "⏳ Langsamer bitte, versuche `%s` in %s erneut.".
+//
+// Command descriptions can be translated too, with the key
+// "command.<name>.description".
+type Catalog map[string]string
+
+// builtinCatalogs are the translations of the bot's built-in responses.
+var builtinCatalogs = map[string]Catalog{
+	"en": {
+		"access.admin_only":        "only bot admins can run this command",
+		"access.not_allowed":       "you aren't allowed to run this command",
+		"access.power_level":       "this command requires power level %d",
+		"access.room":              "the command isn't available in this room",
+		"command.denied":           "⛔ Sorry, %s.",
+		"command.cooldown":         "⏳ Please slow down, try `%s` again in %s.",
+		"command.suggest":          "Unknown command `%s`. Did you mean %s?",
+		"command.help.description": "Show the commands, or details of one",
+		"list.or":                  "%s or %s",
+		"dispatch.overloaded":      "⚠️ I'm overloaded right now and had to skip your request. Please try again in a moment.",
+		"dispatch.timeout":         "⏱️ This took longer than %s and was cancelled.",
+		"dialog.timeout":           "⌛ No answer within %s, cancelled.",
+		"dialog.cancelled":         "Cancelled.",
+		"menu.page":                "(page %d/%d)",
+		"menu.empty":               "_Nothing to show._",
+		"menu.turn":                "%s %s to change pages",
+		"help.title":               "**Commands**",
+		"help.general":             "General",
+		"help.more":                "Type `%s` for details.",
+		"help.unknown":             "Unknown command `%s`. Type `%s` for the available commands.",
+		"help.usage":               "Usage: %s",
+		"help.aliases":             "Aliases: %s",
+		"help.module":              "Module: %s",
+		"help.cooldown":            "Cooldown: %s per user",
+		"help.limit":               "Limit: %d runs per %s in this room",
+		"help.options":             "**Options**",
+		"help.examples":            "**Examples**",
+		"setting.set":              "⚙️ %s set to %s by %s",
+		"setting.removed":          "⚙️ %s removed (was %s) by %s",
+		"setting.changed":          "⚙️ %s changed from %s to %s by %s",
+		"crypto.keys_lost": "ℹ️ The bot's encryption keys were lost on %s. Encrypted messages sent before then are " +
+			"unreadable to the bot; please repeat anything it should still act on.",
+	},
+	"de": {
+		"access.admin_only":        "nur Bot-Admins dürfen diesen Befehl ausführen",
+		"access.not_allowed":       "du darfst diesen Befehl nicht ausführen",
+		"access.power_level":       "dieser Befehl erfordert Berechtigungsstufe %d",
+		"access.room":              "der Befehl ist in diesem Raum nicht verfügbar",
+		"command.denied":           "⛔ Leider %s.",
+		"command.cooldown":         "⏳ Bitte etwas langsamer, versuche `%s` in %s erneut.",
+		"command.suggest":          "Unbekannter Befehl `%s`. Meintest du %s?",
+		"command.help.description": "Zeigt die Befehle oder Details zu einem Befehl",
+		"list.or":                  "%s oder %s",
+		"dispatch.overloaded":      "⚠️ Ich bin gerade überlastet und musste deine Anfrage überspringen. Bitte versuche es gleich noch einmal.",
+		"dispatch.timeout":         "⏱️ Das hat länger als %s gedauert und wurde abgebrochen.",
+		"dialog.timeout":           "⌛ Keine Antwort innerhalb von %s, abgebrochen.",
+		"dialog.cancelled":         "Abgebrochen.",
+		"menu.page":                "(Seite %d/%d)",
+		"menu.empty":               "_Nichts anzuzeigen._",
+		"menu.turn":                "%s %s zum Blättern",
+		"help.title":               "**Befehle**",
+		"help.general":             "Allgemein",
+		"help.more":                "Details mit `%s`.",
+		"help.unknown":             "Unbekannter Befehl `%s`. `%s` zeigt die verfügbaren Befehle.",
+		"help.usage":               "Aufruf: %s",
+		"help.aliases":             "Aliase: %s",
+		"help.module":              "Modul: %s",
+		"help.cooldown":            "Wartezeit: %s pro Benutzer",
+		"help.limit":               "Limit: %d Aufrufe pro %s in diesem Raum",
+		"help.options":             "**Optionen**",
+		"help.examples":            "**Beispiele**",
+		"setting.set":              "⚙️ %s auf %s gesetzt von %s",
+		"setting.removed":          "⚙️ %s entfernt (war %s) von %s",
+		"setting.changed":          "⚙️ %s von %s auf %s geändert von %s",
+		"crypto.keys_lost": "ℹ️ Die Verschlüsselungsschlüssel des Bots gingen am %s verloren. Verschlüsselte Nachrichten " +
+			"von davor kann der Bot nicht lesen; bitte wiederhole alles, worauf er noch reagieren soll.",
+	},
+	"ru": {
+		"access.admin_only":        "эту команду могут выполнять только администраторы бота",
+		"access.not_allowed":       "вам нельзя выполнять эту команду",
+		"access.power_level":       "для этой команды нужен уровень прав %d",
+		"access.room":              "эта команда недоступна в этой комнате",
+		"command.denied":           "⛔ К сожалению, %s.",
+		"command.cooldown":         "⏳ Не так быстро, повторите `%s` через %s.",
+		"command.suggest":          "Неизвестная команда `%s`. Возможно, вы имели в виду %s?",
+		"command.help.description": "Показать команды или подробности об одной из них",
+		"list.or":                  "%s или %s",
+		"dispatch.overloaded":      "⚠️ Я сейчас перегружен и пропустил ваш запрос. Пожалуйста, повторите чуть позже.",
+		"dispatch.timeout":         "⏱️ Это заняло больше %s и было отменено.",
+		"dialog.timeout":           "⌛ Нет ответа в течение %s, отменено.",
+		"dialog.cancelled":         "Отменено.",
+		"menu.page":                "(страница %d/%d)",
+		"menu.empty":               "_Нечего показать._",
+		"menu.turn":                "%s %s — листать страницы",
+		"help.title":               "**Команды**",
+		"help.general":             "Общие",
+		"help.more":                "Подробнее: `%s`.",
+		"help.unknown":             "Неизвестная команда `%s`. Список доступных команд: `%s`.",
+		"help.usage":               "Использование: %s",
+		"help.aliases":             "Псевдонимы: %s",
+		"help.module":              "Модуль: %s",
+		"help.cooldown":            "Пауза: %s для каждого пользователя",
+		"help.limit":               "Лимит: %d запусков за %s в этой комнате",
+		"help.options":             "**Параметры**",
+		"help.examples":            "**Примеры**",
+		"setting.set":              "⚙️ %s установлено в %s пользователем %s",
+		"setting.removed":          "⚙️ %s удалено (было %s) пользователем %s",
+		"setting.changed":          "⚙️ %s изменено с %s на %s пользователем %s",
+		"crypto.keys_lost": "ℹ️ Ключи шифрования бота были утеряны %s. Зашифрованные сообщения, отправленные раньше, " +
+			"бот прочитать не может; пожалуйста, повторите всё, на что он ещё должен отреагировать.",
+	},
+}
+
+// RegisterCatalog adds translations for a language, e.g. "de" or "pt-BR",
+// overriding built-in ones with the same keys. Modules register the
+// translations of their own messages and look them up with Bot.T.
+func (b *Bot) RegisterCatalog(language string, catalog Catalog) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.catalogs == nil {
+		b.catalogs = make(map[string]Catalog)
+	}
+	language = strings.ToLower(language)
+	merged := make(Catalog, len(b.catalogs[language])+len(catalog))
+	for key, message := range b.catalogs[language] {
+		merged[key] = message
+	}
+	for key, message := range catalog {
+		merged[key] = message
+	}
+	b.catalogs[language] = merged
+}
+
+// Language returns the language of a room: its LanguageSetting, else
+// Config.Language, else DefaultLanguage.
+func (b *Bot) Language(ctx context.Context, roomID id.RoomID) string {
+	if language, err := b.RoomSetting(ctx, roomID, LanguageSetting); err == nil && language != "" {
+		return strings.ToLower(language)
+	}
+	if b.config.Language != "" {
+		return strings.ToLower(b.config.Language)
+	}
+	return DefaultLanguage
+}
+
+// T returns a message in the language of a room, formatted with args. See
+// Translate.
+func (b *Bot) T(ctx context.Context, roomID id.RoomID, key string, args ...any) string {
+	return b.Translate(b.Language(ctx, roomID), key, args...)
+}
+
+// Translate returns a message in a language, formatted with args. Missing
+// translations fall back to the base language ("de" for "de-AT"), then to
+// English, then to the key itself.
+func (b *Bot) Translate(language, key string, args ...any) string {
+	message, ok := b.lookupMessage(language, key)
+	if !ok {
+		message = key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// lookupMessage finds the translation of a key, with fallbacks.
+func (b *Bot) lookupMessage(language, key string) (string, bool) {
+	language = strings.ToLower(language)
+	languages := []string{language}
+	if base, _, ok := strings.Cut(strings.ReplaceAll(language, "_", "-"), "-"); ok {
+		languages = append(languages, base)
+	}
+	languages = append(languages, DefaultLanguage)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, language := range languages {
+		if message, ok := b.catalogs[language][key]; ok {
+			return message, true
+		}
+		if message, ok := builtinCatalogs[language][key]; ok {
+			return message, true
+		}
+	}
+	return "", false
+}
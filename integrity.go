@@ -0,0 +1,190 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// DatabaseBackupSuffix is appended to Config.Database for the backup that is
+// restored when the database turns out to be corrupt, see BackupDatabase.
+const DatabaseBackupSuffix = ".bak"
+
+// StoreCorruptError is returned by Run when the integrity check of the SQLite
+// database fails on startup and Config.RecoverCorruptStore isn't set.
+type StoreCorruptError struct {
+	Path     string
+	Problems []string // Findings of PRAGMA quick_check, or the error of running it
+}
+
+func (e *StoreCorruptError) Error() string {
+	return fmt.Sprintf("matrix: database %s is corrupt (%s); restore %s%s or set Config.RecoverCorruptStore to start over with a new device",
+		e.Path, strings.Join(e.Problems, "; "), e.Path, DatabaseBackupSuffix)
+}
+
+// storeRecovery describes a recovery from a corrupt database, for the notices after login.
+type storeRecovery struct {
+	at          time.Time
+	fromBackup  bool
+	corruptPath string // Where the corrupt database was moved
+}
+
+// checkIntegrity runs a quick integrity check of an SQLite database.
+func checkIntegrity(ctx context.Context, db *dbutil.Database, path string) error {
+	rows, err := db.Query(ctx, "PRAGMA quick_check")
+	if err != nil {
+		return &StoreCorruptError{Path: path, Problems: []string{err.Error()}}
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err = rows.Scan(&result); err != nil {
+			return &StoreCorruptError{Path: path, Problems: []string{err.Error()}}
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return &StoreCorruptError{Path: path, Problems: problems}
+	}
+	return nil
+}
+
+// checkedDatabase opens the SQLite database at Config.Database and checks its
+// integrity. With Config.RecoverCorruptStore a corrupt database is moved aside
+// and replaced by the backup, if that one is intact, or by an empty database.
+func (b *Bot) checkedDatabase(ctx context.Context) (*dbutil.Database, error) {
+	db, err := b.openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	path := b.config.Database
+	if b.config.DatabaseURI != "" || path == MemoryDatabase {
+		return db, nil
+	}
+	checkErr := checkIntegrity(ctx, db, path)
+	if checkErr == nil {
+		return db, nil
+	}
+	_ = db.Close()
+	if !b.config.RecoverCorruptStore {
+		return nil, checkErr
+	}
+
+	b.log.Error().Err(checkErr).Msg("Database is corrupt, recovering")
+	recovery := &storeRecovery{at: time.Now(), corruptPath: fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err = os.Rename(path+suffix, recovery.corruptPath+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("matrix: failed to move corrupt database aside: %w", err)
+		}
+	}
+	if err = copyFile(path+DatabaseBackupSuffix, path); err == nil {
+		if db, err = b.openDatabase(); err != nil {
+			return nil, err
+		}
+		if err = checkIntegrity(ctx, db, path+DatabaseBackupSuffix); err == nil {
+			recovery.fromBackup = true
+			b.recovery = recovery
+			b.log.Warn().Msg("Restored the database from its backup")
+			return db, nil
+		}
+		b.log.Warn().Err(err).Msg("The database backup is corrupt too, starting with an empty database")
+		_ = db.Close()
+		_ = os.Remove(path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("matrix: failed to restore database backup: %w", err)
+	}
+	b.recovery = recovery
+	return b.openDatabase()
+}
+
+// copyFile copies src to dst, failing with os.ErrNotExist if src doesn't exist.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// BackupDatabase writes a consistent copy of the SQLite database to path while
+// the bot runs, e.g. daily to Config.Database+DatabaseBackupSuffix, which is
+// restored automatically if the database is found corrupt on startup (see
+// Config.RecoverCorruptStore). PostgreSQL databases should be backed up with pg_dump.
+func (b *Bot) BackupDatabase(ctx context.Context, path string) error {
+	if b.db == nil {
+		return ErrNoDatabase
+	}
+	if b.db.Dialect != dbutil.SQLite {
+		return fmt.Errorf("matrix: BackupDatabase only supports SQLite, use pg_dump for PostgreSQL")
+	}
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if _, err := b.db.Exec(ctx, "VACUUM INTO $1", tmp); err != nil {
+		return fmt.Errorf("matrix: failed to back up database: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("matrix: failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// announceRecovery tells the admin room about a recovery from a corrupt
+// database and, unless keys were restored from the key backup, warns the
+// encrypted rooms that the bot can't read their earlier history.
+func (b *Bot) announceRecovery(ctx context.Context) {
+	r := b.recovery
+	source := "an empty database with a new device"
+	if r.fromBackup {
+		source = "the database backup"
+	}
+	b.NotifyAdmin(ctx, fmt.Sprintf("⚠️ **The bot's database was corrupt** and has been replaced with %s. "+
+		"The corrupt copy was kept at `%s`.", source, r.corruptPath))
+	if r.fromBackup || b.backupKey != nil {
+		return
+	}
+
+	rooms, err := b.client.JoinedRooms(ctx)
+	if err != nil {
+		b.log.Warn().Err(err).Msg("Failed to list rooms to announce the database recovery")
+		return
+	}
+	for _, roomID := range rooms.JoinedRooms {
+		if !b.roomEncrypted(ctx, roomID) {
+			continue
+		}
+		md := b.T(ctx, roomID, "crypto.keys_lost", r.at.UTC().Format("2006-01-02 15:04 UTC"))
+		if err = b.SendHTML(ctx, roomID, md, MarkdownToHTML(md)); err != nil {
+			b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to announce the database recovery")
+		}
+	}
+}
+
+// roomEncrypted checks the encryption state of a room on the homeserver, as
+// the state store of a recovered database doesn't know the rooms yet.
+func (b *Bot) roomEncrypted(ctx context.Context, roomID id.RoomID) bool {
+	var content event.EncryptionEventContent
+	return b.client.StateEvent(ctx, roomID, event.StateEncryption, "", &content) == nil && content.Algorithm != ""
+}
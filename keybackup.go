@@ -0,0 +1,139 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// keyBackupInterval is how often new megolm sessions are uploaded to the key backup.
+const keyBackupInterval = 5 * time.Minute
+
+// setupKeyBackup unlocks secure secret storage (SSSS) with Config.RecoveryKey:
+// the device is cross-signed, the megolm backup key is fetched, and all room
+// keys are restored from the latest server-side backup if it wasn't restored
+// before. This lets the bot decrypt history after losing its database.
+func (b *Bot) setupKeyBackup(ctx context.Context) error {
+	mach := b.crypto.Machine()
+	keyID, keyData, err := mach.SSSS.GetDefaultKeyData(ctx)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to get secret storage key: %w", err)
+	}
+	key, err := keyData.VerifyRecoveryKey(keyID, b.config.RecoveryKey)
+	if err != nil {
+		return fmt.Errorf("matrix: invalid recovery key: %w", err)
+	}
+
+	if err = mach.VerifyWithRecoveryKey(ctx, b.config.RecoveryKey); err != nil {
+		// Not fatal: key backup works without cross-signing.
+		b.log.Warn().Err(err).Msg("Failed to cross-sign the bot device")
+	}
+
+	data, err := mach.SSSS.GetDecryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to get key backup key: %w", err)
+	}
+	backupKey, err := backup.MegolmBackupKeyFromBytes(data)
+	if err != nil {
+		return fmt.Errorf("matrix: invalid key backup key: %w", err)
+	}
+
+	versionInfo, err := mach.GetAndVerifyLatestKeyBackupVersion(ctx, backupKey)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to check key backup: %w", err)
+	}
+	if versionInfo == nil {
+		b.log.Warn().Msg("No key backup found on the server, keys won't be backed up")
+		return nil
+	}
+
+	if mach.KeyBackupVersion() != versionInfo.Version {
+		b.log.Info().Stringer("version", versionInfo.Version).Int("keys", versionInfo.Count).Msg("Restoring keys from backup")
+		if err = mach.GetAndStoreKeyBackup(ctx, versionInfo.Version, backupKey); err != nil {
+			return fmt.Errorf("matrix: failed to restore key backup: %w", err)
+		}
+		if err = mach.SetKeyBackupVersion(ctx, versionInfo.Version); err != nil {
+			return fmt.Errorf("matrix: failed to save key backup version: %w", err)
+		}
+	}
+	b.backupKey = backupKey
+	return nil
+}
+
+// runKeyBackup periodically uploads new megolm sessions to the key backup.
+func (b *Bot) runKeyBackup(ctx context.Context) {
+	ticker := time.NewTicker(keyBackupInterval)
+	defer ticker.Stop()
+	for {
+		if err := b.uploadKeys(ctx); err != nil {
+			b.log.Warn().Err(err).Msg("Failed to upload keys to backup")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// uploadKeys uploads all sessions that aren't in the current key backup yet.
+func (b *Bot) uploadKeys(ctx context.Context) error {
+	mach := b.crypto.Machine()
+	version := mach.KeyBackupVersion()
+	sessions, err := mach.CryptoStore.GetGroupSessionsWithoutKeyBackupVersion(ctx, version).AsList()
+	if err != nil || len(sessions) == 0 {
+		return err
+	}
+
+	req := &mautrix.ReqKeyBackup{Rooms: make(map[id.RoomID]mautrix.ReqRoomKeyBackup)}
+	for _, session := range sessions {
+		firstIndex := session.Internal.FirstKnownIndex()
+		sessionKey, err := session.Internal.Export(firstIndex)
+		if err != nil {
+			return fmt.Errorf("failed to export session: %w", err)
+		}
+		encrypted, err := backup.EncryptSessionData(b.backupKey, &backup.MegolmSessionData{
+			Algorithm:          id.AlgorithmMegolmV1,
+			ForwardingKeyChain: session.ForwardingChains,
+			SenderClaimedKeys:  backup.SenderClaimedKeys{Ed25519: session.SigningKey},
+			SenderKey:          session.SenderKey,
+			SessionKey:         string(sessionKey),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encrypt session: %w", err)
+		}
+		sessionData, err := json.Marshal(encrypted)
+		if err != nil {
+			return err
+		}
+
+		room, ok := req.Rooms[session.RoomID]
+		if !ok {
+			room = mautrix.ReqRoomKeyBackup{Sessions: make(map[id.SessionID]mautrix.ReqKeyBackupData)}
+			req.Rooms[session.RoomID] = room
+		}
+		room.Sessions[session.ID()] = mautrix.ReqKeyBackupData{
+			FirstMessageIndex: int(firstIndex),
+			ForwardedCount:    len(session.ForwardingChains),
+			SessionData:       sessionData,
+		}
+	}
+
+	if _, err = b.client.PutKeysInBackup(ctx, version, req); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		session.KeyBackupVersion = version
+		if err = mach.CryptoStore.PutGroupSession(ctx, session); err != nil {
+			return err
+		}
+	}
+	b.log.Debug().Int("keys", len(sessions)).Msg("Uploaded keys to backup")
+	return nil
+}
@@ -0,0 +1,161 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ReadyHandler is called once per Run, after login and the first sync, when
+// the bot's rooms and their state are known.
+type ReadyHandler func(ctx context.Context)
+
+// SyncErrorHandler is called when a sync request fails. The bot retries it.
+type SyncErrorHandler func(ctx context.Context, err error)
+
+// RoomJoinedHandler is called when the bot has joined a room.
+type RoomJoinedHandler func(ctx context.Context, roomID id.RoomID)
+
+// InviteHandler is called for invites from allowed rooms and users, see
+// Config.AllowedRooms. Returning false declines the invite; otherwise the bot joins.
+type InviteHandler func(ctx context.Context, roomID id.RoomID, inviter id.UserID) bool
+
+// OnReady registers a handler that runs once the bot is logged in and has
+// processed its first sync, e.g. to announce startup or create rooms.
+func (b *Bot) OnReady(handler ReadyHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.readyHandlers = append(b.readyHandlers, handler)
+}
+
+// OnSyncError registers a handler that is called when a sync request fails,
+// e.g. to count errors or alert when the homeserver rejects the access token.
+func (b *Bot) OnSyncError(handler SyncErrorHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.syncErrorHandlers = append(b.syncErrorHandlers, handler)
+}
+
+// OnRoomJoined registers a handler that is called when the bot joins a room,
+// whether after an invite or through JoinRoom. Rooms joined before the bot
+// started aren't reported.
+func (b *Bot) OnRoomJoined(handler RoomJoinedHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.joinHandlers = append(b.joinHandlers, handler)
+}
+
+// OnInvite registers a handler that decides whether to accept an invite. The
+// invite is declined if any handler returns false.
+func (b *Bot) OnInvite(handler InviteHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inviteHandlers = append(b.inviteHandlers, handler)
+}
+
+// runReady calls the OnReady handlers after the first sync.
+func (b *Bot) runReady(ctx context.Context) {
+	if !b.waitFirstSync(ctx) {
+		return
+	}
+	b.mu.RLock()
+	handlers := append([]ReadyHandler(nil), b.readyHandlers...)
+	b.mu.RUnlock()
+	b.log.Debug().Int("handlers", len(handlers)).Msg("Bot is ready")
+	for _, handler := range handlers {
+		handler(ctx)
+	}
+}
+
+// botSyncer is the client's syncer, reporting failed syncs to the OnSyncError handlers.
+type botSyncer struct {
+	*mautrix.DefaultSyncer
+	bot *Bot
+	ctx context.Context // Passed to the handlers, set by Run
+}
+
+// OnFailedSync implements mautrix.Syncer.
+func (s *botSyncer) OnFailedSync(res *mautrix.RespSync, err error) (time.Duration, error) {
+	b := s.bot
+	b.mu.RLock()
+	handlers := append([]SyncErrorHandler(nil), b.syncErrorHandlers...)
+	b.mu.RUnlock()
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	b.ReportError(ctx, "Sync failed", err)
+	for _, handler := range handlers {
+		handler(ctx, err)
+	}
+	return s.DefaultSyncer.OnFailedSync(res, err)
+}
+
+// handleMembership joins rooms the bot is invited to, unless an OnInvite
+// handler declines, and reports joins to the OnRoomJoined handlers.
+func (b *Bot) handleMembership(ctx context.Context, evt *event.Event) {
+	if evt.GetStateKey() != b.client.UserID.String() {
+		return
+	}
+	switch evt.Content.AsMember().Membership {
+	case event.MembershipInvite:
+		b.handleInvite(ctx, evt)
+	case event.MembershipJoin:
+		if b.initialSync.Load() || prevMembership(evt) == event.MembershipJoin {
+			return // Not a new join, e.g. a display name change
+		}
+		b.mu.RLock()
+		handlers := append([]RoomJoinedHandler(nil), b.joinHandlers...)
+		b.mu.RUnlock()
+		for _, handler := range handlers {
+			handler(ctx, evt.RoomID)
+		}
+	}
+}
+
+// handleInvite joins the room of an invite that is allowed and not declined.
+func (b *Bot) handleInvite(ctx context.Context, evt *event.Event) {
+	log := b.log.With().
+		Str("room_id", evt.RoomID.String()).
+		Str("inviter", evt.Sender.String()).
+		Logger()
+	if !b.config.allowed(evt.RoomID, evt.Sender) {
+		log.Info().Msg("Ignoring invite from a room or user that isn't allowed")
+		return
+	}
+	b.mu.RLock()
+	handlers := append([]InviteHandler(nil), b.inviteHandlers...)
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		if handler(ctx, evt.RoomID, evt.Sender) {
+			continue
+		}
+		if _, err := b.client.LeaveRoom(ctx, evt.RoomID); err != nil {
+			log.Error().Err(err).Msg("Failed to decline invite")
+		} else {
+			log.Info().Msg("Declined invite")
+		}
+		return
+	}
+	if _, err := b.client.JoinRoomByID(ctx, evt.RoomID); err != nil {
+		log.Error().Err(err).Msg("Failed to join room after invite")
+	} else {
+		log.Info().Msg("Joined room after invite")
+	}
+}
+
+// prevMembership returns the membership a member event replaced, if known.
+func prevMembership(evt *event.Event) event.Membership {
+	if evt.Unsigned.PrevContent == nil || len(evt.Unsigned.PrevContent.VeryRaw) == 0 {
+		return ""
+	}
+	var prev event.MemberEventContent
+	if err := json.Unmarshal(evt.Unsigned.PrevContent.VeryRaw, &prev); err != nil {
+		return ""
+	}
+	return prev.Membership
+}
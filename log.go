@@ -0,0 +1,92 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newLogger returns Config.Logger, or the default console logger on stderr.
+func newLogger(config Config) zerolog.Logger {
+	if config.Logger != nil {
+		return *config.Logger
+	}
+	log := zerolog.New(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) {
+		w.Out = os.Stderr
+		w.TimeFormat = time.Stamp
+	})).With().Timestamp().Logger()
+	if !config.Debug {
+		log = log.Level(zerolog.InfoLevel)
+	}
+	return log
+}
+
+// slogWriter forwards zerolog events to a slog.Logger.
+type slogWriter struct {
+	logger *slog.Logger
+}
+
+// NewSlogWriter returns a zerolog writer that forwards log events to logger,
+// for bots that are part of an application logging with log/slog:
+//
+//	log := zerolog.New(matrix.NewSlogWriter(slog.Default())).Level(zerolog.InfoLevel)
+//	bot.SetLogger(log)
+//
+// The message and level become those of the slog record; the other fields
+// become attributes, in alphabetical order.
+func NewSlogWriter(logger *slog.Logger) zerolog.LevelWriter {
+	return slogWriter{logger: logger}
+}
+
+// Write implements io.Writer for events without a level.
+func (w slogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter.
+func (w slogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	fields := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		w.logger.Log(context.Background(), slogLevel(level), string(bytes.TrimSpace(p)))
+		return len(p), nil
+	}
+	message, _ := fields[zerolog.MessageFieldName].(string)
+	delete(fields, zerolog.MessageFieldName)
+	delete(fields, zerolog.LevelFieldName)
+	delete(fields, zerolog.TimestampFieldName)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, key := range keys {
+		attrs[i] = slog.Any(key, fields[key])
+	}
+	w.logger.LogAttrs(context.Background(), slogLevel(level), message, attrs...)
+	return len(p), nil
+}
+
+// slogLevel maps a zerolog level to the closest slog level.
+func slogLevel(level zerolog.Level) slog.Level {
+	switch level {
+	case zerolog.TraceLevel:
+		return slog.LevelDebug - 4
+	case zerolog.DebugLevel:
+		return slog.LevelDebug
+	case zerolog.WarnLevel:
+		return slog.LevelWarn
+	case zerolog.ErrorLevel, zerolog.FatalLevel, zerolog.PanicLevel:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
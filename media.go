@@ -0,0 +1,160 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// UploadMedia uploads raw bytes to the homeserver media repository and returns the mxc:// URI.
+// The upload is not encrypted; use SendFile to post attachments into encrypted rooms.
+func (b *Bot) UploadMedia(ctx context.Context, data []byte, contentType, fileName string) (id.ContentURI, error) {
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	resp, err := b.client.UploadBytesWithName(ctx, data, contentType, fileName)
+	if err != nil {
+		return id.ContentURI{}, fmt.Errorf("matrix: failed to upload media: %w", err)
+	}
+	return resp.ContentURI, nil
+}
+
+// SendFile uploads data and posts it as an attachment. Images, audio and video
+// get the matching message type, everything else is sent as m.file.
+// In encrypted rooms the file is encrypted before uploading.
+func (b *Bot) SendFile(ctx context.Context, roomID id.RoomID, fileName, contentType string, data []byte) error {
+	return b.SendFileWithCaption(ctx, roomID, fileName, contentType, data, "")
+}
+
+// SendFileWithCaption is like SendFile, with a caption shown alongside the attachment.
+func (b *Bot) SendFileWithCaption(ctx context.Context, roomID id.RoomID, fileName, contentType string, data []byte, caption string) error {
+	content, err := b.uploadFile(ctx, roomID, fileName, contentType, data, caption)
+	if err != nil {
+		return err
+	}
+	_, err = b.SendMessage(ctx, roomID, content)
+	return err
+}
+
+// uploadFile uploads an attachment, encrypted if the room is, and returns the
+// message content referencing it.
+func (b *Bot) uploadFile(ctx context.Context, roomID id.RoomID, fileName, contentType string, data []byte, caption string) (*event.MessageEventContent, error) {
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	content := &event.MessageEventContent{
+		MsgType:  fileMessageType(contentType),
+		Body:     fileName,
+		FileName: fileName,
+		Info: &event.FileInfo{
+			MimeType: contentType,
+			Size:     len(data),
+		},
+	}
+	if caption != "" {
+		content.Body = caption
+	}
+
+	encrypted, err := b.IsEncrypted(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		file := attachment.NewEncryptedFile()
+		uri, err := b.UploadMedia(ctx, file.Encrypt(data), "application/octet-stream", "")
+		if err != nil {
+			return nil, err
+		}
+		content.File = &event.EncryptedFileInfo{EncryptedFile: *file, URL: uri.CUString()}
+	} else {
+		uri, err := b.UploadMedia(ctx, data, contentType, fileName)
+		if err != nil {
+			return nil, err
+		}
+		content.URL = uri.CUString()
+	}
+	return content, nil
+}
+
+// ErrMediaTooLarge is returned by DownloadMediaLimit for attachments larger
+// than the limit.
+var ErrMediaTooLarge = errors.New("matrix: attachment too large")
+
+// DownloadMedia downloads the attachment of a file, image, audio or video message,
+// decrypting it if it was sent in an encrypted room.
+func (b *Bot) DownloadMedia(ctx context.Context, content *event.MessageEventContent) ([]byte, error) {
+	return b.DownloadMediaLimit(ctx, content, 0)
+}
+
+// DownloadMediaLimit downloads an attachment like DownloadMedia, but reads at
+// most maxSize bytes (no limit if maxSize <= 0). Larger attachments fail with
+// ErrMediaTooLarge, whatever size the message claims.
+func (b *Bot) DownloadMediaLimit(ctx context.Context, content *event.MessageEventContent, maxSize int) ([]byte, error) {
+	url := content.URL
+	if content.File != nil {
+		url = content.File.URL
+	}
+	if url == "" {
+		return nil, fmt.Errorf("matrix: message has no attachment")
+	}
+	uri, err := url.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("matrix: invalid attachment URL: %w", err)
+	}
+	resp, err := b.client.Download(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to download media: %w", err)
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, int64(maxSize)+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to download media: %w", err)
+	}
+	if maxSize > 0 && len(data) > maxSize {
+		return nil, ErrMediaTooLarge
+	}
+	if content.File != nil {
+		if err = content.File.DecryptInPlace(data); err != nil {
+			return nil, fmt.Errorf("matrix: failed to decrypt media: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// IsEncrypted reports whether a room has encryption enabled, according to the state store.
+func (b *Bot) IsEncrypted(ctx context.Context, roomID id.RoomID) (bool, error) {
+	if b.client.StateStore == nil {
+		return false, nil
+	}
+	encrypted, err := b.client.StateStore.IsEncrypted(ctx, roomID)
+	if err != nil {
+		return false, fmt.Errorf("matrix: failed to check room encryption: %w", err)
+	}
+	return encrypted, nil
+}
+
+// fileMessageType picks the message type for an attachment based on its MIME type.
+func fileMessageType(contentType string) event.MessageType {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return event.MsgImage
+	case strings.HasPrefix(contentType, "audio/"):
+		return event.MsgAudio
+	case strings.HasPrefix(contentType, "video/"):
+		return event.MsgVideo
+	default:
+		return event.MsgFile
+	}
+}
@@ -0,0 +1,43 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestDownloadMediaLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/v1/media/download/example.com/abc" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bot{client: client}
+	// The claimed size is smaller than the attachment
+	content := &event.MessageEventContent{MsgType: event.MsgAudio, URL: "mxc://example.com/abc", Info: &event.FileInfo{Size: 10}}
+
+	got, err := b.DownloadMediaLimit(context.Background(), content, len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("DownloadMediaLimit(%d) = %d bytes, %v", len(data), len(got), err)
+	}
+	if _, err = b.DownloadMediaLimit(context.Background(), content, len(data)-1); !errors.Is(err, ErrMediaTooLarge) {
+		t.Errorf("DownloadMediaLimit(%d) = %v, want ErrMediaTooLarge", len(data)-1, err)
+	}
+	if got, err = b.DownloadMedia(context.Background(), content); err != nil || len(got) != len(data) {
+		t.Errorf("DownloadMedia() = %d bytes, %v", len(got), err)
+	}
+}
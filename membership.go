@@ -0,0 +1,241 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Invite invites a user to the given room.
+// The reason is optional and shown to the invitee by most clients.
+func (b *Bot) Invite(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := b.client.InviteUser(ctx, roomID, &mautrix.ReqInviteUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// Kick removes a user from the given room. The user is able to rejoin if the room allows it.
+func (b *Bot) Kick(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := b.client.KickUser(ctx, roomID, &mautrix.ReqKickUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// Ban bans a user from the given room, removing them if they are currently joined.
+func (b *Bot) Ban(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := b.client.BanUser(ctx, roomID, &mautrix.ReqBanUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// Unban lifts a ban for a user in the given room. It does not re-invite them.
+func (b *Bot) Unban(ctx context.Context, roomID id.RoomID, userID id.UserID, reason string) error {
+	_, err := b.client.UnbanUser(ctx, roomID, &mautrix.ReqUnbanUser{UserID: userID, Reason: reason})
+	return err
+}
+
+// Redact removes the content of an event, e.g. a spam message. The bot needs
+// the power level to redact other users' events.
+func (b *Bot) Redact(ctx context.Context, roomID id.RoomID, eventID id.EventID, reason string) error {
+	_, err := b.client.RedactEvent(ctx, roomID, eventID, mautrix.ReqRedact{Reason: reason})
+	return err
+}
+
+// invitePace is the delay between consecutive invites in InviteMany.
+const invitePace = 300 * time.Millisecond
+
+// inviteRetries is how many times InviteMany retries a rate-limited invite.
+const inviteRetries = 5
+
+// InviteSummary is the outcome of a bulk invite.
+type InviteSummary struct {
+	Invited []id.UserID
+	Skipped []id.UserID // Already joined or already invited
+	Failed  map[id.UserID]error
+}
+
+// String returns a one-line summary suitable for posting to a room.
+func (s *InviteSummary) String() string {
+	return fmt.Sprintf("Invited %d, skipped %d, failed %d", len(s.Invited), len(s.Skipped), len(s.Failed))
+}
+
+// InviteMany invites all given users to a room, e.g. to onboard a whole team.
+// Invites are paced, rate-limited requests are retried after the server-provided delay,
+// and users who are already joined or invited are skipped.
+// An error is only returned if the room membership cannot be read or ctx is cancelled;
+// per-user failures are reported in the summary.
+func (b *Bot) InviteMany(ctx context.Context, roomID id.RoomID, userIDs []id.UserID) (*InviteSummary, error) {
+	members, err := b.client.Members(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to get room members: %w", err)
+	}
+	present := make(map[id.UserID]bool)
+	for _, evt := range members.Chunk {
+		switch evt.Content.AsMember().Membership {
+		case event.MembershipJoin, event.MembershipInvite:
+			present[id.UserID(evt.GetStateKey())] = true
+		}
+	}
+
+	summary := &InviteSummary{Failed: make(map[id.UserID]error)}
+	for i, userID := range userIDs {
+		if present[userID] {
+			summary.Skipped = append(summary.Skipped, userID)
+			continue
+		}
+		present[userID] = true // Deduplicate the input list
+
+		if i > 0 {
+			if err = sleepContext(ctx, invitePace); err != nil {
+				return summary, err
+			}
+		}
+
+		if err = b.inviteWithRetry(ctx, roomID, userID); err != nil {
+			if ctx.Err() != nil {
+				return summary, ctx.Err()
+			}
+			summary.Failed[userID] = err
+			b.log.Warn().Err(err).
+				Str("room_id", roomID.String()).
+				Str("user_id", userID.String()).
+				Msg("Failed to invite user")
+			continue
+		}
+		summary.Invited = append(summary.Invited, userID)
+	}
+
+	b.log.Info().
+		Str("room_id", roomID.String()).
+		Int("invited", len(summary.Invited)).
+		Int("skipped", len(summary.Skipped)).
+		Int("failed", len(summary.Failed)).
+		Msg("Bulk invite finished")
+	return summary, nil
+}
+
+// inviteWithRetry invites a single user, waiting out M_LIMIT_EXCEEDED responses.
+func (b *Bot) inviteWithRetry(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	for attempt := 0; ; attempt++ {
+		err := b.Invite(ctx, roomID, userID, "")
+		delay, limited := retryAfter(err)
+		if !limited || attempt >= inviteRetries {
+			return err
+		}
+		if err = sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// retryAfter reports whether err is a rate limit error and how long to wait before retrying.
+func retryAfter(err error) (time.Duration, bool) {
+	var respErr mautrix.RespError
+	if err == nil || !errors.As(err, &respErr) || respErr.ErrCode != mautrix.MLimitExceeded.ErrCode {
+		return 0, false
+	}
+	if ms, ok := respErr.ExtraData["retry_after_ms"].(float64); ok && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	return time.Second, true
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Leave leaves the given room. The reason is optional.
+func (b *Bot) Leave(ctx context.Context, roomID id.RoomID, reason string) error {
+	_, err := b.client.LeaveRoom(ctx, roomID, &mautrix.ReqLeave{Reason: reason})
+	return err
+}
+
+// Forget forgets a room the bot has left, so it no longer shows up in sync.
+func (b *Bot) Forget(ctx context.Context, roomID id.RoomID) error {
+	_, err := b.client.ForgetRoom(ctx, roomID)
+	return err
+}
+
+// autoLeaveInterval is how often rooms are checked for the auto-leave policy.
+const autoLeaveInterval = time.Hour
+
+// autoLeaveLoop periodically leaves and forgets rooms where the bot has been
+// alone for longer than Config.AutoLeaveAfter.
+func (b *Bot) autoLeaveLoop(ctx context.Context) {
+	ticker := time.NewTicker(autoLeaveInterval)
+	defer ticker.Stop()
+	for {
+		b.leaveAbandonedRooms(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// leaveAbandonedRooms runs a single pass of the auto-leave policy.
+func (b *Bot) leaveAbandonedRooms(ctx context.Context) {
+	rooms, err := b.client.JoinedRooms(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			b.log.Warn().Err(err).Msg("Auto-leave: failed to list joined rooms")
+		}
+		return
+	}
+
+	for _, roomID := range rooms.JoinedRooms {
+		aloneSince, alone, err := b.aloneSince(ctx, roomID)
+		if err != nil {
+			b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Auto-leave: failed to check room")
+			continue
+		}
+		if !alone || time.Since(aloneSince) < b.config.AutoLeaveAfter {
+			continue
+		}
+
+		if err = b.Leave(ctx, roomID, "Nobody else is in this room"); err != nil {
+			b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Auto-leave: failed to leave room")
+			continue
+		}
+		if err = b.Forget(ctx, roomID); err != nil {
+			b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Auto-leave: failed to forget room")
+		}
+		b.log.Info().
+			Str("room_id", roomID.String()).
+			Time("alone_since", aloneSince).
+			Msg("Left room where the bot was alone")
+	}
+}
+
+// aloneSince reports whether the bot is the only joined member of a room and,
+// if so, since when. The time is taken from the most recent membership change,
+// so it survives restarts without any local bookkeeping.
+func (b *Bot) aloneSince(ctx context.Context, roomID id.RoomID) (time.Time, bool, error) {
+	state, err := b.client.State(ctx, roomID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var latest int64
+	for stateKey, evt := range state[event.StateMember] {
+		membership := evt.Content.AsMember().Membership
+		if stateKey != b.client.UserID.String() && (membership == event.MembershipJoin || membership == event.MembershipInvite) {
+			return time.Time{}, false, nil
+		}
+		if evt.Timestamp > latest {
+			latest = evt.Timestamp
+		}
+	}
+	return time.UnixMilli(latest), true, nil
+}
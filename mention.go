@@ -0,0 +1,89 @@
+package matrix
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// parseMessageCommand returns the command of a message: the text after one
+// of the room's command prefixes or, with Config.MentionTrigger, after a
+// leading mention of the bot, e.g. "@bot summarize repo X".
+func (b *Bot) parseMessageCommand(ctx context.Context, msg *MessageContext) (name, args string, ok bool) {
+	prefixes := b.CommandPrefixes(ctx, msg.RoomID)
+	if name, args, ok = parseCommand(prefixes, msg.Message.Body); ok || !b.config.MentionTrigger {
+		return name, args, ok
+	}
+	rest, ok := b.stripMention(ctx, msg)
+	if !ok {
+		return "", "", false
+	}
+	if name, args, ok = parseCommand(prefixes, rest); ok {
+		return name, args, true // "@bot !help"
+	}
+	name, args = splitCommand(rest)
+	return name, args, name != ""
+}
+
+// MentionsBot reports whether a message starts with a mention of the bot, by
+// user ID, localpart or display name in the room (e.g. "Bot: hello").
+func (m *MessageContext) MentionsBot(ctx context.Context) bool {
+	_, ok := m.Bot.stripMention(ctx, m)
+	return ok
+}
+
+// WithoutMention returns the body of a message without a leading mention of
+// the bot, e.g. the question in "Bot: what is this?".
+func (m *MessageContext) WithoutMention(ctx context.Context) string {
+	if rest, ok := m.Bot.stripMention(ctx, m); ok {
+		return rest
+	}
+	return strings.TrimSpace(m.Message.Body)
+}
+
+// IsCommand reports whether a message runs a registered command, e.g. for
+// handlers that shouldn't respond to commands as well.
+func (m *MessageContext) IsCommand(ctx context.Context) bool {
+	name, _, ok := m.Bot.parseMessageCommand(ctx, m)
+	return ok && m.Bot.resolveCommand(ctx, m.RoomID, name) != nil
+}
+
+// stripMention removes a leading mention of the bot from a message body. The
+// pill of a mention is sent as the display name in the plain body, usually
+// followed by a colon.
+func (b *Bot) stripMention(ctx context.Context, msg *MessageContext) (string, bool) {
+	userID := b.client.UserID
+	if userID == "" || msg.Sender == userID {
+		return "", false
+	}
+	names := []string{userID.String(), userID.Localpart()}
+	if member, err := b.client.StateStore.GetMember(ctx, msg.RoomID, userID); err == nil && member != nil && member.Displayname != "" {
+		names = append(names, member.Displayname)
+	}
+	// Longest first, so that "@bot:example.com" isn't taken for "@bot".
+	slices.SortFunc(names, func(a, b string) int { return len(b) - len(a) })
+
+	body := strings.TrimSpace(msg.Message.Body)
+	for _, name := range names {
+		rest, ok := cutPrefixFold(body, name)
+		if !ok {
+			rest, ok = cutPrefixFold(body, "@"+name)
+		}
+		if !ok {
+			continue
+		}
+		if rest != "" && !strings.ContainsAny(rest[:1], " :,\n") {
+			continue // Another word, e.g. "botany" for "bot"
+		}
+		return strings.TrimLeft(rest, " :,\n"), true
+	}
+	return "", false
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
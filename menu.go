@@ -0,0 +1,351 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Reactions used by menus to change pages.
+const (
+	MenuPreviousKey = "⬅️"
+	MenuNextKey     = "➡️"
+)
+
+// DefaultMenuPageSize is used when Menu.PageSize is zero.
+const DefaultMenuPageSize = 10
+
+// menuLifetime is how long the reactions on a menu are handled after it was sent.
+const menuLifetime = 7 * 24 * time.Hour
+
+// menuCleanupInterval is how often expired menus are removed from the store.
+const menuCleanupInterval = time.Hour
+
+// menuIndexKey is the store key of the expiry of all stored menus, by event ID,
+// so they can be pruned without listing the store.
+const menuIndexKey = "menu.index"
+
+// menuNumberKeys are the reactions selecting the items of a page.
+var menuNumberKeys = []string{"1️⃣", "2️⃣", "3️⃣", "4️⃣", "5️⃣", "6️⃣", "7️⃣", "8️⃣", "9️⃣", "🔟"}
+
+// MenuItem is an entry of a menu. Items with a command can be selected with
+// the number reaction shown next to them.
+type MenuItem struct {
+	Label   string `json:"label"`             // Markdown, e.g. "#42 Login fails"
+	Command string `json:"command,omitempty"` // Command run on selection without prefix, e.g. "issue"
+	Args    string `json:"args,omitempty"`    // Arguments of the command, e.g. "42"
+}
+
+// Menu is a paged list. Room members page through it with the ⬅️ and ➡️
+// reactions; the bot edits the message in place.
+type Menu struct {
+	Title    string     `json:"title"`
+	Items    []MenuItem `json:"items"`
+	PageSize int        `json:"page_size,omitempty"` // Items per page (default: 10, at most 10 with selectable items)
+	Page     int        `json:"page"`                // Current page, from 0
+}
+
+// storedMenu is a menu in the store.
+type storedMenu struct {
+	Menu
+	Expires int64 `json:"expires"` // Unix seconds, 0 for menus stored without expiry
+}
+
+// menuKey is the store key of a menu.
+func menuKey(eventID id.EventID) string {
+	return "menu." + eventID.String()
+}
+
+// selectable reports whether any item can be selected.
+func (m *Menu) selectable() bool {
+	for _, item := range m.Items {
+		if item.Command != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Menu) pageSize() int {
+	size := m.PageSize
+	if size <= 0 {
+		size = DefaultMenuPageSize
+	}
+	if m.selectable() {
+		size = min(size, len(menuNumberKeys))
+	}
+	return size
+}
+
+// pages returns the number of pages, at least one.
+func (m *Menu) pages() int {
+	return max(1, (len(m.Items)+m.pageSize()-1)/m.pageSize())
+}
+
+// pageItems returns the items of the current page and the index of the first.
+func (m *Menu) pageItems() ([]MenuItem, int) {
+	start := m.Page * m.pageSize()
+	end := min(start+m.pageSize(), len(m.Items))
+	if start >= end {
+		return nil, start
+	}
+	return m.Items[start:end], start
+}
+
+// render returns the markdown of the current page in a language.
+func (m *Menu) render(b *Bot, language string) string {
+	var sb strings.Builder
+	if m.Title != "" {
+		sb.WriteString("**" + m.Title + "**")
+		if m.pages() > 1 {
+			sb.WriteString(" " + b.Translate(language, "menu.page", m.Page+1, m.pages()))
+		}
+		sb.WriteString("\n\n")
+	}
+	items, first := m.pageItems()
+	if len(items) == 0 {
+		sb.WriteString(b.Translate(language, "menu.empty"))
+	}
+	for i, item := range items {
+		if m.selectable() {
+			sb.WriteString(fmt.Sprintf("%s %s  \n", menuNumberKeys[i], item.Label))
+		} else {
+			sb.WriteString(fmt.Sprintf("%d. %s\n", first+i+1, item.Label))
+		}
+	}
+	if m.pages() > 1 {
+		sb.WriteString("\n" + b.Translate(language, "menu.turn", MenuPreviousKey, MenuNextKey))
+	}
+	return strings.TrimRight(sb.String(), " \n")
+}
+
+// SendMenu posts the first page of a menu and pre-reacts with the page and
+// selection reactions. Selecting an item runs its command as if the reactor
+// had sent "!<command> <args>", subject to room muting, access rules and
+// AllowedUsers, like an action card. The menu is kept in the store for a week,
+// so paging keeps working after a restart.
+func (b *Bot) SendMenu(ctx context.Context, roomID id.RoomID, menu Menu) (id.EventID, error) {
+	menu.Page = 0
+	md := menu.render(b, b.Language(ctx, roomID))
+	eventID, err := b.SendMessage(ctx, roomID, &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          md,
+		Format:        event.FormatHTML,
+		FormattedBody: MarkdownToHTML(md),
+	})
+	if err != nil {
+		return "", err
+	}
+	if menu.pages() == 1 && !menu.selectable() {
+		return eventID, nil // Nothing to react to
+	}
+	if err = b.saveMenu(ctx, eventID, &storedMenu{Menu: menu, Expires: time.Now().Add(menuLifetime).Unix()}); err != nil {
+		return eventID, err
+	}
+	if menu.pages() > 1 {
+		for _, key := range []string{MenuPreviousKey, MenuNextKey} {
+			if err = b.React(ctx, roomID, eventID, key); err != nil {
+				return eventID, err
+			}
+		}
+	}
+	if menu.selectable() {
+		for _, key := range menuNumberKeys[:min(menu.pageSize(), len(menu.Items))] {
+			if err = b.React(ctx, roomID, eventID, key); err != nil {
+				return eventID, err
+			}
+		}
+	}
+	return eventID, nil
+}
+
+// SendPagedList posts lines as a paged list, e.g. 100 issues, pageSize lines
+// per page (0 = 10).
+func (b *Bot) SendPagedList(ctx context.Context, roomID id.RoomID, title string, lines []string, pageSize int) (id.EventID, error) {
+	items := make([]MenuItem, len(lines))
+	for i, line := range lines {
+		items[i] = MenuItem{Label: line}
+	}
+	return b.SendMenu(ctx, roomID, Menu{Title: title, Items: items, PageSize: pageSize})
+}
+
+// RemoveMenu stops handling the reactions on a menu.
+func (b *Bot) RemoveMenu(ctx context.Context, eventID id.EventID) error {
+	b.menuMu.Lock()
+	defer b.menuMu.Unlock()
+	if err := b.store.Delete(ctx, menuKey(eventID)); err != nil {
+		return fmt.Errorf("matrix: failed to remove menu: %w", err)
+	}
+	return b.updateMenuIndex(ctx, func(index map[id.EventID]int64) {
+		delete(index, eventID)
+	})
+}
+
+// saveMenu stores a new menu and adds it to the index.
+func (b *Bot) saveMenu(ctx context.Context, eventID id.EventID, menu *storedMenu) error {
+	b.menuMu.Lock()
+	defer b.menuMu.Unlock()
+	data, err := json.Marshal(menu)
+	if err != nil {
+		return err
+	}
+	if err = b.store.Set(ctx, menuKey(eventID), string(data)); err != nil {
+		return fmt.Errorf("matrix: failed to save menu: %w", err)
+	}
+	return b.updateMenuIndex(ctx, func(index map[id.EventID]int64) {
+		index[eventID] = menu.Expires
+	})
+}
+
+// updateMenuIndex changes the index of the stored menus. Callers hold b.menuMu.
+func (b *Bot) updateMenuIndex(ctx context.Context, update func(index map[id.EventID]int64)) error {
+	index := make(map[id.EventID]int64)
+	data, err := b.store.Get(ctx, menuIndexKey)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to load menu index: %w", err)
+	}
+	if data != "" {
+		if err = json.Unmarshal([]byte(data), &index); err != nil {
+			return fmt.Errorf("matrix: invalid menu index: %w", err)
+		}
+	}
+	update(index)
+	if len(index) == 0 {
+		err = b.store.Delete(ctx, menuIndexKey)
+	} else if encoded, marshalErr := json.Marshal(index); marshalErr != nil {
+		err = marshalErr
+	} else {
+		err = b.store.Set(ctx, menuIndexKey, string(encoded))
+	}
+	if err != nil {
+		return fmt.Errorf("matrix: failed to save menu index: %w", err)
+	}
+	return nil
+}
+
+// runMenuCleanup periodically removes expired menus from the store.
+func (b *Bot) runMenuCleanup(ctx context.Context) {
+	ticker := time.NewTicker(menuCleanupInterval)
+	defer ticker.Stop()
+	for {
+		if err := b.pruneMenus(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			b.log.Warn().Err(err).Msg("Failed to remove expired menus")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneMenus removes the menus that expired before now.
+func (b *Bot) pruneMenus(ctx context.Context, now time.Time) error {
+	b.menuMu.Lock()
+	defer b.menuMu.Unlock()
+	var err error
+	updateErr := b.updateMenuIndex(ctx, func(index map[id.EventID]int64) {
+		for eventID, expires := range index {
+			if expires > now.Unix() {
+				continue
+			}
+			if err = b.store.Delete(ctx, menuKey(eventID)); err != nil {
+				err = fmt.Errorf("matrix: failed to remove menu: %w", err)
+				return // Keep the remaining entries for the next run
+			}
+			delete(index, eventID)
+		}
+	})
+	return errors.Join(err, updateErr)
+}
+
+// handleMenuReaction turns the page of a menu, or queues the command of the
+// selected item.
+func (b *Bot) handleMenuReaction(ctx context.Context, evt *event.Event) {
+	if evt.Sender == b.client.UserID || b.initialSync.Load() || !b.config.allowed(evt.RoomID, evt.Sender) || b.isBacklog(evt) {
+		return
+	}
+	relates := evt.Content.AsReaction().GetRelatesTo()
+	switch relates.Key {
+	case MenuPreviousKey:
+		go b.turnMenuPage(context.WithoutCancel(ctx), evt, -1) // Editing must not hold up the sync loop
+		return
+	case MenuNextKey:
+		go b.turnMenuPage(context.WithoutCancel(ctx), evt, 1)
+		return
+	}
+	menu, err := b.loadMenu(ctx, relates.EventID, time.Now())
+	if err != nil || menu == nil {
+		return
+	}
+	items, _ := menu.pageItems()
+	for i, item := range items {
+		if menuNumberKeys[i] == relates.Key && item.Command != "" {
+			action := CardAction{Key: relates.Key, Command: item.Command, Args: item.Args}
+			b.enqueueMessage(ctx, b.newCardMessageContext(ctx, evt, relates.EventID, action))
+			return
+		}
+	}
+}
+
+// loadMenu returns the menu sent as an event, or nil if there is none or it
+// expired before now.
+func (b *Bot) loadMenu(ctx context.Context, eventID id.EventID, now time.Time) (*storedMenu, error) {
+	data, err := b.store.Get(ctx, menuKey(eventID))
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var menu storedMenu
+	if err = json.Unmarshal([]byte(data), &menu); err != nil {
+		return nil, fmt.Errorf("matrix: invalid menu: %w", err)
+	}
+	if menu.Expires != 0 && menu.Expires <= now.Unix() {
+		return nil, nil
+	}
+	return &menu, nil
+}
+
+// turnMenuPage edits a menu to show the previous (delta -1) or next page.
+func (b *Bot) turnMenuPage(ctx context.Context, reaction *event.Event, delta int) {
+	menuID := reaction.Content.AsReaction().GetRelatesTo().EventID
+	log := b.log.With().Str("room_id", reaction.RoomID.String()).Str("event_id", menuID.String()).Logger()
+	b.menuMu.Lock()
+	defer b.menuMu.Unlock()
+	menu, err := b.loadMenu(ctx, menuID, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load menu")
+	}
+	if menu == nil {
+		return
+	}
+
+	// Remove the reaction, so that it can be used again. This needs the power
+	// level to redact; without it users toggle their reaction instead.
+	if _, err = b.client.RedactEvent(ctx, reaction.RoomID, reaction.ID, mautrix.ReqRedact{Reason: "Menu page turned"}); err != nil {
+		log.Debug().Err(err).Msg("Failed to remove menu reaction")
+	}
+	page := min(max(menu.Page+delta, 0), menu.pages()-1)
+	if page == menu.Page {
+		return
+	}
+	menu.Page = page
+	md := menu.render(b, b.Language(ctx, reaction.RoomID))
+	if err = b.EditMessage(ctx, reaction.RoomID, menuID, md, MarkdownToHTML(md)); err != nil {
+		log.Warn().Err(err).Msg("Failed to turn menu page")
+		return
+	}
+	data, err := json.Marshal(menu)
+	if err == nil {
+		err = b.store.Set(ctx, menuKey(menuID), string(data))
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to save menu")
+	}
+}
@@ -0,0 +1,41 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPruneMenus(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBot(t, Config{})
+	now := time.Now()
+	menu := Menu{Title: "Issues", Items: []MenuItem{{Label: "#42 Login fails", Command: "issue", Args: "42"}}}
+	if err := b.saveMenu(ctx, "$expired", &storedMenu{Menu: menu, Expires: now.Add(-time.Minute).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.saveMenu(ctx, "$current", &storedMenu{Menu: menu, Expires: now.Add(time.Hour).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := b.loadMenu(ctx, "$expired", now); err != nil || loaded != nil {
+		t.Errorf("loadMenu(expired) = %v, %v, want nil", loaded, err)
+	}
+	if err := b.pruneMenus(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := b.store.Get(ctx, menuKey("$expired")); data != "" {
+		t.Errorf("expired menu still stored: %s", data)
+	}
+	loaded, err := b.loadMenu(ctx, "$current", now)
+	if err != nil || loaded == nil || loaded.Title != "Issues" {
+		t.Fatalf("loadMenu(current) = %v, %v", loaded, err)
+	}
+
+	if err = b.RemoveMenu(ctx, "$current"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := b.store.Get(ctx, menuIndexKey); data != "" {
+		t.Errorf("menu index = %s, want empty", data)
+	}
+}
@@ -0,0 +1,104 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+)
+
+// Module is a self-contained feature that plugs into the bot, such as a
+// membership reconciler or a digest mailer. Modules live in their own packages
+// and only use the public Bot API.
+type Module interface {
+	// Name returns a short unique identifier used in logs and configuration.
+	Name() string
+	// Init is called once by Use, before the bot starts running.
+	// Modules register their handlers here.
+	Init(b *Bot) error
+}
+
+// Runner is implemented by modules that need a background loop.
+// Run is started once the bot is logged in and must return when ctx is cancelled.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// Use initializes and registers modules. It must be called before Run.
+// Modules listed in Config.DisabledModules are skipped.
+func (b *Bot) Use(modules ...Module) error {
+	for _, m := range modules {
+		if slices.Contains(b.config.DisabledModules, m.Name()) {
+			continue
+		}
+		if existing := b.Module(m.Name()); existing != nil {
+			return fmt.Errorf("matrix: module %q is already registered", m.Name())
+		}
+		if configurable, ok := m.(Configurable); ok {
+			if err := b.configureModule(configurable, m.Name()); err != nil {
+				return err
+			}
+		}
+		b.initModule = m.Name()
+		err := m.Init(b)
+		b.initModule = ""
+		if err != nil {
+			return fmt.Errorf("matrix: failed to init module %q: %w", m.Name(), err)
+		}
+		b.mu.Lock()
+		b.modules = append(b.modules, m)
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// Module returns a registered module by name, or nil.
+func (b *Bot) Module(name string) Module {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, m := range b.modules {
+		if m.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Modules returns all registered modules in registration order.
+func (b *Bot) Modules() []Module {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Module(nil), b.modules...)
+}
+
+// Log returns the bot logger, see Config.Logger.
+func (b *Bot) Log() *zerolog.Logger {
+	return &b.log
+}
+
+// startModules starts the background loops of all modules implementing Runner.
+func (b *Bot) startModules(ctx context.Context) {
+	for _, m := range b.Modules() {
+		runner, ok := m.(Runner)
+		if !ok {
+			continue
+		}
+		name := m.Name()
+		b.goBackground(func() {
+			if err := runner.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				b.log.Error().Err(err).Str("module", name).Msg("Module stopped with error")
+			}
+		})
+	}
+}
+
+// goBackground runs fn in a goroutine that Stop waits for.
+func (b *Bot) goBackground(fn func()) {
+	b.syncWait.Add(1)
+	go func() {
+		defer b.syncWait.Done()
+		fn()
+	}()
+}
@@ -0,0 +1,108 @@
+package matrix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configurable is implemented by modules with a typed section under "modules"
+// in the config file, e.g.
+//
+//	modules:
+//	  reminders:
+//	    max_per_user: 20
+//
+// Use decodes the section named after the module into the value returned by
+// ModuleConfig before Init; unknown keys are rejected. Values set in code act
+// as defaults. If the value implements ConfigValidator, it is validated too, so
+// misconfigurations fail at startup rather than at runtime.
+type Configurable interface {
+	// ModuleConfig returns a pointer to the module's config struct. Fields are
+	// matched by their yaml tags.
+	ModuleConfig() any
+}
+
+// ConfigValidator is implemented by module config structs that check their values.
+// Return ConfigErrors (joined with errors.Join for several) to name the fields.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// ConfigError reports an invalid field of a module config, e.g.
+// "reminders.max_per_user must be > 0".
+type ConfigError struct {
+	Module  string // Set by Use
+	Field   string // Key in the config file, e.g. "max_per_user"
+	Message string // e.g. "must be > 0"
+}
+
+// InvalidConfig returns a ConfigError for a field, for use in Validate.
+func InvalidConfig(field, format string, args ...any) *ConfigError {
+	return &ConfigError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *ConfigError) Error() string {
+	if e.Module == "" {
+		return fmt.Sprintf("%s %s", e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s.%s %s", e.Module, e.Field, e.Message)
+}
+
+// configureModule decodes and validates the config section of a module.
+func (b *Bot) configureModule(m Configurable, name string) error {
+	target := m.ModuleConfig()
+	if section, ok := b.config.Modules[name]; ok {
+		data, err := yaml.Marshal(section)
+		if err != nil {
+			return fmt.Errorf("matrix: invalid config: modules.%s: %w", name, err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(target); err != nil {
+			return fmt.Errorf("matrix: invalid config: modules.%s: %s", name, describeYAMLError(err))
+		}
+	}
+	validator, ok := target.(ConfigValidator)
+	if !ok {
+		return nil
+	}
+	if err := validator.Validate(); err != nil {
+		setConfigErrorModule(err, name)
+		return fmt.Errorf("matrix: invalid config: %w", err)
+	}
+	return nil
+}
+
+// setConfigErrorModule fills in the module of all ConfigErrors in err.
+func setConfigErrorModule(err error, name string) {
+	var configErr *ConfigError
+	if errors.As(err, &configErr) && configErr.Module == "" {
+		configErr.Module = name
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, inner := range joined.Unwrap() {
+			setConfigErrorModule(inner, name)
+		}
+	}
+}
+
+// describeYAMLError strips line numbers of the re-encoded section from decoding
+// errors, which would point to the wrong lines of the config file.
+func describeYAMLError(err error) string {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err.Error()
+	}
+	problems := make([]string, len(typeErr.Errors))
+	for i, problem := range typeErr.Errors {
+		if _, rest, ok := strings.Cut(problem, ": "); ok && strings.HasPrefix(problem, "line ") {
+			problem = rest
+		}
+		problems[i] = problem
+	}
+	return strings.Join(problems, "; ")
+}
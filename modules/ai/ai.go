@@ -0,0 +1,511 @@
+// Package ai adds an !ai command answering questions with a language model.
+// Follow-up questions have context: the last exchanges of each user in a room
+// or thread are sent along, until the conversation has been idle for a while
+// or the user runs !forget.
+//
+//	!ai What is a goroutine?
+//	!ai And how do I stop one?
+//	!forget
+//
+// Bot admins choose the model and temperature per room, overriding the
+// configuration:
+//
+//	!model set qwen2.5:7b
+//	!model temperature 0.2
+//
+// and a persona, a system prompt replacing Config.SystemPrompt, so the bot can
+// be a strict code reviewer in one room and a friendly helpdesk in another:
+//
+//	!persona set You are a strict code reviewer. Point out bugs first.
+//
+// Answers come from Ollama, or with the openai backend from any server of the
+// OpenAI chat completions API, e.g. vLLM, LiteLLM or llama.cpp.
+//
+// With an image backend configured, !imagine generates images:
+//
+//	!imagine a lighthouse in a storm, oil painting
+//
+// Images can be asked about with a multimodal model: mention the bot in the
+// caption of an image or in a reply to one, or use !describe:
+//
+//	!describe What does this error message mean?
+//
+// With a Whisper endpoint configured, voice messages are transcribed, and the
+// transcript is posted or answered like a question.
+//
+// Daily budgets per user and room limit the requests and tokens, UTC; !usage
+// shows what is left. The usage is kept in the bot's SQL store for 30 days.
+//
+// Commands marked with matrix.Command.AsTool are offered to the model as
+// tools, so users can ask in natural language, e.g. "which issues are open in
+// foo?" runs !issues foo. The model sees their replies and answers with them.
+// Tools need a provider supporting them, e.g. the openai backend.
+//
+// Prompts built by code, e.g. to summarize issues, are templates registered
+// in Templates. Config.Templates names a directory of template files
+// overriding them, reloaded when they change:
+//
+//	_ = ai.Templates.Register("summarize-issues", "Summarize the issues of {{.Repo}}:{{range .Issues}}\n- {{.Title}}{{end}}")
+//	prompt, err := ai.Templates.Execute("summarize-issues", data)
+//
+// Usage:
+//
+//	assistant := ai.New(ai.GetEnvironmentConfig())
+//	if err := bot.Use(assistant); err != nil { ... }
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+// Backends selectable with Config.Backend.
+const (
+	BackendOllama = "ollama" // Ollama generate API, see OllamaProvider
+	BackendOpenAI = "openai" // OpenAI-compatible chat completions, see OpenAIProvider
+)
+
+// templatePollInterval is how often Config.Templates is checked for changes.
+const templatePollInterval = 5 * time.Second
+
+// maxToolRounds limits how often the model may call tools for one question.
+const maxToolRounds = 5
+
+// toolParameters is the JSON schema of the arguments of a command tool.
+var toolParameters = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"args": map[string]any{
+			"type":        "string",
+			"description": "Arguments after the command name, as in the usage",
+		},
+	},
+}
+
+// Config configures the AI module. It can also be set in the "modules.ai"
+// section of the config file.
+type Config struct {
+	// Provider generates the answers. Without one, the provider of Backend
+	// for URL and Token is used.
+	Provider Provider `yaml:"-"`
+	// Backend is the API at URL: BackendOllama (default) or BackendOpenAI.
+	Backend string `yaml:"backend" doc:"API at url: ollama (default) or openai for OpenAI-compatible servers"`
+	// URL is the Ollama generate endpoint, e.g.
+	// "http://localhost:11434/api/generate", or the base URL of an
+	// OpenAI-compatible API, e.g. "http://localhost:8000/v1".
+	URL string `yaml:"url" doc:"Ollama generate endpoint, or base URL of an OpenAI-compatible API, e.g. http://localhost:8000/v1"`
+	// Token authenticates with the endpoint, e.g. an Open WebUI API key.
+	Token string `yaml:"token" doc:"API token of the endpoint"`
+	// Model answers the questions (default: "llama3.2:3b"). Rooms may choose
+	// another one, see ModelSetting.
+	Model string `yaml:"model" doc:"Model answering the questions"`
+	// Temperature controls how random the answers are (default: 0.7), unless
+	// a room sets TemperatureSetting.
+	Temperature float64 `yaml:"temperature" doc:"How random the answers are, from 0"`
+	// SystemPrompt is sent before every conversation, e.g. "Answer briefly."
+	// Rooms may set their own, see PersonaSetting.
+	SystemPrompt string `yaml:"system_prompt" doc:"Default system prompt, replaced by a room's !persona"`
+	// Augment, if set, returns context for a question that is appended to
+	// the system prompt, e.g. relevant earlier messages from the recall
+	// module's Augment.
+	Augment func(ctx context.Context, msg *matrix.MessageContext, question string) string `yaml:"-"`
+	// VisionModel answers questions about images (default: the room's
+	// model), e.g. "llava" or "gpt-4o".
+	VisionModel string `yaml:"vision_model" doc:"Multimodal model for questions about images (default: the room's model)"`
+	// Image configures the !imagine command.
+	Image ImageConfig `yaml:"image" doc:"Image generation for !imagine: backend (sdwebui, comfyui, openai), url, token, model, size, workflow"`
+	// Transcription configures the transcription of voice messages.
+	Transcription TranscriptionConfig `yaml:"transcription" doc:"Voice message transcription: url, token, model, language, mode (post, ask), all_audio, max_size"`
+	// DailyRequests limits the questions and images per user and day, UTC
+	// (default: unlimited).
+	DailyRequests int `yaml:"daily_requests" doc:"Questions and images per user and day (0: unlimited)"`
+	// DailyTokens limits the tokens per user and day (default: unlimited).
+	// Tokens are estimated for backends that don't report them.
+	DailyTokens int `yaml:"daily_tokens" doc:"Tokens per user and day (0: unlimited)"`
+	// RoomDailyTokens limits the tokens per room and day (default: unlimited).
+	RoomDailyTokens int `yaml:"room_daily_tokens" doc:"Tokens per room and day (0: unlimited)"`
+	// Templates is a directory of prompt templates overriding those
+	// registered in code, see TemplateRegistry. Changes of the files are
+	// picked up while the bot runs.
+	Templates string `yaml:"templates" doc:"Directory of prompt templates (<name>.tmpl), reloaded when they change"`
+	// MaxExchanges is how many earlier questions and answers of a
+	// conversation are sent along (default: 10; negative: none).
+	MaxExchanges int `yaml:"max_exchanges" doc:"Earlier questions and answers sent along with a question"`
+	// MemoryTTL is how long an idle conversation is remembered (default: 30m).
+	MemoryTTL time.Duration `yaml:"memory_ttl" doc:"How long an idle conversation is remembered"`
+}
+
+// GetEnvironmentConfig creates a Config from the AI_BACKEND, AI_MODEL,
+// AI_SYSTEM_PROMPT and AI_TEMPLATES environment variables, and OPEN_WEB_API_GENERATE_URL and
+// OPEN_WEB_API_TOKEN, or OPENAI_BASE_URL and OPENAI_API_KEY with the openai
+// backend.
+func GetEnvironmentConfig() Config {
+	config := Config{
+		Backend:      os.Getenv("AI_BACKEND"),
+		URL:          os.Getenv("OPEN_WEB_API_GENERATE_URL"),
+		Token:        os.Getenv("OPEN_WEB_API_TOKEN"),
+		Model:        os.Getenv("AI_MODEL"),
+		SystemPrompt: os.Getenv("AI_SYSTEM_PROMPT"),
+		Templates:    os.Getenv("AI_TEMPLATES"),
+	}
+	if config.Backend == BackendOpenAI {
+		config.URL = os.Getenv("OPENAI_BASE_URL")
+		config.Token = os.Getenv("OPENAI_API_KEY")
+	}
+	return config
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Provider == nil && c.URL == "" {
+		errs = append(errs, matrix.InvalidConfig("url", "is required"))
+	}
+	if c.Backend != "" && c.Backend != BackendOllama && c.Backend != BackendOpenAI {
+		errs = append(errs, matrix.InvalidConfig("backend", "must be %q or %q", BackendOllama, BackendOpenAI))
+	}
+	switch c.Image.Backend {
+	case "", ImageBackendSDWebUI, ImageBackendOpenAI:
+	case ImageBackendComfyUI:
+		if c.Image.Workflow == "" {
+			errs = append(errs, matrix.InvalidConfig("image.workflow", "is required with the comfyui backend"))
+		}
+	default:
+		errs = append(errs, matrix.InvalidConfig("image.backend", "must be %q, %q or %q", ImageBackendSDWebUI, ImageBackendComfyUI, ImageBackendOpenAI))
+	}
+	if c.Image.Backend != "" && c.Image.URL == "" {
+		errs = append(errs, matrix.InvalidConfig("image.url", "is required with an image backend"))
+	}
+	if mode := c.Transcription.Mode; mode != "" && mode != TranscribePost && mode != TranscribeAsk {
+		errs = append(errs, matrix.InvalidConfig("transcription.mode", "must be %q or %q", TranscribePost, TranscribeAsk))
+	}
+	if c.DailyRequests < 0 {
+		errs = append(errs, matrix.InvalidConfig("daily_requests", "must be >= 0"))
+	}
+	if c.DailyTokens < 0 {
+		errs = append(errs, matrix.InvalidConfig("daily_tokens", "must be >= 0"))
+	}
+	if c.RoomDailyTokens < 0 {
+		errs = append(errs, matrix.InvalidConfig("room_daily_tokens", "must be >= 0"))
+	}
+	if c.Temperature < 0 {
+		errs = append(errs, matrix.InvalidConfig("temperature", "must be >= 0"))
+	}
+	if c.MemoryTTL <= 0 {
+		errs = append(errs, matrix.InvalidConfig("memory_ttl", "must be > 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// Module provides the !ai, !forget, !model, !persona, !describe, !imagine
+// and !usage commands.
+type Module struct {
+	config      Config
+	bot         *matrix.Bot
+	memory      *Memory
+	images      ImageGenerator // Nil without an image backend
+	transcriber Transcriber    // Nil without a transcription endpoint
+
+	usageMu  sync.Mutex       // Guards usage and usageDay
+	usage    *dbutil.Database // Database of the usage, see usageDB
+	usageDay string           // Day the usage was last cleaned up, see cleanupUsage
+
+	imagesMu   sync.Mutex
+	lastImages map[id.RoomID]postedImage // Last image per room, for !describe
+}
+
+// New creates the AI module.
+func New(config Config) *Module {
+	if config.Model == "" {
+		config.Model = "llama3.2:3b"
+	}
+	if config.Temperature == 0 {
+		config.Temperature = 0.7
+	}
+	if config.MaxExchanges == 0 {
+		config.MaxExchanges = 10
+	}
+	if config.MemoryTTL <= 0 {
+		config.MemoryTTL = 30 * time.Minute
+	}
+	if config.Transcription.Model == "" {
+		config.Transcription.Model = "whisper-1"
+	}
+	if config.Transcription.MaxSize <= 0 {
+		config.Transcription.MaxSize = 25 << 20
+	}
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "ai"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	if m.config.Provider == nil {
+		m.config.Provider = NewProvider(m.config.Backend, m.config.URL, m.config.Token, b.HTTPClient())
+	}
+	images, err := m.config.Image.generator(b.HTTPClient())
+	if err != nil {
+		return err
+	}
+	m.images = images
+	m.transcriber = m.config.Transcription.Transcriber
+	if t := m.config.Transcription; m.transcriber == nil && t.URL != "" {
+		m.transcriber = &WhisperTranscriber{URL: t.URL, Token: t.Token, Model: t.Model, Language: t.Language, HTTPClient: b.HTTPClient()}
+	}
+	if m.transcriber != nil {
+		b.OnMessageContext(m.handleAudio)
+	}
+	if m.config.Templates != "" {
+		if err = Templates.LoadDir(m.config.Templates); err != nil {
+			return err
+		}
+	}
+	m.lastImages = make(map[id.RoomID]postedImage)
+	b.OnMessageContext(m.handleImage)
+	m.memory = NewMemory(m.config.MaxExchanges, m.config.MemoryTTL)
+	// !model and !persona are admin-only; keep !setting and state events from bypassing them
+	b.ProtectRoomSetting(ModelSetting, TemperatureSetting, PersonaSetting)
+	b.Command("ai", m.cmdAI).
+		Describe("Ask the AI; follow-up questions keep the context", "!ai <question>").
+		WithExamples("!ai What is a goroutine?")
+	b.Command("forget", m.cmdForget).
+		Describe("Make the AI forget your conversation in this room", "!forget")
+	b.Command("model", m.cmdModel).
+		Describe("Show or change the AI model and temperature of this room", "!model [set <name> | temperature <0-2> | reset]").
+		WithExamples("!model set qwen2.5:7b", "!model temperature 0.2").
+		RequireAdmin()
+	b.Command("persona", m.cmdPersona).
+		Describe("Show or change the AI's system prompt in this room", "!persona [set <prompt> | reset]").
+		WithExamples("!persona set You are a friendly helpdesk agent. Keep answers short.").
+		RequireAdmin()
+	b.Command("describe", m.cmdDescribe).
+		Describe("Describe an image, or answer a question about it; reply to the image or post it first", "!describe [question]").
+		WithExamples("!describe", "!describe What does this error message mean?")
+	b.Command("usage", m.cmdUsage).
+		Describe("Show your and this room's AI usage today", "!usage")
+	if m.images != nil {
+		b.Command("imagine", m.cmdImagine).
+			Describe("Generate an image from a description", "!imagine <prompt>").
+			WithExamples("!imagine a lighthouse in a storm, oil painting").
+			WithTimeout(5 * time.Minute)
+	}
+	return nil
+}
+
+// Run implements matrix.Runner and reloads the prompt templates of
+// Config.Templates when they change.
+func (m *Module) Run(ctx context.Context) error {
+	if m.config.Templates == "" {
+		return nil
+	}
+	ticker := time.NewTicker(templatePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		reloaded, err := Templates.Reload()
+		if err != nil {
+			m.bot.ReportError(ctx, "Reloading the prompt templates failed", err)
+		} else if reloaded {
+			m.bot.Log().Info().Str("dir", m.config.Templates).Msg("Reloaded prompt templates")
+		}
+	}
+}
+
+// Memory returns the conversation memory, e.g. to build chats for other
+// commands with Memory.Messages.
+func (m *Module) Memory() *Memory {
+	return m.memory
+}
+
+// Ask answers a question in the conversation of a message and remembers the
+// exchange. The model may run tool commands on behalf of the sender first.
+// onToken, if not nil, receives the answer in pieces as it is generated. If
+// the sender or room used up a daily budget, a QuotaError is returned.
+func (m *Module) Ask(ctx context.Context, msg *matrix.MessageContext, question string, onToken func(token string)) (string, error) {
+	return m.AskAbout(ctx, msg, question, nil, onToken)
+}
+
+// AskAbout is like Ask, with images the question is about, e.g. "What does
+// this error say?". They are sent to Config.VisionModel, if set.
+func (m *Module) AskAbout(ctx context.Context, msg *matrix.MessageContext, question string, images [][]byte, onToken func(token string)) (string, error) {
+	if err := m.reserve(ctx, msg.RoomID, msg.Sender); err != nil {
+		return "", err
+	}
+	key := KeyOf(msg)
+	model, temperature := m.Settings(ctx, msg.RoomID)
+	if len(images) > 0 && m.config.VisionModel != "" {
+		model = m.config.VisionModel
+	}
+	system := m.SystemPrompt(ctx, msg.RoomID)
+	if m.config.Augment != nil {
+		if augmented := m.config.Augment(ctx, msg, question); augmented != "" {
+			system = strings.TrimSpace(system + "\n\n" + augmented)
+		}
+	}
+	messages := m.memory.Messages(key, system, question)
+	messages[len(messages)-1].Images = images
+	tools := m.tools()
+	tokens := 0
+	for round := 0; ; round++ {
+		req := Request{Model: model, Messages: messages, Temperature: &temperature}
+		if round < maxToolRounds {
+			req.Tools = tools
+		}
+		resp, err := m.config.Provider.Chat(ctx, req, onToken)
+		if err != nil {
+			return "", err
+		}
+		if resp.Tokens > 0 {
+			tokens += resp.Tokens
+		} else {
+			tokens += estimateTokens(resp.Content) + estimateMessages(messages)
+		}
+		if len(resp.ToolCalls) == 0 || round >= maxToolRounds {
+			m.recordTokens(ctx, msg.RoomID, msg.Sender, tokens)
+			m.memory.Add(key, question, resp.Content)
+			return resp.Content, nil
+		}
+		messages = append(messages, Message{Role: RoleAssistant, Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			messages = append(messages, Message{Role: RoleTool, Content: m.runTool(ctx, msg, call), ToolCallID: call.ID})
+		}
+	}
+}
+
+// tools describes the commands marked with AsTool to the model.
+func (m *Module) tools() []Tool {
+	var tools []Tool
+	for _, cmd := range m.bot.ToolCommands() {
+		description := cmd.Description
+		if cmd.Usage != "" {
+			description += ". Usage: " + cmd.Usage
+		}
+		tools = append(tools, Tool{Name: cmd.Name, Description: description, Parameters: toolParameters})
+	}
+	return tools
+}
+
+// runTool runs the command of a tool call and returns its replies, or the
+// error, for the model.
+func (m *Module) runTool(ctx context.Context, msg *matrix.MessageContext, call ToolCall) string {
+	var arguments struct {
+		Args string `json:"args"`
+	}
+	if err := json.Unmarshal([]byte(call.Arguments), &arguments); err != nil {
+		return "Error: invalid arguments: " + err.Error()
+	}
+	msg.Log.Debug().Str("tool", call.Name).Str("args", arguments.Args).Msg("AI called a tool")
+	output, err := m.bot.RunCommand(ctx, msg, call.Name, arguments.Args)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if output == "" {
+		return "The command returned no output."
+	}
+	return output
+}
+
+func (m *Module) cmdAI(ctx context.Context, cmd *matrix.CommandContext) {
+	if cmd.Args == "" {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	m.answer(ctx, cmd.MessageContext, cmd.Args, nil)
+}
+
+// answer streams the answer to a question, about images if any, into a reply
+// that grows as it is generated.
+func (m *Module) answer(ctx context.Context, msg *matrix.MessageContext, question string, images [][]byte) {
+	var (
+		answer string
+		err    error
+	)
+	tokens := make(chan string)
+	go func() {
+		defer close(tokens)
+		answer, err = m.AskAbout(ctx, msg, question, images, func(token string) {
+			select {
+			case tokens <- token:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	eventID, streamErr := msg.StreamReply(ctx, tokens)
+	for range tokens {
+		// Drain what arrives after a failed send, until the query is done
+	}
+	var quota *QuotaError
+	switch {
+	case errors.As(err, &quota):
+		_ = msg.Reply(ctx, "⏳ "+quota.Message)
+	case err != nil:
+		msg.Log.Warn().Err(err).Msg("AI query failed")
+		_ = msg.Reply(ctx, "Sorry, the AI query failed: "+err.Error())
+	case streamErr != nil:
+		msg.Log.Warn().Err(streamErr).Msg("Failed to send AI answer")
+	case eventID == "" && answer == "":
+		_ = msg.Reply(ctx, "The AI returned no answer.")
+	}
+}
+
+func (m *Module) cmdForget(ctx context.Context, cmd *matrix.CommandContext) {
+	if m.memory.Forget(cmd.RoomID, cmd.Sender) == 0 {
+		_ = cmd.Reply(ctx, "There was nothing to forget.")
+		return
+	}
+	_ = cmd.Reply(ctx, "🧹 Forgot our conversation in this room.")
+}
+
+// cmdImagine generates an image and posts it with the prompt as caption.
+func (m *Module) cmdImagine(ctx context.Context, cmd *matrix.CommandContext) {
+	if cmd.Args == "" {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	var quota *QuotaError
+	if err := m.reserve(ctx, cmd.RoomID, cmd.Sender); errors.As(err, &quota) {
+		_ = cmd.Reply(ctx, "⏳ "+quota.Message)
+		return
+	}
+	_ = cmd.React(ctx, "🎨")
+	image, err := m.images.Generate(ctx, cmd.Args)
+	if err != nil {
+		cmd.Log.Warn().Err(err).Msg("Image generation failed")
+		_ = cmd.Reply(ctx, "Sorry, generating the image failed: "+err.Error())
+		return
+	}
+	name := "image.png"
+	switch image.ContentType {
+	case "image/jpeg":
+		name = "image.jpg"
+	case "image/webp":
+		name = "image.webp"
+	}
+	if err = m.bot.SendFileWithCaption(ctx, cmd.RoomID, name, image.ContentType, image.Data, cmd.Args); err != nil {
+		cmd.Log.Warn().Err(err).Msg("Failed to post generated image")
+		_ = cmd.Reply(ctx, "Failed to post the image: "+err.Error())
+	}
+}
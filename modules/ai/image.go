@@ -0,0 +1,281 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Image backends selectable with ImageConfig.Backend.
+const (
+	ImageBackendSDWebUI = "sdwebui" // Stable Diffusion WebUI, see SDWebUIGenerator
+	ImageBackendComfyUI = "comfyui" // ComfyUI, see ComfyUIGenerator
+	ImageBackendOpenAI  = "openai"  // OpenAI-compatible images API, see OpenAIImageGenerator
+)
+
+// Image is a generated image.
+type Image struct {
+	Data        []byte
+	ContentType string // e.g. "image/png"; detected from Data if empty
+}
+
+// ImageGenerator creates images from text prompts.
+type ImageGenerator interface {
+	Generate(ctx context.Context, prompt string) (*Image, error)
+}
+
+// ImageConfig configures the !imagine command. Without a generator or
+// backend the command isn't available.
+type ImageConfig struct {
+	// Generator creates the images. Without one, the generator of Backend for
+	// URL and Token is used.
+	Generator ImageGenerator `yaml:"-"`
+	// Backend is the API at URL: ImageBackendSDWebUI, ImageBackendComfyUI or
+	// ImageBackendOpenAI.
+	Backend string `yaml:"backend" doc:"sdwebui, comfyui or openai; empty disables !imagine"`
+	// URL is the base URL of the backend, e.g. "http://localhost:7860" for
+	// Stable Diffusion WebUI or "https://api.openai.com/v1".
+	URL string `yaml:"url" doc:"Base URL of the image backend"`
+	// Token authenticates with the backend.
+	Token string `yaml:"token" doc:"API token of the image backend"`
+	// Model generates the images with the openai backend, e.g. "dall-e-3".
+	Model string `yaml:"model" doc:"Image model of the openai backend"`
+	// Size of the images (default: "1024x1024").
+	Size string `yaml:"size" doc:"Image size, WIDTHxHEIGHT"`
+	// Workflow is a ComfyUI workflow file in API format; "{{prompt}}" in it is
+	// replaced with the prompt.
+	Workflow string `yaml:"workflow" doc:"ComfyUI workflow file in API format, with {{prompt}} as placeholder"`
+}
+
+// generator returns the image generator of the config, or nil without a backend.
+func (c *ImageConfig) generator(client *http.Client) (ImageGenerator, error) {
+	if c.Generator != nil {
+		return c.Generator, nil
+	}
+	size := c.Size
+	if size == "" {
+		size = "1024x1024"
+	}
+	switch c.Backend {
+	case "":
+		return nil, nil
+	case ImageBackendSDWebUI:
+		var width, height int
+		if _, err := fmt.Sscanf(size, "%dx%d", &width, &height); err != nil {
+			return nil, fmt.Errorf("ai: invalid image size %q", size)
+		}
+		return &SDWebUIGenerator{URL: c.URL, Token: c.Token, Width: width, Height: height, HTTPClient: client}, nil
+	case ImageBackendComfyUI:
+		workflow, err := os.ReadFile(c.Workflow)
+		if err != nil {
+			return nil, fmt.Errorf("ai: failed to read ComfyUI workflow: %w", err)
+		}
+		return &ComfyUIGenerator{URL: c.URL, Workflow: workflow, HTTPClient: client}, nil
+	default:
+		return &OpenAIImageGenerator{BaseURL: c.URL, Token: c.Token, Model: c.Model, Size: size, HTTPClient: client}, nil
+	}
+}
+
+// SDWebUIGenerator generates images with the txt2img API of the Stable
+// Diffusion WebUI (AUTOMATIC1111, Forge), started with --api.
+type SDWebUIGenerator struct {
+	URL        string // e.g. "http://localhost:7860"
+	Token      string // Bearer token, if a proxy requires one
+	Width      int
+	Height     int
+	Steps      int          // Sampling steps (default: the WebUI's)
+	HTTPClient *http.Client // Default: http.DefaultClient
+}
+
+// Generate implements ImageGenerator.
+func (g *SDWebUIGenerator) Generate(ctx context.Context, prompt string) (*Image, error) {
+	body := map[string]any{"prompt": prompt, "width": g.Width, "height": g.Height}
+	if g.Steps > 0 {
+		body["steps"] = g.Steps
+	}
+	var resp struct {
+		Images []string `json:"images"`
+	}
+	if err := imageRequest(ctx, g.HTTPClient, http.MethodPost, strings.TrimSuffix(g.URL, "/")+"/sdapi/v1/txt2img", g.Token, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Images) == 0 {
+		return nil, fmt.Errorf("ai: no image returned")
+	}
+	return decodeImage(resp.Images[0])
+}
+
+// OpenAIImageGenerator generates images with the OpenAI images API.
+type OpenAIImageGenerator struct {
+	BaseURL    string // e.g. "https://api.openai.com/v1"
+	Token      string
+	Model      string       // e.g. "dall-e-3"
+	Size       string       // e.g. "1024x1024"
+	HTTPClient *http.Client // Default: http.DefaultClient
+}
+
+// Generate implements ImageGenerator.
+func (g *OpenAIImageGenerator) Generate(ctx context.Context, prompt string) (*Image, error) {
+	body := map[string]any{"prompt": prompt, "n": 1, "size": g.Size, "response_format": "b64_json"}
+	if g.Model != "" {
+		body["model"] = g.Model
+	}
+	var resp struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+		} `json:"data"`
+	}
+	if err := imageRequest(ctx, g.HTTPClient, http.MethodPost, strings.TrimSuffix(g.BaseURL, "/")+"/images/generations", g.Token, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("ai: no image returned")
+	}
+	return decodeImage(resp.Data[0].B64JSON)
+}
+
+// ComfyUIGenerator generates images by queueing a workflow on ComfyUI and
+// waiting for its first output image.
+type ComfyUIGenerator struct {
+	URL          string        // e.g. "http://localhost:8188"
+	Workflow     []byte        // Workflow in API format; "{{prompt}}" is replaced with the prompt
+	PollInterval time.Duration // How often the result is checked (default: 1s)
+	HTTPClient   *http.Client  // Default: http.DefaultClient
+}
+
+// Generate implements ImageGenerator.
+func (g *ComfyUIGenerator) Generate(ctx context.Context, prompt string) (*Image, error) {
+	base := strings.TrimSuffix(g.URL, "/")
+	quoted, err := json.Marshal(prompt)
+	if err != nil {
+		return nil, err
+	}
+	// The placeholder sits in a JSON string, so the prompt goes in escaped, without quotes
+	workflow := bytes.ReplaceAll(g.Workflow, []byte("{{prompt}}"), quoted[1:len(quoted)-1])
+	var queued struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err = imageRequest(ctx, g.HTTPClient, http.MethodPost, base+"/prompt", "", map[string]json.RawMessage{"prompt": workflow}, &queued); err != nil {
+		return nil, err
+	}
+
+	interval := g.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		var history map[string]struct {
+			Outputs map[string]struct {
+				Images []struct {
+					Filename  string `json:"filename"`
+					Subfolder string `json:"subfolder"`
+					Type      string `json:"type"`
+				} `json:"images"`
+			} `json:"outputs"`
+		}
+		if err = imageRequest(ctx, g.HTTPClient, http.MethodGet, base+"/history/"+queued.PromptID, "", nil, &history); err != nil {
+			return nil, err
+		}
+		entry, ok := history[queued.PromptID]
+		if !ok {
+			continue // Still running
+		}
+		for _, output := range entry.Outputs {
+			for _, image := range output.Images {
+				query := url.Values{"filename": {image.Filename}, "subfolder": {image.Subfolder}, "type": {image.Type}}
+				return g.download(ctx, base+"/view?"+query.Encode())
+			}
+		}
+		return nil, fmt.Errorf("ai: the ComfyUI workflow produced no image")
+	}
+}
+
+// download fetches an output image of ComfyUI.
+func (g *ComfyUIGenerator) download(ctx context.Context, url string) (*Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ai: failed to create request: %w", err)
+	}
+	client := g.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ai: failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ai: failed to download image: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ai: failed to download image: %w", err)
+	}
+	return &Image{Data: data, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// imageRequest sends a JSON request, if body isn't nil, to an image backend
+// and decodes the JSON response into out.
+func imageRequest(ctx context.Context, client *http.Client, method, url, token string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return fmt.Errorf("ai: failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ai: image request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("ai: image request failed: %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("ai: invalid image response: %w", err)
+	}
+	return nil
+}
+
+// decodeImage decodes a base64 image, as returned by the WebUI and OpenAI.
+func decodeImage(encoded string) (*Image, error) {
+	// The WebUI may prefix a data URI header
+	if _, data, ok := strings.Cut(encoded, ";base64,"); ok {
+		encoded = data
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("ai: invalid image data: %w", err)
+	}
+	return &Image{Data: data, ContentType: http.DetectContentType(data)}, nil
+}
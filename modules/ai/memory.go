@@ -0,0 +1,116 @@
+package ai
+
+import (
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// ConversationKey identifies a conversation: a user talking to the AI in a
+// room, or in a thread of it.
+type ConversationKey struct {
+	RoomID   id.RoomID
+	ThreadID id.EventID // Root of the thread, "" outside of threads
+	UserID   id.UserID
+}
+
+// KeyOf returns the conversation a message belongs to.
+func KeyOf(msg *matrix.MessageContext) ConversationKey {
+	return ConversationKey{RoomID: msg.RoomID, ThreadID: msg.ThreadRoot(), UserID: msg.Sender}
+}
+
+// Exchange is a question and the AI's answer.
+type Exchange struct {
+	Question string
+	Answer   string
+	Time     time.Time
+}
+
+// Memory keeps the last exchanges of each conversation, so that follow-up
+// questions have context. Conversations without an exchange for the TTL are
+// forgotten. Memory is kept in memory only; a restart starts afresh.
+type Memory struct {
+	maxExchanges int
+	ttl          time.Duration
+
+	mu            sync.Mutex
+	conversations map[ConversationKey][]Exchange
+}
+
+// NewMemory creates a memory keeping up to maxExchanges exchanges per
+// conversation for ttl after the last one.
+func NewMemory(maxExchanges int, ttl time.Duration) *Memory {
+	return &Memory{
+		maxExchanges:  maxExchanges,
+		ttl:           ttl,
+		conversations: make(map[ConversationKey][]Exchange),
+	}
+}
+
+// Add records an exchange, dropping the oldest beyond the limit.
+func (m *Memory) Add(key ConversationKey, question, answer string) {
+	if m.maxExchanges <= 0 {
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	exchanges := append(m.conversations[key], Exchange{Question: question, Answer: answer, Time: now})
+	if len(exchanges) > m.maxExchanges {
+		exchanges = exchanges[len(exchanges)-m.maxExchanges:]
+	}
+	m.conversations[key] = exchanges
+}
+
+// History returns the exchanges of a conversation, oldest first.
+func (m *Memory) History(key ConversationKey) []Exchange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	return append([]Exchange(nil), m.conversations[key]...)
+}
+
+// Forget drops the conversations of a user in a room, including its threads,
+// and returns how many there were.
+func (m *Memory) Forget(roomID id.RoomID, userID id.UserID) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	forgotten := 0
+	for key := range m.conversations {
+		if key.RoomID == roomID && key.UserID == userID {
+			delete(m.conversations, key)
+			forgotten++
+		}
+	}
+	return forgotten
+}
+
+// Messages builds the chat for a question: the system prompt, if any, the
+// history of the conversation and the question.
+func (m *Memory) Messages(key ConversationKey, system, question string) []Message {
+	var messages []Message
+	if system != "" {
+		messages = append(messages, Message{Role: RoleSystem, Content: system})
+	}
+	for _, exchange := range m.History(key) {
+		messages = append(messages,
+			Message{Role: RoleUser, Content: exchange.Question},
+			Message{Role: RoleAssistant, Content: exchange.Answer})
+	}
+	return append(messages, Message{Role: RoleUser, Content: question})
+}
+
+// expire drops conversations idle for longer than the TTL. Callers hold m.mu.
+func (m *Memory) expire(now time.Time) {
+	if m.ttl <= 0 {
+		return
+	}
+	for key, exchanges := range m.conversations {
+		if now.Sub(exchanges[len(exchanges)-1].Time) > m.ttl {
+			delete(m.conversations, key)
+		}
+	}
+}
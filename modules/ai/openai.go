@@ -0,0 +1,269 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxToolCalls limits the tool calls of a streamed answer, whose deltas
+// address them by index.
+const maxToolCalls = 64
+
+// OpenAIProvider generates answers with the OpenAI chat completions API, as
+// served by vLLM, LiteLLM, llama.cpp and Ollama's /v1 endpoint too. Answers
+// are streamed; tool calls are supported.
+type OpenAIProvider struct {
+	BaseURL    string       // e.g. "http://localhost:8000/v1"
+	Token      string       // API key, if the server requires one
+	HTTPClient *http.Client // Default: http.DefaultClient
+}
+
+// NewOpenAIProvider creates a provider for an OpenAI-compatible API.
+func NewOpenAIProvider(baseURL, token string) *OpenAIProvider {
+	return &OpenAIProvider{BaseURL: baseURL, Token: token}
+}
+
+// openAIMessage is a message in the wire format.
+type openAIMessage struct {
+	Role       Role             `json:"role"`
+	Content    openAIContent    `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAIContent is the content of a message: text, or text and images as
+// content parts.
+type openAIContent struct {
+	Text   string
+	Images [][]byte
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c openAIContent) MarshalJSON() ([]byte, error) {
+	if len(c.Images) == 0 {
+		return json.Marshal(c.Text)
+	}
+	parts := []map[string]any{{"type": "text", "text": c.Text}}
+	for _, image := range c.Images {
+		uri := "data:" + http.DetectContentType(image) + ";base64," + base64.StdEncoding.EncodeToString(image)
+		parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": uri}})
+	}
+	return json.Marshal(parts)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Answers are text or null.
+func (c *openAIContent) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	if text != nil {
+		c.Text = *text
+	}
+	return nil
+}
+
+type openAIToolCall struct {
+	Index    *int           `json:"index,omitempty"` // Set in streamed deltas
+	ID       string         `json:"id,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Arguments   string         `json:"arguments,omitempty"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	Tools       []openAITool    `json:"tools,omitempty"`
+	Stream      bool            `json:"stream"`
+	// StreamOptions asks for the token usage in the last chunk
+	StreamOptions map[string]bool `json:"stream_options,omitempty"`
+}
+
+// openAIResponse is a completion, or a chunk of a streamed one.
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// tokens returns the tokens used, if reported.
+func (r *openAIResponse) tokens() int {
+	if r.Usage == nil {
+		return 0
+	}
+	return r.Usage.TotalTokens
+}
+
+// Chat implements Provider.
+func (p *OpenAIProvider) Chat(ctx context.Context, req Request, onToken func(token string)) (*Response, error) {
+	body := openAIRequest{
+		Model:         req.Model,
+		Temperature:   req.Temperature,
+		Stream:        true,
+		StreamOptions: map[string]bool{"include_usage": true},
+	}
+	for _, msg := range req.Messages {
+		wire := openAIMessage{
+			Role:       msg.Role,
+			Content:    openAIContent{Text: msg.Content, Images: msg.Images},
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, call := range msg.ToolCalls {
+			wire.ToolCalls = append(wire.ToolCalls, openAIToolCall{
+				ID:       call.ID,
+				Type:     "function",
+				Function: openAIFunction{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		body.Messages = append(body.Messages, wire)
+	}
+	for _, tool := range req.Tools {
+		body.Tools = append(body.Tools, openAITool{
+			Type:     "function",
+			Function: openAIFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(p.BaseURL, "/")
+	if !strings.HasSuffix(url, "/chat/completions") {
+		url += "/chat/completions"
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("ai: failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	if p.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("ai: chat completion failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("ai: chat completion failed: %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+
+	// Servers that don't stream answer with the whole completion
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var completion openAIResponse
+		if err = json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+			return nil, fmt.Errorf("ai: invalid chat completion: %w", err)
+		}
+		if len(completion.Choices) == 0 {
+			return &Response{Tokens: completion.tokens()}, nil
+		}
+		message := completion.Choices[0].Message
+		if onToken != nil && message.Content.Text != "" {
+			onToken(message.Content.Text)
+		}
+		return &Response{Content: message.Content.Text, ToolCalls: toolCalls(message.ToolCalls), Tokens: completion.tokens()}, nil
+	}
+
+	var (
+		content strings.Builder
+		calls   []openAIToolCall // Assembled from the deltas, by index
+		tokens  int
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		payload, ok := strings.CutPrefix(scanner.Text(), "data:")
+		payload = strings.TrimSpace(payload)
+		if !ok || payload == "" {
+			continue
+		}
+		if payload == "[DONE]" {
+			break
+		}
+		var chunk openAIResponse
+		if err = json.Unmarshal([]byte(payload), &chunk); err != nil {
+			return nil, fmt.Errorf("ai: invalid chat completion chunk: %w", err)
+		}
+		if chunk.Usage != nil {
+			tokens = chunk.tokens()
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content.Text != "" {
+			content.WriteString(delta.Content.Text)
+			if onToken != nil {
+				onToken(delta.Content.Text)
+			}
+		}
+		for _, part := range delta.ToolCalls {
+			i := len(calls)
+			if part.Index != nil {
+				i = *part.Index
+			}
+			if i < 0 || i >= maxToolCalls {
+				return nil, fmt.Errorf("ai: invalid tool call index %d in chat completion", i)
+			}
+			for len(calls) <= i {
+				calls = append(calls, openAIToolCall{})
+			}
+			if part.ID != "" {
+				calls[i].ID = part.ID
+			}
+			calls[i].Function.Name += part.Function.Name
+			calls[i].Function.Arguments += part.Function.Arguments
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ai: failed to read chat completion: %w", err)
+	}
+	return &Response{Content: content.String(), ToolCalls: toolCalls(calls), Tokens: tokens}, nil
+}
+
+// toolCalls converts tool calls from the wire format.
+func toolCalls(calls []openAIToolCall) []ToolCall {
+	var result []ToolCall
+	for _, call := range calls {
+		if call.Function.Name == "" {
+			continue
+		}
+		arguments := call.Function.Arguments
+		if arguments == "" {
+			arguments = "{}"
+		}
+		result = append(result, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: arguments})
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// streamServer serves a chat completion streamed as the given chunks.
+func streamServer(t *testing.T, chunks ...string) *OpenAIProvider {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return NewOpenAIProvider(srv.URL+"/v1", "")
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	p := streamServer(t,
+		`{"choices":[{"delta":{"content":"Hel"}}]}`,
+		`{"choices":[{"delta":{"content":"lo"}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","function":{"name":"time","arguments":"{}"}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Berlin\"}"}}]}}]}`,
+		`{"choices":[],"usage":{"total_tokens":42}}`,
+	)
+	var streamed strings.Builder
+	resp, err := p.Chat(context.Background(), Request{Model: "m", Messages: []Message{{Role: RoleUser, Content: "hi"}}}, func(token string) {
+		streamed.WriteString(token)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "Hello" || streamed.String() != "Hello" {
+		t.Errorf("content = %q, streamed %q, want Hello", resp.Content, streamed.String())
+	}
+	if resp.Tokens != 42 {
+		t.Errorf("tokens = %d, want 42", resp.Tokens)
+	}
+	if len(resp.ToolCalls) != 2 {
+		t.Fatalf("tool calls = %+v, want 2", resp.ToolCalls)
+	}
+	if call := resp.ToolCalls[0]; call.ID != "call_1" || call.Name != "weather" || call.Arguments != `{"city":"Berlin"}` {
+		t.Errorf("first tool call = %+v", call)
+	}
+	if call := resp.ToolCalls[1]; call.ID != "call_2" || call.Name != "time" {
+		t.Errorf("second tool call = %+v", call)
+	}
+}
+
+func TestOpenAIChatStreamInvalidIndex(t *testing.T) {
+	for _, index := range []int{-1, maxToolCalls, 1 << 30} {
+		p := streamServer(t, fmt.Sprintf(`{"choices":[{"delta":{"tool_calls":[{"index":%d,"function":{"name":"x"}}]}}]}`, index))
+		if _, err := p.Chat(context.Background(), Request{Model: "m"}, nil); err == nil {
+			t.Errorf("index %d: no error", index)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	ollama "github.com/eslider/go-ollama"
+)
+
+// Role is the author of a chat message.
+type Role string
+
+// Roles of chat messages.
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool" // Result of a tool call
+)
+
+// Message is a message of a chat with the model.
+type Message struct {
+	Role       Role
+	Content    string
+	ToolCalls  []ToolCall // Tools the assistant called
+	ToolCallID string     // Call answered by a RoleTool message
+	Images     [][]byte   // Images of a user message, for multimodal models
+}
+
+// Tool is a function the model may call instead of answering, see
+// Response.ToolCalls.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON schema of the arguments
+}
+
+// ToolCall is a call of a tool requested by the model.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // JSON object
+}
+
+// Request asks a model to answer the last message of a chat.
+type Request struct {
+	Model       string
+	Messages    []Message
+	Temperature *float64 // Nil uses the model's default
+	Tools       []Tool   // Tools the model may call, if the provider supports them
+}
+
+// Response is the answer of a model.
+type Response struct {
+	Content   string
+	ToolCalls []ToolCall // Tools to call; their results go back in RoleTool messages
+	Tokens    int        // Prompt and answer tokens used, 0 if the backend doesn't report them
+}
+
+// Provider is an AI backend generating chat answers.
+type Provider interface {
+	// Chat answers the last message of req.Messages. onToken, if not nil,
+	// receives the answer in pieces as it is generated.
+	Chat(ctx context.Context, req Request, onToken func(token string)) (*Response, error)
+}
+
+// NewProvider creates the provider of a backend, BackendOllama (or "") or
+// BackendOpenAI, for a URL and token. client is used by the openai backend
+// only: go-ollama creates its own HTTP client and offers no way to replace
+// it, so requests of the ollama backend don't use Config.ProxyURL or a custom
+// Config.HTTPClient of the bot.
+func NewProvider(backend, url, token string, client *http.Client) Provider {
+	if backend == BackendOpenAI {
+		provider := NewOpenAIProvider(url, token)
+		provider.HTTPClient = client
+		return provider
+	}
+	return NewOllamaProvider(url, token)
+}
+
+// OllamaProvider generates answers with the Ollama generate API, e.g. of an
+// Open WebUI instance. The API takes a single prompt, so the chat is sent as a
+// transcript, with the images of all messages. Tools aren't supported, and
+// requests go through go-ollama's own HTTP client, which has no timeout and
+// only honors the HTTPS_PROXY environment variable.
+type OllamaProvider struct {
+	client *ollama.Client
+}
+
+// NewOllamaProvider creates a provider for an Ollama generate endpoint, e.g.
+// "http://localhost:11434/api/generate".
+func NewOllamaProvider(url, token string) *OllamaProvider {
+	return &OllamaProvider{client: ollama.NewOpenWebUiClient(&ollama.DSN{URL: url, Token: token})}
+}
+
+// Chat implements Provider.
+func (p *OllamaProvider) Chat(ctx context.Context, req Request, onToken func(token string)) (*Response, error) {
+	var (
+		answer strings.Builder
+		images []ollama.RequestImage
+	)
+	for _, msg := range req.Messages {
+		for _, image := range msg.Images {
+			images = append(images, image)
+		}
+	}
+	err := p.client.Query(ollama.Request{
+		Model:   req.Model,
+		Prompt:  transcript(req.Messages),
+		Images:  images,
+		Options: &ollama.RequestOptions{Temperature: req.Temperature},
+		OnJson: func(res ollama.Response) error {
+			if err := ctx.Err(); err != nil {
+				return err // The client takes no context; stop reading instead
+			}
+			if res.Response != nil {
+				answer.WriteString(*res.Response)
+				if onToken != nil {
+					onToken(*res.Response)
+				}
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Content: answer.String()}, nil
+}
+
+// transcript renders a chat as a prompt. A single user message is sent as is.
+func transcript(messages []Message) string {
+	if len(messages) == 1 && messages[0].Role == RoleUser {
+		return messages[0].Content
+	}
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case RoleSystem:
+			sb.WriteString(msg.Content + "\n\n")
+		case RoleUser:
+			sb.WriteString("User: " + msg.Content + "\n\n")
+		case RoleAssistant:
+			sb.WriteString("Assistant: " + msg.Content + "\n\n")
+		}
+	}
+	sb.WriteString("Assistant:")
+	return sb.String()
+}
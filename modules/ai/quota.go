@@ -0,0 +1,263 @@
+package ai
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+// usageRetention is how many days of usage are kept, today included.
+const usageRetention = 30
+
+// usageTable keeps the usage per day, UTC, and user or room ID.
+const usageTable = `CREATE TABLE IF NOT EXISTS ai_usage (
+	day      TEXT    NOT NULL,
+	scope    TEXT    NOT NULL,
+	requests INTEGER NOT NULL,
+	tokens   INTEGER NOT NULL,
+	PRIMARY KEY (day, scope)
+)`
+
+// ErrQuotaExceeded matches the QuotaError returned by Ask when the sender or
+// the room used up its daily budget.
+var ErrQuotaExceeded = errors.New("ai: daily quota exceeded")
+
+// QuotaError explains to the user which daily budget is used up.
+type QuotaError struct {
+	Message string // Friendly explanation, including when the budget resets
+}
+
+func (e *QuotaError) Error() string {
+	return "ai: " + e.Message
+}
+
+// Is makes QuotaError match ErrQuotaExceeded.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Usage is the AI use of a user or room on a day.
+type Usage struct {
+	Requests int `json:"requests"` // Questions and images
+	Tokens   int `json:"tokens"`   // Prompt and answer tokens, estimated if the backend doesn't report them
+}
+
+// usageDate returns the day of the usage at a time, UTC.
+func usageDate(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// usageDB returns the database of the usage, creating its table on first use:
+// the bot's store is only available once it runs. The usage needs an SQL store.
+func (m *Module) usageDB(ctx context.Context) (*dbutil.Database, error) {
+	m.usageMu.Lock()
+	defer m.usageMu.Unlock()
+	if m.usage != nil {
+		return m.usage, nil
+	}
+	sqlStore, ok := m.bot.Store().(*matrix.SQLStore)
+	if !ok {
+		return nil, matrix.ErrNoDatabase
+	}
+	if _, err := sqlStore.DB.Exec(ctx, usageTable); err != nil {
+		return nil, fmt.Errorf("ai: failed to create usage table: %w", err)
+	}
+	m.usage = sqlStore.DB
+	return m.usage, nil
+}
+
+// TodayUsage returns the usage of a user and a room today, UTC.
+func (m *Module) TodayUsage(ctx context.Context, roomID id.RoomID, userID id.UserID) (user, room Usage, err error) {
+	db, err := m.usageDB(ctx)
+	if err != nil {
+		return user, room, err
+	}
+	day := usageDate(time.Now())
+	for scope, usage := range map[string]*Usage{userID.String(): &user, roomID.String(): &room} {
+		err = db.QueryRow(ctx, "SELECT requests, tokens FROM ai_usage WHERE day=$1 AND scope=$2", day, scope).
+			Scan(&usage.Requests, &usage.Tokens)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return user, room, fmt.Errorf("ai: failed to read usage: %w", err)
+		}
+	}
+	return user, room, nil
+}
+
+// checkQuota returns a QuotaError if the user or room used up a daily budget,
+// without counting a request, see reserve. If the usage can't be read, the
+// request is allowed.
+func (m *Module) checkQuota(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	if m.config.DailyRequests <= 0 && m.config.DailyTokens <= 0 && m.config.RoomDailyTokens <= 0 {
+		return nil
+	}
+	user, room, err := m.TodayUsage(ctx, roomID, userID)
+	if err != nil {
+		m.bot.Log().Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to read AI usage")
+		return nil
+	}
+	return m.quotaError(user, room)
+}
+
+// quotaError returns a QuotaError if a usage reached a daily budget.
+func (m *Module) quotaError(user, room Usage) error {
+	var reason string
+	switch {
+	case m.config.DailyRequests > 0 && user.Requests >= m.config.DailyRequests:
+		reason = fmt.Sprintf("You've used your %d AI requests for today.", m.config.DailyRequests)
+	case m.config.DailyTokens > 0 && user.Tokens >= m.config.DailyTokens:
+		reason = fmt.Sprintf("You've used your AI budget of %d tokens for today.", m.config.DailyTokens)
+	case m.config.RoomDailyTokens > 0 && room.Tokens >= m.config.RoomDailyTokens:
+		reason = fmt.Sprintf("This room has used its AI budget of %d tokens for today.", m.config.RoomDailyTokens)
+	default:
+		return nil
+	}
+	return &QuotaError{Message: reason + " It resets in " + untilReset(time.Now()) + " (midnight UTC)."}
+}
+
+// errBudgetUsed rolls back the transaction of reserve.
+var errBudgetUsed = errors.New("ai: budget used")
+
+// reserve counts a request of a user in a room, unless the user or the room
+// used up a daily budget; then a QuotaError is returned. The budgets are
+// checked by the updates counting the request, in one transaction, so
+// concurrent requests can't exceed them. If the usage can't be stored, the
+// request is allowed.
+func (m *Module) reserve(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	db, err := m.usageDB(ctx)
+	if err != nil {
+		m.bot.Log().Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to record AI usage")
+		return nil
+	}
+	now := time.Now()
+	m.cleanupUsage(ctx, db, now)
+	day := usageDate(now)
+	err = db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		for _, scope := range []string{userID.String(), roomID.String()} {
+			_, err := db.Exec(ctx, `INSERT INTO ai_usage (day, scope, requests, tokens) VALUES ($1, $2, 0, 0)
+				ON CONFLICT (day, scope) DO NOTHING`, day, scope)
+			if err != nil {
+				return err
+			}
+		}
+		var requests int
+		err := db.QueryRow(ctx, `UPDATE ai_usage SET requests=requests+1
+			WHERE day=$1 AND scope=$2 AND ($3<=0 OR requests<$3) AND ($4<=0 OR tokens<$4) RETURNING requests`,
+			day, userID, m.config.DailyRequests, m.config.DailyTokens).Scan(&requests)
+		if err == nil {
+			err = db.QueryRow(ctx, `UPDATE ai_usage SET requests=requests+1
+				WHERE day=$1 AND scope=$2 AND ($3<=0 OR tokens<$3) RETURNING requests`,
+				day, roomID, m.config.RoomDailyTokens).Scan(&requests)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return errBudgetUsed
+		}
+		return err
+	})
+	switch {
+	case errors.Is(err, errBudgetUsed):
+		user, room, err := m.TodayUsage(ctx, roomID, userID)
+		if quota := m.quotaError(user, room); err == nil && quota != nil {
+			return quota
+		}
+		return &QuotaError{Message: "The AI budget for today is used up. It resets in " + untilReset(now) + " (midnight UTC)."}
+	case err != nil:
+		m.bot.Log().Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to record AI usage")
+	}
+	return nil
+}
+
+// recordTokens adds the tokens of a request counted by reserve to the usage
+// of the user and room.
+func (m *Module) recordTokens(ctx context.Context, roomID id.RoomID, userID id.UserID, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	db, err := m.usageDB(ctx)
+	if err != nil {
+		return // Logged by reserve
+	}
+	day := usageDate(time.Now())
+	for _, scope := range []string{userID.String(), roomID.String()} {
+		_, err = db.Exec(ctx, `INSERT INTO ai_usage (day, scope, requests, tokens) VALUES ($1, $2, 0, $3)
+			ON CONFLICT (day, scope) DO UPDATE SET tokens=ai_usage.tokens+excluded.tokens`, day, scope, tokens)
+		if err != nil {
+			m.bot.Log().Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to record AI usage")
+			return
+		}
+	}
+}
+
+// cleanupUsage drops the days falling out of the retention, once a day.
+func (m *Module) cleanupUsage(ctx context.Context, db *dbutil.Database, now time.Time) {
+	m.usageMu.Lock()
+	defer m.usageMu.Unlock()
+	if today := usageDate(now); m.usageDay != today {
+		_, err := db.Exec(ctx, "DELETE FROM ai_usage WHERE day<$1", usageDate(now.AddDate(0, 0, 1-usageRetention)))
+		if err != nil {
+			m.bot.Log().Warn().Err(err).Msg("Failed to clean up AI usage")
+			return
+		}
+		m.usageDay = today
+	}
+}
+
+// estimateTokens estimates the tokens of texts for backends that don't
+// report them, at about four characters per token.
+func estimateTokens(texts ...string) int {
+	chars := 0
+	for _, text := range texts {
+		chars += len([]rune(text))
+	}
+	return (chars + 3) / 4
+}
+
+// estimateMessages estimates the tokens of a chat, see estimateTokens.
+func estimateMessages(messages []Message) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += estimateTokens(msg.Content)
+	}
+	return tokens
+}
+
+// untilReset formats the time until the next midnight UTC, e.g. "5h 12m".
+func untilReset(now time.Time) string {
+	now = now.UTC()
+	wait := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now).Round(time.Minute)
+	if wait < time.Hour {
+		return fmt.Sprintf("%dm", int(wait.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dm", int(wait.Hours()), int(wait.Minutes())%60)
+}
+
+// limit formats a usage with its limit, e.g. "12 of 50 requests".
+func limit(used, budget int, unit string) string {
+	if budget <= 0 {
+		return fmt.Sprintf("%d %s", used, unit)
+	}
+	return fmt.Sprintf("%d of %d %s", used, budget, unit)
+}
+
+// cmdUsage shows the sender's and the room's usage today.
+func (m *Module) cmdUsage(ctx context.Context, cmd *matrix.CommandContext) {
+	user, room, err := m.TodayUsage(ctx, cmd.RoomID, cmd.Sender)
+	if err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("**AI usage today:**\n\n")
+	sb.WriteString(fmt.Sprintf("- You: %s, %s\n",
+		limit(user.Requests, m.config.DailyRequests, "requests"), limit(user.Tokens, m.config.DailyTokens, "tokens")))
+	sb.WriteString(fmt.Sprintf("- This room: %d requests, %s\n",
+		room.Requests, limit(room.Tokens, m.config.RoomDailyTokens, "tokens")))
+	sb.WriteString("\nUsage resets in " + untilReset(time.Now()) + " (midnight UTC).")
+	_ = cmd.Reply(ctx, sb.String())
+}
@@ -0,0 +1,125 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	testRoom = id.RoomID("!room:example.com")
+	testUser = id.UserID("@alice:example.com")
+)
+
+// newQuotaModule creates a module with budgets and a usage database.
+func newQuotaModule(t *testing.T, config Config) *Module {
+	t.Helper()
+	b, err := matrix.NewBot(matrix.Config{Homeserver: "https://matrix.example.com", AccessToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dbutil.NewWithDialect("file:"+filepath.Join(t.TempDir(), "usage.db")+"?_txlock=immediate", "sqlite3-fk-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err = db.Exec(context.Background(), usageTable); err != nil {
+		t.Fatal(err)
+	}
+	m := New(config)
+	m.bot, m.usage = b, db
+	return m
+}
+
+func TestReserveRequests(t *testing.T) {
+	ctx := context.Background()
+	m := newQuotaModule(t, Config{DailyRequests: 2})
+	for range 2 {
+		if err := m.reserve(ctx, testRoom, testUser); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.reserve(ctx, testRoom, testUser); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("third request: %v, want ErrQuotaExceeded", err)
+	}
+	if err := m.reserve(ctx, testRoom, "@bob:example.com"); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+	user, room, err := m.TodayUsage(ctx, testRoom, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Requests != 2 || room.Requests != 3 {
+		t.Errorf("usage: user %d, room %d requests, want 2 and 3", user.Requests, room.Requests)
+	}
+}
+
+func TestReserveTokens(t *testing.T) {
+	ctx := context.Background()
+	m := newQuotaModule(t, Config{RoomDailyTokens: 100})
+	if err := m.reserve(ctx, testRoom, testUser); err != nil {
+		t.Fatal(err)
+	}
+	m.recordTokens(ctx, testRoom, testUser, 100)
+	err := m.reserve(ctx, testRoom, "@bob:example.com")
+	var quota *QuotaError
+	if !errors.As(err, &quota) {
+		t.Fatalf("request after the room budget: %v, want a QuotaError", err)
+	}
+	if user, _, _ := m.TodayUsage(ctx, testRoom, "@bob:example.com"); user.Requests != 0 {
+		t.Errorf("rejected request counted for the user: %d", user.Requests)
+	}
+}
+
+func TestReserveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := newQuotaModule(t, Config{DailyRequests: 5})
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.reserve(ctx, testRoom, testUser); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 5 {
+		t.Errorf("%d concurrent requests accepted, want 5", accepted)
+	}
+}
+
+func TestCleanupUsage(t *testing.T) {
+	ctx := context.Background()
+	m := newQuotaModule(t, Config{})
+	now := time.Now()
+	for _, daysAgo := range []int{0, usageRetention - 1, usageRetention, 90} {
+		_, err := m.usage.Exec(ctx, "INSERT INTO ai_usage (day, scope, requests, tokens) VALUES ($1, $2, 1, 0)",
+			usageDate(now.AddDate(0, 0, -daysAgo)), testUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.cleanupUsage(ctx, m.usage, now)
+	var days int
+	if err := m.usage.QueryRow(ctx, "SELECT COUNT(*) FROM ai_usage").Scan(&days); err != nil {
+		t.Fatal(err)
+	}
+	if days != 2 {
+		t.Errorf("%d days kept, want 2", days)
+	}
+}
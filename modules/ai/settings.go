@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// Room settings overriding Config.Model, Config.Temperature and
+// Config.SystemPrompt, changed with !model, !persona or !setting.
+const (
+	ModelSetting       = "ai.model"
+	TemperatureSetting = "ai.temperature"
+	PersonaSetting     = "ai.persona"
+)
+
+// maxPersonaLength limits the system prompt of a room, in characters.
+const maxPersonaLength = 4000
+
+// Settings returns the model and temperature used in a room: the room
+// settings, or else the configured ones. Without a database the configured
+// ones are used everywhere.
+func (m *Module) Settings(ctx context.Context, roomID id.RoomID) (model string, temperature float64) {
+	model, temperature = m.config.Model, m.config.Temperature
+	settings, err := m.bot.RoomSettings(ctx, roomID)
+	if err != nil {
+		if !errors.Is(err, matrix.ErrNoDatabase) {
+			m.bot.Log().Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to read AI settings")
+		}
+		return model, temperature
+	}
+	if value := settings[ModelSetting]; value != "" {
+		model = value
+	}
+	if value, err := strconv.ParseFloat(settings[TemperatureSetting], 64); err == nil && value >= 0 {
+		temperature = value
+	}
+	return model, temperature
+}
+
+// cmdModel shows or changes the model and temperature of the room.
+func (m *Module) cmdModel(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	var key, value string
+	switch {
+	case len(fields) == 0:
+		model, temperature := m.Settings(ctx, cmd.RoomID)
+		_ = cmd.Reply(ctx, fmt.Sprintf("Model: `%s`, temperature: %g", model, temperature))
+		return
+	case len(fields) == 2 && fields[0] == "set":
+		key, value = ModelSetting, fields[1]
+	case len(fields) == 2 && fields[0] == "temperature":
+		temperature, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			_ = cmd.Reply(ctx, "The temperature must be a number from 0 to 2.")
+			return
+		}
+		key, value = TemperatureSetting, strconv.FormatFloat(temperature, 'g', -1, 64)
+	case len(fields) == 1 && fields[0] == "reset":
+		for _, key := range []string{ModelSetting, TemperatureSetting} {
+			if err := m.bot.SetRoomSetting(ctx, cmd.RoomID, key, "", cmd.Sender); err != nil {
+				_ = cmd.Reply(ctx, "Error: "+err.Error())
+				return
+			}
+		}
+		_ = cmd.React(ctx, "✅")
+		return
+	default:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	if err := m.bot.SetRoomSetting(ctx, cmd.RoomID, key, value, cmd.Sender); err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	_ = cmd.React(ctx, "✅")
+}
+
+// SystemPrompt returns the system prompt used in a room: the room's persona,
+// or else Config.SystemPrompt.
+func (m *Module) SystemPrompt(ctx context.Context, roomID id.RoomID) string {
+	persona, err := m.bot.RoomSetting(ctx, roomID, PersonaSetting)
+	if err != nil && !errors.Is(err, matrix.ErrNoDatabase) {
+		m.bot.Log().Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to read AI persona")
+	}
+	if persona == "" {
+		return m.config.SystemPrompt
+	}
+	return persona
+}
+
+// cmdPersona shows or changes the system prompt of the room.
+func (m *Module) cmdPersona(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	switch {
+	case len(fields) == 0:
+		persona := m.SystemPrompt(ctx, cmd.RoomID)
+		if persona == "" {
+			_ = cmd.Reply(ctx, "No persona is set; the model answers as it is.")
+			return
+		}
+		_ = cmd.Reply(ctx, "**Persona:**\n\n> "+strings.ReplaceAll(persona, "\n", "\n> "))
+	case len(fields) >= 2 && fields[0] == "set":
+		persona := strings.TrimSpace(strings.TrimPrefix(cmd.Args, fields[0]))
+		if len([]rune(persona)) > maxPersonaLength {
+			_ = cmd.Reply(ctx, fmt.Sprintf("The persona is too long, at most %d characters.", maxPersonaLength))
+			return
+		}
+		if err := m.bot.SetRoomSetting(ctx, cmd.RoomID, PersonaSetting, persona, cmd.Sender); err != nil {
+			_ = cmd.Reply(ctx, "Error: "+err.Error())
+			return
+		}
+		_ = cmd.React(ctx, "✅")
+	case len(fields) == 1 && fields[0] == "reset":
+		if err := m.bot.SetRoomSetting(ctx, cmd.RoomID, PersonaSetting, "", cmd.Sender); err != nil {
+			_ = cmd.Reply(ctx, "Error: "+err.Error())
+			return
+		}
+		_ = cmd.React(ctx, "✅")
+	default:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+	}
+}
@@ -0,0 +1,206 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TemplateExt is the file extension of prompt templates loaded from a directory.
+const TemplateExt = ".tmpl"
+
+// templateFuncs are available in prompt templates besides the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"trim": strings.TrimSpace,
+	"truncate": func(n int, s string) string {
+		if runes := []rune(s); len(runes) > n {
+			return string(runes[:n]) + "…"
+		}
+		return s
+	},
+}
+
+// Templates is the registry of the bot's prompt templates. Code registers
+// defaults; Config.Templates loads overrides from a directory.
+var Templates = NewTemplateRegistry()
+
+// TemplateRegistry holds named prompt templates in Go text/template syntax,
+// e.g. "Summarize these issues of {{.Repo}}:{{range .Issues}}\n- {{.Title}}{{end}}".
+// Besides the builtins, templates can use join, trim and truncate, e.g.
+// {{truncate 200 .Body}}.
+//
+// Templates registered in code are defaults: a file "<name>.tmpl" in the
+// directory given to LoadDir replaces the template of that name, so prompts
+// can be tuned without a rebuild, and Reload picks up changes of the files.
+type TemplateRegistry struct {
+	mu         sync.RWMutex
+	registered map[string]*template.Template
+	loaded     map[string]*template.Template // From the directory, overriding registered
+	dir        string
+	modTimes   map[string]time.Time // Of the loaded files, by name
+}
+
+// NewTemplateRegistry creates an empty registry.
+func NewTemplateRegistry() *TemplateRegistry {
+	return &TemplateRegistry{registered: make(map[string]*template.Template)}
+}
+
+// parseTemplate parses the text of a prompt template.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("ai: %w", err) // "template: <name>:<line>: ..."
+	}
+	return tmpl, nil
+}
+
+// Register adds or replaces the default template of a name.
+func (r *TemplateRegistry) Register(name, text string) error {
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered[name] = tmpl
+	return nil
+}
+
+// Has reports whether a template of a name is registered or loaded.
+func (r *TemplateRegistry) Has(name string) bool {
+	return r.lookup(name) != nil
+}
+
+// Names returns the names of all templates, sorted.
+func (r *TemplateRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for name := range r.registered {
+		names = append(names, name)
+	}
+	for name := range r.loaded {
+		if r.registered[name] == nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Execute renders the template of a name with data, e.g. a struct or map
+// with the repository, issues and user of a request.
+func (r *TemplateRegistry) Execute(name string, data any) (string, error) {
+	tmpl := r.lookup(name)
+	if tmpl == nil {
+		return "", fmt.Errorf("ai: unknown template %q", name)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("ai: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// lookup returns the template of a name, preferring one loaded from the directory.
+func (r *TemplateRegistry) lookup(name string) *template.Template {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if tmpl := r.loaded[name]; tmpl != nil {
+		return tmpl
+	}
+	return r.registered[name]
+}
+
+// LoadDir loads the "*.tmpl" files of a directory, named after the file
+// without the extension, and remembers the directory for Reload. Templates
+// loaded from an earlier directory are dropped. If a file doesn't parse,
+// nothing is changed.
+func (r *TemplateRegistry) LoadDir(dir string) error {
+	loaded, modTimes, err := readTemplates(dir)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dir, r.loaded, r.modTimes = dir, loaded, modTimes
+	return nil
+}
+
+// Reload loads the directory of LoadDir again if a file was added, changed
+// or removed, and reports whether it did. On errors, the templates loaded
+// before are kept.
+func (r *TemplateRegistry) Reload() (bool, error) {
+	r.mu.RLock()
+	dir, modTimes := r.dir, r.modTimes
+	r.mu.RUnlock()
+	if dir == "" {
+		return false, nil
+	}
+	current, err := templateModTimes(dir)
+	if err != nil {
+		return false, err
+	}
+	if sameModTimes(current, modTimes) {
+		return false, nil
+	}
+	return true, r.LoadDir(dir)
+}
+
+// readTemplates parses the templates of a directory.
+func readTemplates(dir string) (map[string]*template.Template, map[string]time.Time, error) {
+	modTimes, err := templateModTimes(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	loaded := make(map[string]*template.Template, len(modTimes))
+	for name := range modTimes {
+		text, err := os.ReadFile(filepath.Join(dir, name+TemplateExt))
+		if err != nil {
+			return nil, nil, fmt.Errorf("ai: template %s: %w", name, err)
+		}
+		if loaded[name], err = parseTemplate(name, string(text)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return loaded, modTimes, nil
+}
+
+// templateModTimes returns the modification times of the templates of a directory, by name.
+func templateModTimes(dir string) (map[string]time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ai: templates: %w", err)
+	}
+	modTimes := make(map[string]time.Time)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != TemplateExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("ai: templates: %w", err)
+		}
+		modTimes[strings.TrimSuffix(entry.Name(), TemplateExt)] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// sameModTimes reports whether two sets of modification times are the same.
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, t := range a {
+		if other, ok := b[name]; !ok || !other.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
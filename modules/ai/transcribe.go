@@ -0,0 +1,169 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+)
+
+// Transcription modes selectable with TranscriptionConfig.Mode.
+const (
+	TranscribePost = "post" // Reply with the transcript
+	TranscribeAsk  = "ask"  // Answer the transcript like an !ai question
+)
+
+// Transcriber turns speech into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, contentType, fileName string) (string, error)
+}
+
+// TranscriptionConfig configures the transcription of voice messages. Without
+// a transcriber or URL, voice messages are ignored.
+type TranscriptionConfig struct {
+	// Transcriber turns speech into text. Without one, a WhisperTranscriber
+	// for URL and Token is used.
+	Transcriber Transcriber `yaml:"-"`
+	// URL is the transcription endpoint, e.g.
+	// "http://localhost:8000/v1/audio/transcriptions".
+	URL string `yaml:"url" doc:"Whisper transcription endpoint, e.g. http://localhost:8000/v1/audio/transcriptions"`
+	// Token authenticates with the endpoint.
+	Token string `yaml:"token" doc:"API token of the transcription endpoint"`
+	// Model transcribes the audio (default: "whisper-1").
+	Model string `yaml:"model" doc:"Transcription model"`
+	// Language is the spoken language as ISO-639-1 code, e.g. "de"; empty
+	// detects it.
+	Language string `yaml:"language" doc:"Spoken language, e.g. de; empty detects it"`
+	// Mode is TranscribePost (default) or TranscribeAsk.
+	Mode string `yaml:"mode" doc:"post replies with the transcript, ask answers it like an !ai question"`
+	// AllAudio transcribes all audio messages, not only voice messages.
+	AllAudio bool `yaml:"all_audio" doc:"Transcribe all audio messages, not only voice messages"`
+	// MaxSize skips larger recordings, in bytes (default: 25 MB).
+	MaxSize int `yaml:"max_size" doc:"Larger recordings aren't transcribed, in bytes"`
+}
+
+// WhisperTranscriber transcribes with the OpenAI audio transcriptions API, as
+// served by faster-whisper-server, whisper.cpp, LocalAI and others.
+type WhisperTranscriber struct {
+	URL        string // e.g. "http://localhost:8000/v1/audio/transcriptions"
+	Token      string
+	Model      string       // e.g. "whisper-1"
+	Language   string       // ISO-639-1 code; empty detects it
+	HTTPClient *http.Client // Default: http.DefaultClient
+}
+
+// Transcribe implements Transcriber.
+func (t *WhisperTranscriber) Transcribe(ctx context.Context, audio []byte, contentType, fileName string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(audio); err != nil {
+		return "", err
+	}
+	fields := map[string]string{"model": t.Model, "language": t.Language, "response_format": "json"}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err = form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	if err = form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, &body)
+	if err != nil {
+		return "", fmt.Errorf("ai: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	client := t.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ai: transcription failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("ai: transcription failed: %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("ai: invalid transcription response: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// handleAudio transcribes voice messages and posts or answers the transcript.
+func (m *Module) handleAudio(ctx context.Context, msg *matrix.MessageContext) {
+	config := m.config.Transcription
+	content := msg.Message
+	if msg.FromSelf() || content.MsgType != event.MsgAudio || content.MSC3245Voice == nil && !config.AllAudio {
+		return
+	}
+	if content.Info != nil && content.Info.Size > config.MaxSize {
+		msg.Log.Debug().Int("size", content.Info.Size).Msg("Not transcribing large recording")
+		return
+	}
+	// Asking counts the request in Ask; posting the transcript counts it here
+	count := m.reserve
+	if config.Mode == TranscribeAsk {
+		count = m.checkQuota
+	}
+	var quota *QuotaError
+	if err := count(ctx, msg.RoomID, msg.Sender); errors.As(err, &quota) {
+		_ = msg.Reply(ctx, "⏳ "+quota.Message)
+		return
+	}
+
+	audio, err := m.bot.DownloadMediaLimit(ctx, content, config.MaxSize)
+	if errors.Is(err, matrix.ErrMediaTooLarge) {
+		msg.Log.Debug().Msg("Not transcribing large recording")
+		return
+	}
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to download voice message")
+		return
+	}
+	contentType, fileName := "", content.FileName
+	if content.Info != nil {
+		contentType = content.Info.MimeType
+	}
+	if fileName == "" {
+		fileName = "voice.ogg"
+	}
+	text, err := m.transcriber.Transcribe(ctx, audio, contentType, fileName)
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Transcription failed")
+		_ = msg.Reply(ctx, "Sorry, I couldn't transcribe that: "+err.Error())
+		return
+	}
+	if text == "" {
+		return
+	}
+	if config.Mode == TranscribeAsk {
+		m.answer(ctx, msg, text, nil)
+		return
+	}
+	_ = msg.Reply(ctx, "🎙️ "+text)
+}
@@ -0,0 +1,125 @@
+package ai
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// describeQuestion is asked about images without a question.
+	describeQuestion = "Describe this image."
+	// lastImageMaxAge is how long !describe without a reply refers to the
+	// last image posted in the room.
+	lastImageMaxAge = 15 * time.Minute
+)
+
+// postedImage is an image message seen in a room.
+type postedImage struct {
+	content *event.MessageEventContent
+	time    time.Time
+}
+
+// handleImage remembers the last image of each room and answers images and
+// replies to images that mention the bot.
+func (m *Module) handleImage(ctx context.Context, msg *matrix.MessageContext) {
+	if msg.FromSelf() || msg.Reaction != nil || msg.IsEdit() {
+		return
+	}
+	content := msg.Message
+	if content.MsgType == event.MsgImage {
+		m.imagesMu.Lock()
+		m.lastImages[msg.RoomID] = postedImage{content: content, time: time.Now()}
+		m.imagesMu.Unlock()
+		// A caption differs from the file name, see MSC2530
+		if content.FileName != "" && content.Body != content.FileName && m.mentionsBot(ctx, msg) {
+			m.answerImage(ctx, msg, content, m.question(ctx, msg))
+		}
+		return
+	}
+	if content.MsgType != event.MsgText || msg.ReplyTo() == "" || !m.mentionsBot(ctx, msg) {
+		return
+	}
+	if msg.IsCommand(ctx) {
+		return // e.g. !describe, which handles the reply itself
+	}
+	if image := m.repliedImage(ctx, msg); image != nil {
+		m.answerImage(ctx, msg, image, m.question(ctx, msg))
+	}
+}
+
+// mentionsBot reports whether a message mentions the bot, as pill or at its start.
+func (m *Module) mentionsBot(ctx context.Context, msg *matrix.MessageContext) bool {
+	mentions := msg.Message.Mentions
+	return mentions != nil && slices.Contains(mentions.UserIDs, m.bot.UserID()) || msg.MentionsBot(ctx)
+}
+
+// question returns the question of a message mentioning the bot, without
+// the mention.
+func (m *Module) question(ctx context.Context, msg *matrix.MessageContext) string {
+	question := msg.WithoutMention(ctx)
+	if question == "" {
+		return describeQuestion
+	}
+	return question
+}
+
+// repliedImage returns the image a message replies to, or nil.
+func (m *Module) repliedImage(ctx context.Context, msg *matrix.MessageContext) *event.MessageEventContent {
+	evt, err := msg.FetchEvent(ctx, msg.ReplyTo())
+	if err != nil {
+		msg.Log.Debug().Err(err).Msg("Failed to fetch replied-to event")
+		return nil
+	}
+	if content := evt.Content.AsMessage(); content != nil && content.MsgType == event.MsgImage {
+		return content
+	}
+	return nil
+}
+
+// lastImage returns the last image posted in a room recently, or nil.
+func (m *Module) lastImage(roomID id.RoomID) *event.MessageEventContent {
+	m.imagesMu.Lock()
+	defer m.imagesMu.Unlock()
+	image, ok := m.lastImages[roomID]
+	if !ok || time.Since(image.time) > lastImageMaxAge {
+		return nil
+	}
+	return image.content
+}
+
+// answerImage downloads an image and answers a question about it.
+func (m *Module) answerImage(ctx context.Context, msg *matrix.MessageContext, image *event.MessageEventContent, question string) {
+	data, err := m.bot.DownloadMedia(ctx, image)
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to download image")
+		_ = msg.Reply(ctx, "Sorry, I couldn't download the image: "+err.Error())
+		return
+	}
+	m.answer(ctx, msg, question, [][]byte{data})
+}
+
+// cmdDescribe answers a question about the image the command replies to, or
+// the last image of the room.
+func (m *Module) cmdDescribe(ctx context.Context, cmd *matrix.CommandContext) {
+	var image *event.MessageEventContent
+	if cmd.ReplyTo() != "" {
+		image = m.repliedImage(ctx, cmd.MessageContext)
+	}
+	if image == nil {
+		image = m.lastImage(cmd.RoomID)
+	}
+	if image == nil {
+		_ = cmd.Reply(ctx, "Reply to an image with `"+cmd.Command.Usage+"`, or post one first.")
+		return
+	}
+	question := cmd.Args
+	if question == "" {
+		question = describeQuestion
+	}
+	m.answerImage(ctx, cmd.MessageContext, image, question)
+}
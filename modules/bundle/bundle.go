@@ -0,0 +1,144 @@
+// Package bundle adds a !config command to export the bot's effective
+// configuration (global config, routing rules, room settings, module sections)
+// as a YAML file, and to import such a file elsewhere, e.g. to promote a setup
+// from staging to production.
+//
+//	!config export           - upload the bundle without secrets
+//	!config export secrets   - include passwords and tokens, sent by encrypted DM
+//	!config import           - as a reply to an uploaded bundle file
+//
+// Only the bot admins (matrix.Config.Admins) may use the command. A bundle
+// with secrets is only sent to a DM room shared with nobody else, and is
+// redacted like Bot.SendSecret.
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+)
+
+// Config configures the bundle module.
+type Config struct {
+	// SecretTTL is how long a bundle with secrets stays in the DM room if it
+	// isn't read (default: 10m). It is redacted a minute after it is read.
+	SecretTTL time.Duration
+
+	// OnImport is called with the imported Config, e.g. to write it to the
+	// config file for the next start. Runtime settings are applied regardless.
+	OnImport func(ctx context.Context, config matrix.Config) error
+}
+
+// Module provides the !config command.
+type Module struct {
+	config Config
+	bot    *matrix.Bot
+}
+
+// New creates the bundle module.
+func New(config Config) *Module {
+	if config.SecretTTL <= 0 {
+		config.SecretTTL = 10 * time.Minute
+	}
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "bundle"
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	b.Command("config", m.cmdConfig).
+		Describe("Export or import the bot configuration", "!config export [secrets] | !config import").
+		RequireAdmin().
+		WithPriority(matrix.PriorityControl)
+	return nil
+}
+
+func (m *Module) cmdConfig(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	if len(fields) == 0 {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+
+	switch fields[0] {
+	case "export":
+		m.export(ctx, cmd, len(fields) > 1 && fields[1] == "secrets")
+	case "import":
+		m.importBundle(ctx, cmd)
+	default:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+	}
+}
+
+func (m *Module) export(ctx context.Context, cmd *matrix.CommandContext, secrets bool) {
+	var buf bytes.Buffer
+	if err := m.bot.ExportConfig(ctx, &buf, secrets); err != nil {
+		_ = cmd.Reply(ctx, "Export failed: "+err.Error())
+		return
+	}
+	fileName := fmt.Sprintf("bot-config-%s.yaml", time.Now().Format("2006-01-02"))
+	if !secrets {
+		if err := m.bot.SendFile(ctx, cmd.RoomID, fileName, "application/yaml", buf.Bytes()); err != nil {
+			_ = cmd.Reply(ctx, "Failed to upload the bundle: "+err.Error())
+		}
+		return
+	}
+
+	// Secrets stay out of the room history: they only go to the admin's
+	// private encrypted DM, and are redacted there
+	eventID, err := m.bot.SendSecretFile(ctx, cmd.Sender, fileName, "application/yaml", buf.Bytes(), m.config.SecretTTL)
+	if err != nil && eventID == "" {
+		_ = cmd.Reply(ctx, "Not exporting secrets: "+err.Error())
+		return
+	} else if err != nil {
+		cmd.Log.Warn().Err(err).Msg("Failed to schedule the removal of the exported secrets")
+	}
+	cmd.Log.Warn().Str("user_id", cmd.Sender.String()).Msg("Exported configuration including secrets")
+	_ = cmd.Reply(ctx, fmt.Sprintf("Sent the bundle with secrets to you in a direct message; it is removed after %s.", m.config.SecretTTL))
+}
+
+func (m *Module) importBundle(ctx context.Context, cmd *matrix.CommandContext) {
+	replyTo := cmd.ReplyTo()
+	if replyTo == "" {
+		_ = cmd.Reply(ctx, "Reply to an uploaded bundle file with `!config import`.")
+		return
+	}
+	evt, err := cmd.FetchEvent(ctx, replyTo)
+	if err != nil {
+		_ = cmd.Reply(ctx, "Failed to load the bundle message: "+err.Error())
+		return
+	}
+	content := evt.Content.AsMessage()
+	if content.MsgType != event.MsgFile {
+		_ = cmd.Reply(ctx, "The replied-to message is not a file.")
+		return
+	}
+	data, err := m.bot.DownloadMedia(ctx, content)
+	if err != nil {
+		_ = cmd.Reply(ctx, "Failed to download the bundle: "+err.Error())
+		return
+	}
+
+	config, err := m.bot.ImportConfig(ctx, bytes.NewReader(data))
+	if err != nil {
+		_ = cmd.Reply(ctx, "Import failed: "+err.Error())
+		return
+	}
+	if m.config.OnImport != nil {
+		if err = m.config.OnImport(ctx, config); err != nil {
+			_ = cmd.Reply(ctx, "Settings were applied, but saving the config failed: "+err.Error())
+			return
+		}
+	}
+	cmd.Log.Info().Msg("Imported configuration bundle")
+	_ = cmd.Reply(ctx, "✅ Configuration imported.")
+}
@@ -0,0 +1,337 @@
+// Package digest collects events during a period — messages matching a
+// filter, webhook payloads, Gitea activity — and posts them as one summary on
+// a schedule, instead of a notification each, to keep busy rooms readable.
+//
+// Usage:
+//
+//	digests := digest.New(digest.Config{Digests: []digest.Digest{{
+//		Name:     "gitea",
+//		RoomID:   "!dev:example.com",
+//		Schedule: "0 17 * * mon-fri",
+//		Title:    "Repository activity",
+//	}, {
+//		Name:     "incidents",
+//		RoomID:   "!ops:example.com",
+//		Schedule: "@daily",
+//		Rooms:    []id.RoomID{"!alerts:example.com"},
+//		Keywords: []string{"outage", "incident"},
+//	}}})
+//	if err := bot.Use(digests); err != nil { ... }
+//
+//	// In a webhook or Gitea handler:
+//	_ = digests.Add(ctx, "gitea", digest.Item{Source: "go-matrix-bot", Text: "alice pushed 3 commits"})
+//
+// Collected items are kept in the bot's store until they are posted, so a
+// restart loses nothing.
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// Item is an entry of a digest.
+type Item struct {
+	Source string    `json:"source"`         // Heading the item is grouped under, e.g. a repository or room name
+	Text   string    `json:"text"`           // Markdown, a single line
+	Link   string    `json:"link,omitempty"` // Appended to the text as a link
+	Time   time.Time `json:"time"`
+}
+
+// Digest is a summary of collected items posted to a room on a schedule.
+type Digest struct {
+	Name     string    `yaml:"name" doc:"Identifies the digest for Add"`
+	RoomID   id.RoomID `yaml:"room_id" doc:"Room the digest is posted to"`
+	Schedule string    `yaml:"schedule" doc:"Cron expression of the posting times, e.g. \"0 17 * * mon-fri\""`
+	Title    string    `yaml:"title" doc:"Heading of the digest (default: the name)"`
+	MaxItems int       `yaml:"max_items" doc:"Items kept per period; the oldest are dropped first (default: 200)"`
+
+	// Rooms whose messages are collected. Without rooms, items only come from Add.
+	Rooms []id.RoomID `yaml:"rooms" doc:"Rooms whose messages are collected"`
+	// Keywords limit the collected messages to those containing one of them,
+	// case-insensitively. Without keywords, all messages are collected.
+	Keywords []string `yaml:"keywords" doc:"Collect only messages containing one of these words"`
+	// Filter, if set, further limits the collected messages.
+	Filter func(msg *matrix.MessageContext) bool `yaml:"-"`
+	// Render, if set, replaces the default rendering, which groups the items
+	// by source.
+	Render func(d *Digest, items []Item, dropped int) string `yaml:"-"`
+}
+
+// Config lists the digests. It can also be set in the "modules.digest"
+// section of the config file, without Filter and Render.
+type Config struct {
+	Digests []Digest `yaml:"digests" doc:"Digests with name, room_id, schedule and optionally rooms and keywords"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, d := range c.Digests {
+		field := fmt.Sprintf("digests[%d]", i)
+		switch {
+		case d.Name == "":
+			errs = append(errs, matrix.InvalidConfig(field+".name", "is required"))
+		case names[d.Name]:
+			errs = append(errs, matrix.InvalidConfig(field+".name", "%q is used twice", d.Name))
+		}
+		names[d.Name] = true
+		if d.RoomID == "" {
+			errs = append(errs, matrix.InvalidConfig(field+".room_id", "is required"))
+		}
+		if _, err := matrix.ParseCron(d.Schedule); err != nil {
+			errs = append(errs, matrix.InvalidConfig(field+".schedule", "is invalid: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pending is the stored state of a digest between postings.
+type pending struct {
+	Since   time.Time `json:"since"`
+	Items   []Item    `json:"items"`
+	Dropped int       `json:"dropped,omitempty"` // Items dropped because of MaxItems
+}
+
+// Module collects items and posts the digests.
+type Module struct {
+	config Config
+	bot    *matrix.Bot
+	mu     sync.Mutex // Serializes changes of the stored items
+}
+
+// New creates the digest module.
+func New(config Config) *Module {
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "digest"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	watch := false
+	for i := range m.config.Digests {
+		d := &m.config.Digests[i]
+		if d.MaxItems <= 0 {
+			d.MaxItems = 200
+		}
+		if d.Title == "" {
+			d.Title = d.Name
+		}
+		if err := b.Schedule(d.Schedule, func(ctx context.Context) {
+			if err := m.Post(ctx, d.Name); err != nil {
+				b.ReportError(ctx, "Posting the digest "+d.Name+" failed", err)
+			}
+		}); err != nil {
+			return fmt.Errorf("digest: %s: %w", d.Name, err)
+		}
+		watch = watch || len(d.Rooms) > 0
+	}
+	if watch {
+		b.OnMessageContext(m.handleMessage)
+	}
+	return nil
+}
+
+// digest returns the digest with a name, or nil.
+func (m *Module) digest(name string) *Digest {
+	for i := range m.config.Digests {
+		if m.config.Digests[i].Name == name {
+			return &m.config.Digests[i]
+		}
+	}
+	return nil
+}
+
+// Add collects an item for the next posting of a digest, e.g. from a webhook
+// or Gitea handler. A zero Time is set to now.
+func (m *Module) Add(ctx context.Context, name string, item Item) error {
+	d := m.digest(name)
+	if d == nil {
+		return fmt.Errorf("digest: unknown digest %q", name)
+	}
+	if item.Time.IsZero() {
+		item.Time = time.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.load(ctx, name)
+	if err != nil {
+		return err
+	}
+	if p.Since.IsZero() {
+		p.Since = item.Time
+	}
+	p.Items = append(p.Items, item)
+	if excess := len(p.Items) - d.MaxItems; excess > 0 {
+		p.Items = p.Items[excess:]
+		p.Dropped += excess
+	}
+	return m.save(ctx, name, p)
+}
+
+// handleMessage collects the messages of the watched rooms.
+func (m *Module) handleMessage(ctx context.Context, msg *matrix.MessageContext) {
+	if msg.FromSelf() || msg.Reaction != nil || msg.IsEdit() {
+		return
+	}
+	body := strings.ToLower(msg.Message.Body)
+	for i := range m.config.Digests {
+		d := &m.config.Digests[i]
+		if !slices.Contains(d.Rooms, msg.RoomID) || !matches(body, d.Keywords) || d.Filter != nil && !d.Filter(msg) {
+			continue
+		}
+		source := msg.RoomID.String()
+		if name := msg.Room().Name; name != "" {
+			source = name
+		}
+		text, _, _ := strings.Cut(msg.Message.Body, "\n")
+		if len([]rune(text)) > 200 {
+			text = string([]rune(text)[:200]) + "…"
+		}
+		item := Item{
+			Source: source,
+			Text:   msg.Sender.String() + ": " + text,
+			Link:   msg.RoomID.EventURI(msg.EventID()).MatrixToURL(),
+			Time:   msg.Time(),
+		}
+		if err := m.Add(ctx, d.Name, item); err != nil {
+			msg.Log.Warn().Err(err).Str("digest", d.Name).Msg("Failed to collect message for digest")
+		}
+	}
+}
+
+// matches reports whether a lowercased body contains any keyword, or there are none.
+func matches(body string, keywords []string) bool {
+	if len(keywords) == 0 {
+		return true
+	}
+	for _, keyword := range keywords {
+		if strings.Contains(body, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns the items collected for the next posting of a digest.
+func (m *Module) Pending(ctx context.Context, name string) ([]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return p.Items, nil
+}
+
+// Post posts a digest now and starts a new period. Nothing is posted if no
+// items were collected, or the module is disabled in the digest's room.
+func (m *Module) Post(ctx context.Context, name string) error {
+	d := m.digest(name)
+	if d == nil {
+		return fmt.Errorf("digest: unknown digest %q", name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.load(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(p.Items) == 0 || !m.bot.ModuleEnabled(ctx, d.RoomID, m.Name()) {
+		return nil
+	}
+
+	var md string
+	if d.Render != nil {
+		md = d.Render(d, p.Items, p.Dropped)
+	} else {
+		md = render(d, p)
+	}
+	if err = m.bot.SendHTML(ctx, d.RoomID, md, matrix.MarkdownToHTML(md)); err != nil && !errors.Is(err, matrix.ErrQueued) {
+		return err // Keep the items for the next posting
+	}
+	return m.save(ctx, name, &pending{Since: time.Now()})
+}
+
+// render groups the items of a digest by source, in the order sources first appeared.
+func render(d *Digest, p *pending) string {
+	var sources []string
+	bySource := make(map[string][]Item)
+	for _, item := range p.Items {
+		if _, ok := bySource[item.Source]; !ok {
+			sources = append(sources, item.Source)
+		}
+		bySource[item.Source] = append(bySource[item.Source], item)
+	}
+
+	var sb strings.Builder
+	total := len(p.Items) + p.Dropped
+	sb.WriteString(fmt.Sprintf("**%s** — %d item", d.Title, total))
+	if total != 1 {
+		sb.WriteString("s")
+	}
+	if !p.Since.IsZero() {
+		sb.WriteString(" since " + p.Since.Format("Mon Jan 2 15:04"))
+	}
+	sb.WriteString("\n")
+	for _, source := range sources {
+		items := bySource[source]
+		if source != "" {
+			sb.WriteString(fmt.Sprintf("\n**%s** (%d)\n", source, len(items)))
+		}
+		sb.WriteString("\n")
+		for _, item := range items {
+			sb.WriteString("- " + item.Text)
+			if item.Link != "" {
+				sb.WriteString(" ([link](" + item.Link + "))")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if p.Dropped > 0 {
+		sb.WriteString(fmt.Sprintf("\n_…and %d earlier items not shown._", p.Dropped))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// load reads the pending items of a digest. Callers hold m.mu.
+func (m *Module) load(ctx context.Context, name string) (*pending, error) {
+	p := &pending{}
+	data, err := m.bot.Store().Get(ctx, "digest."+name)
+	if err != nil || data == "" {
+		return p, err
+	}
+	if err = json.Unmarshal([]byte(data), p); err != nil {
+		return nil, fmt.Errorf("digest: invalid stored items of %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// save stores the pending items of a digest. Callers hold m.mu.
+func (m *Module) save(ctx context.Context, name string, p *pending) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return m.bot.Store().Set(ctx, "digest."+name, string(data))
+}
@@ -0,0 +1,167 @@
+package jira
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Client calls the REST API (version 2) of Jira Cloud, Server or Data Center.
+type Client struct {
+	URL        string // Base URL of the instance, e.g. "https://example.atlassian.net"
+	User       string // Account email on Cloud; empty to send Token as bearer token (Server and Data Center)
+	Token      string // API token on Cloud, personal access token on Server and Data Center
+	HTTPClient *http.Client
+}
+
+// Issue is a Jira issue with the fields shown by the module.
+type Issue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary     string   `json:"summary"`
+		Description string   `json:"description"`
+		Status      *Named   `json:"status"`
+		IssueType   *Named   `json:"issuetype"`
+		Priority    *Named   `json:"priority"`
+		Assignee    *User    `json:"assignee"`
+		Reporter    *User    `json:"reporter"`
+		Project     *Named   `json:"project"`
+		Labels      []string `json:"labels"`
+	} `json:"fields"`
+}
+
+// Named is a field value with a name, e.g. a status or priority.
+type Named struct {
+	Key  string `json:"key,omitempty"`
+	Name string `json:"name"`
+}
+
+// String returns the name, or "" for nil.
+func (n *Named) String() string {
+	if n == nil {
+		return ""
+	}
+	return n.Name
+}
+
+// User is a Jira account.
+type User struct {
+	DisplayName string `json:"displayName"`
+}
+
+// String returns the display name, or "Unassigned" for nil.
+func (u *User) String() string {
+	if u == nil {
+		return "Unassigned"
+	}
+	return u.DisplayName
+}
+
+// Link returns the URL of an issue in the browser.
+func (c *Client) Link(key string) string {
+	return strings.TrimRight(c.URL, "/") + "/browse/" + key
+}
+
+// Issue fetches an issue by key, e.g. "PROJ-123".
+func (c *Client) Issue(ctx context.Context, key string) (*Issue, error) {
+	issue := &Issue{}
+	path := "issue/" + url.PathEscape(key) + "?fields=summary,description,status,issuetype,priority,assignee,reporter,project,labels"
+	if err := c.do(ctx, http.MethodGet, path, nil, issue); err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+// CreateIssue creates an issue in a project and returns its key.
+func (c *Client) CreateIssue(ctx context.Context, project, issueType, summary, description string) (string, error) {
+	fields := map[string]any{
+		"project":   map[string]string{"key": project},
+		"issuetype": map[string]string{"name": issueType},
+		"summary":   summary,
+	}
+	if description != "" {
+		fields["description"] = description
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := c.do(ctx, http.MethodPost, "issue", map[string]any{"fields": fields}, &created); err != nil {
+		return "", err
+	}
+	return created.Key, nil
+}
+
+// do sends a request to the API and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.URL, "/")+"/rest/api/2/"+path, body)
+	if err != nil {
+		return fmt.Errorf("jira: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Token)
+	} else if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("jira: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("jira: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("jira: %s: %s", resp.Status, apiError(data))
+	}
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("jira: invalid response: %w", err)
+	}
+	return nil
+}
+
+// apiError returns the messages of a Jira error response, or else the body.
+func apiError(data []byte) string {
+	var resp struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(data, &resp) != nil {
+		return strings.TrimSpace(string(data))
+	}
+	messages := resp.ErrorMessages
+	fields := make([]string, 0, len(resp.Errors))
+	for field := range resp.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		messages = append(messages, field+": "+resp.Errors[field])
+	}
+	return strings.Join(messages, "; ")
+}
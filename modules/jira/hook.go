@@ -0,0 +1,144 @@
+package jira
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	matrix "github.com/eslider/go-matrix-bot"
+)
+
+// maxPayloadSize limits the webhook payloads accepted by the handler.
+const maxPayloadSize = 5 << 20
+
+// Payload is a Jira issue webhook.
+type Payload struct {
+	WebhookEvent string `json:"webhookEvent"` // e.g. "jira:issue_updated"
+	User         *User  `json:"user"`
+	Issue        Issue  `json:"issue"`
+	Changelog    struct {
+		Items []Change `json:"items"`
+	} `json:"changelog"`
+}
+
+// Change is a changed field of an issue.
+type Change struct {
+	Field      string `json:"field"`
+	FromString string `json:"fromString"`
+	ToString   string `json:"toString"`
+}
+
+// Transition is a status change of an issue, posted to the rooms of its project.
+type Transition struct {
+	Issue *Issue
+	From  string
+	To    string
+	By    string // Display name of the user, "" if unknown
+}
+
+// Transition returns the status change of the webhook, or nil.
+func (p *Payload) Transition() *Transition {
+	for _, change := range p.Changelog.Items {
+		if change.Field == "status" {
+			t := &Transition{Issue: &p.Issue, From: change.FromString, To: change.ToString}
+			if p.User != nil {
+				t.By = p.User.DisplayName
+			}
+			return t
+		}
+	}
+	return nil
+}
+
+// Handler returns the HTTP handler receiving the Jira issue webhooks via
+// POST. Status changes of issues are posted to the rooms of their project and
+// routed with Bot.Route, with the fields source (jira), project, issue and
+// status; other events are ignored.
+func (m *Module) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxPayloadSize {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !m.verify(r, body) {
+			m.bot.Log().Warn().Str("remote", r.RemoteAddr).Msg("Rejected Jira webhook")
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+		var payload Payload
+		if err = json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "jira: invalid payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if t := payload.Transition(); t != nil {
+			if err = m.postTransition(r.Context(), t); err != nil {
+				m.bot.ReportError(r.Context(), "Posting Jira transition of "+t.Issue.Key+" failed", err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// verify checks the secret of a webhook: the X-Hub-Signature of signed Jira
+// Cloud webhooks, or else the "secret" query parameter.
+func (m *Module) verify(r *http.Request, body []byte) bool {
+	if m.config.Secret == "" {
+		return false
+	}
+	if signature := r.Header.Get("X-Hub-Signature"); signature != "" {
+		got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(m.config.Secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(m.config.Secret)) == 1
+}
+
+// postTransition posts a status change to the rooms of the issue's project
+// and the targets of matching rules.
+func (m *Module) postTransition(ctx context.Context, t *Transition) error {
+	key := t.Issue.Key
+	projKey, _, _ := strings.Cut(key, "-")
+	if project := t.Issue.Fields.Project; project != nil && project.Key != "" {
+		projKey = project.Key
+	}
+	md := fmt.Sprintf("**[%s](%s)** %s: %s → **%s**", key, m.client.Link(key), t.Issue.Fields.Summary, t.From, t.To)
+	if t.By != "" {
+		md += " by " + t.By
+	}
+	m.bot.Log().Debug().Str("issue", key).Str("status", t.To).Msg("Posting Jira transition")
+
+	report := &matrix.Report{Markdown: md, Data: t}
+	var targets []matrix.Target
+	if project := m.project(projKey); project != nil {
+		for _, room := range project.Rooms {
+			targets = append(targets, matrix.RoomTarget(room))
+		}
+	}
+	err := m.bot.Deliver(ctx, report, targets...)
+	fields := map[string]string{"source": "jira", "project": projKey, "issue": key, "status": t.To}
+	_, routeErr := m.bot.Route(ctx, fields, report)
+	return errors.Join(err, routeErr)
+}
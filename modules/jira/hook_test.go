@@ -0,0 +1,64 @@
+package jira
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	const body = `{"webhookEvent":"jira:issue_updated"}`
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	tests := []struct {
+		name      string
+		secret    string
+		target    string
+		signature string
+		ok        bool
+	}{
+		{"signature", "s3cret", "/jira", "sha256=" + signature, true},
+		{"signature without prefix", "s3cret", "/jira", signature, true},
+		{"wrong signature", "other", "/jira", "sha256=" + signature, false},
+		{"invalid signature beats valid query", "s3cret", "/jira?secret=s3cret", "sha256=00", false},
+		{"query secret", "s3cret", "/jira?secret=s3cret", "", true},
+		{"wrong query secret", "s3cret", "/jira?secret=other", "", false},
+		{"unauthenticated", "s3cret", "/jira", "", false},
+		{"no secret configured", "", "/jira?secret=", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Config{Secret: tt.secret})
+			r := httptest.NewRequest("POST", tt.target, strings.NewReader(body))
+			if tt.signature != "" {
+				r.Header.Set("X-Hub-Signature", tt.signature)
+			}
+			if got := m.verify(r, []byte(body)); got != tt.ok {
+				t.Errorf("verify() = %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPayloadTransition(t *testing.T) {
+	const body = `{"webhookEvent":"jira:issue_updated","user":{"displayName":"Alice"},"issue":{"key":"OPS-7"},
+		"changelog":{"items":[{"field":"assignee","toString":"Bob"},{"field":"status","fromString":"To Do","toString":"Done"}]}}`
+	var payload Payload
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	tr := payload.Transition()
+	if tr == nil || tr.Issue.Key != "OPS-7" || tr.From != "To Do" || tr.To != "Done" || tr.By != "Alice" {
+		t.Fatalf("Transition() = %+v", tr)
+	}
+
+	payload.Changelog.Items = payload.Changelog.Items[:1]
+	if tr = payload.Transition(); tr != nil {
+		t.Errorf("Transition() without status change = %+v, want nil", tr)
+	}
+}
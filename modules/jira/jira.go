@@ -0,0 +1,248 @@
+// Package jira brings Jira Cloud, Server and Data Center issues into Matrix
+// rooms, for teams tracking their work in Jira: "!jira PROJ-123" shows an
+// issue, "!jira create" creates one, and a webhook receiver posts issue
+// transitions to the rooms mapped to their project.
+//
+// Usage:
+//
+//	config := jira.GetEnvironmentConfig()
+//	config.Projects = []jira.Project{{Key: "OPS", Rooms: []string{"#ops:example.com"}}}
+//	issues := jira.New(config)
+//	if err := bot.Use(issues); err != nil { ... }
+//	http.Handle("/jira", issues.Handler())
+//
+// In Jira, add a webhook for "Issue updated" events posting to
+// https://bot.example.com/jira?secret=<secret>. Jira Cloud webhooks created
+// with a secret are verified by their X-Hub-Signature header instead.
+package jira
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxResponseSize limits the API responses read.
+const maxResponseSize = 1 << 20
+
+// maxDescriptionLength limits the description shown of an issue.
+const maxDescriptionLength = 300
+
+// issueKey matches issue keys, e.g. "PROJ-123".
+var issueKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]+-[0-9]+$`)
+
+// projectKey matches project keys, e.g. "PROJ".
+var projectKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]+$`)
+
+// Project maps a Jira project to the rooms its transitions are posted to.
+// In those rooms, "!jira create" creates issues in the project by default.
+type Project struct {
+	Key   string   `yaml:"key" doc:"Project key, e.g. OPS"`
+	Rooms []string `yaml:"rooms" doc:"Rooms (IDs or aliases) issue transitions are posted to"`
+}
+
+// Config holds the Jira instance and the projects. It can also be set in the
+// "modules.jira" section of the config file.
+type Config struct {
+	URL   string `yaml:"url" doc:"Base URL of the Jira instance, e.g. https://example.atlassian.net"`
+	User  string `yaml:"user" doc:"Account email (Jira Cloud); empty to use the token as personal access token"`
+	Token string `yaml:"token" doc:"API token (Jira Cloud) or personal access token (Server, Data Center)"`
+	// Secret authenticates the webhooks, as "secret" query parameter or as
+	// HMAC key of the X-Hub-Signature header. Without it, Handler rejects all
+	// webhooks.
+	Secret    string    `yaml:"secret" doc:"Secret of the webhooks, in the URL (?secret=) or signing them"`
+	IssueType string    `yaml:"issue_type" doc:"Type of the issues created with !jira create (default: Task)"`
+	Projects  []Project `yaml:"projects" doc:"Projects with key and the rooms their transitions are posted to"`
+}
+
+// GetEnvironmentConfig creates a Config from the JIRA_URL, JIRA_USER,
+// JIRA_TOKEN and JIRA_WEBHOOK_SECRET environment variables.
+func GetEnvironmentConfig() Config {
+	return Config{
+		URL:    os.Getenv("JIRA_URL"),
+		User:   os.Getenv("JIRA_USER"),
+		Token:  os.Getenv("JIRA_TOKEN"),
+		Secret: os.Getenv("JIRA_WEBHOOK_SECRET"),
+	}
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.URL == "" {
+		errs = append(errs, matrix.InvalidConfig("url", "is required"))
+	}
+	keys := make(map[string]bool)
+	for i, project := range c.Projects {
+		field := fmt.Sprintf("projects[%d]", i)
+		switch {
+		case !projectKey.MatchString(project.Key):
+			errs = append(errs, matrix.InvalidConfig(field+".key", "must be a project key like OPS, not %q", project.Key))
+		case keys[project.Key]:
+			errs = append(errs, matrix.InvalidConfig(field+".key", "%q is used twice", project.Key))
+		}
+		keys[project.Key] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Module shows, creates and follows Jira issues.
+type Module struct {
+	config Config
+	client *Client
+	bot    *matrix.Bot
+}
+
+// New creates the Jira module.
+func New(config Config) *Module {
+	if config.IssueType == "" {
+		config.IssueType = "Task"
+	}
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "jira"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	m.client = &Client{URL: m.config.URL, User: m.config.User, Token: m.config.Token, HTTPClient: b.HTTPClient()}
+	b.Command("jira", m.cmdJira).
+		Describe("Show or create Jira issues", "!jira <PROJ-123...> | !jira create [PROJ] <summary>").
+		WithExamples("!jira OPS-42", "!jira create OPS Renew the TLS certificate")
+	return nil
+}
+
+// Client returns the API client, e.g. to query other fields. It is set by Init.
+func (m *Module) Client() *Client {
+	return m.client
+}
+
+func (m *Module) cmdJira(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	switch {
+	case len(fields) == 0:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+	case fields[0] == "create":
+		m.create(ctx, cmd, strings.TrimSpace(strings.TrimPrefix(cmd.Args, "create")))
+	default:
+		m.show(ctx, cmd, fields)
+	}
+}
+
+// show replies with the details of issues.
+func (m *Module) show(ctx context.Context, cmd *matrix.CommandContext, keys []string) {
+	var parts []string
+	for _, key := range keys {
+		key = strings.ToUpper(key)
+		if !issueKey.MatchString(key) {
+			_ = cmd.Reply(ctx, fmt.Sprintf("%q is no issue key like OPS-42. Usage: `%s`", key, cmd.Command.Usage))
+			return
+		}
+		issue, err := m.client.Issue(ctx, key)
+		if err != nil {
+			cmd.Log.Warn().Err(err).Str("issue", key).Msg("Failed to fetch Jira issue")
+			parts = append(parts, fmt.Sprintf("**%s**: %v", key, err))
+			continue
+		}
+		parts = append(parts, m.render(issue))
+	}
+	_ = cmd.Reply(ctx, strings.Join(parts, "\n\n"))
+}
+
+// render formats the details of an issue in Markdown.
+func (m *Module) render(issue *Issue) string {
+	f := issue.Fields
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("**[%s](%s)** %s\n", issue.Key, m.client.Link(issue.Key), f.Summary))
+	details := []string{f.IssueType.String(), f.Status.String(), f.Priority.String()}
+	details = append(details, "assignee: "+f.Assignee.String())
+	if f.Reporter != nil {
+		details = append(details, "reporter: "+f.Reporter.String())
+	}
+	var shown []string
+	for _, detail := range details {
+		if detail != "" {
+			shown = append(shown, detail)
+		}
+	}
+	sb.WriteString(strings.Join(shown, " · "))
+	if len(f.Labels) > 0 {
+		sb.WriteString(" · `" + strings.Join(f.Labels, "` `") + "`")
+	}
+	if description := strings.TrimSpace(f.Description); description != "" {
+		if runes := []rune(description); len(runes) > maxDescriptionLength {
+			description = string(runes[:maxDescriptionLength]) + "…"
+		}
+		sb.WriteString("\n\n> " + strings.ReplaceAll(description, "\n", "\n> "))
+	}
+	return sb.String()
+}
+
+// create creates an issue from "[PROJ] <summary>", with further lines as
+// description. Without a project, the project mapped to the room is used.
+func (m *Module) create(ctx context.Context, cmd *matrix.CommandContext, args string) {
+	summary, description, _ := strings.Cut(args, "\n")
+	project, rest, _ := strings.Cut(strings.TrimSpace(summary), " ")
+	if projectKey.MatchString(project) {
+		summary = rest
+	} else if project = m.roomProject(ctx, cmd.RoomID); project == "" {
+		_ = cmd.Reply(ctx, "No Jira project is mapped to this room; name one: `!jira create PROJ <summary>`")
+		return
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	description = strings.TrimSpace(description)
+	if description != "" {
+		description += "\n\n"
+	}
+	description += "Created by " + cmd.Sender.String() + " in Matrix."
+
+	key, err := m.client.CreateIssue(ctx, project, m.config.IssueType, summary, description)
+	if err != nil {
+		cmd.Log.Warn().Err(err).Str("project", project).Msg("Failed to create Jira issue")
+		_ = cmd.Reply(ctx, fmt.Sprintf("Failed to create the issue: %v", err))
+		return
+	}
+	cmd.Log.Info().Str("issue", key).Msg("Created Jira issue")
+	_ = cmd.Reply(ctx, fmt.Sprintf("Created [%s](%s): %s", key, m.client.Link(key), summary))
+}
+
+// roomProject returns the key of the project mapped to a room, or "".
+func (m *Module) roomProject(ctx context.Context, roomID id.RoomID) string {
+	for _, project := range m.config.Projects {
+		for _, room := range project.Rooms {
+			if resolved, err := m.bot.ResolveRoom(ctx, room); err == nil && resolved == roomID {
+				return project.Key
+			}
+		}
+	}
+	return ""
+}
+
+// project returns the configured project with a key, or nil.
+func (m *Module) project(key string) *Project {
+	for i := range m.config.Projects {
+		if m.config.Projects[i].Key == key {
+			return &m.config.Projects[i]
+		}
+	}
+	return nil
+}
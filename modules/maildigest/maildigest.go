@@ -0,0 +1,277 @@
+// Package maildigest emails a daily digest of unanswered mentions and
+// important messages to users who don't keep a Matrix client open.
+//
+// A mention stays in the digest until the mentioned user writes in the same
+// room, which is treated as having seen and answered it.
+//
+// Usage:
+//
+//	digest := maildigest.New(email.NewSMTPSender(email.GetEnvironmentConfig()), maildigest.Config{
+//		Recipients: map[id.UserID]string{"@alice:example.com": "alice@example.com"},
+//		Keywords:   []string{"urgent", "outage"},
+//	})
+//	if err := bot.Use(digest); err != nil { ... }
+package maildigest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"github.com/eslider/go-matrix-bot/email"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Config controls who receives digests and what goes into them. It can also
+// be set in the "modules.maildigest" section of the config file.
+type Config struct {
+	// Recipients maps Matrix users to the email address receiving their digest.
+	Recipients map[id.UserID]string `yaml:"recipients" doc:"Matrix user to email address receiving their digest"`
+	// Keywords mark a message as important for every recipient in the room,
+	// even without a direct mention. Matching is case-insensitive.
+	Keywords []string `yaml:"keywords" doc:"Words marking a message as important for all recipients in the room"`
+	// SendAt is the time of day the digest is sent, as an offset from midnight (default: 8h).
+	SendAt time.Duration `yaml:"send_at" doc:"Time of day the digest is sent, e.g. 8h30m"`
+	// Location is the time zone for SendAt (default: time.Local).
+	Location *time.Location `yaml:"-"`
+	// MaxItems caps the entries kept per user (default: 100). The oldest are dropped first.
+	MaxItems int `yaml:"max_items" doc:"Entries kept per user; the oldest are dropped first"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	for userID, address := range c.Recipients {
+		if !strings.Contains(address, "@") {
+			errs = append(errs, matrix.InvalidConfig("recipients", "has an invalid email address %q for %s", address, userID))
+		}
+	}
+	if c.SendAt <= 0 || c.SendAt >= 24*time.Hour {
+		errs = append(errs, matrix.InvalidConfig("send_at", "must be between 0 and 24h, e.g. 8h30m"))
+	}
+	if c.MaxItems <= 0 {
+		errs = append(errs, matrix.InvalidConfig("max_items", "must be > 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// Item is a single digest entry.
+type Item struct {
+	RoomID id.RoomID
+	Sender id.UserID
+	Body   string
+	Time   time.Time
+	Reason string // "mention" or the matched keyword
+}
+
+// Module collects digest items and mails them daily.
+type Module struct {
+	config Config
+	sender email.Sender
+	bot    *matrix.Bot
+
+	mu      sync.Mutex
+	pending map[id.UserID][]Item
+}
+
+// New creates a digest module delivering through the given email sender.
+func New(sender email.Sender, config Config) *Module {
+	if config.SendAt <= 0 {
+		config.SendAt = 8 * time.Hour
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	if config.MaxItems <= 0 {
+		config.MaxItems = 100
+	}
+	return &Module{
+		config:  config,
+		sender:  sender,
+		pending: make(map[id.UserID][]Item),
+	}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "maildigest"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	b.OnMessage(m.handleMessage)
+	return nil
+}
+
+// Run implements matrix.Runner and sends the digest once a day.
+func (m *Module) Run(ctx context.Context) error {
+	for {
+		timer := time.NewTimer(time.Until(m.nextSend(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		m.SendDigests(ctx)
+	}
+}
+
+// nextSend returns the next time the digest is due after now.
+func (m *Module) nextSend(now time.Time) time.Time {
+	now = now.In(m.config.Location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, m.config.Location)
+	next := midnight.Add(m.config.SendAt)
+	if !next.After(now) {
+		next = midnight.AddDate(0, 0, 1).Add(m.config.SendAt)
+	}
+	return next
+}
+
+func (m *Module) handleMessage(_ context.Context, roomID id.RoomID, sender id.UserID, msg *event.MessageEventContent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Writing in a room counts as having answered everything pending there.
+	if items, ok := m.pending[sender]; ok {
+		kept := items[:0]
+		for _, item := range items {
+			if item.RoomID != roomID {
+				kept = append(kept, item)
+			}
+		}
+		m.pending[sender] = kept
+	}
+
+	for userID := range m.config.Recipients {
+		if userID == sender {
+			continue
+		}
+		reason := m.match(userID, msg)
+		if reason == "" {
+			continue
+		}
+		items := append(m.pending[userID], Item{
+			RoomID: roomID,
+			Sender: sender,
+			Body:   msg.Body,
+			Time:   time.Now(),
+			Reason: reason,
+		})
+		if len(items) > m.config.MaxItems {
+			items = items[len(items)-m.config.MaxItems:]
+		}
+		m.pending[userID] = items
+	}
+}
+
+// match returns why a message belongs in a user's digest, or "" if it doesn't.
+func (m *Module) match(userID id.UserID, msg *event.MessageEventContent) string {
+	if msg.Mentions != nil {
+		for _, mentioned := range msg.Mentions.UserIDs {
+			if mentioned == userID {
+				return "mention"
+			}
+		}
+	}
+	if strings.Contains(msg.Body, userID.String()) {
+		return "mention"
+	}
+	body := strings.ToLower(msg.Body)
+	for _, keyword := range m.config.Keywords {
+		if strings.Contains(body, strings.ToLower(keyword)) {
+			return keyword
+		}
+	}
+	return ""
+}
+
+// Pending returns the items currently queued for a user.
+func (m *Module) Pending(userID id.UserID) []Item {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Item(nil), m.pending[userID]...)
+}
+
+// SendDigests mails every recipient with pending items and clears them on success.
+func (m *Module) SendDigests(ctx context.Context) {
+	m.mu.Lock()
+	batches := m.pending
+	m.pending = make(map[id.UserID][]Item)
+	m.mu.Unlock()
+
+	for userID, items := range batches {
+		if len(items) == 0 {
+			continue
+		}
+		msg := m.compose(userID, items)
+		if err := m.sender.Send(ctx, msg); err != nil {
+			m.bot.ReportError(ctx, "Sending digest emails failed", err)
+			m.requeue(userID, items)
+			continue
+		}
+		m.bot.Log().Info().Str("user_id", userID.String()).Int("items", len(items)).Msg("Sent digest email")
+	}
+}
+
+// requeue puts items back in front of anything collected while sending failed.
+func (m *Module) requeue(userID id.UserID, items []Item) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	merged := append(items, m.pending[userID]...)
+	if len(merged) > m.config.MaxItems {
+		merged = merged[len(merged)-m.config.MaxItems:]
+	}
+	m.pending[userID] = merged
+}
+
+// compose renders the digest email, grouped by room.
+func (m *Module) compose(userID id.UserID, items []Item) *email.Message {
+	byRoom := make(map[id.RoomID][]Item)
+	var rooms []id.RoomID
+	for _, item := range items {
+		if _, ok := byRoom[item.RoomID]; !ok {
+			rooms = append(rooms, item.RoomID)
+		}
+		byRoom[item.RoomID] = append(byRoom[item.RoomID], item)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i] < rooms[j] })
+
+	var text, body strings.Builder
+	for _, roomID := range rooms {
+		name := roomID.String()
+		if info, ok := m.bot.RoomInfo(roomID); ok && info.Name != "" {
+			name = info.Name
+		}
+		text.WriteString(name + "\n")
+		body.WriteString("<h3>" + html.EscapeString(name) + "</h3><ul>")
+		for _, item := range byRoom[roomID] {
+			when := item.Time.In(m.config.Location).Format("Jan 2 15:04")
+			text.WriteString(fmt.Sprintf("  [%s] %s (%s): %s\n", when, item.Sender, item.Reason, item.Body))
+			body.WriteString(fmt.Sprintf("<li><small>%s</small> <b>%s</b> <i>(%s)</i><br>%s</li>",
+				when, html.EscapeString(item.Sender.String()), html.EscapeString(item.Reason), html.EscapeString(item.Body)))
+		}
+		text.WriteString("\n")
+		body.WriteString("</ul>")
+	}
+
+	return &email.Message{
+		To:      []string{m.config.Recipients[userID]},
+		Subject: fmt.Sprintf("Matrix digest: %d unanswered message(s)", len(items)),
+		Text:    text.String(),
+		HTML:    body.String(),
+	}
+}
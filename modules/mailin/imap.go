@@ -0,0 +1,245 @@
+package mailin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IMAPSource polls a mailbox folder over IMAP with implicit TLS (port 993)
+// and delivers every unseen message. Delivered messages are flagged as seen,
+// so they are processed once; failed ones stay unseen and are retried up to
+// MaxAttempts times, then flagged and marked as seen for a human to handle.
+// Unroutable ones are marked as seen at once, as they would never succeed.
+type IMAPSource struct {
+	Addr         string // Host and port, e.g. "imap.example.com:993"
+	Username     string
+	Password     string
+	Folder       string        // default: INBOX
+	PollInterval time.Duration // default: 1 minute
+	MaxAttempts  int           // default: 3
+	TLSConfig    *tls.Config   // nil uses the defaults for the host
+	// OnError is called when a poll or the delivery of a message fails,
+	// e.g. because the server is unreachable; polling continues. The module
+	// reports the errors to the admin room if it is nil.
+	OnError func(err error)
+
+	failures map[string]int // Failed deliveries by message UID
+}
+
+// NewIMAPSource creates a source for the INBOX of a mailbox.
+func NewIMAPSource(addr, username, password string) *IMAPSource {
+	return &IMAPSource{Addr: addr, Username: username, Password: password, Folder: "INBOX", PollInterval: time.Minute}
+}
+
+// Receive implements Source.
+func (s *IMAPSource) Receive(ctx context.Context, deliver func(ctx context.Context, raw []byte) error) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.poll(ctx, deliver); err != nil && ctx.Err() == nil {
+			s.reportError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll delivers the unseen messages of the folder, oldest first.
+func (s *IMAPSource) poll(ctx context.Context, deliver func(ctx context.Context, raw []byte) error) error {
+	dialer := &tls.Dialer{Config: s.TLSConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("mailin: failed to connect to %s: %w", s.Addr, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	if line, _, err := c.readResponse(); err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return fmt.Errorf("mailin: unexpected IMAP greeting %q", line)
+	}
+	if _, err = c.command("LOGIN %s %s", imapQuote(s.Username), imapQuote(s.Password)); err != nil {
+		return err
+	}
+	defer func() { _, _ = c.command("LOGOUT") }()
+	folder := s.Folder
+	if folder == "" {
+		folder = "INBOX"
+	}
+	if _, err = c.command("SELECT %s", imapQuote(folder)); err != nil {
+		return err
+	}
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return err
+	}
+	var uids []string
+	for _, resp := range responses {
+		if rest, ok := strings.CutPrefix(resp.line, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+
+	for _, uid := range uids {
+		if ctx.Err() != nil {
+			return nil
+		}
+		responses, err = c.command("UID FETCH %s (BODY.PEEK[])", uid)
+		if err != nil {
+			return err
+		}
+		var raw []byte
+		for _, resp := range responses {
+			if strings.Contains(resp.line, "FETCH") && len(resp.literals) > 0 {
+				raw = resp.literals[0]
+			}
+		}
+		if raw == nil {
+			continue
+		}
+		flags := `\Seen`
+		if err = deliver(ctx, raw); err != nil && !errors.Is(err, ErrNoRoute) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !s.deliveryFailed(uid, err) {
+				continue
+			}
+			flags = `\Seen \Flagged`
+		}
+		delete(s.failures, uid)
+		if _, err = c.command("UID STORE %s +FLAGS.SILENT (%s)", uid, flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryFailed counts and reports a failed delivery of a message, and
+// returns whether the source gives up on it.
+func (s *IMAPSource) deliveryFailed(uid string, err error) bool {
+	maxAttempts := s.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	if s.failures == nil {
+		s.failures = make(map[string]int)
+	}
+	s.failures[uid]++
+	attempts := s.failures[uid]
+	if attempts < maxAttempts {
+		s.reportError(fmt.Errorf("mailin: failed to deliver message %s (attempt %d of %d): %w", uid, attempts, maxAttempts, err))
+		return false
+	}
+	s.reportError(fmt.Errorf("mailin: gave up delivering message %s after %d attempts, flagged it: %w", uid, attempts, err))
+	return true
+}
+
+// reportError passes an error to OnError, if set.
+func (s *IMAPSource) reportError(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// imapConn is a connection speaking the few IMAP commands the source needs.
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is an untagged response line with the literals it contained.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// command sends a command and returns its untagged responses, or an error if
+// it didn't complete with OK.
+func (c *imapConn) command(format string, args ...any) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	cmd := fmt.Sprintf(format, args...)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, fmt.Errorf("mailin: IMAP: %w", err)
+	}
+	verb, _, _ := strings.Cut(cmd, " ")
+	var responses []imapResponse
+	for {
+		line, literals, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("mailin: IMAP %s failed: %s", verb, status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, imapResponse{line: line, literals: literals})
+	}
+}
+
+// readResponse reads a response line, including the literals ("{size}" and
+// that many bytes) it continues after.
+func (c *imapConn) readResponse() (string, [][]byte, error) {
+	var sb strings.Builder
+	var literals [][]byte
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", nil, fmt.Errorf("mailin: IMAP: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		sb.WriteString(line)
+		size, ok := literalSize(line)
+		if !ok {
+			return sb.String(), literals, nil
+		}
+		if size > maxMessageSize {
+			return "", nil, fmt.Errorf("mailin: message exceeds %d bytes", maxMessageSize)
+		}
+		literal := make([]byte, size)
+		if _, err = io.ReadFull(c.r, literal); err != nil {
+			return "", nil, fmt.Errorf("mailin: IMAP: %w", err)
+		}
+		literals = append(literals, literal)
+	}
+}
+
+// literalSize returns the size of the literal announced at the end of a line, e.g. "{1234}".
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	start := strings.LastIndexByte(line, '{')
+	if start < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(line[start+1 : len(line)-1])
+	return size, err == nil && size >= 0
+}
+
+// imapQuote quotes a string argument.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
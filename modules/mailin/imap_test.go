@@ -0,0 +1,136 @@
+package mailin
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIMAP is an IMAP server holding one unseen message with UID 7.
+type fakeIMAP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	stores   []string // Flags of the UID STORE commands
+}
+
+func newFakeIMAP(t *testing.T) *fakeIMAP {
+	t.Helper()
+	// Borrow the self-signed certificate of httptest.
+	srv := httptest.NewTLSServer(nil)
+	cert := srv.TLS.Certificates[0]
+	srv.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeIMAP{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	const raw = "Subject: hi\r\n\r\nhello"
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch {
+		case strings.HasPrefix(cmd, "UID SEARCH"):
+			f.mu.Lock()
+			if len(f.stores) == 0 {
+				fmt.Fprint(conn, "* SEARCH 7\r\n")
+			}
+			f.mu.Unlock()
+		case strings.HasPrefix(cmd, "UID FETCH"):
+			fmt.Fprintf(conn, "* 1 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\n", len(raw), raw)
+		case strings.HasPrefix(cmd, "UID STORE"):
+			f.mu.Lock()
+			f.stores = append(f.stores, cmd[strings.IndexByte(cmd, '(')+1:len(cmd)-1])
+			f.mu.Unlock()
+		case cmd == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK\r\n", tag)
+			return
+		}
+		fmt.Fprintf(conn, "%s OK\r\n", tag)
+	}
+}
+
+func TestIMAPSourceGivesUpOnFailingMessage(t *testing.T) {
+	f := newFakeIMAP(t)
+	var reported []error
+	s := NewIMAPSource(f.listener.Addr().String(), "support@example.com", "secret")
+	s.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	s.OnError = func(err error) { reported = append(reported, err) }
+	deliverErr := errors.New("room unavailable")
+	deliveries := 0
+	deliver := func(ctx context.Context, raw []byte) error {
+		deliveries++
+		return deliverErr
+	}
+
+	for i := 1; i <= 4; i++ {
+		if err := s.poll(context.Background(), deliver); err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+	}
+	if deliveries != 3 {
+		t.Errorf("deliveries = %d, want 3", deliveries)
+	}
+	if len(reported) != 3 {
+		t.Fatalf("reported %d errors, want 3: %v", len(reported), reported)
+	}
+	for _, err := range reported {
+		if !errors.Is(err, deliverErr) {
+			t.Errorf("reported %v, want it to wrap the delivery error", err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.stores) != 1 || f.stores[0] != `\Seen \Flagged` {
+		t.Errorf("stored flags %q, want [\\Seen \\Flagged]", f.stores)
+	}
+}
+
+func TestIMAPSourceMarksDeliveredSeen(t *testing.T) {
+	f := newFakeIMAP(t)
+	s := NewIMAPSource(f.listener.Addr().String(), "support@example.com", "secret")
+	s.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	var body string
+	deliver := func(ctx context.Context, raw []byte) error {
+		body = string(raw)
+		return nil
+	}
+
+	if err := s.poll(context.Background(), deliver); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(body, "hello") {
+		t.Errorf("delivered %q, want the fetched message", body)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.stores) != 1 || f.stores[0] != `\Seen` {
+		t.Errorf("stored flags %q, want [\\Seen]", f.stores)
+	}
+}
@@ -0,0 +1,77 @@
+package mailin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaildirSource watches the new/ directory of a maildir and delivers every
+// message that appears there. Delivered messages are moved to cur/ so they
+// are processed exactly once; failed ones stay in new/ and are retried,
+// except unroutable ones, which would never succeed.
+type MaildirSource struct {
+	Dir          string
+	PollInterval time.Duration // default: 10 seconds
+}
+
+// NewMaildirSource creates a source for the maildir at dir.
+func NewMaildirSource(dir string) *MaildirSource {
+	return &MaildirSource{Dir: dir, PollInterval: 10 * time.Second}
+}
+
+// Receive implements Source.
+func (s *MaildirSource) Receive(ctx context.Context, deliver func(ctx context.Context, raw []byte) error) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if err := os.MkdirAll(filepath.Join(s.Dir, "cur"), 0o700); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.poll(ctx, deliver); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll delivers all messages currently in new/, oldest first.
+func (s *MaildirSource) poll(ctx context.Context, deliver func(ctx context.Context, raw []byte) error) error {
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "new"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if entry.IsDir() || ctx.Err() != nil {
+			continue
+		}
+		path := filepath.Join(s.Dir, "new", entry.Name())
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err = deliver(ctx, raw); err != nil && !errors.Is(err, ErrNoRoute) {
+			continue
+		}
+		// Maildir convention: seen messages move to cur/ with an info suffix.
+		_ = os.Rename(path, filepath.Join(s.Dir, "cur", entry.Name()+":2,S"))
+	}
+	return nil
+}
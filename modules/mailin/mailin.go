@@ -0,0 +1,298 @@
+// Package mailin posts inbound email into Matrix rooms, so shared mailboxes
+// like support@ can flow into a triage room.
+//
+// Emails arrive either through the HTTP gateway (Handler, for mail services that
+// POST raw MIME) or through a Source such as a maildir watcher or an IMAP
+// mailbox. Each email is routed by sender and subject filters or by recipient
+// address, posted as a formatted message, and its attachments are uploaded
+// through the bot's media API.
+//
+// Usage:
+//
+//	in := mailin.New(mailin.Config{
+//		Rooms: map[string]id.RoomID{"support@example.com": "!triage:example.com"},
+//		Token: os.Getenv("MAILIN_TOKEN"),
+//	}, mailin.NewMaildirSource("/var/mail/support"))
+//	if err := bot.Use(in); err != nil { ... }
+//	http.Handle("/mail", in.Handler())
+//
+// Polling the support@ mailbox instead, and keeping newsletters out, without
+// the HTTP gateway and so without a token:
+//
+//	in := mailin.New(mailin.Config{
+//		DefaultRoom: "!triage:example.com",
+//		Filters:     []mailin.Filter{{From: "*@newsletter.example.com"}},
+//	}, mailin.NewIMAPSource("imap.example.com:993", "support@example.com", os.Getenv("IMAP_PASSWORD")))
+package mailin
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/mail"
+	"path"
+	"strings"
+	"sync"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxMessageSize limits raw emails accepted by the HTTP gateway.
+const maxMessageSize = 25 << 20
+
+// minTokenLength is the shortest Config.Token accepted.
+const minTokenLength = 16
+
+// ErrNoRoute is returned when an email matches no mapped room and there is no default room.
+var ErrNoRoute = errors.New("mailin: no room mapped for recipients")
+
+// Config controls routing of inbound email. It can also be set in the
+// "modules.mailin" section of the config file.
+type Config struct {
+	// Rooms maps recipient addresses (case-insensitive) to rooms.
+	Rooms map[string]id.RoomID `yaml:"rooms" doc:"Recipient address to room"`
+	// DefaultRoom receives emails that match no mapping. Empty drops them.
+	DefaultRoom id.RoomID `yaml:"default_room" doc:"Room of emails matching no mapping (default: dropped)"`
+	// Token protects the HTTP gateway. Requests must send "Authorization:
+	// Bearer <token>", of at least 16 characters. Without a token, the
+	// gateway rejects all requests, e.g. when emails only come from sources.
+	Token string `yaml:"token" doc:"Bearer token of the HTTP gateway, at least 16 characters (default: gateway disabled)"`
+	// MaxBodyLength truncates long email bodies (default: 4000 characters).
+	MaxBodyLength int `yaml:"max_body_length" doc:"Longer email bodies are truncated"`
+	// Filters route emails by sender and subject before the recipient
+	// mapping; the first matching filter decides.
+	Filters []Filter `yaml:"filters" doc:"Routing by sender (from) and subject to a room; without room, emails are dropped"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	if c.Token != "" && len(c.Token) < minTokenLength {
+		return matrix.InvalidConfig("token", "must be at least %d characters", minTokenLength)
+	}
+	return nil
+}
+
+// Filter routes the emails matching a sender and subject to a room, or drops them.
+type Filter struct {
+	From    string    `yaml:"from"`    // Glob pattern of the sender address, e.g. "*@customer.example"; empty matches all
+	Subject string    `yaml:"subject"` // Text the subject contains, case-insensitively; empty matches all
+	Room    id.RoomID `yaml:"room"`    // Room the emails are posted to; empty drops them
+}
+
+// Matches reports whether an email matches the filter.
+func (f Filter) Matches(e *Email) bool {
+	if f.From != "" {
+		from := strings.ToLower(e.From)
+		if addr, err := mail.ParseAddress(e.From); err == nil {
+			from = strings.ToLower(addr.Address)
+		}
+		if ok, _ := path.Match(strings.ToLower(f.From), from); !ok {
+			return false
+		}
+	}
+	return f.Subject == "" || strings.Contains(strings.ToLower(e.Subject), strings.ToLower(f.Subject))
+}
+
+// Source delivers raw emails to the module, e.g. by watching a maildir or polling a mailbox.
+type Source interface {
+	// Receive calls deliver for every new email until ctx is cancelled.
+	Receive(ctx context.Context, deliver func(ctx context.Context, raw []byte) error) error
+}
+
+// Module posts inbound email into rooms.
+type Module struct {
+	config  Config
+	sources []Source
+	bot     *matrix.Bot
+	rooms   map[string]id.RoomID
+}
+
+// New creates an email ingestion module reading from the given sources.
+// Sources are optional when only the HTTP gateway is used.
+func New(config Config, sources ...Source) *Module {
+	if config.MaxBodyLength <= 0 {
+		config.MaxBodyLength = 4000
+	}
+	return &Module{config: config, sources: sources}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "mailin"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	m.rooms = make(map[string]id.RoomID, len(m.config.Rooms))
+	for addr, roomID := range m.config.Rooms {
+		m.rooms[strings.ToLower(addr)] = roomID
+	}
+	for _, src := range m.sources {
+		if s, ok := src.(*IMAPSource); ok && s.OnError == nil {
+			s.OnError = func(err error) {
+				b.ReportError(context.Background(), "Polling the mailbox "+s.Username+" failed", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run implements matrix.Runner and drives all configured sources.
+func (m *Module) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, src := range m.sources {
+		wg.Add(1)
+		go func(src Source) {
+			defer wg.Done()
+			if err := src.Receive(ctx, m.Deliver); err != nil && !errors.Is(err, context.Canceled) {
+				m.bot.ReportError(ctx, "Email source stopped", err)
+			}
+		}(src)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Handler returns the HTTP gateway accepting raw MIME messages via POST. It
+// rejects all requests without Config.Token.
+func (m *Module) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !m.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		raw, err := readAllLimited(r.Body, maxMessageSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err = m.Deliver(r.Context(), raw); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNoRoute) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// authorized checks the bearer token of a request; without Config.Token, all
+// requests are rejected.
+func (m *Module) authorized(r *http.Request) bool {
+	if m.config.Token == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(m.config.Token)) == 1
+}
+
+// Deliver parses a raw email and posts it into every room its recipients map to.
+func (m *Module) Deliver(ctx context.Context, raw []byte) error {
+	e, err := Parse(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	rooms, dropped := m.route(e)
+	if dropped {
+		m.bot.Log().Debug().Str("from", e.From).Msg("Dropped inbound email by filter")
+		return nil
+	}
+	if len(rooms) == 0 {
+		return ErrNoRoute
+	}
+	for _, roomID := range rooms {
+		if err = m.post(ctx, roomID, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route returns the distinct rooms an email should be posted to, or whether
+// a filter drops it.
+func (m *Module) route(e *Email) ([]id.RoomID, bool) {
+	for _, f := range m.config.Filters {
+		if f.Matches(e) {
+			return []id.RoomID{f.Room}, f.Room == ""
+		}
+	}
+	var rooms []id.RoomID
+	seen := make(map[id.RoomID]bool)
+	for _, addr := range e.To {
+		if roomID, ok := m.rooms[addr]; ok && !seen[roomID] {
+			seen[roomID] = true
+			rooms = append(rooms, roomID)
+		}
+	}
+	if len(rooms) == 0 && m.config.DefaultRoom != "" {
+		rooms = append(rooms, m.config.DefaultRoom)
+	}
+	return rooms, false
+}
+
+// post sends the email text and then each attachment.
+func (m *Module) post(ctx context.Context, roomID id.RoomID, e *Email) error {
+	text, formatted := m.render(e)
+	if err := m.bot.SendHTML(ctx, roomID, text, formatted); err != nil {
+		return fmt.Errorf("mailin: failed to post email: %w", err)
+	}
+
+	for _, att := range e.Attachments {
+		if err := m.bot.SendFile(ctx, roomID, att.FileName, att.ContentType, att.Data); err != nil {
+			return fmt.Errorf("mailin: failed to post attachment %s: %w", att.FileName, err)
+		}
+	}
+	m.bot.Log().Info().
+		Str("room_id", roomID.String()).
+		Str("from", e.From).
+		Int("attachments", len(e.Attachments)).
+		Msg("Posted inbound email")
+	return nil
+}
+
+// render returns the text and HTML of the message posting an email. Emails are
+// untrusted, so the HTML is built with all their text escaped instead of
+// rendering Markdown, which would pass HTML in the email through.
+func (m *Module) render(e *Email) (string, string) {
+	body := e.Text
+	if runes := []rune(body); len(runes) > m.config.MaxBodyLength {
+		body = string(runes[:m.config.MaxBodyLength]) + "\n\n…"
+	}
+	subject := e.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+
+	text := fmt.Sprintf("📧 %s\n\nFrom: %s\n\n%s", subject, e.From, quote(body))
+	formatted := fmt.Sprintf("📧 <strong>%s</strong><br><em>From: %s</em>", html.EscapeString(subject), html.EscapeString(e.From))
+	if body != "" {
+		formatted += "<blockquote>" + strings.ReplaceAll(html.EscapeString(body), "\n", "<br>") + "</blockquote>"
+	}
+	return strings.TrimSpace(text), formatted
+}
+
+// quote renders text as a block quote.
+func quote(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
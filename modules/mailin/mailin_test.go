@@ -0,0 +1,162 @@
+package mailin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateToken(t *testing.T) {
+	for _, tt := range []struct {
+		token string
+		valid bool
+	}{
+		{"", true}, // Gateway disabled
+		{"short", false},
+		{strings.Repeat("x", minTokenLength), true},
+	} {
+		config := Config{Token: tt.token}
+		if err := config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate() with token %q = %v, want valid %v", tt.token, err, tt.valid)
+		}
+	}
+}
+
+func TestHandlerAuthorization(t *testing.T) {
+	const token = "0123456789abcdef"
+	for _, tt := range []struct {
+		name   string
+		config string
+		header string
+	}{
+		{"no configured token", "", ""},
+		{"no configured token, empty bearer", "", "Bearer "},
+		{"missing token", token, ""},
+		{"wrong token", token, "Bearer fedcba9876543210"},
+	} {
+		m := New(Config{Token: tt.config})
+		req := httptest.NewRequest(http.MethodPost, "/mail", strings.NewReader("Subject: hi\r\n\r\nhello"))
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", tt.name, rec.Code)
+		}
+	}
+}
+
+func TestParseMultipart(t *testing.T) {
+	raw := strings.ReplaceAll(`From: =?UTF-8?Q?J=C3=BCrgen?= <juergen@customer.example>
+To: Support <SUPPORT@example.com>
+Cc: sales@example.com
+Subject: =?UTF-8?B?UHLDvGZ1bmc=?=
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Gr=C3=BC=C3=9Fe
+--inner
+Content-Type: text/html; charset=utf-8
+
+<p>Gr&uuml;&szlig;e</p>
+--inner--
+--outer
+Content-Type: text/plain; name="log.txt"
+Content-Disposition: attachment; filename="log.txt"
+Content-Transfer-Encoding: base64
+
+aGVsbG8gd29ybGQ=
+--outer--
+`, "\n", "\r\n")
+	e, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "Prüfung" || e.Text != "Grüße" {
+		t.Errorf("subject %q, text %q", e.Subject, e.Text)
+	}
+	if !strings.Contains(e.From, "juergen@customer.example") {
+		t.Errorf("from %q", e.From)
+	}
+	if len(e.To) != 2 || e.To[0] != "support@example.com" || e.To[1] != "sales@example.com" {
+		t.Errorf("to %q", e.To)
+	}
+	if len(e.Attachments) != 1 || e.Attachments[0].FileName != "log.txt" || string(e.Attachments[0].Data) != "hello world" {
+		t.Errorf("attachments %+v", e.Attachments)
+	}
+	if !(Filter{From: "*@customer.example", Subject: "prüf"}).Matches(e) {
+		t.Error("filter doesn't match the email")
+	}
+	if (Filter{From: "*@other.example"}).Matches(e) {
+		t.Error("filter of another sender matches the email")
+	}
+}
+
+func TestParseHTMLOnly(t *testing.T) {
+	raw := "From: a@example.com\r\nContent-Type: text/html\r\n\r\n<p>Hello <b>world</b></p>"
+	e, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Text != "Hello world" {
+		t.Errorf("text %q, want Hello world", e.Text)
+	}
+}
+
+func TestParseCharsets(t *testing.T) {
+	for _, tt := range []struct {
+		name, contentType, body, subject, wantText string
+	}{
+		{"ISO-8859-1", "text/plain; charset=iso-8859-1", "caf\xe9 \xe0 la cr\xe8me", "=?windows-1252?Q?caf=E9?=", "café à la crème"},
+		{"windows-1252", "text/plain; charset=windows-1252", "\x93quoted\x94 \x80", "=?windows-1252?Q?caf=E9?=", "“quoted” €"},
+		{"invalid UTF-8", "text/plain; charset=utf-8", "bad \xff byte", "=?utf-8?Q?caf=C3=A9?=", "bad \uFFFD byte"},
+		{"unknown charset", "text/plain; charset=x-unknown", "bad \xff byte", "=?windows-1252?Q?caf=E9?=", "bad \uFFFD byte"},
+	} {
+		raw := "From: a@example.com\r\nSubject: " + tt.subject + "\r\nContent-Type: " + tt.contentType + "\r\n\r\n" + tt.body
+		e, err := Parse(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if e.Text != tt.wantText {
+			t.Errorf("%s: text %q, want %q", tt.name, e.Text, tt.wantText)
+		}
+		if e.Subject != "café" {
+			t.Errorf("%s: subject %q, want café", tt.name, e.Subject)
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	raw := "From: \"<b>Eve</b>\" <eve@example.com>\r\n" +
+		"Subject: <a href=\"https://evil.example\">Urgent</a>\r\n" +
+		"Content-Type: text/html\r\n\r\n" +
+		"<p>Click <a href=\"https://evil.example\">here</a> &lt;b&gt;now&lt;/b&gt; **bold**</p>"
+	e, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(Config{})
+	text, formatted := m.render(e)
+	for _, injected := range []string{"<a ", "<b>", "</b>"} {
+		if strings.Contains(formatted, injected) {
+			t.Errorf("HTML contains %q from the email: %s", injected, formatted)
+		}
+	}
+	for _, escaped := range []string{"&lt;a href=&#34;https://evil.example&#34;&gt;Urgent&lt;/a&gt;", "&lt;b&gt;now&lt;/b&gt;", "**bold**"} {
+		if !strings.Contains(formatted, escaped) {
+			t.Errorf("HTML lacks %q: %s", escaped, formatted)
+		}
+	}
+	if !strings.Contains(text, "> Click here <b>now</b> **bold**") {
+		t.Errorf("text = %q", text)
+	}
+}
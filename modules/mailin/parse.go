@@ -0,0 +1,220 @@
+package mailin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Email is a parsed inbound email.
+type Email struct {
+	From        string
+	To          []string // Lowercased recipient addresses from To, Cc and delivery headers
+	Subject     string
+	Text        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to an email.
+type Attachment struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// Parse reads a raw RFC 5322 message, walking multipart bodies to find the
+// text part and attachments. HTML-only messages are reduced to plain text.
+func Parse(r io.Reader) (*Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("mailin: invalid message: %w", err)
+	}
+
+	e := &Email{
+		From:    decodeHeader(msg.Header.Get("From")),
+		Subject: decodeHeader(msg.Header.Get("Subject")),
+	}
+	if addr, err := mail.ParseAddress(e.From); err == nil {
+		e.From = addr.String()
+	}
+	e.To = recipients(msg.Header)
+
+	var htmlBody string
+	if err = walkPart(msg.Header, msg.Body, e, &htmlBody); err != nil {
+		return nil, err
+	}
+	if e.Text == "" && htmlBody != "" {
+		e.Text = stripHTML(htmlBody)
+	}
+	e.Text = strings.TrimSpace(e.Text)
+	return e, nil
+}
+
+// header is satisfied by both mail.Header and the textproto.MIMEHeader of multipart parts.
+type header interface {
+	Get(key string) string
+}
+
+// walkPart decodes one MIME part, recursing into multiparts.
+func walkPart(h header, body io.Reader, e *Email, htmlBody *string) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("mailin: invalid multipart body: %w", err)
+			}
+			if err = walkPart(part.Header, part, e, htmlBody); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("mailin: failed to decode part: %w", err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	fileName := decodeHeader(dispParams["filename"])
+	if fileName == "" {
+		fileName = decodeHeader(params["name"])
+	}
+
+	switch {
+	case disposition == "attachment" || (fileName != "" && !strings.HasPrefix(mediaType, "text/")):
+		if fileName == "" {
+			fileName = "attachment"
+		}
+		e.Attachments = append(e.Attachments, Attachment{FileName: fileName, ContentType: mediaType, Data: data})
+	case mediaType == "text/plain" && e.Text == "":
+		e.Text = decodeCharset(params["charset"], data)
+	case mediaType == "text/html" && *htmlBody == "":
+		*htmlBody = decodeCharset(params["charset"], data)
+	}
+	return nil
+}
+
+// decodeCharset converts text in the given charset, e.g. ISO-8859-1, to
+// UTF-8. Unknown charsets and invalid bytes are replaced with U+FFFD.
+func decodeCharset(charset string, data []byte) string {
+	if enc, err := htmlindex.Get(charset); err == nil {
+		if decoded, err := enc.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+	return strings.ToValidUTF8(string(data), "\uFFFD")
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, newlineStripper{r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// newlineStripper removes line breaks, which the base64 decoder does not tolerate in all positions.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n newlineStripper) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	out := p[:0]
+	for _, c := range p[:count] {
+		if c != '\r' && c != '\n' {
+			out = append(out, c)
+		}
+	}
+	return len(out), err
+}
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// recipients collects all addresses the message was delivered to.
+func recipients(h mail.Header) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, key := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		for _, value := range h[key] {
+			addrs, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				address := strings.ToLower(addr.Address)
+				if !seen[address] {
+					seen[address] = true
+					out = append(out, address)
+				}
+			}
+		}
+	}
+	return out
+}
+
+var (
+	htmlBreaks   = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>|</tr>`)
+	htmlTags     = regexp.MustCompile(`<[^>]*>`)
+	htmlScripts  = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	excessBlanks = regexp.MustCompile(`\n{3,}`)
+)
+
+// stripHTML is a crude HTML to text conversion for HTML-only emails.
+func stripHTML(s string) string {
+	s = htmlScripts.ReplaceAllString(s, "")
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	s = strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(s)
+	return excessBlanks.ReplaceAllString(s, "\n\n")
+}
+
+// readAllLimited reads at most limit bytes and reports an error if there is more.
+func readAllLimited(r io.Reader, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, fmt.Errorf("mailin: message exceeds %d bytes", limit)
+	}
+	return buf.Bytes(), nil
+}
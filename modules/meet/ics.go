@@ -0,0 +1,103 @@
+package meet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// Meeting is a parsed !meet request.
+type Meeting struct {
+	Title     string
+	Start     time.Time
+	Duration  time.Duration
+	Organizer id.UserID
+	Attendees []id.UserID
+}
+
+// Parse reads "<when> <duration> <title>" where when is understood by matrix.ParseWhen.
+// Matrix user IDs in the title are moved to the attendee list.
+func Parse(fields []string, now time.Time) (*Meeting, error) {
+	start, n, err := matrix.ParseWhen(fields, now)
+	if err != nil {
+		return nil, err
+	}
+	fields = fields[n:]
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing duration")
+	}
+	duration, err := time.ParseDuration(fields[0])
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("invalid duration %q", fields[0])
+	}
+
+	m := &Meeting{Start: start, Duration: duration}
+	var title []string
+	for _, field := range fields[1:] {
+		if userID := id.UserID(field); strings.HasPrefix(field, "@") && strings.Contains(field, ":") {
+			m.addAttendee(userID)
+			continue
+		}
+		title = append(title, field)
+	}
+	m.Title = strings.Join(title, " ")
+	if m.Title == "" {
+		return nil, fmt.Errorf("missing title")
+	}
+	return m, nil
+}
+
+func (m *Meeting) addAttendee(userID id.UserID) {
+	for _, existing := range m.Attendees {
+		if existing == userID {
+			return
+		}
+	}
+	m.Attendees = append(m.Attendees, userID)
+}
+
+// ICS renders the meeting as an iCalendar (RFC 5545) document.
+// Attendees are identified by matrix: URIs.
+func (m *Meeting) ICS(now time.Time) string {
+	const stamp = "20060102T150405Z"
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//go-matrix-bot//meet//EN",
+		"METHOD:REQUEST",
+		"BEGIN:VEVENT",
+		"UID:" + randomUID() + "@go-matrix-bot",
+		"DTSTAMP:" + now.UTC().Format(stamp),
+		"DTSTART:" + m.Start.UTC().Format(stamp),
+		"DTEND:" + m.Start.Add(m.Duration).UTC().Format(stamp),
+		"SUMMARY:" + escapeText(m.Title),
+	}
+	if m.Organizer != "" {
+		lines = append(lines, "ORGANIZER:"+matrixURI(m.Organizer))
+	}
+	for _, userID := range m.Attendees {
+		lines = append(lines, "ATTENDEE;ROLE=REQ-PARTICIPANT;RSVP=TRUE:"+matrixURI(userID))
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// matrixURI converts @alice:example.com to matrix:u/alice:example.com.
+func matrixURI(userID id.UserID) string {
+	return "matrix:u/" + strings.TrimPrefix(userID.String(), "@")
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func randomUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
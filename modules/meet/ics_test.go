@@ -0,0 +1,64 @@
+package meet
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	m, err := Parse(strings.Fields("tomorrow 14:00 45m Planning @bob:example.com with @carol:example.com @bob:example.com"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 15, 14, 0, 0, 0, time.UTC); !m.Start.Equal(want) {
+		t.Errorf("Start = %s, want %s", m.Start, want)
+	}
+	if m.Duration != 45*time.Minute || m.Title != "Planning with" {
+		t.Errorf("Duration, Title = %s, %q", m.Duration, m.Title)
+	}
+	if want := []id.UserID{"@bob:example.com", "@carol:example.com"}; !slices.Equal(m.Attendees, want) {
+		t.Errorf("Attendees = %v, want %v", m.Attendees, want)
+	}
+
+	for _, input := range []string{"", "someday 1h Sync", "15:00", "15:00 soon Sync", "15:00 -1h Sync", "15:00 1h", "15:00 1h @bob:example.com"} {
+		if _, err = Parse(strings.Fields(input), now); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestICS(t *testing.T) {
+	m := &Meeting{
+		Title:     "Review; budget, Q4\\next",
+		Start:     time.Date(2026, 10, 15, 16, 0, 0, 0, time.FixedZone("CEST", 2*3600)),
+		Duration:  90 * time.Minute,
+		Organizer: "@alice:example.com",
+		Attendees: []id.UserID{"@bob:example.com"},
+	}
+	ics := m.ICS(time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC))
+	if !strings.HasSuffix(ics, "\r\n") || strings.Contains(strings.ReplaceAll(ics, "\r\n", ""), "\n") {
+		t.Errorf("lines aren't CRLF-terminated:\n%q", ics)
+	}
+	for _, line := range []string{
+		"BEGIN:VCALENDAR", "METHOD:REQUEST", "BEGIN:VEVENT",
+		"DTSTAMP:20261014T080000Z",
+		"DTSTART:20261015T140000Z", // In UTC
+		"DTEND:20261015T153000Z",
+		`SUMMARY:Review\; budget\, Q4\\next`,
+		"ORGANIZER:matrix:u/alice:example.com",
+		"ATTENDEE;ROLE=REQ-PARTICIPANT;RSVP=TRUE:matrix:u/bob:example.com",
+		"END:VEVENT", "END:VCALENDAR",
+	} {
+		if !strings.Contains(ics, "\r\n"+line+"\r\n") && !strings.HasPrefix(ics, line+"\r\n") {
+			t.Errorf("ICS lacks line %q:\n%s", line, ics)
+		}
+	}
+	if strings.Count(ics, "UID:") != 1 || m.ICS(time.Now()) == m.ICS(time.Now()) {
+		t.Error("ICS should have one random UID per invite")
+	}
+}
@@ -0,0 +1,108 @@
+// Package meet adds a !meet command that turns a chat message into a calendar
+// invite: it builds an ICS file, uploads it to the room, and pings the
+// mentioned attendees.
+//
+//	!meet tomorrow 15:00 30m Sprint planning @alice:example.com
+//	!timezone Europe/Berlin
+//
+// Times are interpreted in the sender's time zone preference (see !timezone).
+package meet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// Module provides the !meet and !timezone commands.
+type Module struct {
+	bot *matrix.Bot
+}
+
+// New creates the meeting module.
+func New() *Module {
+	return &Module{}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "meet"
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	b.Command("meet", m.cmdMeet).
+		Describe("Create a calendar invite and ping attendees", "!meet <when> <duration> <title> [@attendees]")
+	b.Command("timezone", m.cmdTimezone).
+		Describe("Show or set your time zone", "!timezone [Europe/Berlin]")
+	return nil
+}
+
+func (m *Module) cmdMeet(ctx context.Context, cmd *matrix.CommandContext) {
+	loc := m.bot.UserTimezone(ctx, cmd.Sender)
+	meeting, err := Parse(cmd.Fields(), cmd.Time().In(loc))
+	if err != nil {
+		_ = cmd.Reply(ctx, fmt.Sprintf("%v. Usage: `%s`", err, cmd.Command.Usage))
+		return
+	}
+	meeting.Organizer = cmd.Sender
+	if cmd.Message.Mentions != nil {
+		for _, userID := range cmd.Message.Mentions.UserIDs {
+			meeting.addAttendee(userID)
+		}
+	}
+
+	ics := meeting.ICS(time.Now())
+	fileName := fileNameFor(meeting.Title)
+	if err = m.bot.SendFile(ctx, cmd.RoomID, fileName, "text/calendar", []byte(ics)); err != nil {
+		_ = cmd.Reply(ctx, "Failed to upload the invite: "+err.Error())
+		return
+	}
+
+	md := fmt.Sprintf("📅 **%s** — %s (%s)", meeting.Title,
+		meeting.Start.Format("Mon Jan 2 15:04 MST"), meeting.Duration)
+	mentions := append([]id.UserID{cmd.Sender}, meeting.Attendees...)
+	if len(meeting.Attendees) > 0 {
+		names := make([]string, len(meeting.Attendees))
+		for i, userID := range meeting.Attendees {
+			names[i] = userID.String()
+		}
+		md += "\n\nAttendees: " + strings.Join(names, ", ")
+	}
+	_ = m.bot.SendReply(ctx, cmd.RoomID, md, matrix.MarkdownToHTML(md), mentions...)
+}
+
+func (m *Module) cmdTimezone(ctx context.Context, cmd *matrix.CommandContext) {
+	if cmd.Args == "" {
+		_ = cmd.Reply(ctx, fmt.Sprintf("Your time zone is **%s**.", m.bot.UserTimezone(ctx, cmd.Sender)))
+		return
+	}
+	if err := m.bot.SetUserTimezone(ctx, cmd.Sender, cmd.Args); err != nil {
+		_ = cmd.Reply(ctx, err.Error())
+		return
+	}
+	_ = cmd.Reply(ctx, fmt.Sprintf("Time zone set to **%s**.", cmd.Args))
+}
+
+// fileNameFor builds a safe .ics file name from a meeting title.
+func fileNameFor(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '-'
+		default:
+			return -1
+		}
+	}, title)
+	if name == "" {
+		name = "meeting"
+	}
+	return name + ".ics"
+}
@@ -0,0 +1,264 @@
+// Package membersync reconciles Matrix room membership against an external
+// directory (a static file, LDAP, SCIM, ...). On a schedule it invites members
+// that are missing from a room, optionally kicks members that were removed from
+// the directory, and reports the drift it found.
+//
+// Usage:
+//
+//	sync := membersync.New(membersync.NewFileDirectory("members.json"), membersync.Config{
+//		Interval:   time.Hour,
+//		ReportRoom: "!ops:example.com",
+//	})
+//	if err := bot.Use(sync); err != nil { ... }
+package membersync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Directory is the source of truth for room membership.
+// Implement it to plug in LDAP, SCIM, or any other user directory.
+type Directory interface {
+	// Members returns the desired members for every managed room.
+	Members(ctx context.Context) (map[id.RoomID][]id.UserID, error)
+}
+
+// DirectoryFunc adapts a plain function to the Directory interface,
+// e.g. a closure running an LDAP group query.
+type DirectoryFunc func(ctx context.Context) (map[id.RoomID][]id.UserID, error)
+
+// Members calls f(ctx).
+func (f DirectoryFunc) Members(ctx context.Context) (map[id.RoomID][]id.UserID, error) {
+	return f(ctx)
+}
+
+// FileDirectory reads desired membership from a JSON file mapping room IDs to user IDs:
+//
+//	{"!abc:example.com": ["@alice:example.com", "@bob:example.com"]}
+//
+// The file is re-read on every reconciliation, so edits take effect without a restart.
+type FileDirectory struct {
+	Path string
+}
+
+// NewFileDirectory creates a directory backed by a JSON file.
+func NewFileDirectory(path string) *FileDirectory {
+	return &FileDirectory{Path: path}
+}
+
+// Members reads and parses the file.
+func (d *FileDirectory) Members(_ context.Context) (map[id.RoomID][]id.UserID, error) {
+	data, err := os.ReadFile(d.Path)
+	if err != nil {
+		return nil, fmt.Errorf("membersync: failed to read directory file: %w", err)
+	}
+	var members map[id.RoomID][]id.UserID
+	if err = json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("membersync: invalid directory file %s: %w", d.Path, err)
+	}
+	return members, nil
+}
+
+// Config controls how reconciliation behaves.
+type Config struct {
+	Interval   time.Duration // How often to reconcile (default: 1 hour)
+	Kick       bool          // Kick joined members that are not in the directory
+	DryRun     bool          // Only report drift, don't invite or kick anyone
+	ReportRoom id.RoomID     // Room that receives drift reports (optional)
+	Ignore     []id.UserID   // Users never kicked, e.g. other bots or admins
+}
+
+// Drift describes the difference between a room and the directory.
+type Drift struct {
+	RoomID  id.RoomID
+	Missing []id.UserID // In the directory, but not joined or invited
+	Extra   []id.UserID // Joined, but not in the directory
+	Invite  *matrix.InviteSummary
+	Kicked  []id.UserID
+	Errors  []error
+}
+
+// Empty reports whether the room matches the directory.
+func (d *Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Errors) == 0
+}
+
+// Module periodically reconciles room membership.
+type Module struct {
+	dir    Directory
+	config Config
+	bot    *matrix.Bot
+}
+
+// New creates a membership sync module.
+func New(dir Directory, config Config) *Module {
+	if config.Interval <= 0 {
+		config.Interval = time.Hour
+	}
+	return &Module{dir: dir, config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "membersync"
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	return nil
+}
+
+// Run implements matrix.Runner and reconciles on every interval.
+func (m *Module) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		drifts, err := m.Reconcile(ctx)
+		if err != nil {
+			m.bot.ReportError(ctx, "Membership sync failed", err)
+		} else if m.config.ReportRoom != "" {
+			m.report(ctx, drifts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile runs a single reconciliation pass and returns the drift of every managed room.
+func (m *Module) Reconcile(ctx context.Context) ([]*Drift, error) {
+	desired, err := m.dir.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roomIDs := make([]id.RoomID, 0, len(desired))
+	for roomID := range desired {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Slice(roomIDs, func(i, j int) bool { return roomIDs[i] < roomIDs[j] })
+
+	drifts := make([]*Drift, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		drift, err := m.reconcileRoom(ctx, roomID, desired[roomID])
+		if err != nil {
+			if ctx.Err() != nil {
+				return drifts, ctx.Err()
+			}
+			drift = &Drift{RoomID: roomID, Errors: []error{err}}
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts, nil
+}
+
+// reconcileRoom compares one room against its desired members and applies the changes.
+func (m *Module) reconcileRoom(ctx context.Context, roomID id.RoomID, want []id.UserID) (*Drift, error) {
+	resp, err := m.bot.Client().Members(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members of %s: %w", roomID, err)
+	}
+
+	present := make(map[id.UserID]event.Membership)
+	for _, evt := range resp.Chunk {
+		present[id.UserID(evt.GetStateKey())] = evt.Content.AsMember().Membership
+	}
+	wanted := make(map[id.UserID]bool, len(want))
+	for _, userID := range want {
+		wanted[userID] = true
+	}
+	ignored := map[id.UserID]bool{m.bot.Client().UserID: true}
+	for _, userID := range m.config.Ignore {
+		ignored[userID] = true
+	}
+
+	drift := &Drift{RoomID: roomID}
+	for _, userID := range want {
+		if ms := present[userID]; ms != event.MembershipJoin && ms != event.MembershipInvite {
+			drift.Missing = append(drift.Missing, userID)
+		}
+	}
+	for userID, ms := range present {
+		if ms == event.MembershipJoin && !wanted[userID] && !ignored[userID] {
+			drift.Extra = append(drift.Extra, userID)
+		}
+	}
+	sort.Slice(drift.Extra, func(i, j int) bool { return drift.Extra[i] < drift.Extra[j] })
+
+	if m.config.DryRun {
+		return drift, nil
+	}
+
+	if len(drift.Missing) > 0 {
+		drift.Invite, err = m.bot.InviteMany(ctx, roomID, drift.Missing)
+		if err != nil {
+			drift.Errors = append(drift.Errors, err)
+		}
+	}
+	if m.config.Kick {
+		for _, userID := range drift.Extra {
+			if err = m.bot.Kick(ctx, roomID, userID, "Removed from directory"); err != nil {
+				drift.Errors = append(drift.Errors, fmt.Errorf("kick %s: %w", userID, err))
+				continue
+			}
+			drift.Kicked = append(drift.Kicked, userID)
+		}
+	}
+	return drift, nil
+}
+
+// report posts a markdown drift report if any room was out of sync.
+func (m *Module) report(ctx context.Context, drifts []*Drift) {
+	var sb strings.Builder
+	for _, d := range drifts {
+		if d.Empty() {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("**%s**\n", d.RoomID))
+		if len(d.Missing) > 0 {
+			sb.WriteString(fmt.Sprintf("- missing: %s\n", joinUsers(d.Missing)))
+		}
+		if d.Invite != nil {
+			sb.WriteString(fmt.Sprintf("- %s\n", d.Invite))
+		}
+		if len(d.Extra) > 0 {
+			sb.WriteString(fmt.Sprintf("- not in directory: %s\n", joinUsers(d.Extra)))
+		}
+		if len(d.Kicked) > 0 {
+			sb.WriteString(fmt.Sprintf("- kicked: %s\n", joinUsers(d.Kicked)))
+		}
+		for _, err := range d.Errors {
+			sb.WriteString(fmt.Sprintf("- error: %v\n", err))
+		}
+	}
+	if sb.Len() == 0 {
+		return
+	}
+
+	md := "**Membership drift**\n\n" + sb.String()
+	if err := m.bot.SendHTML(ctx, m.config.ReportRoom, md, matrix.MarkdownToHTML(md)); err != nil {
+		m.bot.Log().Warn().Err(err).Msg("Failed to post membership drift report")
+	}
+}
+
+func joinUsers(userIDs []id.UserID) string {
+	parts := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		parts[i] = userID.String()
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,301 @@
+// Package mentionmail emails users who are mentioned by the bot and haven't
+// read the room a while later, e.g. an on-call engineer paged by an alert who
+// doesn't live in Matrix. A mention counts as read when the user sends a read
+// receipt for it or a later message, or writes in the room.
+//
+// Usage:
+//
+//	mail := mentionmail.New(email.NewSMTPSender(email.GetEnvironmentConfig()), mentionmail.Config{
+//		Recipients: map[id.UserID]string{"@alice:example.com": "alice@example.com"},
+//		After:      2 * time.Hour,
+//	})
+//	if err := bot.Use(mail); err != nil { ... }
+//
+// Unread mentions are kept in the bot's store, so a restart doesn't lose them.
+package mentionmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"github.com/eslider/go-matrix-bot/email"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// storeKey is the key of the unread mentions in the bot's store.
+const storeKey = "mentionmail.pending"
+
+// maxLaterEvents caps the events remembered after a mention; a receipt for
+// any of them means the mention was read.
+const maxLaterEvents = 50
+
+// checkInterval is how often unread mentions are checked.
+const checkInterval = time.Minute
+
+// Config controls who is emailed and when. It can also be set in the
+// "modules.mentionmail" section of the config file.
+type Config struct {
+	// Recipients maps Matrix users to the email address notified of their
+	// unread mentions. Mentions of other users are ignored.
+	Recipients map[id.UserID]string `yaml:"recipients" doc:"Matrix user to email address notified of unread mentions"`
+	// After is how long a mention may stay unread before it is emailed (default: 4h).
+	After time.Duration `yaml:"after" doc:"How long a mention may stay unread before it is emailed, e.g. 2h"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	for userID, address := range c.Recipients {
+		if !strings.Contains(address, "@") {
+			errs = append(errs, matrix.InvalidConfig("recipients", "has an invalid email address %q for %s", address, userID))
+		}
+	}
+	if c.After <= 0 {
+		errs = append(errs, matrix.InvalidConfig("after", "must be > 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// Mention is a message of the bot mentioning a user who hasn't read it yet.
+type Mention struct {
+	RoomID  id.RoomID    `json:"room_id"`
+	UserID  id.UserID    `json:"user_id"`
+	EventID id.EventID   `json:"event_id"`
+	Body    string       `json:"body"`
+	Time    time.Time    `json:"time"`
+	Later   []id.EventID `json:"later,omitempty"` // Events in the room after the mention
+}
+
+// Module tracks the bot's mentions and emails those left unread.
+type Module struct {
+	config Config
+	sender email.Sender
+	bot    *matrix.Bot
+
+	mu      sync.Mutex
+	pending []Mention // Loaded from the store on first use
+	loaded  bool
+}
+
+// New creates the module delivering through the given email sender.
+func New(sender email.Sender, config Config) *Module {
+	if config.After <= 0 {
+		config.After = 4 * time.Hour
+	}
+	return &Module{config: config, sender: sender}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "mentionmail"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	b.OnMessageSent(m.handleSent)
+	b.OnReceipt(m.handleReceipt)
+	b.OnMessageContext(m.handleMessage)
+	return nil
+}
+
+// Run implements matrix.Runner and emails the mentions left unread.
+func (m *Module) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		m.SendDue(ctx)
+	}
+}
+
+// Pending returns the unread mentions of a user.
+func (m *Module) Pending(ctx context.Context, userID id.UserID) []Mention {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(ctx)
+	var mentions []Mention
+	for _, mention := range m.pending {
+		if mention.UserID == userID {
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions
+}
+
+// handleSent tracks the recipients mentioned in a message of the bot, and
+// remembers it as a later event for earlier mentions in the room.
+func (m *Module) handleSent(ctx context.Context, roomID id.RoomID, eventID id.EventID, content *event.MessageEventContent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(ctx)
+	changed := m.later(roomID, eventID)
+	if content.Mentions != nil && content.NewContent == nil {
+		for _, userID := range content.Mentions.UserIDs {
+			if _, ok := m.config.Recipients[userID]; !ok || !m.bot.ModuleEnabled(ctx, roomID, m.Name()) {
+				continue
+			}
+			m.pending = append(m.pending, Mention{RoomID: roomID, UserID: userID, EventID: eventID, Body: content.Body, Time: time.Now()})
+			changed = true
+		}
+	}
+	if changed {
+		m.save(ctx)
+	}
+}
+
+// handleMessage marks the mentions of the sender in the room as read, and
+// remembers the message as a later event for the other mentions.
+func (m *Module) handleMessage(ctx context.Context, msg *matrix.MessageContext) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(ctx)
+	changed := m.later(msg.RoomID, msg.EventID())
+	if m.read(msg.RoomID, msg.Sender, func(Mention) bool { return true }) || changed {
+		m.save(ctx)
+	}
+}
+
+// handleReceipt marks the mentions a receipt covers as read.
+func (m *Module) handleReceipt(ctx context.Context, roomID id.RoomID, eventID id.EventID, userID id.UserID) {
+	if _, ok := m.config.Recipients[userID]; !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load(ctx)
+	if m.read(roomID, userID, func(mention Mention) bool {
+		return mention.EventID == eventID || slices.Contains(mention.Later, eventID)
+	}) {
+		m.save(ctx)
+	}
+}
+
+// later records an event after the pending mentions of a room and reports
+// whether there were any. Callers hold m.mu.
+func (m *Module) later(roomID id.RoomID, eventID id.EventID) bool {
+	changed := false
+	for i := range m.pending {
+		if mention := &m.pending[i]; mention.RoomID == roomID && len(mention.Later) < maxLaterEvents {
+			mention.Later = append(mention.Later, eventID)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// read drops the pending mentions of a user in a room that match and reports
+// whether there were any. Callers hold m.mu.
+func (m *Module) read(roomID id.RoomID, userID id.UserID, match func(Mention) bool) bool {
+	before := len(m.pending)
+	m.pending = slices.DeleteFunc(m.pending, func(mention Mention) bool {
+		return mention.RoomID == roomID && mention.UserID == userID && match(mention)
+	})
+	return len(m.pending) != before
+}
+
+// SendDue emails every recipient the mentions unread for longer than
+// Config.After, one email per recipient, and forgets them once sent.
+func (m *Module) SendDue(ctx context.Context) {
+	m.mu.Lock()
+	m.load(ctx)
+	due := make(map[id.UserID][]Mention)
+	var users []id.UserID
+	for _, mention := range m.pending {
+		if time.Since(mention.Time) < m.config.After {
+			continue
+		}
+		if _, ok := due[mention.UserID]; !ok {
+			users = append(users, mention.UserID)
+		}
+		due[mention.UserID] = append(due[mention.UserID], mention)
+	}
+	m.mu.Unlock()
+
+	for _, userID := range users {
+		mentions := due[userID]
+		if err := m.sender.Send(ctx, m.compose(userID, mentions)); err != nil {
+			m.bot.ReportError(ctx, "Emailing unread mentions failed", err)
+			continue
+		}
+		m.bot.Log().Info().Str("user_id", userID.String()).Int("mentions", len(mentions)).Msg("Emailed unread mentions")
+		m.mu.Lock()
+		m.pending = slices.DeleteFunc(m.pending, func(mention Mention) bool {
+			return slices.ContainsFunc(mentions, func(sent Mention) bool {
+				return sent.EventID == mention.EventID && sent.UserID == mention.UserID
+			})
+		})
+		m.save(ctx)
+		m.mu.Unlock()
+	}
+}
+
+// compose renders the email of a recipient's unread mentions.
+func (m *Module) compose(userID id.UserID, mentions []Mention) *email.Message {
+	var text, body strings.Builder
+	for _, mention := range mentions {
+		name := mention.RoomID.String()
+		if info, ok := m.bot.RoomInfo(mention.RoomID); ok && info.Name != "" {
+			name = info.Name
+		}
+		link := mention.RoomID.EventURI(mention.EventID).MatrixToURL()
+		when := mention.Time.Format("Jan 2 15:04")
+		text.WriteString(fmt.Sprintf("[%s] %s:\n%s\n%s\n\n", when, name, mention.Body, link))
+		body.WriteString(fmt.Sprintf("<p><small>%s</small> <b>%s</b><br>%s<br><a href=\"%s\">Open in Matrix</a></p>",
+			when, html.EscapeString(name), html.EscapeString(mention.Body), html.EscapeString(link)))
+	}
+	subject := "Unread mention in Matrix"
+	if len(mentions) > 1 {
+		subject = fmt.Sprintf("%d unread mentions in Matrix", len(mentions))
+	}
+	return &email.Message{
+		To:      []string{m.config.Recipients[userID]},
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    body.String(),
+	}
+}
+
+// load reads the pending mentions from the store once. Callers hold m.mu.
+func (m *Module) load(ctx context.Context) {
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+	data, err := m.bot.Store().Get(ctx, storeKey)
+	if err != nil || data == "" {
+		return
+	}
+	if err = json.Unmarshal([]byte(data), &m.pending); err != nil {
+		m.bot.Log().Warn().Err(err).Msg("Ignoring invalid stored mentions")
+	}
+}
+
+// save writes the pending mentions to the store. Callers hold m.mu.
+func (m *Module) save(ctx context.Context) {
+	data, err := json.Marshal(m.pending)
+	if err == nil {
+		err = m.bot.Store().Set(ctx, storeKey, string(data))
+	}
+	if err != nil {
+		m.bot.Log().Warn().Err(err).Msg("Failed to store pending mentions")
+	}
+}
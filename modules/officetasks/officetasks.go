@@ -0,0 +1,317 @@
+// Package officetasks tells people about their OnlyOffice tasks in a direct
+// message: when a task is assigned to them, and when one of their tasks is due
+// soon, so nobody has to ask "!tasks" to find out.
+//
+// Usage:
+//
+//	creds := onlyoffice.GetEnvironmentCredentials()
+//	tasks := officetasks.New(onlyoffice.NewClient(creds), officetasks.Config{
+//		URL:   creds.Url,
+//		Users: map[string]id.UserID{"alice@example.com": "@alice:example.com"},
+//	})
+//	if err := bot.Use(tasks); err != nil { ... }
+//
+// On its first poll, the module only records the current assignments, so
+// existing tasks aren't announced. What was announced is kept in the bot's
+// store, so a restart doesn't repeat it.
+package officetasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	onlyoffice "github.com/eslider/go-onlyoffice"
+	"maunium.net/go/mautrix/id"
+)
+
+// storeKey is the key of the announced tasks in the bot's store.
+const storeKey = "officetasks.state"
+
+// Source is the part of the OnlyOffice client the module uses, implemented by
+// *onlyoffice.Client.
+type Source interface {
+	GetProjects() (onlyoffice.Projects, error)
+	GetTasks(req onlyoffice.ProjectGetTasksRequest) ([]*onlyoffice.Task, error)
+}
+
+// Config controls who is notified and when. It can also be set in the
+// "modules.officetasks" section of the config file.
+type Config struct {
+	// Users maps OnlyOffice accounts, by user ID, email or user name, to the
+	// Matrix users notified of their tasks. Tasks of other accounts are ignored.
+	Users map[string]id.UserID `yaml:"users" doc:"OnlyOffice account (user ID, email or user name) to Matrix user"`
+	// Projects limits the watched projects by title. Without projects, all are watched.
+	Projects []string `yaml:"projects" doc:"Titles of the watched projects (default: all)"`
+	// URL of the OnlyOffice instance, to link the tasks. Without it, tasks aren't linked.
+	URL string `yaml:"url" doc:"Base URL of OnlyOffice, for links to the tasks"`
+	// DueWithin is how long before its deadline a task is announced as due (default: 24h).
+	DueWithin time.Duration `yaml:"due_within" doc:"How long before the deadline a task is announced as due"`
+	// PollInterval is how often the tasks are checked (default: 15m).
+	PollInterval time.Duration `yaml:"poll_interval" doc:"How often the tasks are checked"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Users) == 0 {
+		errs = append(errs, matrix.InvalidConfig("users", "is required"))
+	}
+	for account, userID := range c.Users {
+		if _, _, err := userID.Parse(); err != nil {
+			errs = append(errs, matrix.InvalidConfig("users", "has an invalid Matrix user %q for %s", userID, account))
+		}
+	}
+	if c.DueWithin <= 0 {
+		errs = append(errs, matrix.InvalidConfig("due_within", "must be > 0"))
+	}
+	if c.PollInterval < time.Minute {
+		errs = append(errs, matrix.InvalidConfig("poll_interval", "must be at least 1m"))
+	}
+	return errors.Join(errs...)
+}
+
+// state is what was announced, stored between polls.
+type state struct {
+	Seeded   bool                `json:"seeded"`   // The assignments of the first poll were recorded
+	Assigned map[int][]id.UserID `json:"assigned"` // Users told about their assignment, by task ID
+	Due      map[int]time.Time   `json:"due"`      // Deadline announced as due, by task ID
+}
+
+// Module polls OnlyOffice and notifies the responsible users.
+type Module struct {
+	config Config
+	source Source
+	bot    *matrix.Bot
+	mu     sync.Mutex // Serializes polls
+}
+
+// New creates the module polling an OnlyOffice client.
+func New(source Source, config Config) *Module {
+	if config.DueWithin <= 0 {
+		config.DueWithin = 24 * time.Hour
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 15 * time.Minute
+	}
+	return &Module{config: config, source: source}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "officetasks"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	return nil
+}
+
+// Run implements matrix.Runner and polls the tasks.
+func (m *Module) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.Poll(ctx); err != nil && ctx.Err() == nil {
+			m.bot.ReportError(ctx, "Polling OnlyOffice tasks failed", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks the tasks once and notifies the users of new assignments and
+// tasks due within Config.DueWithin.
+func (m *Module) Poll(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+	projects, err := m.source.GetProjects()
+	if err != nil {
+		return fmt.Errorf("officetasks: %w", err)
+	}
+
+	var errs []error
+	seen := make(map[int]bool)
+	now := time.Now()
+	for _, project := range projects {
+		if project.ID == nil || project.Title == nil || len(m.config.Projects) > 0 && !slices.Contains(m.config.Projects, *project.Title) {
+			continue
+		}
+		tasks, err := m.source.GetTasks(onlyoffice.NewProjectGetTasksRequest(*project.ID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("officetasks: tasks of %s: %w", *project.Title, err))
+			continue
+		}
+		for _, task := range tasks {
+			if task.ID == nil || task.Status != nil && *task.Status == onlyoffice.ProjectTaskStatusClosed {
+				continue
+			}
+			seen[*task.ID] = true
+			errs = append(errs, m.check(ctx, st, *project.Title, *project.ID, task, now))
+		}
+	}
+	if err = errors.Join(errs...); err == nil {
+		// Forget closed and deleted tasks, unless a project failed and its tasks are unknown
+		for taskID := range st.Assigned {
+			if !seen[taskID] {
+				delete(st.Assigned, taskID)
+			}
+		}
+		for taskID := range st.Due {
+			if !seen[taskID] {
+				delete(st.Due, taskID)
+			}
+		}
+	}
+	st.Seeded = true
+	return errors.Join(err, m.save(ctx, st))
+}
+
+// check notifies the responsible users of a task about a new assignment or
+// an upcoming deadline, and records what was announced.
+func (m *Module) check(ctx context.Context, st *state, projectTitle string, projectID int, task *onlyoffice.Task, now time.Time) error {
+	taskID := *task.ID
+	users := m.responsibles(task)
+	title := "task"
+	if task.Title != nil {
+		title = *task.Title
+	}
+	if link := m.link(projectID, taskID); link != "" {
+		title = "[" + title + "](" + link + ")"
+	} else {
+		title = "**" + title + "**"
+	}
+
+	var errs []error
+	for _, userID := range users {
+		if slices.Contains(st.Assigned[taskID], userID) {
+			continue
+		}
+		if st.Seeded {
+			md := fmt.Sprintf("You were assigned the task %s in %s", title, projectTitle)
+			if task.Deadline != nil && !task.Deadline.IsZero() {
+				md += ", due " + formatDeadline(*task.Deadline)
+			}
+			if err := m.notify(ctx, userID, md+"."); err != nil {
+				errs = append(errs, err)
+				continue // Try again on the next poll
+			}
+		}
+		st.Assigned[taskID] = append(st.Assigned[taskID], userID)
+	}
+
+	if task.Deadline == nil || task.Deadline.IsZero() || st.Due[taskID].Equal(*task.Deadline) {
+		return errors.Join(errs...)
+	}
+	deadline := *task.Deadline
+	// Deadlines more than a day past were missed long ago, e.g. before the first poll
+	if deadline.After(now.Add(m.config.DueWithin)) || deadline.Before(now.Add(-24*time.Hour)) {
+		return errors.Join(errs...)
+	}
+	failed := false
+	for _, userID := range users {
+		md := fmt.Sprintf("Your task %s in %s is due %s.", title, projectTitle, formatDeadline(deadline))
+		if err := m.notify(ctx, userID, md); err != nil {
+			errs = append(errs, err)
+			failed = true
+		}
+	}
+	if !failed {
+		st.Due[taskID] = deadline
+	}
+	return errors.Join(errs...)
+}
+
+// responsibles returns the Matrix users responsible for a task.
+func (m *Module) responsibles(task *onlyoffice.Task) []id.UserID {
+	var accounts []string
+	accounts = append(accounts, task.ResponsibleIDS...)
+	for _, user := range task.Responsibles {
+		for _, account := range []*string{user.ID, user.Email, user.UserName} {
+			if account != nil {
+				accounts = append(accounts, *account)
+			}
+		}
+	}
+	var users []id.UserID
+	for _, account := range accounts {
+		if userID, ok := m.config.Users[account]; ok && !slices.Contains(users, userID) {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
+
+// notify sends a user a direct message.
+func (m *Module) notify(ctx context.Context, userID id.UserID, md string) error {
+	err := m.bot.Deliver(ctx, &matrix.Report{Markdown: md}, matrix.UserTarget(userID))
+	if errors.Is(err, matrix.ErrQueued) {
+		return nil
+	}
+	return err
+}
+
+// link returns the URL of a task, or "" without Config.URL.
+func (m *Module) link(projectID, taskID int) string {
+	if m.config.URL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/Products/Projects/Tasks.aspx?prjID=%d&id=%d", strings.TrimRight(m.config.URL, "/"), projectID, taskID)
+}
+
+// formatDeadline formats a deadline, without the time for date-only deadlines.
+func formatDeadline(deadline time.Time) string {
+	if deadline.Hour() == 0 && deadline.Minute() == 0 {
+		return deadline.Format("Mon Jan 2")
+	}
+	return deadline.Format("Mon Jan 2 15:04")
+}
+
+// load reads what was announced. Callers hold m.mu.
+func (m *Module) load(ctx context.Context) (*state, error) {
+	st := &state{}
+	data, err := m.bot.Store().Get(ctx, storeKey)
+	if err != nil {
+		return nil, err
+	}
+	if data != "" {
+		if err = json.Unmarshal([]byte(data), st); err != nil {
+			return nil, fmt.Errorf("officetasks: invalid stored state: %w", err)
+		}
+	}
+	if st.Assigned == nil {
+		st.Assigned = make(map[int][]id.UserID)
+	}
+	if st.Due == nil {
+		st.Due = make(map[int]time.Time)
+	}
+	return st, nil
+}
+
+// save stores what was announced. Callers hold m.mu.
+func (m *Module) save(ctx context.Context, st *state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return m.bot.Store().Set(ctx, storeKey, string(data))
+}
@@ -0,0 +1,102 @@
+package recall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Embedder turns text into an embedding vector. Texts with similar meaning
+// get vectors pointing in similar directions.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// OllamaEmbedder embeds texts with the Ollama embeddings API.
+type OllamaEmbedder struct {
+	URL        string       // e.g. "http://localhost:11434/api/embeddings"
+	Token      string       // Bearer token, e.g. an Open WebUI API key
+	Model      string       // e.g. "nomic-embed-text"
+	HTTPClient *http.Client // Default: http.DefaultClient
+}
+
+// Embed implements Embedder.
+func (e *OllamaEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	body := map[string]any{"model": e.Model, "prompt": text}
+	if err := postJSON(ctx, e.HTTPClient, e.URL, e.Token, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embedding) == 0 {
+		return nil, fmt.Errorf("recall: no embedding returned")
+	}
+	return resp.Embedding, nil
+}
+
+// OpenAIEmbedder embeds texts with the OpenAI embeddings API, as served by
+// vLLM, LiteLLM, llama.cpp and others.
+type OpenAIEmbedder struct {
+	BaseURL    string       // e.g. "http://localhost:8000/v1"
+	Token      string       // API key, if the server requires one
+	Model      string       // e.g. "text-embedding-3-small"
+	HTTPClient *http.Client // Default: http.DefaultClient
+}
+
+// Embed implements Embedder.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	url := strings.TrimSuffix(e.BaseURL, "/")
+	if !strings.HasSuffix(url, "/embeddings") {
+		url += "/embeddings"
+	}
+	body := map[string]any{"model": e.Model, "input": text}
+	if err := postJSON(ctx, e.HTTPClient, url, e.Token, body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 || len(resp.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("recall: no embedding returned")
+	}
+	return resp.Data[0].Embedding, nil
+}
+
+// postJSON posts a JSON request and decodes the JSON response into out.
+func postJSON(ctx context.Context, client *http.Client, url, token string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("recall: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("recall: embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("recall: embedding request failed: %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("recall: invalid embedding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Package recall indexes the messages of rooms as embeddings, so that what
+// was discussed earlier can be found by meaning rather than by exact words:
+//
+//	!recall what did we decide about the release date?
+//
+// It also augments the answers of the ai module with the most relevant
+// earlier messages, which the model can then cite:
+//
+//	history := recall.New(recall.Config{URL: "http://localhost:11434/api/embeddings"})
+//	aiConfig := ai.GetEnvironmentConfig()
+//	aiConfig.Augment = history.Augment
+//	if err := bot.Use(history, ai.New(aiConfig)); err != nil { ... }
+//
+// Embeddings are kept in the bot's database by default; set Config.Store for
+// another vector store. Only messages received while the module runs are
+// indexed, in the rooms of Config.Rooms or all rooms.
+package recall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/id"
+)
+
+// Backends selectable with Config.Backend.
+const (
+	BackendOllama = "ollama" // Ollama embeddings API, see OllamaEmbedder
+	BackendOpenAI = "openai" // OpenAI-compatible embeddings API, see OpenAIEmbedder
+)
+
+// Config configures the recall module. It can also be set in the
+// "modules.recall" section of the config file.
+type Config struct {
+	// Embedder computes the embeddings. Without one, the embedder of Backend
+	// for URL and Token is used.
+	Embedder Embedder `yaml:"-"`
+	// Store keeps the embeddings (default: an SQLVectorStore on the bot's database).
+	Store VectorStore `yaml:"-"`
+	// Backend is the API at URL: BackendOllama (default) or BackendOpenAI.
+	Backend string `yaml:"backend" doc:"API at url: ollama (default) or openai for OpenAI-compatible servers"`
+	// URL is the Ollama embeddings endpoint, e.g.
+	// "http://localhost:11434/api/embeddings", or the base URL of an
+	// OpenAI-compatible API, e.g. "http://localhost:8000/v1".
+	URL string `yaml:"url" doc:"Ollama embeddings endpoint, or base URL of an OpenAI-compatible API"`
+	// Token authenticates with the endpoint.
+	Token string `yaml:"token" doc:"API token of the endpoint"`
+	// Model computes the embeddings (default: "nomic-embed-text"). Changing
+	// it makes the messages indexed before unsearchable.
+	Model string `yaml:"model" doc:"Embedding model; changing it makes earlier messages unsearchable"`
+	// Rooms whose messages are indexed. Without rooms, all rooms are indexed.
+	Rooms []id.RoomID `yaml:"rooms" doc:"Rooms whose messages are indexed (default: all)"`
+	// MinLength skips shorter messages, e.g. "ok" (default: 20 characters).
+	MinLength int `yaml:"min_length" doc:"Shorter messages aren't indexed, in characters"`
+	// Results is how many messages !recall and Augment return (default: 5).
+	Results int `yaml:"results" doc:"Messages returned by !recall and passed to the AI"`
+	// MinScore is the similarity from 0 to 1 a message needs to be returned
+	// (default: 0.5).
+	MinScore float64 `yaml:"min_score" doc:"Similarity from 0 to 1 a message needs to be returned"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Embedder == nil && c.URL == "" {
+		errs = append(errs, matrix.InvalidConfig("url", "is required"))
+	}
+	if c.Backend != "" && c.Backend != BackendOllama && c.Backend != BackendOpenAI {
+		errs = append(errs, matrix.InvalidConfig("backend", "must be %q or %q", BackendOllama, BackendOpenAI))
+	}
+	if c.MinScore < 0 || c.MinScore > 1 {
+		errs = append(errs, matrix.InvalidConfig("min_score", "must be from 0 to 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// Module indexes messages and provides the !recall command.
+type Module struct {
+	config Config
+	bot    *matrix.Bot
+
+	mu    sync.Mutex // Guards store
+	store VectorStore
+}
+
+// New creates the recall module.
+func New(config Config) *Module {
+	if config.Model == "" {
+		config.Model = "nomic-embed-text"
+	}
+	if config.MinLength <= 0 {
+		config.MinLength = 20
+	}
+	if config.Results <= 0 {
+		config.Results = 5
+	}
+	if config.MinScore == 0 {
+		config.MinScore = 0.5
+	}
+	return &Module{config: config, store: config.Store}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "recall"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	if m.config.Embedder == nil {
+		switch m.config.Backend {
+		case BackendOpenAI:
+			m.config.Embedder = &OpenAIEmbedder{BaseURL: m.config.URL, Token: m.config.Token, Model: m.config.Model, HTTPClient: b.HTTPClient()}
+		default:
+			m.config.Embedder = &OllamaEmbedder{URL: m.config.URL, Token: m.config.Token, Model: m.config.Model, HTTPClient: b.HTTPClient()}
+		}
+	}
+	b.OnMessageContext(m.index)
+	b.Command("recall", m.cmdRecall).
+		Describe("Find earlier messages of this room by meaning", "!recall <question>").
+		WithExamples("!recall what did we decide about the release date?")
+	return nil
+}
+
+// vectors returns the vector store, setting up the default one on first use:
+// the bot's store is only available once it runs.
+func (m *Module) vectors(ctx context.Context) (VectorStore, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.store != nil {
+		return m.store, nil
+	}
+	sqlStore, ok := m.bot.Store().(*matrix.SQLStore)
+	if !ok {
+		return nil, matrix.ErrNoDatabase
+	}
+	store := NewSQLVectorStore(sqlStore.DB)
+	if err := store.Upgrade(ctx); err != nil {
+		return nil, err
+	}
+	m.store = store
+	return store, nil
+}
+
+// index embeds and stores the messages of the indexed rooms. Commands aren't indexed.
+func (m *Module) index(ctx context.Context, msg *matrix.MessageContext) {
+	if msg.Reaction != nil || msg.IsEdit() || len(m.config.Rooms) > 0 && !slices.Contains(m.config.Rooms, msg.RoomID) {
+		return
+	}
+	text := strings.TrimSpace(msg.Message.Body)
+	if len([]rune(text)) < m.config.MinLength {
+		return
+	}
+	for _, prefix := range m.bot.CommandPrefixes(ctx, msg.RoomID) {
+		if strings.HasPrefix(text, prefix) {
+			return
+		}
+	}
+	store, err := m.vectors(ctx)
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to set up recall index")
+		return
+	}
+	vector, err := m.config.Embedder.Embed(ctx, text)
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to embed message")
+		return
+	}
+	err = store.Add(ctx, Document{
+		RoomID:  msg.RoomID,
+		EventID: msg.EventID(),
+		Sender:  msg.Sender,
+		Text:    text,
+		Time:    msg.Time(),
+		Vector:  vector,
+	})
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to index message")
+	}
+}
+
+// Recall returns the earlier messages of a room most relevant to a question,
+// the most relevant first.
+func (m *Module) Recall(ctx context.Context, roomID id.RoomID, question string) ([]Match, error) {
+	store, err := m.vectors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vector, err := m.config.Embedder.Embed(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := store.Search(ctx, roomID, vector, m.config.Results)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(matches, func(match Match) bool {
+		return match.Score < m.config.MinScore
+	}), nil
+}
+
+// Augment returns the earlier messages relevant to a question as context for
+// a system prompt, or "" if there are none. Its signature matches
+// ai.Config.Augment.
+func (m *Module) Augment(ctx context.Context, msg *matrix.MessageContext, question string) string {
+	if !m.bot.ModuleEnabled(ctx, msg.RoomID, m.Name()) {
+		return ""
+	}
+	matches, err := m.Recall(ctx, msg.RoomID, question)
+	if err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to recall earlier messages")
+		return ""
+	}
+	var matching []Match
+	for _, match := range matches {
+		if match.EventID != msg.EventID() {
+			matching = append(matching, match)
+		}
+	}
+	if len(matching) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Earlier messages of this room that may be relevant. If you use them, cite them by date and sender.\n")
+	for _, match := range matching {
+		sb.WriteString(fmt.Sprintf("\n[%s] %s: %s", match.Time.Format("2006-01-02 15:04"), match.Sender, match.Text))
+	}
+	return sb.String()
+}
+
+func (m *Module) cmdRecall(ctx context.Context, cmd *matrix.CommandContext) {
+	if cmd.Args == "" {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	matches, err := m.Recall(ctx, cmd.RoomID, cmd.Args)
+	if err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	if len(matches) == 0 {
+		_ = cmd.Reply(ctx, "I don't recall anything about that in this room.")
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("**Earlier in this room:**\n")
+	for _, match := range matches {
+		text := match.Text
+		if len([]rune(text)) > 300 {
+			text = string([]rune(text)[:300]) + "…"
+		}
+		link := cmd.RoomID.EventURI(match.EventID).MatrixToURL()
+		sb.WriteString(fmt.Sprintf("\n- %s, [%s](%s): %s", match.Sender, match.Time.Format("Jan 2 15:04"), link, strings.ReplaceAll(text, "\n", " ")))
+	}
+	_ = cmd.Reply(ctx, sb.String())
+}
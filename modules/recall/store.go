@@ -0,0 +1,136 @@
+package recall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+// Document is an indexed message.
+type Document struct {
+	RoomID  id.RoomID
+	EventID id.EventID
+	Sender  id.UserID
+	Text    string
+	Time    time.Time
+	Vector  []float32
+}
+
+// Match is a document found by a search, with its cosine similarity to the
+// query, from -1 to 1.
+type Match struct {
+	Document
+	Score float64
+}
+
+// VectorStore keeps the embeddings of indexed messages.
+type VectorStore interface {
+	// Add indexes a document; documents already indexed are ignored.
+	Add(ctx context.Context, doc Document) error
+	// Search returns up to limit documents of a room most similar to a
+	// vector, the most similar first.
+	Search(ctx context.Context, roomID id.RoomID, vector []float32, limit int) ([]Match, error)
+}
+
+// SQLVectorStore is the default VectorStore, on the bot's SQLite or
+// PostgreSQL database. Searches compare the query with every message of the
+// room, which is fast enough for some ten thousand messages per room.
+type SQLVectorStore struct {
+	db *dbutil.Database
+}
+
+// NewSQLVectorStore creates a vector store on a database, e.g. the DB of the
+// bot's matrix.SQLStore. Call Upgrade before using it.
+func NewSQLVectorStore(db *dbutil.Database) *SQLVectorStore {
+	return &SQLVectorStore{db: db}
+}
+
+// Upgrade creates the table of the store.
+func (s *SQLVectorStore) Upgrade(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, `CREATE TABLE IF NOT EXISTS recall_messages (
+		room_id  TEXT   NOT NULL,
+		event_id TEXT   NOT NULL,
+		sender   TEXT   NOT NULL,
+		body     TEXT   NOT NULL,
+		ts       BIGINT NOT NULL,
+		vector   TEXT   NOT NULL,
+		PRIMARY KEY (room_id, event_id)
+	)`)
+	if err != nil {
+		return fmt.Errorf("recall: failed to create table: %w", err)
+	}
+	return nil
+}
+
+// Add implements VectorStore.
+func (s *SQLVectorStore) Add(ctx context.Context, doc Document) error {
+	vector, err := json.Marshal(doc.Vector)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(ctx, `INSERT INTO recall_messages (room_id, event_id, sender, body, ts, vector)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (room_id, event_id) DO NOTHING`,
+		doc.RoomID, doc.EventID, doc.Sender, doc.Text, doc.Time.UnixMilli(), string(vector))
+	if err != nil {
+		return fmt.Errorf("recall: failed to index message: %w", err)
+	}
+	return nil
+}
+
+// Search implements VectorStore.
+func (s *SQLVectorStore) Search(ctx context.Context, roomID id.RoomID, vector []float32, limit int) ([]Match, error) {
+	rows, err := s.db.Query(ctx, "SELECT event_id, sender, body, ts, vector FROM recall_messages WHERE room_id=$1", roomID)
+	if err != nil {
+		return nil, fmt.Errorf("recall: failed to search: %w", err)
+	}
+	defer rows.Close()
+	var matches []Match
+	for rows.Next() {
+		var (
+			match  = Match{Document: Document{RoomID: roomID}}
+			ts     int64
+			stored string
+		)
+		if err = rows.Scan(&match.EventID, &match.Sender, &match.Text, &ts, &stored); err != nil {
+			return nil, fmt.Errorf("recall: failed to search: %w", err)
+		}
+		if err = json.Unmarshal([]byte(stored), &match.Vector); err != nil {
+			continue // Skip corrupt rows rather than failing every search
+		}
+		match.Time = time.UnixMilli(ts)
+		match.Score = cosine(vector, match.Vector)
+		matches = append(matches, match)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("recall: failed to search: %w", err)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 if their
+// dimensions differ, e.g. after switching the embedding model.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
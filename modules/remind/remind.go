@@ -0,0 +1,433 @@
+// Package remind adds a !remind command that reminds users of something later,
+// in the room or by direct message. Reminders are kept in the bot's store, so
+// they survive restarts; those that fell due while the bot was down are
+// delivered when it is back.
+//
+//	!remind me in 2h to review PR 42
+//	!remind @alice:example.com tomorrow 9:00 standup
+//	!remind me --dm friday 16:00 submit the timesheet
+//	!remind list
+//	!remind cancel 3
+//
+// Times are interpreted in the sender's time zone preference (see !timezone
+// of the meet module) and take the forms of matrix.ParseWhen.
+package remind
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// storeKey is the store key of the pending reminders.
+const storeKey = "remind.reminders"
+
+// Config limits reminders. It can also be set in the "modules.remind" section
+// of the config file.
+type Config struct {
+	// MaxPerUser caps the pending reminders a user may create (default: 25).
+	MaxPerUser int `yaml:"max_per_user" doc:"Pending reminders a user may create"`
+	// MaxAhead is how far ahead reminders may be set (default: one year).
+	MaxAhead time.Duration `yaml:"max_ahead" doc:"How far ahead reminders may be set, e.g. 8760h"`
+	// CheckInterval is how often due reminders are looked for (default: 15s).
+	CheckInterval time.Duration `yaml:"check_interval" doc:"How often due reminders are looked for"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.MaxPerUser <= 0 {
+		errs = append(errs, matrix.InvalidConfig("max_per_user", "must be > 0"))
+	}
+	if c.MaxAhead <= 0 {
+		errs = append(errs, matrix.InvalidConfig("max_ahead", "must be > 0"))
+	}
+	if c.CheckInterval <= 0 {
+		errs = append(errs, matrix.InvalidConfig("check_interval", "must be > 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// Reminder is a pending reminder.
+type Reminder struct {
+	ID      int         `json:"id"`
+	RoomID  id.RoomID   `json:"room_id"` // Room it was set in
+	Creator id.UserID   `json:"creator"`
+	Users   []id.UserID `json:"users"` // Users to remind
+	Text    string      `json:"text"`
+	At      time.Time   `json:"at"`
+	DM      bool        `json:"dm,omitempty"` // Deliver by direct message instead of in the room
+}
+
+// Module provides the !remind command and delivers the reminders.
+type Module struct {
+	config Config
+	bot    *matrix.Bot
+
+	mu        sync.Mutex // Serializes changes of the stored reminders
+	reminders []Reminder // Loaded from the store on first use
+	loaded    bool
+}
+
+// New creates the reminder module.
+func New(config Config) *Module {
+	if config.MaxPerUser <= 0 {
+		config.MaxPerUser = 25
+	}
+	if config.MaxAhead <= 0 {
+		config.MaxAhead = 365 * 24 * time.Hour
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = 15 * time.Second
+	}
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "remind"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	b.Command("remind", m.cmdRemind).
+		Describe("Set, list or cancel reminders", "!remind <me|@user...> [--dm] <when> <what> | !remind list | !remind cancel <id>").
+		WithFlag("--dm", "Deliver the reminder by direct message; only for reminders of yourself").
+		WithExamples("!remind me in 2h to review PR 42", "!remind @alice:example.com tomorrow 9:00 standup")
+	return nil
+}
+
+// Run implements matrix.Runner and delivers due reminders.
+func (m *Module) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		m.deliverDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Module) cmdRemind(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	switch {
+	case len(fields) == 0:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+	case len(fields) == 1 && fields[0] == "list":
+		m.list(ctx, cmd)
+	case len(fields) == 2 && fields[0] == "cancel":
+		m.cancel(ctx, cmd, fields[1])
+	default:
+		m.add(ctx, cmd, fields)
+	}
+}
+
+func (m *Module) add(ctx context.Context, cmd *matrix.CommandContext, fields []string) {
+	var mentioned []id.UserID
+	if cmd.Message.Mentions != nil {
+		mentioned = cmd.Message.Mentions.UserIDs
+	}
+	now := cmd.Time().In(m.bot.UserTimezone(ctx, cmd.Sender))
+	r, err := Parse(fields, now, cmd.Sender, mentioned)
+	if err != nil {
+		_ = cmd.Reply(ctx, fmt.Sprintf("%v. Usage: `%s`", err, cmd.Command.Usage))
+		return
+	}
+	if !r.At.After(now) {
+		_ = cmd.Reply(ctx, "That time has already passed.")
+		return
+	}
+	if r.At.Sub(now) > m.config.MaxAhead {
+		_ = cmd.Reply(ctx, fmt.Sprintf("Reminders can be set at most %s ahead.", m.config.MaxAhead))
+		return
+	}
+	if r.DM && !(len(r.Users) == 1 && r.Users[0] == cmd.Sender) {
+		_ = cmd.Reply(ctx, "Only reminders of yourself can be sent by direct message.")
+		return
+	}
+	r.RoomID = cmd.RoomID
+	r.Creator = cmd.Sender
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.load(ctx); err != nil {
+		_ = cmd.Reply(ctx, "Failed to load reminders: "+err.Error())
+		return
+	}
+	pending := 0
+	for _, other := range m.reminders {
+		if other.Creator == cmd.Sender {
+			pending++
+		}
+		r.ID = max(r.ID, other.ID)
+	}
+	if pending >= m.config.MaxPerUser {
+		_ = cmd.Reply(ctx, fmt.Sprintf("You have %d pending reminders already; cancel some first.", pending))
+		return
+	}
+	r.ID++
+	if err = m.save(ctx, append(m.reminders, *r)); err != nil {
+		_ = cmd.Reply(ctx, "Failed to save the reminder: "+err.Error())
+		return
+	}
+	_ = cmd.Reply(ctx, fmt.Sprintf("⏰ I'll remind %s %s (reminder #%d).",
+		who(r.Users, cmd.Sender), r.At.Format("Mon Jan 2 15:04 MST"), r.ID))
+}
+
+// list shows the pending reminders set in the room that the sender created
+// or is reminded of.
+func (m *Module) list(ctx context.Context, cmd *matrix.CommandContext) {
+	m.mu.Lock()
+	err := m.load(ctx)
+	reminders := slices.Clone(m.reminders)
+	m.mu.Unlock()
+	if err != nil {
+		_ = cmd.Reply(ctx, "Failed to load reminders: "+err.Error())
+		return
+	}
+
+	loc := m.bot.UserTimezone(ctx, cmd.Sender)
+	var lines []string
+	for _, r := range reminders {
+		if r.RoomID != cmd.RoomID || r.Creator != cmd.Sender && !slices.Contains(r.Users, cmd.Sender) {
+			continue
+		}
+		line := fmt.Sprintf("#%d %s — %s", r.ID, r.At.In(loc).Format("Mon Jan 2 15:04 MST"), r.Text)
+		if r.Users[0] != cmd.Sender || len(r.Users) > 1 {
+			line += " (for " + who(r.Users, cmd.Sender) + ")"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		_ = cmd.Reply(ctx, "You have no pending reminders in this room.")
+		return
+	}
+	if _, err = m.bot.SendPagedList(ctx, cmd.RoomID, "Reminders", lines, 0); err != nil {
+		cmd.Log.Warn().Err(err).Msg("Failed to send reminders")
+	}
+}
+
+// cancel removes a reminder the sender created or is reminded of.
+func (m *Module) cancel(ctx context.Context, cmd *matrix.CommandContext, arg string) {
+	reminderID, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = m.load(ctx); err != nil {
+		_ = cmd.Reply(ctx, "Failed to load reminders: "+err.Error())
+		return
+	}
+	i := slices.IndexFunc(m.reminders, func(r Reminder) bool {
+		return r.ID == reminderID && (r.Creator == cmd.Sender || slices.Contains(r.Users, cmd.Sender))
+	})
+	if i < 0 {
+		_ = cmd.Reply(ctx, fmt.Sprintf("You have no reminder #%d.", reminderID))
+		return
+	}
+	if err = m.save(ctx, slices.Delete(slices.Clone(m.reminders), i, i+1)); err != nil {
+		_ = cmd.Reply(ctx, "Failed to cancel the reminder: "+err.Error())
+		return
+	}
+	_ = cmd.React(ctx, "✅")
+}
+
+// deliverDue sends the reminders due at now and removes them. Reminders that
+// can't be sent are retried, for up to a day.
+func (m *Module) deliverDue(ctx context.Context, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.load(ctx); err != nil {
+		m.bot.Log().Warn().Err(err).Msg("Failed to load reminders")
+		return
+	}
+	var due []Reminder
+	for _, r := range m.reminders {
+		if !r.At.After(now) {
+			due = append(due, r)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	done := make(map[int]bool)
+	for _, r := range due {
+		err := m.deliver(ctx, r, now)
+		if err == nil || errors.Is(err, matrix.ErrQueued) || now.Sub(r.At) > 24*time.Hour {
+			done[r.ID] = true
+		}
+		if err != nil && !errors.Is(err, matrix.ErrQueued) {
+			m.bot.Log().Warn().Err(err).Str("room_id", r.RoomID.String()).Int("reminder", r.ID).Msg("Failed to deliver reminder")
+		}
+	}
+	remaining := slices.DeleteFunc(slices.Clone(m.reminders), func(r Reminder) bool {
+		return done[r.ID]
+	})
+	if err := m.save(ctx, remaining); err != nil {
+		m.bot.Log().Warn().Err(err).Msg("Failed to save reminders")
+	}
+}
+
+// deliver sends a reminder to its room, or by direct message.
+func (m *Module) deliver(ctx context.Context, r Reminder, now time.Time) error {
+	roomID := r.RoomID
+	if r.DM {
+		var err error
+		if roomID, err = m.bot.EnsureDM(ctx, r.Users[0]); err != nil {
+			return err
+		}
+	} else if !m.bot.ModuleEnabled(ctx, roomID, m.Name()) {
+		return nil // Disabled in the room meanwhile
+	}
+
+	names := make([]string, len(r.Users))
+	for i, userID := range r.Users {
+		names[i] = userID.String()
+	}
+	md := fmt.Sprintf("⏰ %s: %s", strings.Join(names, ", "), r.Text)
+	if r.Creator != r.Users[0] || len(r.Users) > 1 {
+		md += fmt.Sprintf(" _(from %s)_", r.Creator)
+	}
+	if now.Sub(r.At) > 5*time.Minute {
+		md += fmt.Sprintf(" _(due %s)_", r.At.Format("Mon Jan 2 15:04 MST"))
+	}
+	_, err := m.bot.SendMessage(ctx, roomID, &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          md,
+		Format:        event.FormatHTML,
+		FormattedBody: matrix.MarkdownToHTML(md),
+		Mentions:      &event.Mentions{UserIDs: r.Users},
+	})
+	return err
+}
+
+// load reads the reminders from the store once. Callers hold m.mu.
+func (m *Module) load(ctx context.Context) error {
+	if m.loaded {
+		return nil
+	}
+	data, err := m.bot.Store().Get(ctx, storeKey)
+	if err != nil {
+		return err
+	}
+	if data != "" {
+		if err = json.Unmarshal([]byte(data), &m.reminders); err != nil {
+			return fmt.Errorf("remind: invalid stored reminders: %w", err)
+		}
+	}
+	m.loaded = true
+	return nil
+}
+
+// save stores reminders and makes them the current ones. Callers hold m.mu.
+func (m *Module) save(ctx context.Context, reminders []Reminder) error {
+	data, err := json.Marshal(reminders)
+	if err != nil {
+		return err
+	}
+	if err = m.bot.Store().Set(ctx, storeKey, string(data)); err != nil {
+		return err
+	}
+	m.reminders = reminders
+	return nil
+}
+
+// Parse parses the arguments of !remind: who to remind ("me", user IDs or
+// mentions), an optional --dm, the time in the forms of matrix.ParseWhen
+// relative to now, and the text, from which a leading "to" is dropped.
+// mentioned are the users mentioned in the message, for mention pills whose
+// text isn't a user ID.
+func Parse(fields []string, now time.Time, sender id.UserID, mentioned []id.UserID) (*Reminder, error) {
+	r := &Reminder{}
+	var targets []string
+	for i := 0; i < len(fields); i++ {
+		if fields[i] == "--dm" {
+			r.DM = true
+			continue
+		}
+		if at, n, err := matrix.ParseWhen(fields[i:], now); err == nil && len(targets) > 0 {
+			r.At = at
+			fields = fields[i+n:]
+			break
+		}
+		targets = append(targets, fields[i])
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("say who to remind: me or @user")
+	}
+	if r.At.IsZero() {
+		return nil, fmt.Errorf("missing or unrecognized time")
+	}
+
+	pills := false // Targets that are neither "me" nor user IDs, e.g. display names
+	for _, target := range targets {
+		target = strings.TrimRight(target, ",")
+		switch {
+		case strings.EqualFold(target, "me"):
+			r.Users = appendUser(r.Users, sender)
+		case strings.HasPrefix(target, "@") && strings.Contains(target, ":"):
+			r.Users = appendUser(r.Users, id.UserID(target))
+		default:
+			pills = true
+		}
+	}
+	if pills {
+		for _, userID := range mentioned {
+			r.Users = appendUser(r.Users, userID)
+		}
+	}
+	if len(r.Users) == 0 {
+		return nil, fmt.Errorf("say who to remind: me or @user")
+	}
+
+	if len(fields) > 0 && strings.EqualFold(fields[0], "to") {
+		fields = fields[1:]
+	}
+	r.Text = strings.Join(fields, " ")
+	if r.Text == "" {
+		return nil, fmt.Errorf("missing what to remind of")
+	}
+	return r, nil
+}
+
+// appendUser adds a user to a list unless it is in it already.
+func appendUser(users []id.UserID, userID id.UserID) []id.UserID {
+	if slices.Contains(users, userID) {
+		return users
+	}
+	return append(users, userID)
+}
+
+// who describes users for the sender, e.g. "you" or "@alice:example.com".
+func who(users []id.UserID, sender id.UserID) string {
+	names := make([]string, len(users))
+	for i, userID := range users {
+		names[i] = userID.String()
+		if userID == sender {
+			names[i] = "you"
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,236 @@
+// Package roomsettings adds a !setting command to view and change per-room
+// bot settings. Every change is versioned (see Bot.RoomSettingsHistory) and,
+// with Config.AnnounceSettingChanges, announced in the room.
+//
+//	!setting                   - list the room's settings
+//	!setting ai.model qwen     - change a setting
+//	!setting unset ai.model    - remove a setting
+//	                             (protected settings: bot admins only, see Bot.ProtectRoomSetting)
+//	!setting history           - show the latest changes
+//	!mute-command ai           - ignore a command or a module's commands here
+//	!unmute-command ai         - allow it again
+//	!modules                   - list the modules and whether they are enabled here
+//	!modules disable ai        - turn a module off in this room (bot admins only)
+//	!modules enable ai         - turn it on again
+//
+// Settings can also be changed by sending a com.github.eslider.matrix-bot.setting
+// state event with the setting name as state key.
+package roomsettings
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+)
+
+// Config configures the settings module.
+type Config struct {
+	// MinPowerLevel is required to change settings (default: 50, moderators);
+	// point it at 0 to let everyone change them. Everyone can list settings
+	// and the history.
+	MinPowerLevel *int
+	// HistoryLength is the number of changes shown by !setting history (default: 10).
+	HistoryLength int
+}
+
+// Module provides the !setting command.
+type Module struct {
+	config Config
+	bot    *matrix.Bot
+}
+
+// New creates the settings module.
+func New(config Config) *Module {
+	if config.MinPowerLevel == nil {
+		level := 50
+		config.MinPowerLevel = &level
+	}
+	if config.HistoryLength == 0 {
+		config.HistoryLength = 10
+	}
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "roomsettings"
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	b.Command("setting", m.cmdSetting).
+		Describe("Show or change room settings", "!setting [history | unset <key> | <key> <value>]")
+	b.Command("mute-command", m.cmdMute).
+		Describe("Mute a command or module in this room", "!mute-command [<command or module>]").
+		Unmutable()
+	b.Command("unmute-command", m.cmdUnmute).
+		Describe("Unmute a command or module in this room", "!unmute-command <command or module>").
+		Unmutable()
+	b.Command("modules", m.cmdModules).
+		Describe("List modules, or enable or disable one in this room", "!modules [enable | disable <module>]").
+		RequireAdmin().
+		Unmutable()
+	return nil
+}
+
+func (m *Module) cmdSetting(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	switch {
+	case len(fields) == 0:
+		m.list(ctx, cmd)
+	case len(fields) == 1 && fields[0] == "history":
+		m.history(ctx, cmd)
+	case len(fields) == 2 && fields[0] == "unset":
+		m.set(ctx, cmd, fields[1], "")
+	case len(fields) >= 2:
+		m.set(ctx, cmd, fields[0], strings.TrimSpace(strings.TrimPrefix(cmd.Args, fields[0])))
+	default:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+	}
+}
+
+func (m *Module) list(ctx context.Context, cmd *matrix.CommandContext) {
+	settings, err := m.bot.RoomSettings(ctx, cmd.RoomID)
+	if err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	if len(settings) == 0 {
+		_ = cmd.Reply(ctx, "No settings in this room.")
+		return
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("**Room settings:**\n\n")
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("- `%s`: %s\n", key, settings[key]))
+	}
+	_ = cmd.Reply(ctx, sb.String())
+}
+
+func (m *Module) history(ctx context.Context, cmd *matrix.CommandContext) {
+	changes, err := m.bot.RoomSettingsHistory(ctx, cmd.RoomID, m.config.HistoryLength)
+	if err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	if len(changes) == 0 {
+		_ = cmd.Reply(ctx, "No setting changes in this room yet.")
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("**Setting history:**\n\n")
+	for _, change := range changes {
+		sb.WriteString(fmt.Sprintf("- v%d %s: %s\n", change.Version, change.ChangedAt.Format("2006-01-02 15:04"), change))
+	}
+	_ = cmd.Reply(ctx, sb.String())
+}
+
+func (m *Module) set(ctx context.Context, cmd *matrix.CommandContext, key, value string) {
+	if !m.canChange(ctx, cmd) {
+		return
+	}
+	if !m.bot.CanChangeRoomSetting(cmd.Sender, key) {
+		_ = cmd.Reply(ctx, fmt.Sprintf("Only bot admins can change `%s`.", key))
+		return
+	}
+	if err := m.bot.SetRoomSetting(ctx, cmd.RoomID, key, value, cmd.Sender); err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	_ = cmd.React(ctx, "✅")
+}
+
+func (m *Module) cmdMute(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	if len(fields) == 0 {
+		muted, err := m.bot.MutedCommands(ctx, cmd.RoomID)
+		if err != nil {
+			_ = cmd.Reply(ctx, "Error: "+err.Error())
+		} else if len(muted) == 0 {
+			_ = cmd.Reply(ctx, "No commands are muted in this room.")
+		} else {
+			_ = cmd.Reply(ctx, "Muted in this room: `"+strings.Join(muted, "`, `")+"`")
+		}
+		return
+	}
+	if !m.canChange(ctx, cmd) {
+		return
+	}
+	if err := m.bot.MuteCommand(ctx, cmd.RoomID, fields[0], cmd.Sender); err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	_ = cmd.React(ctx, "🔇")
+}
+
+func (m *Module) cmdUnmute(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	if len(fields) != 1 {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	if !m.canChange(ctx, cmd) {
+		return
+	}
+	if err := m.bot.UnmuteCommand(ctx, cmd.RoomID, fields[0], cmd.Sender); err != nil {
+		_ = cmd.Reply(ctx, "Error: "+err.Error())
+		return
+	}
+	_ = cmd.React(ctx, "🔊")
+}
+
+func (m *Module) cmdModules(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	switch {
+	case len(fields) == 0:
+		var sb strings.Builder
+		sb.WriteString("**Modules:**\n\n")
+		for _, module := range m.bot.Modules() {
+			state := "✅ enabled"
+			if !m.bot.ModuleEnabled(ctx, cmd.RoomID, module.Name()) {
+				state = "⛔ disabled"
+			}
+			sb.WriteString(fmt.Sprintf("- `%s`: %s\n", module.Name(), state))
+		}
+		_ = cmd.Reply(ctx, sb.String())
+	case len(fields) == 2 && fields[0] == "enable":
+		if err := m.bot.EnableModule(ctx, cmd.RoomID, fields[1], cmd.Sender); err != nil {
+			_ = cmd.Reply(ctx, "Error: "+err.Error())
+			return
+		}
+		_ = cmd.React(ctx, "✅")
+	case len(fields) == 2 && fields[0] == "disable":
+		if err := m.bot.DisableModule(ctx, cmd.RoomID, fields[1], cmd.Sender); err != nil {
+			_ = cmd.Reply(ctx, "Error: "+err.Error())
+			return
+		}
+		_ = cmd.React(ctx, "⛔")
+	default:
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+	}
+}
+
+// canChange checks that the sender has MinPowerLevel, and tells them otherwise.
+func (m *Module) canChange(ctx context.Context, cmd *matrix.CommandContext) bool {
+	var levels event.PowerLevelsEventContent
+	if err := cmd.State(ctx, event.StatePowerLevels, "", &levels); err != nil {
+		_ = cmd.Reply(ctx, "Failed to check your power level: "+err.Error())
+		return false
+	}
+	if levels.GetUserLevel(cmd.Sender) < *m.config.MinPowerLevel {
+		_ = cmd.Reply(ctx, fmt.Sprintf("Changing settings requires power level %d.", *m.config.MinPowerLevel))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+package roomsettings
+
+import "testing"
+
+func TestNewMinPowerLevel(t *testing.T) {
+	if got := *New(Config{}).config.MinPowerLevel; got != 50 {
+		t.Errorf("default MinPowerLevel = %d, want 50", got)
+	}
+	everyone := 0
+	if got := *New(Config{MinPowerLevel: &everyone}).config.MinPowerLevel; got != 0 {
+		t.Errorf("explicit MinPowerLevel = %d, want 0", got)
+	}
+}
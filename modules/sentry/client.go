@@ -0,0 +1,114 @@
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client calls the Sentry web API of an organization.
+type Client struct {
+	URL        string // e.g. "https://sentry.io", or a self-hosted instance
+	Org        string // Organization slug
+	Token      string // Auth token with event:write scope
+	HTTPClient *http.Client
+}
+
+// Issue is a Sentry issue, a group of similar events.
+type Issue struct {
+	ID        string `json:"id"`
+	ShortID   string `json:"shortId"` // e.g. "FRONTEND-1A"
+	Title     string `json:"title"`
+	Culprit   string `json:"culprit"`
+	Permalink string `json:"permalink"`
+	Level     string `json:"level"`
+	Status    string `json:"status"`
+	Count     string `json:"count"` // Events of the issue
+	Project   struct {
+		Slug string `json:"slug"`
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+// Issue fetches an issue by its numeric ID.
+func (c *Client) Issue(ctx context.Context, issueID string) (*Issue, error) {
+	issue := &Issue{}
+	if err := c.do(ctx, http.MethodGet, "issues/"+url.PathEscape(issueID)+"/", nil, issue); err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+// IssueByShortID fetches an issue by its short ID, e.g. "FRONTEND-1A".
+func (c *Client) IssueByShortID(ctx context.Context, shortID string) (*Issue, error) {
+	var resp struct {
+		Group Issue `json:"group"`
+	}
+	if err := c.do(ctx, http.MethodGet, "shortids/"+url.PathEscape(strings.ToUpper(shortID))+"/", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Group, nil
+}
+
+// Resolve marks an issue as resolved.
+func (c *Client) Resolve(ctx context.Context, issueID string) error {
+	return c.do(ctx, http.MethodPut, "issues/"+url.PathEscape(issueID)+"/", map[string]string{"status": "resolved"}, nil)
+}
+
+// do sends a request to the organization API and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	if c.Org == "" || c.Token == "" {
+		return fmt.Errorf("sentry: the API needs an organization and a token")
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	endpoint := strings.TrimRight(c.URL, "/") + "/api/0/organizations/" + url.PathEscape(c.Org) + "/" + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("sentry: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sentry: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("sentry: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Detail != "" {
+			return fmt.Errorf("sentry: %s: %s", resp.Status, apiErr.Detail)
+		}
+		return fmt.Errorf("sentry: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("sentry: invalid response: %w", err)
+	}
+	return nil
+}
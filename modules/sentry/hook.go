@@ -0,0 +1,262 @@
+package sentry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxPayloadSize limits the webhook payloads accepted by the handler.
+const maxPayloadSize = 5 << 20
+
+// Alert is an event of a Sentry issue that triggered an alert.
+type Alert struct {
+	IssueID     string
+	ShortID     string // e.g. "FRONTEND-1A", "" if unknown
+	Title       string
+	Culprit     string
+	Level       string // e.g. "error"
+	Project     string // Slug, "" if unknown
+	Environment string
+	URL         string // Issue or event in the browser
+	Rule        string // Name of the alert rule
+	Frames      []Frame
+}
+
+// Frame is a frame of the stack trace of an event.
+type Frame struct {
+	Filename string `json:"filename"`
+	Function string `json:"function"`
+	Module   string `json:"module"`
+	LineNo   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// String formats a frame as "function in file:line".
+func (f Frame) String() string {
+	file := f.Filename
+	if file == "" {
+		file = f.Module
+	}
+	s := "`" + f.Function + "`"
+	if f.Function == "" {
+		s = "`?`"
+	}
+	if file != "" {
+		s += " in `" + file
+		if f.LineNo > 0 {
+			s += fmt.Sprintf(":%d", f.LineNo)
+		}
+		s += "`"
+	}
+	return s
+}
+
+// eventData is a Sentry event in webhook payloads.
+type eventData struct {
+	IssueID jsonID     `json:"issue_id"`
+	Title   string     `json:"title"`
+	Culprit string     `json:"culprit"`
+	Level   string     `json:"level"`
+	WebURL  string     `json:"web_url"`
+	Tags    [][]string `json:"tags"`
+
+	Exception struct {
+		Values []struct {
+			Type       string `json:"type"`
+			Value      string `json:"value"`
+			Stacktrace *struct {
+				Frames []Frame `json:"frames"`
+			} `json:"stacktrace"`
+		} `json:"values"`
+	} `json:"exception"`
+}
+
+// alertPayload is the payload of an event_alert webhook of an integration.
+type alertPayload struct {
+	Action string `json:"action"`
+	Data   struct {
+		Event         eventData `json:"event"`
+		TriggeredRule string    `json:"triggered_rule"`
+	} `json:"data"`
+}
+
+// issuePayload is the payload of an issue webhook of an integration.
+type issuePayload struct {
+	Action string `json:"action"` // e.g. "resolved"
+	Data   struct {
+		Issue struct {
+			ID jsonID `json:"id"`
+		} `json:"issue"`
+	} `json:"data"`
+}
+
+// pluginPayload is the payload of the legacy WebHooks plugin.
+type pluginPayload struct {
+	ID              jsonID    `json:"id"`
+	ProjectSlug     string    `json:"project_slug"`
+	Culprit         string    `json:"culprit"`
+	Message         string    `json:"message"`
+	URL             string    `json:"url"`
+	Level           string    `json:"level"`
+	TriggeringRules []string  `json:"triggering_rules"`
+	Event           eventData `json:"event"`
+}
+
+// jsonID is an ID sent as JSON string or number.
+type jsonID string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *jsonID) UnmarshalJSON(data []byte) error {
+	if s := strings.Trim(string(data), `"`); s != "null" {
+		*i = jsonID(s)
+	}
+	return nil
+}
+
+// alert converts an event into an alert.
+func (e *eventData) alert() *Alert {
+	a := &Alert{IssueID: string(e.IssueID), Title: e.Title, Culprit: e.Culprit, Level: e.Level, URL: e.WebURL}
+	for _, tag := range e.Tags {
+		if len(tag) == 2 && tag[0] == "environment" {
+			a.Environment = tag[1]
+		}
+	}
+	// The last exception is the one raised; its frames are ordered from the
+	// outermost call to the innermost
+	if values := e.Exception.Values; len(values) > 0 {
+		last := values[len(values)-1]
+		if a.Title == "" {
+			a.Title = strings.TrimPrefix(last.Type+": "+last.Value, ": ")
+		}
+		if last.Stacktrace != nil {
+			a.Frames = summarize(last.Stacktrace.Frames)
+		}
+	}
+	return a
+}
+
+// summarize returns the innermost frames of a stack trace, innermost first,
+// preferring the frames of the application over those of libraries.
+func summarize(frames []Frame) []Frame {
+	var inApp []Frame
+	for _, frame := range frames {
+		if frame.InApp {
+			inApp = append(inApp, frame)
+		}
+	}
+	if len(inApp) > 0 {
+		frames = inApp
+	}
+	var summary []Frame
+	for i := len(frames) - 1; i >= 0 && len(summary) < maxFrames; i-- {
+		summary = append(summary, frames[i])
+	}
+	return summary
+}
+
+// Handler returns the HTTP handler receiving the webhooks of a Sentry
+// integration (event_alert and issue resources) or of the legacy WebHooks
+// plugin via POST. Integration webhooks are verified with the client secret
+// (Sentry-Hook-Signature), plugin webhooks by a "secret" query parameter.
+func (m *Module) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxPayloadSize {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !m.verify(r, body) {
+			m.bot.Log().Warn().Str("remote", r.RemoteAddr).Msg("Rejected Sentry webhook")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		alert, resolvedID, err := parse(r.Header.Get("Sentry-Hook-Resource"), body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case alert != nil:
+			err = m.post(r.Context(), alert)
+		case resolvedID != "":
+			err = m.resolved(r.Context(), resolvedID, "")
+		}
+		if err != nil {
+			m.bot.ReportError(r.Context(), "Posting Sentry alert failed", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// parse decodes a webhook of a resource: the alert of an event_alert or
+// plugin webhook, or the ID of a resolved issue. Other webhooks return neither.
+func parse(resource string, body []byte) (*Alert, string, error) {
+	switch resource {
+	case "event_alert":
+		var payload alertPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, "", fmt.Errorf("sentry: invalid payload: %w", err)
+		}
+		alert := payload.Data.Event.alert()
+		alert.Rule = payload.Data.TriggeredRule
+		return alert, "", nil
+	case "issue":
+		var payload issuePayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, "", fmt.Errorf("sentry: invalid payload: %w", err)
+		}
+		if payload.Action == "resolved" {
+			return nil, string(payload.Data.Issue.ID), nil
+		}
+	case "": // Legacy plugin
+		var payload pluginPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, "", fmt.Errorf("sentry: invalid payload: %w", err)
+		}
+		alert := payload.Event.alert()
+		alert.IssueID, alert.Project, alert.URL = string(payload.ID), payload.ProjectSlug, payload.URL
+		alert.Culprit, alert.Level = payload.Culprit, payload.Level
+		if alert.Title == "" {
+			alert.Title = payload.Message
+		}
+		alert.Rule = strings.Join(payload.TriggeringRules, ", ")
+		return alert, "", nil
+	}
+	return nil, "", nil
+}
+
+// verify checks the Sentry-Hook-Signature of a webhook, or else the "secret"
+// query parameter.
+func (m *Module) verify(r *http.Request, body []byte) bool {
+	if m.config.Secret == "" {
+		return false
+	}
+	if signature := r.Header.Get("Sentry-Hook-Signature"); signature != "" {
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(m.config.Secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(m.config.Secret)) == 1
+}
@@ -0,0 +1,102 @@
+package sentry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	const body = `{"action":"triggered"}`
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+	tests := []struct {
+		name      string
+		secret    string
+		target    string
+		signature string
+		ok        bool
+	}{
+		{"signature", "s3cret", "/sentry", signature, true},
+		{"wrong secret", "other", "/sentry", signature, false},
+		{"not hex", "s3cret", "/sentry", "sha256=" + signature, false},
+		{"invalid signature beats valid query", "s3cret", "/sentry?secret=s3cret", "00", false},
+		{"query secret", "s3cret", "/sentry?secret=s3cret", "", true},
+		{"wrong query secret", "s3cret", "/sentry?secret=other", "", false},
+		{"unauthenticated", "s3cret", "/sentry", "", false},
+		{"no secret configured", "", "/sentry?secret=", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Config{Secret: tt.secret})
+			r := httptest.NewRequest("POST", tt.target, strings.NewReader(body))
+			if tt.signature != "" {
+				r.Header.Set("Sentry-Hook-Signature", tt.signature)
+			}
+			if got := m.verify(r, []byte(body)); got != tt.ok {
+				t.Errorf("verify() = %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseEventAlert(t *testing.T) {
+	const body = `{"action":"triggered","data":{"triggered_rule":"Errors in prod","event":{
+		"issue_id":"1170","culprit":"app.login","level":"error","web_url":"https://sentry.io/issues/1170/",
+		"tags":[["environment","prod"],["release","1.2"]],
+		"exception":{"values":[{"type":"KeyError","value":"'id'"},{"type":"ValueError","value":"bad token",
+			"stacktrace":{"frames":[
+				{"filename":"main.py","function":"main","lineno":1,"in_app":true},
+				{"filename":"auth.py","function":"login","lineno":42,"in_app":true},
+				{"filename":"lib/jwt.py","function":"decode","lineno":7,"in_app":false}]}}]}}}}`
+	alert, resolved, err := parse("event_alert", []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != "" || alert == nil {
+		t.Fatalf("parse() = %+v, %q", alert, resolved)
+	}
+	if alert.IssueID != "1170" || alert.Title != "ValueError: bad token" || alert.Environment != "prod" || alert.Rule != "Errors in prod" {
+		t.Errorf("alert = %+v", alert)
+	}
+	// Innermost frames of the application first
+	if len(alert.Frames) != 2 || alert.Frames[0].Function != "login" || alert.Frames[1].Function != "main" {
+		t.Errorf("frames = %+v", alert.Frames)
+	}
+	if got := alert.Frames[0].String(); got != "`login` in `auth.py:42`" {
+		t.Errorf("Frame.String() = %s", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	alert, resolved, err := parse("issue", []byte(`{"action":"resolved","data":{"issue":{"id":1170}}}`))
+	if err != nil || alert != nil || resolved != "1170" {
+		t.Errorf("parse(resolved issue) = %+v, %q, %v", alert, resolved, err)
+	}
+	alert, resolved, err = parse("issue", []byte(`{"action":"assigned","data":{"issue":{"id":"1170"}}}`))
+	if err != nil || alert != nil || resolved != "" {
+		t.Errorf("parse(assigned issue) = %+v, %q, %v", alert, resolved, err)
+	}
+
+	alert, _, err = parse("", []byte(`{"id":"1170","project_slug":"frontend","message":"Login fails",
+		"url":"https://sentry.io/issues/1170/","level":"warning","triggering_rules":["a","b"]}`))
+	if err != nil || alert == nil {
+		t.Fatalf("parse(plugin) = %+v, %v", alert, err)
+	}
+	if alert.IssueID != "1170" || alert.Project != "frontend" || alert.Title != "Login fails" || alert.Level != "warning" || alert.Rule != "a, b" {
+		t.Errorf("plugin alert = %+v", alert)
+	}
+
+	for _, resource := range []string{"event_alert", "issue", ""} {
+		if _, _, err = parse(resource, []byte("{")); err == nil {
+			t.Errorf("parse(%q) of invalid JSON succeeded", resource)
+		}
+	}
+	if alert, resolved, err = parse("installation", []byte("{")); err != nil || alert != nil || resolved != "" {
+		t.Errorf("parse(other resource) = %+v, %q, %v", alert, resolved, err)
+	}
+}
@@ -0,0 +1,323 @@
+// Package sentry posts Sentry alerts into Matrix rooms with a summary of the
+// stack trace and a link to the issue. Repeated alerts of an issue update the
+// first message with a count instead of flooding the room, and
+// "!sentry resolve FRONTEND-1A" resolves the issue in Sentry.
+//
+// Usage:
+//
+//	config := sentry.GetEnvironmentConfig()
+//	config.Rooms = []string{"#ops:example.com"}
+//	alerts := sentry.New(config)
+//	if err := bot.Use(alerts); err != nil { ... }
+//	http.Handle("/sentry", alerts.Handler())
+//
+// In Sentry, create an internal integration with the webhook URL
+// https://bot.example.com/sentry, the "issue" webhooks and the "Alert Rule
+// Action" option, and use its client secret as Secret and a token with
+// event:write scope as Token; then add the integration to alert rules. The
+// legacy WebHooks plugin works too, posting to
+// https://bot.example.com/sentry?secret=<secret>.
+package sentry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxResponseSize limits the API responses read.
+const maxResponseSize = 1 << 20
+
+// maxFrames is the number of stack frames shown of an alert.
+const maxFrames = 3
+
+// issueID matches the numeric IDs of issues.
+var issueID = regexp.MustCompile(`^[0-9]+$`)
+
+// Config holds the Sentry organization and the rooms alerts are posted to. It
+// can also be set in the "modules.sentry" section of the config file.
+type Config struct {
+	URL   string `yaml:"url" doc:"Sentry instance (default: https://sentry.io)"`
+	Org   string `yaml:"org" doc:"Organization slug, for !sentry resolve"`
+	Token string `yaml:"token" doc:"Auth token with event:write scope, for !sentry resolve and short IDs"`
+	// Secret verifies the webhooks: the client secret of an internal
+	// integration, or the "secret" query parameter of plugin webhooks.
+	// Without it, Handler rejects all webhooks.
+	Secret string   `yaml:"secret" doc:"Client secret of the integration, or ?secret= of plugin webhooks"`
+	Rooms  []string `yaml:"rooms" doc:"Rooms (IDs or aliases) alerts are posted to"`
+	// GroupWindow is how long after the last alert of an issue further alerts
+	// update its message instead of posting a new one (default: 1h).
+	GroupWindow time.Duration `yaml:"group_window" doc:"How long repeated alerts of an issue update its message"`
+}
+
+// GetEnvironmentConfig creates a Config from the SENTRY_URL, SENTRY_ORG,
+// SENTRY_TOKEN and SENTRY_WEBHOOK_SECRET environment variables.
+func GetEnvironmentConfig() Config {
+	return Config{
+		URL:    os.Getenv("SENTRY_URL"),
+		Org:    os.Getenv("SENTRY_ORG"),
+		Token:  os.Getenv("SENTRY_TOKEN"),
+		Secret: os.Getenv("SENTRY_WEBHOOK_SECRET"),
+	}
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Secret == "" {
+		errs = append(errs, matrix.InvalidConfig("secret", "is required"))
+	}
+	if c.Token != "" && c.Org == "" {
+		errs = append(errs, matrix.InvalidConfig("org", "is required with a token"))
+	}
+	if c.GroupWindow < 0 {
+		errs = append(errs, matrix.InvalidConfig("group_window", "must be >= 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// group is an issue posted to the rooms, updated by further alerts.
+type group struct {
+	alert      *Alert
+	count      int
+	first      time.Time
+	last       time.Time
+	events     map[id.RoomID]id.EventID // Message of the issue per room
+	resolved   bool
+	resolvedBy string // Matrix user, "" if resolved in Sentry
+}
+
+// Module posts Sentry alerts and resolves issues.
+type Module struct {
+	config Config
+	client *Client
+	bot    *matrix.Bot
+
+	mu     sync.Mutex // Serializes posting, so repeated alerts find their group
+	groups map[string]*group
+}
+
+// New creates the Sentry module.
+func New(config Config) *Module {
+	if config.URL == "" {
+		config.URL = "https://sentry.io"
+	}
+	if config.GroupWindow == 0 {
+		config.GroupWindow = time.Hour
+	}
+	return &Module{config: config, groups: make(map[string]*group)}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "sentry"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	m.client = &Client{URL: m.config.URL, Org: m.config.Org, Token: m.config.Token, HTTPClient: b.HTTPClient()}
+	b.Command("sentry", m.cmdSentry).
+		Describe("Resolve Sentry issues", "!sentry resolve <short-id>").
+		WithExamples("!sentry resolve FRONTEND-1A")
+	return nil
+}
+
+// Client returns the API client. It is set by Init.
+func (m *Module) Client() *Client {
+	return m.client
+}
+
+func (m *Module) cmdSentry(ctx context.Context, cmd *matrix.CommandContext) {
+	fields := cmd.Fields()
+	if len(fields) != 2 || fields[0] != "resolve" {
+		_ = cmd.Reply(ctx, "Usage: `"+cmd.Command.Usage+"`")
+		return
+	}
+	var (
+		issue *Issue
+		err   error
+	)
+	if issueID.MatchString(fields[1]) {
+		issue, err = m.client.Issue(ctx, fields[1])
+	} else {
+		issue, err = m.client.IssueByShortID(ctx, fields[1])
+	}
+	if err == nil {
+		err = m.client.Resolve(ctx, issue.ID)
+	}
+	if err != nil {
+		cmd.Log.Warn().Err(err).Str("issue", fields[1]).Msg("Failed to resolve Sentry issue")
+		_ = cmd.Reply(ctx, fmt.Sprintf("Failed to resolve %s: %v", fields[1], err))
+		return
+	}
+	cmd.Log.Info().Str("issue", issue.ShortID).Msg("Resolved Sentry issue")
+	_ = cmd.Reply(ctx, fmt.Sprintf("✅ Resolved [%s](%s): %s", issue.ShortID, issue.Permalink, issue.Title))
+	if err = m.resolved(ctx, issue.ID, cmd.Sender.String()); err != nil {
+		cmd.Log.Warn().Err(err).Str("issue", issue.ShortID).Msg("Failed to update Sentry alert")
+	}
+}
+
+// post posts an alert to the rooms and routes it with Bot.Route, with the
+// fields source (sentry), project, level, environment and issue. An alert of
+// an issue posted within Config.GroupWindow updates that message instead.
+func (m *Module) post(ctx context.Context, alert *Alert) error {
+	if alert.ShortID == "" && alert.IssueID != "" && m.config.Token != "" {
+		if issue, err := m.client.Issue(ctx, alert.IssueID); err != nil {
+			m.bot.Log().Warn().Err(err).Str("issue", alert.IssueID).Msg("Failed to fetch Sentry issue")
+		} else {
+			alert.ShortID, alert.Project = issue.ShortID, issue.Project.Slug
+			if issue.Permalink != "" {
+				alert.URL = issue.Permalink
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for key, g := range m.groups {
+		if now.Sub(g.last) > m.config.GroupWindow {
+			delete(m.groups, key)
+		}
+	}
+	if g := m.groups[alert.IssueID]; g != nil && !g.resolved {
+		g.alert, g.last = alert, now
+		g.count++
+		m.bot.Log().Debug().Str("issue", alert.IssueID).Int("count", g.count).Msg("Updating Sentry alert")
+		return m.update(ctx, g)
+	}
+
+	g := &group{alert: alert, count: 1, first: now, last: now, events: make(map[id.RoomID]id.EventID)}
+	md := g.markdown()
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: md, Format: event.FormatHTML, FormattedBody: matrix.MarkdownToHTML(md)}
+	var errs []error
+	for _, room := range m.config.Rooms {
+		roomID, err := m.bot.ResolveRoom(ctx, room)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		eventID, err := m.bot.SendMessage(ctx, roomID, content)
+		if err != nil && !errors.Is(err, matrix.ErrQueued) {
+			errs = append(errs, err)
+			continue
+		}
+		if eventID != "" {
+			g.events[roomID] = eventID
+		}
+	}
+	if alert.IssueID != "" {
+		m.groups[alert.IssueID] = g
+	}
+	fields := map[string]string{
+		"source":      "sentry",
+		"project":     alert.Project,
+		"level":       alert.Level,
+		"environment": alert.Environment,
+		"issue":       alert.ShortID,
+	}
+	_, err := m.bot.Route(ctx, fields, &matrix.Report{Markdown: md, Data: alert})
+	return errors.Join(append(errs, err)...)
+}
+
+// resolved marks the message of a resolved issue, if it was posted recently.
+func (m *Module) resolved(ctx context.Context, issueID, by string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.groups[issueID]
+	if g == nil || g.resolved {
+		return nil
+	}
+	g.resolved, g.resolvedBy = true, by
+	return m.update(ctx, g)
+}
+
+// update edits the messages of a group. Callers hold m.mu.
+func (m *Module) update(ctx context.Context, g *group) error {
+	md := g.markdown()
+	html := matrix.MarkdownToHTML(md)
+	var errs []error
+	for roomID, eventID := range g.events {
+		if err := m.bot.EditMessage(ctx, roomID, eventID, md, html); err != nil && !errors.Is(err, matrix.ErrQueued) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// markdown renders the alert of a group with its stack frames, details and count.
+func (g *group) markdown() string {
+	a := g.alert
+	var sb strings.Builder
+	if g.resolved {
+		sb.WriteString("✅ ")
+	} else {
+		sb.WriteString(levelIcon(a.Level) + " ")
+	}
+	name := a.ShortID
+	if name == "" {
+		name = "Issue"
+	}
+	if a.URL != "" {
+		sb.WriteString(fmt.Sprintf("**[%s](%s)** %s", name, a.URL, a.Title))
+	} else {
+		sb.WriteString(fmt.Sprintf("**%s** %s", name, a.Title))
+	}
+	if a.Culprit != "" {
+		sb.WriteString("\n`" + a.Culprit + "`")
+	}
+	sb.WriteString("\n")
+	for _, frame := range a.Frames {
+		sb.WriteString("\n- " + frame.String())
+	}
+
+	var details []string
+	for _, detail := range []string{a.Level, a.Project, a.Environment} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	if a.Rule != "" {
+		details = append(details, "rule: "+a.Rule)
+	}
+	if g.count > 1 {
+		details = append(details, fmt.Sprintf("%d alerts since %s", g.count, g.first.Format("15:04")))
+	}
+	if len(details) > 0 {
+		sb.WriteString("\n\n" + strings.Join(details, " · "))
+	}
+	switch {
+	case g.resolved && g.resolvedBy != "":
+		sb.WriteString("\n\nResolved by " + g.resolvedBy)
+	case g.resolved:
+		sb.WriteString("\n\nResolved in Sentry")
+	}
+	return sb.String()
+}
+
+// levelIcon returns the icon of an event level.
+func levelIcon(level string) string {
+	switch level {
+	case "fatal", "error":
+		return "🔴"
+	case "warning":
+		return "🟠"
+	default:
+		return "🔵"
+	}
+}
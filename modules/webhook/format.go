@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Format reads the webhooks of a service.
+type Format struct {
+	// Verify checks that a request was signed with the secret of the hook.
+	// Nil accepts all requests.
+	Verify func(r *http.Request, body []byte, secret string) error
+	// Parse decodes a request into an event; Hook and Source are set by the module.
+	Parse func(r *http.Request, body []byte) (*Event, error)
+	// Templates are the default Markdown templates, keyed by "<event>.<action>"
+	// or "<event>".
+	Templates map[string]string
+}
+
+// ErrInvalidSignature is returned by Format.Verify for unsigned requests or
+// requests signed with another secret.
+var ErrInvalidSignature = errors.New("webhook: invalid signature")
+
+// formats are the registered formats by name.
+var formats = map[string]*Format{}
+
+// RegisterFormat adds or replaces a format, e.g. for an in-house service.
+// Call it before the module's config is validated.
+func RegisterFormat(name string, format *Format) {
+	formats[name] = format
+}
+
+// FormatNames returns the names of the registered formats, sorted.
+func FormatNames() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// verifyHMAC checks a hex HMAC-SHA256 signature of the body, after an optional
+// prefix such as "sha256=".
+func verifyHMAC(signature, prefix string, body []byte, secret string) error {
+	signature, ok := strings.CutPrefix(signature, prefix)
+	if !ok || signature == "" {
+		return ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
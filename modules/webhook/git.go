@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Formats of Git hosting services. Gitea's payloads follow GitHub's, so both
+// decode into a GitPayload and share the default templates.
+const (
+	FormatGitHub = "github" // Signed with X-Hub-Signature-256
+	FormatGitea  = "gitea"  // Signed with X-Gitea-Signature; also Forgejo
+)
+
+func init() {
+	RegisterFormat(FormatGitHub, &Format{
+		Verify: func(r *http.Request, body []byte, secret string) error {
+			return verifyHMAC(r.Header.Get("X-Hub-Signature-256"), "sha256=", body, secret)
+		},
+		Parse: func(r *http.Request, body []byte) (*Event, error) {
+			return parseGit(r.Header.Get("X-GitHub-Event"), r, body)
+		},
+		Templates: gitTemplates,
+	})
+	RegisterFormat(FormatGitea, &Format{
+		Verify: func(r *http.Request, body []byte, secret string) error {
+			return verifyHMAC(r.Header.Get("X-Gitea-Signature"), "", body, secret)
+		},
+		Parse: func(r *http.Request, body []byte) (*Event, error) {
+			return parseGit(r.Header.Get("X-Gitea-Event"), r, body)
+		},
+		Templates: gitTemplates,
+	})
+}
+
+// GitPayload is the payload of GitHub and Gitea webhooks, as far as the
+// default templates use it. Fields missing in an event are empty.
+type GitPayload struct {
+	Action      string        `json:"action"`
+	Ref         string        `json:"ref"`
+	RefType     string        `json:"ref_type"`    // Of create and delete events: branch or tag
+	Compare     string        `json:"compare"`     // GitHub
+	CompareURL  string        `json:"compare_url"` // Gitea
+	Commits     []GitCommit   `json:"commits"`
+	Repository  GitRepository `json:"repository"`
+	Sender      GitUser       `json:"sender"`
+	PullRequest *GitIssue     `json:"pull_request"`
+	Issue       *GitIssue     `json:"issue"`
+	Comment     *GitComment   `json:"comment"`
+	Release     *GitRelease   `json:"release"`
+}
+
+// GitCommit is a pushed commit.
+type GitCommit struct {
+	ID      string  `json:"id"`
+	Message string  `json:"message"`
+	URL     string  `json:"url"`
+	Author  GitUser `json:"author"`
+}
+
+// GitRepository is the repository of an event.
+type GitRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+// GitUser is the sender of an event or the author of a commit.
+type GitUser struct {
+	Login    string `json:"login"`
+	Name     string `json:"name"`     // Of commit authors
+	Username string `json:"username"` // Of commit authors
+}
+
+// GitIssue is an issue or pull request.
+type GitIssue struct {
+	Number  int     `json:"number"`
+	Title   string  `json:"title"`
+	HTMLURL string  `json:"html_url"`
+	State   string  `json:"state"`
+	Merged  bool    `json:"merged"`
+	User    GitUser `json:"user"`
+}
+
+// GitComment is a comment on an issue or pull request.
+type GitComment struct {
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+}
+
+// GitRelease is a published release.
+type GitRelease struct {
+	TagName string `json:"tag_name"`
+	Name    string `json:"name"`
+	HTMLURL string `json:"html_url"`
+}
+
+// Branch returns the branch or tag name of the ref.
+func (p *GitPayload) Branch() string {
+	return strings.TrimPrefix(strings.TrimPrefix(p.Ref, "refs/heads/"), "refs/tags/")
+}
+
+// CompareLink returns the URL comparing the commits of a push.
+func (p *GitPayload) CompareLink() string {
+	if p.Compare != "" {
+		return p.Compare
+	}
+	return p.CompareURL
+}
+
+// RepoLink returns a Markdown link to the repository.
+func (p *GitPayload) RepoLink() string {
+	if p.Repository.HTMLURL == "" {
+		return p.Repository.FullName
+	}
+	return "[" + p.Repository.FullName + "](" + p.Repository.HTMLURL + ")"
+}
+
+// Verb describes the action in a sentence, e.g. "merged" for a closed and
+// merged pull request.
+func (p *GitPayload) Verb() string {
+	switch p.Action {
+	case "closed":
+		if p.PullRequest != nil && p.PullRequest.Merged {
+			return "merged"
+		}
+	case "synchronize", "synchronized":
+		return "updated"
+	}
+	return strings.ReplaceAll(p.Action, "_", " ")
+}
+
+// Short returns the abbreviated commit hash.
+func (c GitCommit) Short() string {
+	return c.ID[:min(len(c.ID), 7)]
+}
+
+// Title returns the first line of the commit message.
+func (c GitCommit) Title() string {
+	title, _, _ := strings.Cut(c.Message, "\n")
+	return strings.TrimSpace(title)
+}
+
+// Title returns the name of the release, or its tag.
+func (r GitRelease) Title() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.TagName
+}
+
+// parseGit decodes a GitHub or Gitea payload, sent as JSON or as the payload
+// field of a form.
+func parseGit(eventType string, r *http.Request, body []byte) (*Event, error) {
+	if eventType == "" {
+		return nil, fmt.Errorf("webhook: missing event type header")
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("webhook: invalid form: %w", err)
+		}
+		body = []byte(form.Get("payload"))
+	}
+	payload := &GitPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, fmt.Errorf("webhook: invalid %s payload: %w", eventType, err)
+	}
+	fields := map[string]string{"repo": payload.Repository.FullName, "sender": payload.Sender.Login}
+	if payload.Ref != "" {
+		fields["branch"] = payload.Branch()
+	}
+	return &Event{Type: eventType, Action: payload.Action, Fields: fields, Data: payload}, nil
+}
+
+// Default templates of the Git formats.
+const (
+	gitPullRequestTemplate = "**{{.Data.Sender.Login}}** {{.Data.Verb}} pull request " +
+		"[#{{.Data.PullRequest.Number}} {{.Data.PullRequest.Title}}]({{.Data.PullRequest.HTMLURL}}) in {{.Data.RepoLink}}"
+	gitIssueTemplate = "**{{.Data.Sender.Login}}** {{.Data.Verb}} issue " +
+		"[#{{.Data.Issue.Number}} {{.Data.Issue.Title}}]({{.Data.Issue.HTMLURL}}) in {{.Data.RepoLink}}"
+)
+
+// gitTemplates are the default templates of the Git formats. Other actions,
+// e.g. labels and assignments, aren't posted unless a hook adds templates.
+var gitTemplates = map[string]string{
+	"push": "{{with .Data}}{{if .Commits}}**{{.Sender.Login}}** pushed {{len .Commits}} commit{{if gt (len .Commits) 1}}s{{end}} " +
+		"to {{.RepoLink}} `{{.Branch}}`{{with .CompareLink}} ([compare]({{.}})){{end}}" +
+		"{{range .Commits}}\n- [`{{.Short}}`]({{.URL}}) {{truncate 100 .Title}}{{end}}{{end}}{{end}}",
+	"pull_request.opened":   gitPullRequestTemplate,
+	"pull_request.closed":   gitPullRequestTemplate,
+	"pull_request.reopened": gitPullRequestTemplate,
+	"issues.opened":         gitIssueTemplate,
+	"issues.closed":         gitIssueTemplate,
+	"issues.reopened":       gitIssueTemplate,
+	"issue_comment.created": "**{{.Data.Sender.Login}}** commented on " +
+		"[#{{.Data.Issue.Number}} {{.Data.Issue.Title}}]({{.Data.Comment.HTMLURL}}) in {{.Data.RepoLink}}:\n\n" +
+		"> {{truncate 300 (firstLine .Data.Comment.Body)}}",
+	"release.published": "**{{.Data.Sender.Login}}** released [{{.Data.Release.Title}}]({{.Data.Release.HTMLURL}}) of {{.Data.RepoLink}}",
+	"create":            "**{{.Data.Sender.Login}}** created {{.Data.RefType}} `{{.Data.Ref}}` in {{.Data.RepoLink}}",
+	"delete":            "**{{.Data.Sender.Login}}** deleted {{.Data.RefType}} `{{.Data.Ref}}` in {{.Data.RepoLink}}",
+	"ping":              "Webhook **{{.Hook}}** is connected{{with .Data.RepoLink}} to {{.}}{{end}}.",
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testSecret = "s3cret"
+
+// sign returns the hex HMAC-SHA256 of body with secret.
+func sign(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyGit(t *testing.T) {
+	const body = `{"ref":"refs/heads/main"}`
+	tests := []struct {
+		name   string
+		format string
+		header string
+		value  string
+		ok     bool
+	}{
+		{"github", FormatGitHub, "X-Hub-Signature-256", "sha256=" + sign(body, testSecret), true},
+		{"github without prefix", FormatGitHub, "X-Hub-Signature-256", sign(body, testSecret), false},
+		{"github other secret", FormatGitHub, "X-Hub-Signature-256", "sha256=" + sign(body, "other"), false},
+		{"github unsigned", FormatGitHub, "", "", false},
+		{"github not hex", FormatGitHub, "X-Hub-Signature-256", "sha256=zz", false},
+		{"gitea", FormatGitea, "X-Gitea-Signature", sign(body, testSecret), true},
+		{"gitea other body", FormatGitea, "X-Gitea-Signature", sign(body+" ", testSecret), false},
+		{"gitea github header", FormatGitea, "X-Hub-Signature-256", "sha256=" + sign(body, testSecret), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			err := formats[tt.format].Verify(r, []byte(body), testSecret)
+			if tt.ok && err != nil {
+				t.Errorf("Verify() = %v, want nil", err)
+			} else if !tt.ok && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify() = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestParseGit(t *testing.T) {
+	const payload = `{"ref":"refs/heads/main","repository":{"full_name":"acme/app"},"sender":{"login":"alice"},
+		"commits":[{"id":"0123456789abcdef","message":"Fix login\n\nDetails"}]}`
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json", "application/json", payload},
+		{"form", "application/x-www-form-urlencoded", "payload=" + url.QueryEscape(payload)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/hook", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("X-GitHub-Event", "push")
+			evt, err := formats[FormatGitHub].Parse(r, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if evt.Type != "push" || evt.Fields["repo"] != "acme/app" || evt.Fields["branch"] != "main" || evt.Fields["sender"] != "alice" {
+				t.Errorf("event = %+v", evt)
+			}
+			data := evt.Data.(*GitPayload)
+			if len(data.Commits) != 1 || data.Commits[0].Short() != "0123456" || data.Commits[0].Title() != "Fix login" {
+				t.Errorf("commits = %+v", data.Commits)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("POST", "/hook", strings.NewReader("{}"))
+	if _, err := formats[FormatGitHub].Parse(r, []byte("{}")); err == nil {
+		t.Error("Parse() without event header succeeded")
+	}
+	r.Header.Set("X-GitHub-Event", "push")
+	if _, err := formats[FormatGitHub].Parse(r, []byte("{")); err == nil {
+		t.Error("Parse() of invalid JSON succeeded")
+	}
+}
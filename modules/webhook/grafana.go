@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatGrafana reads Grafana unified alerting webhooks. The contact point
+// authenticates with the hook's secret as "Authorization: Bearer <secret>",
+// as basic auth password, or as HMAC signing secret
+// (X-Grafana-Alerting-Signature), with a timestamp at most 5 minutes off if
+// Grafana sends one. Panel images of alerts, available with
+// Grafana's image rendering, are posted inline.
+const FormatGrafana = "grafana"
+
+func init() {
+	RegisterFormat(FormatGrafana, &Format{
+		Verify:    verifyGrafana,
+		Parse:     parseGrafana,
+		Templates: grafanaTemplates,
+	})
+}
+
+// GrafanaPayload is the payload of a Grafana alerting webhook: a group of alerts.
+type GrafanaPayload struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"` // firing or resolved
+	Title             string            `json:"title"`
+	Message           string            `json:"message"`
+	Alerts            []GrafanaAlert    `json:"alerts"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+}
+
+// GrafanaAlert is an alert of a group.
+type GrafanaAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	ValueString  string            `json:"valueString"`
+	GeneratorURL string            `json:"generatorURL"`
+	SilenceURL   string            `json:"silenceURL"`
+	DashboardURL string            `json:"dashboardURL"`
+	PanelURL     string            `json:"panelURL"`
+	ImageURL     string            `json:"imageURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// Heading returns the title of the group, or its alert name.
+func (p *GrafanaPayload) Heading() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.CommonLabels["alertname"]
+}
+
+// Name returns the alert name.
+func (a GrafanaAlert) Name() string {
+	return a.Labels["alertname"]
+}
+
+// Summary returns the summary annotation, or the description.
+func (a GrafanaAlert) Summary() string {
+	if summary := a.Annotations["summary"]; summary != "" {
+		return summary
+	}
+	return a.Annotations["description"]
+}
+
+// Resolved reports whether the alert is resolved.
+func (a GrafanaAlert) Resolved() bool {
+	return a.Status == "resolved"
+}
+
+// grafanaMaxAge is how far the signature timestamp of a request may be off
+// the current time, so captured requests can't be replayed later.
+const grafanaMaxAge = 5 * time.Minute
+
+// verifyGrafana checks the HMAC signature of a request, and its timestamp if
+// signed with one, or else its credentials.
+func verifyGrafana(r *http.Request, body []byte, secret string) error {
+	if signature := r.Header.Get("X-Grafana-Alerting-Signature"); signature != "" {
+		if timestamp := r.Header.Get("X-Grafana-Alerting-Signature-Timestamp"); timestamp != "" {
+			seconds, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: invalid timestamp", ErrInvalidSignature)
+			}
+			if age := time.Since(time.Unix(seconds, 0)); age > grafanaMaxAge || age < -grafanaMaxAge {
+				return fmt.Errorf("%w: timestamp off by %s", ErrInvalidSignature, age.Round(time.Second))
+			}
+			body = append([]byte(timestamp+":"), body...)
+		}
+		return verifyHMAC(signature, "", body, secret)
+	}
+	credentials, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, credentials, ok = r.BasicAuth()
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(credentials), []byte(secret)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// parseGrafana decodes a Grafana alerting payload into an "alert" event with
+// the status as action. The common labels are routed as fields, e.g. severity.
+func parseGrafana(_ *http.Request, body []byte) (*Event, error) {
+	payload := &GrafanaPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, fmt.Errorf("webhook: invalid grafana payload: %w", err)
+	}
+	fields := map[string]string{"receiver": payload.Receiver}
+	for name, value := range payload.CommonLabels {
+		fields[strings.ToLower(name)] = value
+	}
+	event := &Event{Type: "alert", Action: payload.Status, Fields: fields, Data: payload}
+	for _, alert := range payload.Alerts {
+		if alert.ImageURL != "" && !alert.Resolved() {
+			event.Images = append(event.Images, Image{URL: alert.ImageURL, Name: "panel.png", Caption: alert.Name()})
+		}
+	}
+	return event, nil
+}
+
+// grafanaTemplate renders the alerts of a group with their values and links.
+const grafanaTemplate = "{{with .Data}}{{if eq .Status \"resolved\"}}✅{{else}}🔥{{end}} **{{.Heading}}**" +
+	"{{range .Alerts}}\n- {{if .Resolved}}✅{{else}}🔥{{end}} **{{.Name}}**{{with .Summary}}: {{.}}{{end}}" +
+	"{{with .ValueString}} `{{truncate 200 .}}`{{end}}{{with .PanelURL}} ([panel]({{.}})){{end}}" +
+	"{{if not .Resolved}}{{with .SilenceURL}} ([silence]({{.}})){{end}}{{end}}{{end}}{{end}}"
+
+// grafanaTemplates are the default templates of the Grafana format.
+var grafanaTemplates = map[string]string{
+	"alert": grafanaTemplate,
+}
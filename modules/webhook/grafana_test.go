@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyGrafana(t *testing.T) {
+	const body = `{"status":"firing"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		basic   string // Basic auth password
+		ok      bool
+	}{
+		{"signature", map[string]string{"X-Grafana-Alerting-Signature": sign(body, testSecret)}, "", true},
+		{"signature with timestamp", map[string]string{
+			"X-Grafana-Alerting-Signature":           sign(now+":"+body, testSecret),
+			"X-Grafana-Alerting-Signature-Timestamp": now,
+		}, "", true},
+		{"signature ignoring timestamp", map[string]string{
+			"X-Grafana-Alerting-Signature":           sign(body, testSecret),
+			"X-Grafana-Alerting-Signature-Timestamp": now,
+		}, "", false},
+		{"stale timestamp", map[string]string{
+			"X-Grafana-Alerting-Signature":           sign(stale+":"+body, testSecret),
+			"X-Grafana-Alerting-Signature-Timestamp": stale,
+		}, "", false},
+		{"invalid timestamp", map[string]string{
+			"X-Grafana-Alerting-Signature":           sign("soon:"+body, testSecret),
+			"X-Grafana-Alerting-Signature-Timestamp": "soon",
+		}, "", false},
+		{"invalid signature beats valid token", map[string]string{
+			"X-Grafana-Alerting-Signature": sign(body, "other"),
+			"Authorization":                "Bearer " + testSecret,
+		}, "", false},
+		{"bearer token", map[string]string{"Authorization": "Bearer " + testSecret}, "", true},
+		{"wrong bearer token", map[string]string{"Authorization": "Bearer other"}, "", false},
+		{"basic auth", nil, testSecret, true},
+		{"wrong basic auth", nil, "other", false},
+		{"unauthenticated", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/grafana", strings.NewReader(body))
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if tt.basic != "" {
+				r.SetBasicAuth("grafana", tt.basic)
+			}
+			if err := verifyGrafana(r, []byte(body), testSecret); (err == nil) != tt.ok {
+				t.Errorf("verifyGrafana() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseGrafana(t *testing.T) {
+	const body = `{"receiver":"matrix","status":"firing","commonLabels":{"Severity":"critical"},"alerts":[
+		{"status":"firing","labels":{"alertname":"HighCPU"},"imageURL":"https://grafana.example.com/cpu.png"},
+		{"status":"resolved","labels":{"alertname":"DiskFull"},"imageURL":"https://grafana.example.com/disk.png"}]}`
+	evt, err := parseGrafana(nil, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Type != "alert" || evt.Action != "firing" || evt.Fields["receiver"] != "matrix" || evt.Fields["severity"] != "critical" {
+		t.Errorf("event = %+v", evt)
+	}
+	// Only firing alerts post their panel image
+	if len(evt.Images) != 1 || evt.Images[0].URL != "https://grafana.example.com/cpu.png" || evt.Images[0].Caption != "HighCPU" {
+		t.Errorf("images = %+v", evt.Images)
+	}
+	if _, err = parseGrafana(nil, []byte("[")); err == nil {
+		t.Error("parseGrafana() of invalid JSON succeeded")
+	}
+}
@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+
+	matrix "github.com/eslider/go-matrix-bot"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// minTokenLength is the shortest token accepted for a Client.
+const minTokenLength = 16
+
+// Client is a script or service posting messages with PostHandler, e.g. a
+// cron job reporting a backup.
+type Client struct {
+	Name  string   `yaml:"name" doc:"Identifies the client in logs"`
+	Token string   `yaml:"token" doc:"Secret in the URL: POST /hook/<token>"`
+	Rooms []string `yaml:"rooms" doc:"Rooms the client may post to, IDs or aliases; the first is the default; * for any room"`
+}
+
+// PostRequest is the JSON body of a request to PostHandler:
+//
+//	curl -d '{"room": "#ops:example.com", "markdown": "Backup **done**"}' https://bot.example.com/hook/<token>
+type PostRequest struct {
+	Room     string `json:"room"`     // Room ID or alias (default: the client's first room)
+	Text     string `json:"text"`     // Plain text
+	Markdown string `json:"markdown"` // Formatted text, sent instead of Text
+	MsgType  string `json:"msgtype"`  // m.text (default), m.notice or m.emote
+}
+
+// PostResponse is the JSON response of PostHandler.
+type PostResponse struct {
+	EventID id.EventID `json:"event_id,omitempty"`
+	Queued  bool       `json:"queued,omitempty"` // The homeserver is unreachable; the message is sent later
+}
+
+// PostHandler returns the HTTP handler posting messages for clients that
+// don't speak the Matrix API. The last segment of the path is the token of a
+// Client, so it can be mounted under any prefix:
+//
+//	http.Handle("/hook/", hooks.PostHandler())
+func (m *Module) PostHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		client := m.client(path.Base(r.URL.Path))
+		if client == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req PostRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxPayloadSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, err := req.content()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Room == "" && len(client.Rooms) > 0 && client.Rooms[0] != "*" {
+			req.Room = client.Rooms[0]
+		}
+		if req.Room == "" {
+			http.Error(w, "room is required", http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		roomID, err := m.bot.ResolveRoom(ctx, req.Room)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !m.allowed(r, client, roomID) {
+			http.Error(w, "room not allowed", http.StatusForbidden)
+			return
+		}
+
+		log := m.bot.Log().With().Str("client", client.Name).Str("room_id", roomID.String()).Logger()
+		resp := PostResponse{}
+		resp.EventID, err = m.bot.SendMessage(ctx, roomID, content)
+		switch {
+		case errors.Is(err, matrix.ErrQueued):
+			resp.Queued = true
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+		case err != nil:
+			log.Warn().Err(err).Msg("Failed to post webhook message")
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		default:
+			log.Debug().Str("event_id", resp.EventID.String()).Msg("Posted webhook message")
+			w.Header().Set("Content-Type", "application/json")
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// content returns the message content of a request.
+func (req *PostRequest) content() (*event.MessageEventContent, error) {
+	msgType := event.MessageType(req.MsgType)
+	switch msgType {
+	case "":
+		msgType = event.MsgText
+	case event.MsgText, event.MsgNotice, event.MsgEmote:
+	default:
+		return nil, errors.New("msgtype must be m.text, m.notice or m.emote")
+	}
+	switch {
+	case req.Markdown != "":
+		return &event.MessageEventContent{
+			MsgType:       msgType,
+			Body:          req.Markdown,
+			Format:        event.FormatHTML,
+			FormattedBody: matrix.MarkdownToHTML(req.Markdown),
+		}, nil
+	case req.Text != "":
+		return &event.MessageEventContent{MsgType: msgType, Body: req.Text}, nil
+	}
+	return nil, errors.New("text or markdown is required")
+}
+
+// client returns the client with a token, or nil. All tokens are compared in
+// constant time.
+func (m *Module) client(token string) *Client {
+	var found *Client
+	for i := range m.config.Clients {
+		if subtle.ConstantTimeCompare([]byte(m.config.Clients[i].Token), []byte(token)) == 1 {
+			found = &m.config.Clients[i]
+		}
+	}
+	return found
+}
+
+// allowed reports whether a client may post to a room.
+func (m *Module) allowed(r *http.Request, client *Client, roomID id.RoomID) bool {
+	if slices.Contains(client.Rooms, "*") {
+		return true
+	}
+	for _, room := range client.Rooms {
+		if allowedID, err := m.bot.ResolveRoom(r.Context(), room); err == nil && allowedID == roomID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+// Package webhook posts events of other services — GitHub and Gitea pushes,
+// pull requests, issues and releases, Grafana alerts with their panel
+// images — into Matrix rooms. Each hook is an
+// endpoint for one service, verifies the payload signatures with its secret
+// and renders the events with Markdown templates per event type, so a team
+// working on GitHub and Gitea at once can follow both in one room.
+//
+// Usage:
+//
+//	hooks := webhook.New(webhook.Config{Hooks: []webhook.Hook{{
+//		Name:   "github",
+//		Format: webhook.FormatGitHub,
+//		Secret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
+//		Rooms:  []string{"#dev:example.com"},
+//	}, {
+//		Name:   "gitea",
+//		Format: webhook.FormatGitea,
+//		Secret: os.Getenv("GITEA_WEBHOOK_SECRET"),
+//		Rooms:  []string{"#dev:example.com"},
+//		Events: []string{"push", "pull_request.*"},
+//	}}})
+//	if err := bot.Use(hooks); err != nil { ... }
+//	http.Handle("/hooks/", hooks.Handler()) // GitHub posts to /hooks/github
+//
+// Scripts and cron jobs post messages through the bot without speaking the
+// Matrix API, authenticated by a token in the URL, see PostHandler:
+//
+//	hooks := webhook.New(webhook.Config{Clients: []webhook.Client{{
+//		Name:  "backup",
+//		Token: os.Getenv("BACKUP_HOOK_TOKEN"),
+//		Rooms: []string{"#ops:example.com"},
+//	}}})
+//	http.Handle("/hook/", hooks.PostHandler())
+//
+//	curl -d '{"markdown": "Backup **done**"}' https://bot.example.com/hook/$BACKUP_HOOK_TOKEN
+//
+// Events are also routed with Bot.Route, with the fields source (the format),
+// hook, event, action, repo, sender and branch, so rules can send them to
+// further rooms, e.g. match {source: github, repo: "org/frontend"}.
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+
+	matrix "github.com/eslider/go-matrix-bot"
+)
+
+// maxPayloadSize limits the payloads accepted by the handler.
+const maxPayloadSize = 5 << 20
+
+// maxImageSize limits the images of events, see Event.Images.
+const maxImageSize = 10 << 20
+
+// Hook is an endpoint receiving the webhooks of one service.
+type Hook struct {
+	Name   string   `yaml:"name" doc:"Last path segment of the endpoint, e.g. github for /hooks/github"`
+	Format string   `yaml:"format" doc:"Payload format: github, gitea or grafana"`
+	Secret string   `yaml:"secret" doc:"Secret the payloads are signed with"`
+	Rooms  []string `yaml:"rooms" doc:"Rooms (IDs or aliases) the events are posted to"`
+	// Events limits the posted events to these types, e.g. "push" or
+	// "issues.opened", or glob patterns like "pull_request.*". Without events,
+	// all events with a template are posted.
+	Events []string `yaml:"events" doc:"Posted event types, e.g. push or issues.opened (default: all with a template)"`
+	// Templates render events in Markdown, keyed by "<event>.<action>" or
+	// "<event>", overriding the defaults of the format. The template data is
+	// the Event. An empty result posts nothing.
+	Templates map[string]string `yaml:"templates" doc:"Markdown templates by event type, e.g. push or issues.opened"`
+}
+
+// Config lists the hooks and the clients of PostHandler. It can also be set
+// in the "modules.webhook" section of the config file.
+type Config struct {
+	Hooks   []Hook   `yaml:"hooks" doc:"Endpoints with name, format, secret, rooms and optionally events and templates"`
+	Clients []Client `yaml:"clients" doc:"Scripts posting messages with POST /hook/<token>: name, token, rooms"`
+}
+
+// Validate implements matrix.ConfigValidator.
+func (c *Config) Validate() error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, hook := range c.Hooks {
+		field := fmt.Sprintf("hooks[%d]", i)
+		switch {
+		case hook.Name == "":
+			errs = append(errs, matrix.InvalidConfig(field+".name", "is required"))
+		case names[hook.Name]:
+			errs = append(errs, matrix.InvalidConfig(field+".name", "%q is used twice", hook.Name))
+		}
+		names[hook.Name] = true
+		format := formats[hook.Format]
+		if format == nil {
+			errs = append(errs, matrix.InvalidConfig(field+".format", "must be one of %s", strings.Join(FormatNames(), ", ")))
+		} else if format.Verify != nil && hook.Secret == "" {
+			errs = append(errs, matrix.InvalidConfig(field+".secret", "is required"))
+		}
+		for key, text := range hook.Templates {
+			if _, err := parseTemplate(key, text); err != nil {
+				errs = append(errs, matrix.InvalidConfig(field+".templates."+key, "%v", err))
+			}
+		}
+	}
+	tokens := make(map[string]bool)
+	for i, client := range c.Clients {
+		field := fmt.Sprintf("clients[%d]", i)
+		switch {
+		case len(client.Token) < minTokenLength:
+			errs = append(errs, matrix.InvalidConfig(field+".token", "must be at least %d characters", minTokenLength))
+		case tokens[client.Token]:
+			errs = append(errs, matrix.InvalidConfig(field+".token", "is used twice"))
+		}
+		tokens[client.Token] = true
+		if len(client.Rooms) == 0 {
+			errs = append(errs, matrix.InvalidConfig(field+".rooms", "is required; use * to allow all rooms"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Event is a webhook received by a hook.
+type Event struct {
+	Hook   string
+	Source string // Format of the hook, e.g. "github"
+	Type   string // e.g. "push" or "pull_request"
+	Action string // e.g. "opened", "" for events without actions
+	// Fields are routed with Bot.Route; source, hook, event and action are
+	// added to those of the format.
+	Fields map[string]string
+	Data   any     // Decoded payload, e.g. a *GitPayload
+	Images []Image // Posted to the rooms of the hook after the event, e.g. graphs
+}
+
+// Image is an image of an event, downloaded and uploaded to the rooms so it
+// shows inline.
+type Image struct {
+	URL     string
+	Name    string // File name, e.g. "panel.png"
+	Caption string
+}
+
+// hook is a configured hook with its parsed templates.
+type hook struct {
+	Hook
+	format    *Format
+	templates map[string]*template.Template
+}
+
+// Module receives webhooks and posts their events.
+type Module struct {
+	config Config
+	bot    *matrix.Bot
+	hooks  map[string]*hook
+}
+
+// New creates the webhook module.
+func New(config Config) *Module {
+	return &Module{config: config}
+}
+
+// Name implements matrix.Module.
+func (m *Module) Name() string {
+	return "webhook"
+}
+
+// ModuleConfig implements matrix.Configurable.
+func (m *Module) ModuleConfig() any {
+	return &m.config
+}
+
+// Init implements matrix.Module.
+func (m *Module) Init(b *matrix.Bot) error {
+	m.bot = b
+	m.hooks = make(map[string]*hook, len(m.config.Hooks))
+	for _, config := range m.config.Hooks {
+		format := formats[config.Format]
+		if format == nil {
+			return fmt.Errorf("webhook: %s: unknown format %q", config.Name, config.Format)
+		}
+		h := &hook{Hook: config, format: format, templates: make(map[string]*template.Template)}
+		for key, text := range format.Templates {
+			if h.templates[key], _ = parseTemplate(key, text); h.templates[key] == nil {
+				return fmt.Errorf("webhook: invalid default template %s of %s", key, config.Format)
+			}
+		}
+		for key, text := range config.Templates {
+			tmpl, err := parseTemplate(key, text)
+			if err != nil {
+				return fmt.Errorf("webhook: %s: %w", config.Name, err)
+			}
+			h.templates[key] = tmpl
+		}
+		m.hooks[config.Name] = h
+	}
+	return nil
+}
+
+// Handler returns the HTTP handler receiving the webhooks via POST. The last
+// segment of the path selects the hook, so it can be mounted under any prefix.
+func (m *Module) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h := m.hooks[path.Base(r.URL.Path)]
+		if h == nil {
+			http.Error(w, "unknown hook", http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxPayloadSize {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if h.format.Verify != nil {
+			if err = h.format.Verify(r, body, h.Secret); err != nil {
+				m.bot.Log().Warn().Err(err).Str("hook", h.Name).Str("remote", r.RemoteAddr).Msg("Rejected webhook")
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+		}
+		event, err := h.format.Parse(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		event.Hook, event.Source = h.Name, h.Format
+		if err = m.post(r.Context(), h, event); err != nil {
+			m.bot.ReportError(r.Context(), "Posting webhook "+h.Name+" failed", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// post renders an event and delivers it to the rooms of its hook and the
+// targets of matching rules. Events filtered out or without a template are
+// skipped.
+func (m *Module) post(ctx context.Context, h *hook, event *Event) error {
+	key := event.Type
+	if event.Action != "" {
+		key += "." + event.Action
+	}
+	log := m.bot.Log().With().Str("hook", h.Name).Str("event", key).Logger()
+	if !h.wants(event) {
+		log.Debug().Msg("Skipping webhook event")
+		return nil
+	}
+	tmpl := h.templates[key]
+	if tmpl == nil {
+		tmpl = h.templates[event.Type]
+	}
+	if tmpl == nil {
+		log.Debug().Msg("No template for webhook event")
+		return nil
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, event); err != nil {
+		return fmt.Errorf("webhook: template %s: %w", key, err)
+	}
+	md := strings.TrimSpace(sb.String())
+	if md == "" {
+		return nil
+	}
+	log.Debug().Msg("Posting webhook event")
+
+	report := &matrix.Report{Markdown: md, Data: event.Data}
+	var targets []matrix.Target
+	for _, room := range h.Rooms {
+		targets = append(targets, matrix.RoomTarget(room))
+	}
+	err := m.bot.Deliver(ctx, report, targets...)
+	fields := map[string]string{"source": event.Source, "hook": event.Hook, "event": event.Type, "action": event.Action}
+	for name, value := range event.Fields {
+		fields[name] = value
+	}
+	_, routeErr := m.bot.Route(ctx, fields, report)
+	return errors.Join(err, routeErr, m.postImages(ctx, h, event))
+}
+
+// postImages downloads the images of an event and uploads them to the rooms of its hook.
+func (m *Module) postImages(ctx context.Context, h *hook, event *Event) error {
+	if len(event.Images) == 0 || len(h.Rooms) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, image := range event.Images {
+		data, contentType, err := m.download(ctx, image.URL)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, room := range h.Rooms {
+			roomID, err := m.bot.ResolveRoom(ctx, room)
+			if err == nil {
+				err = m.bot.SendFileWithCaption(ctx, roomID, image.Name, contentType, data, image.Caption)
+			}
+			if err != nil && !errors.Is(err, matrix.ErrQueued) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// download fetches an image of an event.
+func (m *Module) download(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("webhook: invalid image URL: %w", err)
+	}
+	resp, err := m.bot.HTTPClient().Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("webhook: failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("webhook: failed to download image: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("webhook: failed to download image: %w", err)
+	}
+	if len(data) > maxImageSize {
+		return nil, "", fmt.Errorf("webhook: image %s is larger than %d bytes", url, maxImageSize)
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("webhook: %s is no image but %s", url, contentType)
+	}
+	return data, contentType, nil
+}
+
+// wants reports whether the events of the hook include an event.
+func (h *hook) wants(event *Event) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, pattern := range h.Events {
+		if ok, _ := path.Match(pattern, event.Type); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, event.Type+"."+event.Action); ok && event.Action != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFuncs are available in event templates.
+var templateFuncs = template.FuncMap{
+	"firstLine": func(s string) string {
+		line, _, _ := strings.Cut(s, "\n")
+		return strings.TrimSpace(line)
+	},
+	"truncate": func(n int, s string) string {
+		if runes := []rune(s); len(runes) > n {
+			return string(runes[:n]) + "…"
+		}
+		return s
+	},
+}
+
+// parseTemplate parses an event template.
+func parseTemplate(key, text string) (*template.Template, error) {
+	return template.New(key).Funcs(templateFuncs).Parse(text)
+}
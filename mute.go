@@ -0,0 +1,80 @@
+package matrix
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// MutedCommandsSetting is the room setting listing the commands and modules
+// muted in a room, comma-separated, e.g. "ai,meet".
+const MutedCommandsSetting = "commands.muted"
+
+// MutedCommands returns the commands and modules muted in a room.
+func (b *Bot) MutedCommands(ctx context.Context, roomID id.RoomID) ([]string, error) {
+	value, err := b.RoomSetting(ctx, roomID, MutedCommandsSetting)
+	if err != nil {
+		return nil, err
+	}
+	var muted []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			muted = append(muted, name)
+		}
+	}
+	return muted, nil
+}
+
+// MuteCommand mutes a command or all commands of a module in a room. Muted
+// commands are ignored by the command router; other rooms are not affected.
+func (b *Bot) MuteCommand(ctx context.Context, roomID id.RoomID, name string, mutedBy id.UserID) error {
+	muted, err := b.MutedCommands(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	name = b.commandName(ctx, roomID, name)
+	if slices.Contains(muted, name) {
+		return nil
+	}
+	return b.SetRoomSetting(ctx, roomID, MutedCommandsSetting, strings.Join(append(muted, name), ","), mutedBy)
+}
+
+// UnmuteCommand reverts MuteCommand.
+func (b *Bot) UnmuteCommand(ctx context.Context, roomID id.RoomID, name string, unmutedBy id.UserID) error {
+	muted, err := b.MutedCommands(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	name = b.commandName(ctx, roomID, name)
+	return b.SetRoomSetting(ctx, roomID, MutedCommandsSetting, strings.Join(slices.DeleteFunc(muted, func(m string) bool {
+		return m == name
+	}), ","), unmutedBy)
+}
+
+// commandMuted reports whether a command is muted in a room, by its own name
+// or its module's, or its module is disabled there.
+func (b *Bot) commandMuted(ctx context.Context, roomID id.RoomID, cmd *Command) bool {
+	if cmd.AlwaysOn {
+		return false
+	}
+	if cmd.Module != "" && !b.ModuleEnabled(ctx, roomID, cmd.Module) {
+		return true
+	}
+	muted, err := b.MutedCommands(ctx, roomID)
+	if err != nil {
+		return false // Without settings storage nothing can be muted
+	}
+	return slices.Contains(muted, cmd.Name) || (cmd.Module != "" && slices.Contains(muted, cmd.Module))
+}
+
+// commandName normalizes a command or module name given by a user: without
+// prefix, lowercased, and an alias replaced by the command it stands for.
+func (b *Bot) commandName(ctx context.Context, roomID id.RoomID, name string) string {
+	name = strings.ToLower(b.trimCommandPrefix(ctx, roomID, name))
+	if cmd := b.resolveCommand(ctx, roomID, name); cmd != nil {
+		return cmd.Name
+	}
+	return name
+}
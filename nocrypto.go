@@ -0,0 +1,61 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrNoCrypto is returned when sending to an encrypted room while the bot
+// runs without encryption after a failed crypto setup, see Config.CryptoFallback.
+var ErrNoCrypto = errors.New("matrix: encryption is unavailable, refusing to send to an encrypted room")
+
+// disableCrypto continues without end-to-end encryption after the crypto setup
+// failed with cause. The bot is logged in if the failure happened before that.
+// It keeps working in unencrypted rooms; encrypted rooms stay silent.
+func (b *Bot) disableCrypto(ctx context.Context, cause error) error {
+	b.crypto = nil
+	b.client.Crypto = nil
+	b.cryptoErr = cause
+	if b.client.AccessToken == "" {
+		var err error
+		if b.config.AccessToken != "" {
+			err = b.useAccessToken(ctx)
+		} else {
+			err = b.login(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("%w (after crypto setup failed: %v)", err, cause)
+		}
+	}
+
+	b.log.Error().Err(cause).Msg("!!! ENCRYPTION IS DISABLED: crypto setup failed, running in plaintext-only mode. " +
+		"Encrypted rooms are ignored until the crypto store is repaired and the bot restarted.")
+	md := fmt.Sprintf("⚠️ **Encryption is disabled.** Crypto setup failed, so the bot runs in plaintext-only mode "+
+		"and ignores encrypted rooms until it is repaired and restarted.\n\n`%v`", cause)
+	b.NotifyAdmin(ctx, md)
+	return nil
+}
+
+// CryptoError returns why end-to-end encryption is disabled, or nil if it works.
+func (b *Bot) CryptoError() error {
+	return b.cryptoErr
+}
+
+// checkCrypto refuses to send to encrypted rooms while encryption is disabled,
+// as messages would otherwise leak into them unencrypted.
+func (b *Bot) checkCrypto(ctx context.Context, roomID id.RoomID) error {
+	if b.cryptoErr == nil {
+		return nil
+	}
+	encrypted, err := b.IsEncrypted(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return ErrNoCrypto
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package matrix
+
+import (
+	"context"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ReceiptHandler is called when a user has read a room up to an event.
+type ReceiptHandler func(ctx context.Context, roomID id.RoomID, eventID id.EventID, userID id.UserID)
+
+// SentHandler is called when the homeserver has accepted a message of the bot.
+type SentHandler func(ctx context.Context, roomID id.RoomID, eventID id.EventID, content *event.MessageEventContent)
+
+// OnReceipt registers a handler for the read receipts of room members, e.g. to
+// follow up on messages nobody has read. Handlers run during sync and should
+// return quickly.
+func (b *Bot) OnReceipt(handler ReceiptHandler) {
+	b.receiptHandlers = append(b.receiptHandlers, handler)
+}
+
+// OnMessageSent registers a handler for the messages the bot sends, including
+// each part of a split message and those delivered later from the outbox.
+// Handlers run in the sending goroutine and should return quickly.
+func (b *Bot) OnMessageSent(handler SentHandler) {
+	b.sentHandlers = append(b.sentHandlers, handler)
+}
+
+// dispatchReceipt calls the receipt handlers for every read receipt of an event.
+func (b *Bot) dispatchReceipt(ctx context.Context, evt *event.Event) {
+	if len(b.receiptHandlers) == 0 {
+		return
+	}
+	for eventID, receipts := range *evt.Content.AsReceipt() {
+		for _, receiptType := range []event.ReceiptType{event.ReceiptTypeRead, event.ReceiptTypeReadPrivate} {
+			for userID := range receipts[receiptType] {
+				for _, handler := range b.receiptHandlers {
+					handler(ctx, evt.RoomID, eventID, userID)
+				}
+			}
+		}
+	}
+}
+
+// messageSent calls the sent handlers for a delivered message.
+func (b *Bot) messageSent(ctx context.Context, roomID id.RoomID, eventID id.EventID, content *event.MessageEventContent) {
+	for _, handler := range b.sentHandlers {
+		handler(ctx, roomID, eventID, content)
+	}
+}
@@ -0,0 +1,248 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// outboxMaxBackoff caps the delay between delivery attempts of a queued message.
+	outboxMaxBackoff = 5 * time.Minute
+	// outboxMaxAge is how long a queued message is retried before it is dropped.
+	outboxMaxAge = 24 * time.Hour
+	// outboxInlineGrace keeps the worker away from a message while its first
+	// delivery attempt runs in the caller.
+	outboxInlineGrace = time.Minute
+)
+
+// ErrQueued is returned (wrapped) by SendMessage and the Send* helpers when the
+// homeserver couldn't be reached and the message was saved in the outbox for
+// later delivery, see Config.Outbox. Callers may treat it as success.
+var ErrQueued = errors.New("matrix: message queued in the outbox for later delivery")
+
+// outboxSkipKey marks contexts whose messages bypass the outbox.
+type outboxSkipKey struct{}
+
+// withoutOutbox sends messages directly, for callers that need the event ID
+// right away (e.g. to schedule the redaction of an ephemeral message).
+func withoutOutbox(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outboxSkipKey{}, true)
+}
+
+// sendPart sends one event of a message, through the outbox if it is enabled
+// or the homeserver is unavailable, see Bot.Degraded. Messages to encrypted
+// rooms are sent directly, so the outbox never holds them in plaintext.
+func (b *Bot) sendPart(ctx context.Context, roomID id.RoomID, part *event.MessageEventContent) (id.EventID, error) {
+	skip, _ := ctx.Value(outboxSkipKey{}).(bool)
+	if !(b.config.Outbox || b.Degraded()) || b.db == nil || skip {
+		return b.deliver(ctx, roomID, "", part)
+	}
+	if encrypted, err := b.IsEncrypted(ctx, roomID); err != nil || encrypted {
+		return b.deliver(ctx, roomID, "", part)
+	}
+	return b.sendViaOutbox(ctx, roomID, part)
+}
+
+// deliver sends an event in the room's send queue and records it in the audit log.
+// A transaction ID makes retries of the same message idempotent.
+func (b *Bot) deliver(ctx context.Context, roomID id.RoomID, txnID string, part *event.MessageEventContent) (id.EventID, error) {
+	var resp *mautrix.RespSendEvent
+	err := b.enqueueSend(ctx, roomID, func(ctx context.Context) (err error) {
+		resp, err = b.client.SendMessageEvent(ctx, roomID, event.EventMessage, part, mautrix.ReqSendEvent{TransactionID: txnID})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	b.audit(ctx, AuditEntry{
+		Direction: AuditOutbound,
+		RoomID:    roomID,
+		EventID:   resp.EventID,
+		Sender:    b.client.UserID,
+		Body:      part.Body,
+		Time:      time.Now(),
+	})
+	b.messageSent(ctx, roomID, resp.EventID, part)
+	return resp.EventID, nil
+}
+
+// sendViaOutbox saves a message in the outbox and tries to deliver it right
+// away, unless earlier messages to the room are still waiting. Messages that
+// can't be delivered for now stay in the outbox for the worker.
+func (b *Bot) sendViaOutbox(ctx context.Context, roomID id.RoomID, part *event.MessageEventContent) (id.EventID, error) {
+	data, err := json.Marshal(part)
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to encode message: %w", err)
+	}
+	var waiting int
+	if err = b.db.QueryRow(ctx, "SELECT COUNT(*) FROM bot_outbox WHERE room_id=$1", roomID).Scan(&waiting); err != nil {
+		return "", fmt.Errorf("matrix: failed to read outbox: %w", err)
+	}
+	now := time.Now()
+	nextAttempt := now
+	if waiting == 0 {
+		nextAttempt = now.Add(outboxInlineGrace)
+	}
+	txnID := b.client.TxnID()
+	_, err = b.db.Exec(ctx, `INSERT INTO bot_outbox (txn_id, room_id, content, created_at, attempts, next_attempt)
+		VALUES ($1, $2, $3, $4, 0, $5)`, txnID, roomID, string(data), now.UnixMilli(), nextAttempt.UnixMilli())
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to queue message: %w", err)
+	}
+	if waiting > 0 {
+		b.wakeOutbox()
+		return "", ErrQueued
+	}
+
+	eventID, err := b.deliver(ctx, roomID, txnID, part)
+	dbCtx := context.WithoutCancel(ctx)
+	switch {
+	case err == nil:
+		_, _ = b.db.Exec(dbCtx, "DELETE FROM bot_outbox WHERE txn_id=$1", txnID)
+		return eventID, nil
+	case isTransient(err):
+		_, _ = b.db.Exec(dbCtx, "UPDATE bot_outbox SET attempts=1, next_attempt=$1 WHERE txn_id=$2",
+			time.Now().Add(outboxBackoff(1)).UnixMilli(), txnID)
+		b.wakeOutbox()
+		return "", fmt.Errorf("%w: %v", ErrQueued, err)
+	default:
+		_, _ = b.db.Exec(dbCtx, "DELETE FROM bot_outbox WHERE txn_id=$1", txnID)
+		return "", err
+	}
+}
+
+// isTransient reports whether a send failed for a reason that may go away:
+// the homeserver is unreachable, overloaded or rate-limiting, or the caller gave up waiting.
+func isTransient(err error) bool {
+	if errors.Is(err, mautrix.MLimitExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Response == nil || httpErr.Response.StatusCode >= 500
+	}
+	return false
+}
+
+// outboxBackoff returns the delay before the next delivery attempt.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 8 {
+		return outboxMaxBackoff
+	}
+	return min(time.Duration(1<<attempts)*time.Second, outboxMaxBackoff)
+}
+
+// wakeOutbox makes the outbox worker look for due messages.
+func (b *Bot) wakeOutbox() {
+	select {
+	case b.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// PendingOutbox returns the number of messages waiting in the outbox.
+func (b *Bot) PendingOutbox(ctx context.Context) (int, error) {
+	if b.db == nil {
+		return 0, ErrNoDatabase
+	}
+	var count int
+	if err := b.db.QueryRow(ctx, "SELECT COUNT(*) FROM bot_outbox").Scan(&count); err != nil {
+		return 0, fmt.Errorf("matrix: failed to read outbox: %w", err)
+	}
+	return count, nil
+}
+
+// runOutbox delivers queued messages until ctx is cancelled.
+func (b *Bot) runOutbox(ctx context.Context) {
+	for {
+		wait := b.deliverOutbox(ctx)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-b.outboxWake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// outboxMessage is a row of bot_outbox.
+type outboxMessage struct {
+	txnID       string
+	roomID      id.RoomID
+	content     string
+	createdAt   int64
+	attempts    int
+	nextAttempt int64
+}
+
+// deliverOutbox tries to deliver all due messages, oldest first, and returns
+// how long to wait for the next one. A room's messages are delivered in order:
+// while one waits for a retry, later ones to the same room wait too.
+func (b *Bot) deliverOutbox(ctx context.Context) time.Duration {
+	rows, err := b.db.Query(ctx, `SELECT txn_id, room_id, content, created_at, attempts, next_attempt
+		FROM bot_outbox ORDER BY created_at, txn_id`)
+	if err != nil {
+		b.log.Warn().Err(err).Msg("Failed to load outbox")
+		return outboxMaxBackoff
+	}
+	var queued []outboxMessage
+	for rows.Next() {
+		var msg outboxMessage
+		if err = rows.Scan(&msg.txnID, &msg.roomID, &msg.content, &msg.createdAt, &msg.attempts, &msg.nextAttempt); err == nil {
+			queued = append(queued, msg)
+		}
+	}
+	_ = rows.Close()
+
+	now := time.Now()
+	wait := time.Hour
+	blocked := make(map[id.RoomID]bool)
+	for _, msg := range queued {
+		if ctx.Err() != nil {
+			return 0
+		}
+		if blocked[msg.roomID] {
+			continue
+		}
+		if due := time.UnixMilli(msg.nextAttempt); due.After(now) {
+			blocked[msg.roomID] = true
+			wait = min(wait, due.Sub(now))
+			continue
+		}
+
+		log := b.log.With().Str("room_id", msg.roomID.String()).Str("txn_id", msg.txnID).Logger()
+		var content event.MessageEventContent
+		if err = json.Unmarshal([]byte(msg.content), &content); err != nil {
+			log.Error().Err(err).Msg("Dropping invalid message from the outbox")
+			_, _ = b.db.Exec(ctx, "DELETE FROM bot_outbox WHERE txn_id=$1", msg.txnID)
+			continue
+		}
+		_, err = b.deliver(ctx, msg.roomID, msg.txnID, &content)
+		switch {
+		case err == nil:
+			log.Info().Int("attempts", msg.attempts+1).Msg("Delivered queued message")
+			_, _ = b.db.Exec(ctx, "DELETE FROM bot_outbox WHERE txn_id=$1", msg.txnID)
+		case isTransient(err) && now.Sub(time.UnixMilli(msg.createdAt)) < outboxMaxAge:
+			backoff := outboxBackoff(msg.attempts + 1)
+			log.Warn().Err(err).Dur("retry_in", backoff).Msg("Failed to deliver queued message")
+			_, _ = b.db.Exec(ctx, "UPDATE bot_outbox SET attempts=attempts+1, next_attempt=$1 WHERE txn_id=$2",
+				now.Add(backoff).UnixMilli(), msg.txnID)
+			blocked[msg.roomID] = true
+			wait = min(wait, backoff)
+		default:
+			log.Error().Err(err).Int("attempts", msg.attempts+1).Msg("Dropping undeliverable message from the outbox")
+			_, _ = b.db.Exec(ctx, "DELETE FROM bot_outbox WHERE txn_id=$1", msg.txnID)
+		}
+	}
+	return max(wait, time.Second)
+}
@@ -0,0 +1,93 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// newOutboxBot creates a bot with the outbox enabled whose homeserver fails
+// to send messages with the given status.
+func newOutboxBot(t *testing.T, status int) *Bot {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/send/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN","error":"unavailable"}`))
+	}))
+	t.Cleanup(srv.Close)
+	b := newTestBot(t, Config{Outbox: true, BreakerThreshold: -1})
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.StateStore = mautrix.NewMemoryStateStore()
+	b.client = client
+	return b
+}
+
+func TestOutboxQueuesTransientFailures(t *testing.T) {
+	ctx := context.Background()
+	b := newOutboxBot(t, http.StatusBadGateway)
+	if err := b.SendText(ctx, "!room:example.com", "hello"); !errors.Is(err, ErrQueued) {
+		t.Fatalf("SendText() = %v, want ErrQueued", err)
+	}
+	if pending, err := b.PendingOutbox(ctx); err != nil || pending != 1 {
+		t.Errorf("PendingOutbox() = %d, %v, want 1", pending, err)
+	}
+}
+
+func TestOutboxDropsPermanentFailures(t *testing.T) {
+	ctx := context.Background()
+	b := newOutboxBot(t, http.StatusForbidden)
+	if err := b.SendText(ctx, "!room:example.com", "hello"); err == nil || errors.Is(err, ErrQueued) {
+		t.Fatalf("SendText() = %v, want a permanent error", err)
+	}
+	if pending, _ := b.PendingOutbox(ctx); pending != 0 {
+		t.Errorf("PendingOutbox() = %d, want 0", pending)
+	}
+}
+
+func TestOutboxSkipsEncryptedRooms(t *testing.T) {
+	ctx := context.Background()
+	b := newOutboxBot(t, http.StatusBadGateway)
+	const roomID = id.RoomID("!secret:example.com")
+	err := b.client.StateStore.SetEncryptionEvent(ctx, roomID, &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.SendText(ctx, roomID, "secret"); err == nil || errors.Is(err, ErrQueued) {
+		t.Fatalf("SendText() = %v, want the send error", err)
+	}
+	var stored int
+	if err = b.db.QueryRow(ctx, "SELECT COUNT(*) FROM bot_outbox WHERE content LIKE '%secret%'").Scan(&stored); err != nil || stored != 0 {
+		t.Errorf("message to an encrypted room stored in the outbox: %d rows, %v", stored, err)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 2 * time.Second},
+		{4, 16 * time.Second},
+		{9, outboxMaxBackoff},
+		{100, outboxMaxBackoff},
+	} {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package matrix
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// CommandPrefixSetting is the room setting replacing the command prefixes in
+// a room, space-separated, e.g. "?" where another bot already uses "!".
+const CommandPrefixSetting = "commands.prefix"
+
+// CommandAliasesSetting is the room setting adding command aliases in a room,
+// comma-separated, e.g. "i=issues,t=tasks".
+const CommandAliasesSetting = "commands.aliases"
+
+// Alias registers other names the command can be run with, e.g. "i" for "issues".
+func (c *Command) Alias(names ...string) *Command {
+	for _, name := range names {
+		c.Aliases = append(c.Aliases, strings.ToLower(name))
+	}
+	return c
+}
+
+// CommandPrefixes returns the prefixes starting commands in a room: those of
+// the CommandPrefixSetting, or else Config.CommandPrefix and
+// Config.CommandPrefixes. The first one is the one to show users.
+func (b *Bot) CommandPrefixes(ctx context.Context, roomID id.RoomID) []string {
+	if value, err := b.RoomSetting(ctx, roomID, CommandPrefixSetting); err == nil {
+		if prefixes := strings.Fields(value); len(prefixes) > 0 {
+			return prefixes
+		}
+	}
+	return append([]string{b.commandPrefix()}, b.config.CommandPrefixes...)
+}
+
+// CommandAliases returns the aliases defined for a room with the
+// CommandAliasesSetting, mapping each alias to a command name.
+func (b *Bot) CommandAliases(ctx context.Context, roomID id.RoomID) (map[string]string, error) {
+	value, err := b.RoomSetting(ctx, roomID, CommandAliasesSetting)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		alias, name, ok := strings.Cut(entry, "=")
+		alias, name = strings.ToLower(strings.TrimSpace(alias)), strings.ToLower(strings.TrimSpace(name))
+		if ok && alias != "" && name != "" {
+			aliases[alias] = name
+		}
+	}
+	return aliases, nil
+}
+
+// resolveCommand returns the command a name refers to in a room. Command names
+// take precedence over the room's aliases, then Config.CommandAliases, then
+// the aliases registered with Command.Alias.
+func (b *Bot) resolveCommand(ctx context.Context, roomID id.RoomID, name string) *Command {
+	if cmd := b.lookupCommand(name); cmd != nil {
+		return cmd
+	}
+	if aliases, err := b.CommandAliases(ctx, roomID); err == nil && aliases[name] != "" {
+		return b.lookupCommand(aliases[name])
+	}
+	if target := b.config.CommandAliases[name]; target != "" {
+		return b.lookupCommand(strings.ToLower(target))
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, cmd := range b.commands {
+		if slices.Contains(cmd.Aliases, name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// trimCommandPrefix removes any of the room's command prefixes from a command name.
+func (b *Bot) trimCommandPrefix(ctx context.Context, roomID id.RoomID, name string) string {
+	for _, prefix := range b.CommandPrefixes(ctx, roomID) {
+		if trimmed, ok := strings.CutPrefix(name, prefix); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// longestFirst orders prefixes so that "!!" is tried before "!".
+func longestFirst(prefixes []string) []string {
+	return slices.SortedStableFunc(slices.Values(prefixes), func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+}
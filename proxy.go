@@ -0,0 +1,30 @@
+package matrix
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// proxyTransport returns the HTTP transport used for the homeserver and all
+// outbound requests unless Config.HTTPClient is set. Without Config.ProxyURL the standard HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY environment variables apply.
+func (c Config) proxyTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.ProxyURL == "" {
+		transport.Proxy = http.ProxyFromEnvironment
+		return transport, nil
+	}
+
+	proxyURL, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: invalid proxy URL: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("matrix: unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return transport, nil
+}
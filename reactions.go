@@ -0,0 +1,175 @@
+package matrix
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// reactionCacheSize is the number of events whose reactions are kept up to date from sync.
+const reactionCacheSize = 1000
+
+// Reaction is the aggregated count of one reaction key on an event.
+type Reaction struct {
+	Key     string      // Reaction key, usually an emoji like "👍"
+	Count   int         // Number of users who reacted with Key
+	Senders []id.UserID // Users who reacted with Key, in the order they reacted
+}
+
+// reactionCache keeps the reactions of recently requested events, so that
+// repeated GetReactions calls (e.g. to tally a vote) don't page through the
+// relations endpoint every time. Cached events are updated from sync.
+type reactionCache struct {
+	mu      sync.Mutex
+	tallies map[id.EventID]*reactionTally
+	targets map[id.EventID]id.EventID // Reaction event ID to the event it reacts to
+	order   []id.EventID              // Cached events, oldest first, for eviction
+}
+
+// reactionTally holds all reactions to one event.
+type reactionTally struct {
+	reactions map[id.EventID]reactionRef
+	order     []id.EventID // Reaction event IDs in the order they were seen
+}
+
+type reactionRef struct {
+	key    string
+	sender id.UserID
+}
+
+func newReactionCache() *reactionCache {
+	return &reactionCache{
+		tallies: make(map[id.EventID]*reactionTally),
+		targets: make(map[id.EventID]id.EventID),
+	}
+}
+
+// add records a reaction to a cached event. Callers must hold mu.
+func (t *reactionTally) add(reactionID id.EventID, key string, sender id.UserID) {
+	if _, ok := t.reactions[reactionID]; ok {
+		return
+	}
+	t.reactions[reactionID] = reactionRef{key: key, sender: sender}
+	t.order = append(t.order, reactionID)
+}
+
+// handleEvent applies new reactions and redacted reactions to cached events.
+func (c *reactionCache) handleEvent(_ context.Context, evt *event.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch evt.Type {
+	case event.EventReaction:
+		relates := evt.Content.AsReaction().GetRelatesTo()
+		if tally, ok := c.tallies[relates.EventID]; ok && relates.Key != "" {
+			tally.add(evt.ID, relates.Key, evt.Sender)
+			c.targets[evt.ID] = relates.EventID
+		}
+	case event.EventRedaction:
+		redacts := evt.Redacts
+		if redacts == "" {
+			redacts = evt.Content.AsRedaction().Redacts
+		}
+		if target, ok := c.targets[redacts]; ok {
+			delete(c.tallies[target].reactions, redacts)
+			delete(c.targets, redacts)
+		}
+	}
+}
+
+// store caches the reactions fetched for an event, keeping any reactions that
+// arrived via sync in the meantime.
+func (c *reactionCache) store(eventID id.EventID, reactions []*event.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tally, ok := c.tallies[eventID]
+	if !ok {
+		tally = &reactionTally{reactions: make(map[id.EventID]reactionRef)}
+		c.tallies[eventID] = tally
+		c.order = append(c.order, eventID)
+		if len(c.order) > reactionCacheSize {
+			c.evict(c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	for _, evt := range reactions {
+		tally.add(evt.ID, evt.Content.AsReaction().GetRelatesTo().Key, evt.Sender)
+		c.targets[evt.ID] = eventID
+	}
+}
+
+// evict removes an event and its reactions from the cache. Callers must hold mu.
+func (c *reactionCache) evict(eventID id.EventID) {
+	if tally, ok := c.tallies[eventID]; ok {
+		for reactionID := range tally.reactions {
+			delete(c.targets, reactionID)
+		}
+		delete(c.tallies, eventID)
+	}
+}
+
+// summary aggregates the cached reactions of an event per key.
+func (c *reactionCache) summary(eventID id.EventID) ([]Reaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tally, ok := c.tallies[eventID]
+	if !ok {
+		return nil, false
+	}
+
+	byKey := make(map[string]*Reaction)
+	var reactions []*Reaction
+	seen := make(map[reactionRef]bool)
+	for _, reactionID := range tally.order {
+		ref, ok := tally.reactions[reactionID]
+		if !ok || seen[ref] {
+			continue // Redacted, or a duplicate reaction by the same user
+		}
+		seen[ref] = true
+		reaction, ok := byKey[ref.key]
+		if !ok {
+			reaction = &Reaction{Key: ref.key}
+			byKey[ref.key] = reaction
+			reactions = append(reactions, reaction)
+		}
+		reaction.Count++
+		reaction.Senders = append(reaction.Senders, ref.sender)
+	}
+
+	summary := make([]Reaction, len(reactions))
+	for i, reaction := range reactions {
+		summary[i] = *reaction
+	}
+	// Most popular first; ties keep the order in which keys were first used.
+	sort.SliceStable(summary, func(i, j int) bool {
+		return summary[i].Count > summary[j].Count
+	})
+	return summary, true
+}
+
+// GetReactions returns the reactions to an event aggregated per key, most
+// popular first. Each user is counted once per key. The first call for an
+// event pages through the relations endpoint; the result is then kept up to
+// date from sync, so calling it repeatedly (e.g. to tally votes) is cheap.
+func (b *Bot) GetReactions(ctx context.Context, roomID id.RoomID, eventID id.EventID) ([]Reaction, error) {
+	if summary, ok := b.reactions.summary(eventID); ok {
+		return summary, nil
+	}
+
+	related, err := b.GetRelations(ctx, roomID, eventID, event.RelAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	var reactions []*event.Event
+	for _, evt := range related {
+		if evt.Type == event.EventReaction && evt.Content.AsReaction().GetRelatesTo().Key != "" {
+			reactions = append(reactions, evt)
+		}
+	}
+
+	b.reactions.store(eventID, reactions)
+	summary, _ := b.reactions.summary(eventID)
+	return summary, nil
+}
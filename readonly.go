@@ -0,0 +1,72 @@
+package matrix
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ErrReadOnly is returned for any attempt to change a room while Config.ReadOnly is set.
+var ErrReadOnly = errors.New("matrix: bot is in read-only mode")
+
+// readOnlyBlocked lists client-server API path segments of requests that are
+// visible to other users. Sync, key management, filters and downloads pass.
+var readOnlyBlocked = []string{
+	"/send/", "/state/", "/redact/", "/invite", "/kick", "/ban", "/unban",
+	"/leave", "/forget", "/createRoom", "/receipt/", "/read_markers", "/typing/",
+	"/presence/", "/profile/", "/directory/", "/upgrade", "/report/",
+}
+
+// mediaUpload matches the media upload endpoints, but not the key uploads
+// (/keys/upload, /keys/signatures/upload) that encryption needs.
+var mediaUpload = regexp.MustCompile(`^/_matrix/(media/[^/]+|client/v1/media)/(upload|create)(/|$)`)
+
+// readOnlyTransport refuses Matrix requests that would post or change anything
+// in a room, so read-only mode holds for every module, not just the Send* helpers.
+// Joining rooms stays possible, as observing a room requires being in it.
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead &&
+		strings.HasPrefix(req.URL.Path, "/_matrix/") && readOnlyDenied(req.URL.Path) {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, ErrReadOnly
+	}
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections forwards to the wrapped transport, see http.Client.CloseIdleConnections.
+func (t readOnlyTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+func readOnlyDenied(path string) bool {
+	if mediaUpload.MatchString(path) {
+		return true
+	}
+	for _, segment := range readOnlyBlocked {
+		if strings.Contains(path, segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyClient wraps client so that it can't change rooms.
+func readOnlyClient(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = readOnlyTransport{next: next}
+	return &wrapped
+}
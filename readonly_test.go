@@ -0,0 +1,35 @@
+package matrix
+
+import "testing"
+
+func TestReadOnlyDenied(t *testing.T) {
+	tests := []struct {
+		path   string
+		denied bool
+	}{
+		{"/_matrix/client/v3/rooms/!a:b/send/m.room.message/txn1", true},
+		{"/_matrix/client/v3/rooms/!a:b/state/m.room.topic/", true},
+		{"/_matrix/client/v3/rooms/!a:b/redact/$e/txn", true},
+		{"/_matrix/client/v3/rooms/!a:b/receipt/m.read/$e", true},
+		{"/_matrix/client/v3/createRoom", true},
+		{"/_matrix/media/v3/upload", true},
+		{"/_matrix/media/r0/upload", true},
+		{"/_matrix/media/v1/create", true},
+		{"/_matrix/media/v3/upload/example.com/abc", true},
+		{"/_matrix/client/v1/media/upload", true},
+		{"/_matrix/client/v3/keys/upload", false},
+		{"/_matrix/client/v3/keys/device_signing/upload", false},
+		{"/_matrix/client/v3/keys/signatures/upload", false},
+		{"/_matrix/client/v3/keys/query", false},
+		{"/_matrix/client/v3/keys/claim", false},
+		{"/_matrix/client/v3/sendToDevice/m.room.encrypted/txn", false},
+		{"/_matrix/client/v3/room_keys/version", false},
+		{"/_matrix/client/v3/join/!a:b", false},
+		{"/_matrix/client/v3/user/@bot:b/filter", false},
+	}
+	for _, tt := range tests {
+		if got := readOnlyDenied(tt.path); got != tt.denied {
+			t.Errorf("readOnlyDenied(%q) = %v, want %v", tt.path, got, tt.denied)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// GetRelations returns all events relating to an event with the given
+// relation type (e.g. event.RelThread, event.RelReplace, event.RelAnnotation),
+// oldest first. An empty relType returns relations of any type. All pages are
+// fetched and encrypted events are decrypted; events that can't be decrypted
+// are skipped.
+func (b *Bot) GetRelations(ctx context.Context, roomID id.RoomID, eventID id.EventID, relType event.RelationType) ([]*event.Event, error) {
+	var events []*event.Event
+	req := &mautrix.ReqGetRelations{RelationType: relType, Dir: mautrix.DirectionForward, Limit: 100}
+	for {
+		resp, err := b.client.GetRelations(ctx, roomID, eventID, req)
+		if err != nil {
+			return nil, fmt.Errorf("matrix: failed to get relations: %w", err)
+		}
+		for _, evt := range resp.Chunk {
+			evt.RoomID = roomID
+			if evt, err = b.parseEvent(ctx, evt); err != nil {
+				b.log.Debug().Err(err).
+					Str("room_id", roomID.String()).
+					Str("event_id", eventID.String()).
+					Msg("Skipping unreadable related event")
+				continue
+			}
+			events = append(events, evt)
+		}
+		if resp.NextBatch == "" {
+			return events, nil
+		}
+		req.From = resp.NextBatch
+	}
+}
@@ -0,0 +1,287 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxContentSize is the largest message content the bot sends in one event.
+// Homeservers reject events over 64 KiB, and encryption inflates the content
+// by about a third, so this leaves room for both.
+const maxContentSize = 40000
+
+// minSplitLength stops splitting parts whose rendered HTML is still too large.
+const minSplitLength = 1000
+
+// MinMessageLength is the smallest Config.MaxMessageLength.
+const MinMessageLength = 100
+
+// maxPreviewLength is the longest caption of a message uploaded as a file, see Config.MaxMessageParts.
+const maxPreviewLength = 500
+
+// RenderStats describes the sizes of formatted messages sent by the bot, to
+// spot Markdown that expands badly (tables, long lists) before it hits limits.
+type RenderStats struct {
+	Messages      int64 // Formatted messages sent
+	BodyBytes     int64 // Total size of their plain-text bodies
+	HTMLBytes     int64 // Total size of their formatted bodies
+	MaxHTMLBytes  int64 // Largest formatted body
+	Split         int64 // Messages split into several events because they were too large or long
+	PlainFallback int64 // Messages sent without formatting because the HTML didn't fit
+	Files         int64 // Messages uploaded as a file because they needed too many parts
+}
+
+// renderMetrics collects RenderStats.
+type renderMetrics struct {
+	messages, bodyBytes, htmlBytes, maxHTMLBytes, split, plainFallback, files atomic.Int64
+}
+
+// record adds a formatted message to the metrics.
+func (m *renderMetrics) record(content *event.MessageEventContent) {
+	if content.Format != event.FormatHTML {
+		return
+	}
+	size := int64(len(content.FormattedBody))
+	m.messages.Add(1)
+	m.bodyBytes.Add(int64(len(content.Body)))
+	m.htmlBytes.Add(size)
+	for {
+		largest := m.maxHTMLBytes.Load()
+		if size <= largest || m.maxHTMLBytes.CompareAndSwap(largest, size) {
+			return
+		}
+	}
+}
+
+// RenderStats returns the sizes of formatted messages sent since start.
+func (b *Bot) RenderStats() RenderStats {
+	return RenderStats{
+		Messages:      b.renders.messages.Load(),
+		BodyBytes:     b.renders.bodyBytes.Load(),
+		HTMLBytes:     b.renders.htmlBytes.Load(),
+		MaxHTMLBytes:  b.renders.maxHTMLBytes.Load(),
+		Split:         b.renders.split.Load(),
+		PlainFallback: b.renders.plainFallback.Load(),
+		Files:         b.renders.files.Load(),
+	}
+}
+
+// contentSize returns the size of a message content as sent.
+func contentSize(content *event.MessageEventContent) int {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// fitContent returns the events to send for a message content. Text messages
+// that would exceed the event size limit or Config.MaxMessageLength are split
+// between paragraphs, code blocks or lines, and each part's HTML is rendered
+// from its Markdown body again. Edits and media can't be split; they are sent
+// without formatting if that makes them fit.
+func (b *Bot) fitContent(roomID id.RoomID, content *event.MessageEventContent) []*event.MessageEventContent {
+	b.renders.record(content)
+	size := contentSize(content)
+	splittable := (content.MsgType == event.MsgText || content.MsgType == event.MsgNotice) && content.NewContent == nil
+	long := splittable && b.config.MaxMessageLength > 0 && len(content.Body) > b.config.MaxMessageLength
+	if size <= maxContentSize && !long {
+		return []*event.MessageEventContent{content}
+	}
+	log := b.log.With().
+		Str("room_id", roomID.String()).
+		Int("content_bytes", size).
+		Int("body_bytes", len(content.Body)).
+		Int("html_bytes", len(content.FormattedBody)).
+		Logger()
+
+	if !splittable {
+		plain := withoutFormatting(content)
+		if contentSize(plain) <= maxContentSize {
+			b.renders.plainFallback.Add(1)
+			log.Warn().Msg("Message too large with formatting, sending it as plain text")
+			return []*event.MessageEventContent{plain}
+		}
+		log.Warn().Msg("Message too large and can't be split")
+		return []*event.MessageEventContent{content}
+	}
+
+	parts := splitContent(content, maxContentSize, b.config.MaxMessageLength)
+	b.renders.split.Add(1)
+	if size > maxContentSize {
+		log.Warn().Int("parts", len(parts)).Msg("Message too large, splitting it")
+	} else {
+		log.Debug().Int("parts", len(parts)).Msg("Message too long, splitting it")
+	}
+	return parts
+}
+
+// sendAsFile uploads the body of a text message as a Markdown file, captioned
+// with its beginning, instead of sending it in more than Config.MaxMessageParts parts.
+func (b *Bot) sendAsFile(ctx context.Context, roomID id.RoomID, content *event.MessageEventContent) (id.EventID, error) {
+	preview := ""
+	if chunks := splitMarkdown(content.Body, maxPreviewLength); len(chunks) > 0 {
+		preview = chunks[0] + "\n\n…"
+	}
+	file, err := b.uploadFile(ctx, roomID, "message.md", "text/markdown", []byte(content.Body), preview)
+	if err != nil {
+		return "", err
+	}
+	file.RelatesTo, file.Mentions = content.RelatesTo, content.Mentions
+	b.renders.files.Add(1)
+	return b.sendPart(ctx, roomID, file)
+}
+
+// withoutFormatting returns a copy of content without HTML, including its new content if it is an edit.
+func withoutFormatting(content *event.MessageEventContent) *event.MessageEventContent {
+	plain := *content
+	plain.Format, plain.FormattedBody = "", ""
+	if content.NewContent != nil {
+		newContent := *content.NewContent
+		newContent.Format, newContent.FormattedBody = "", ""
+		plain.NewContent = &newContent
+	}
+	return &plain
+}
+
+// splitContent splits a text message into parts that each fit into limit, and
+// whose bodies are at most maxBody bytes long if it is positive. The first part
+// keeps the relation (e.g. the reply) and mentions; later parts stay in the
+// same thread. Parts are only formatted if the HTML was rendered from the
+// Markdown body, so it can be rendered again per part; other HTML, e.g.
+// written by hand, would be replaced by the rendering of the body, so those
+// parts are sent as plain text.
+func splitContent(content *event.MessageEventContent, limit, maxBody int) []*event.MessageEventContent {
+	formatted := content.Format == event.FormatHTML && content.FormattedBody == MarkdownToHTML(content.Body)
+	var parts []*event.MessageEventContent
+	var split func(md string, maxLen int)
+	split = func(md string, maxLen int) {
+		for _, chunk := range splitMarkdown(md, maxLen) {
+			part := &event.MessageEventContent{MsgType: content.MsgType, Body: chunk}
+			if formatted {
+				part.Format, part.FormattedBody = event.FormatHTML, MarkdownToHTML(chunk)
+			}
+			if size := contentSize(part); size > limit {
+				if maxLen > minSplitLength {
+					// The HTML expanded more than the rest, e.g. a table
+					n := size/limit + 1
+					split(chunk, len(chunk)/n*11/10)
+					continue
+				}
+				part = withoutFormatting(part)
+			}
+			parts = append(parts, part)
+		}
+	}
+	// Size the parts by how much the whole message expanded when rendered.
+	maxLen := limit / 2
+	if size := contentSize(content); size > 0 && len(content.Body) > 0 {
+		maxLen = min(maxLen, int(int64(limit)*int64(len(content.Body))/int64(size))*9/10)
+	}
+	maxLen = max(maxLen, minSplitLength)
+	if maxBody > 0 {
+		maxLen = min(maxLen, maxBody)
+	}
+	split(content.Body, maxLen)
+
+	if len(parts) > 0 {
+		parts[0].RelatesTo = content.RelatesTo
+		parts[0].Mentions = content.Mentions
+	}
+	if root := content.RelatesTo.GetThreadParent(); root != "" {
+		for _, part := range parts[1:] {
+			part.RelatesTo = (&event.RelatesTo{}).SetThread(root, root)
+		}
+	}
+	return parts
+}
+
+// splitMarkdown splits Markdown into chunks of at most maxLen bytes. Chunks
+// end between paragraphs, before headings or around code blocks if that
+// fills at least a third of them, and at line breaks otherwise. Code blocks cut
+// by a split are closed and reopened and tables get their header again, so
+// every chunk renders on its own. Lines longer than maxLen are cut.
+func splitMarkdown(md string, maxLen int) []string {
+	var chunks []string
+	var sb strings.Builder
+	fence := ""  // Opening line of the code block the current line is in
+	header := "" // Header and delimiter row of the table the current line is in
+	previous := ""
+	boundary := 0 // Length of sb at the last paragraph or code block boundary
+	emit := func(chunk string) {
+		if chunk = strings.TrimRight(chunk, "\n"); strings.TrimSpace(chunk) != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	flush := func() {
+		chunk := sb.String()
+		sb.Reset()
+		boundary = 0
+		if fence != "" {
+			chunk += "```"
+			sb.WriteString(fence + "\n")
+		} else if header != "" {
+			sb.WriteString(header)
+		}
+		emit(chunk)
+	}
+	for _, line := range strings.Split(md, "\n") {
+		if sb.Len()+len(line)+1 > maxLen && boundary >= maxLen/3 {
+			// Keep the paragraph or code block after the boundary together
+			text := sb.String()
+			emit(text[:boundary])
+			sb.Reset()
+			sb.WriteString(strings.TrimLeft(text[boundary:], "\n"))
+			boundary = 0
+		}
+		if sb.Len()+len(line)+1 > maxLen {
+			flush()
+		}
+		trimmed := strings.TrimSpace(line)
+		if fence == "" && header == "" && sb.Len() > 0 && (strings.TrimSpace(previous) == "" ||
+			strings.HasPrefix(strings.TrimSpace(previous), "```") || strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "#")) {
+			boundary = sb.Len()
+		}
+		for len(line) > maxLen {
+			cut := maxLen
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(line) // maxLen is shorter than the rune
+			}
+			sb.WriteString(line[:cut])
+			flush()
+			line = line[cut:]
+		}
+		sb.WriteString(line + "\n")
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			if fence == "" {
+				fence = trimmed
+			} else if trimmed == "```" {
+				fence = ""
+			}
+		case fence != "":
+		case !strings.HasPrefix(trimmed, "|"):
+			header = ""
+		case isTableDelimiter(trimmed) && strings.HasPrefix(strings.TrimSpace(previous), "|"):
+			header = previous + "\n" + line + "\n"
+		}
+		previous = line
+	}
+	fence, header = "", ""
+	flush()
+	return chunks
+}
+
+// isTableDelimiter reports whether a line is the delimiter row of a Markdown table, e.g. "|---|:-:|".
+func isTableDelimiter(line string) bool {
+	return strings.Contains(line, "-") && strings.Trim(line, "|-: ") == ""
+}
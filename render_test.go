@@ -0,0 +1,67 @@
+package matrix
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestSplitMarkdownMultibyte(t *testing.T) {
+	tests := []struct {
+		md     string
+		maxLen int
+	}{
+		{"ä", 1},
+		{"äöü", 1},
+		{"日本語のテキスト", 2},
+		{"a😀b😀c", 3},
+		{strings.Repeat("ä", 50) + "\n\n" + strings.Repeat("é", 50), 7},
+	}
+	for _, tt := range tests {
+		chunks := splitMarkdown(tt.md, tt.maxLen) // Used to hang on runes longer than maxLen
+		joined := strings.Join(chunks, "")
+		if strings.ReplaceAll(joined, "\n", "") != strings.ReplaceAll(tt.md, "\n", "") {
+			t.Errorf("splitMarkdown(%q, %d) = %q, lost text", tt.md, tt.maxLen, chunks)
+		}
+		for _, chunk := range chunks {
+			if !utf8.ValidString(chunk) {
+				t.Errorf("splitMarkdown(%q, %d) cut a rune: %q", tt.md, tt.maxLen, chunk)
+			}
+		}
+	}
+}
+
+func TestValidateMaxMessageLength(t *testing.T) {
+	for length, ok := range map[int]bool{0: true, MinMessageLength: true, 8000: true, 1: false, MinMessageLength - 1: false, -1: false} {
+		config := Config{Homeserver: "https://matrix.example.com", AccessToken: "token", MaxMessageLength: length}
+		if err := config.Validate(); (err == nil) != ok {
+			t.Errorf("Validate() with MaxMessageLength %d = %v, want ok %v", length, err, ok)
+		}
+	}
+}
+
+func TestSplitContentFormatting(t *testing.T) {
+	md := strings.Repeat("Some **bold** words.\n\n", 40)
+	tests := []struct {
+		name      string
+		html      string
+		formatted bool
+	}{
+		{"rendered from Markdown", MarkdownToHTML(md), true},
+		{"hand-written HTML", "<p>" + strings.Repeat("Other <em>text</em>. ", 40) + "</p>", false},
+	}
+	for _, tt := range tests {
+		content := &event.MessageEventContent{MsgType: event.MsgText, Body: md, Format: event.FormatHTML, FormattedBody: tt.html}
+		parts := splitContent(content, maxContentSize, 200)
+		if len(parts) < 2 {
+			t.Fatalf("%s: got %d parts, want several", tt.name, len(parts))
+		}
+		for _, part := range parts {
+			if formatted := part.Format == event.FormatHTML; formatted != tt.formatted {
+				t.Errorf("%s: part formatted %v, want %v: %q", tt.name, formatted, tt.formatted, part.FormattedBody)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomInfo is cached metadata about a room the bot has joined.
+type RoomInfo struct {
+	ID          id.RoomID
+	Name        string
+	Topic       string
+	MemberCount int  // Joined members, including the bot
+	Encrypted   bool // Whether the room has end-to-end encryption enabled
+}
+
+// roomCache keeps room metadata up to date from sync, so listing rooms doesn't
+// require a state request per room.
+type roomCache struct {
+	mu    sync.RWMutex
+	rooms map[id.RoomID]*cachedRoom
+}
+
+type cachedRoom struct {
+	info    RoomInfo
+	members map[id.UserID]bool
+	// summaryCount is the server-provided joined count, which is authoritative
+	// when members are lazy-loaded and the member list is incomplete.
+	summaryCount int
+}
+
+func newRoomCache() *roomCache {
+	return &roomCache{rooms: make(map[id.RoomID]*cachedRoom)}
+}
+
+// room returns the cache entry for a room, creating it if needed. Callers must hold mu.
+func (c *roomCache) room(roomID id.RoomID) *cachedRoom {
+	room, ok := c.rooms[roomID]
+	if !ok {
+		room = &cachedRoom{info: RoomInfo{ID: roomID}, members: make(map[id.UserID]bool)}
+		c.rooms[roomID] = room
+	}
+	return room
+}
+
+// handleSync applies room summaries and removes rooms the bot has left.
+func (c *roomCache) handleSync(_ context.Context, resp *mautrix.RespSync, _ string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for roomID, data := range resp.Rooms.Join {
+		if data.Summary.JoinedMemberCount != nil {
+			c.room(roomID).summaryCount = *data.Summary.JoinedMemberCount
+		}
+	}
+	for roomID := range resp.Rooms.Leave {
+		delete(c.rooms, roomID)
+	}
+	return true
+}
+
+// handleEvent applies state events from joined rooms.
+func (c *roomCache) handleEvent(_ context.Context, evt *event.Event) {
+	if evt.StateKey == nil || evt.Mautrix.EventSource&event.SourceJoin == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	room := c.room(evt.RoomID)
+	switch evt.Type {
+	case event.StateRoomName:
+		room.info.Name = evt.Content.AsRoomName().Name
+	case event.StateTopic:
+		room.info.Topic = evt.Content.AsTopic().Topic
+	case event.StateEncryption:
+		room.info.Encrypted = true
+	case event.StateMember:
+		userID := id.UserID(evt.GetStateKey())
+		if evt.Content.AsMember().Membership == event.MembershipJoin {
+			room.members[userID] = true
+		} else {
+			delete(room.members, userID)
+		}
+	}
+}
+
+// get returns a copy of the cached info for a room.
+func (c *roomCache) get(roomID id.RoomID) (RoomInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	room, ok := c.rooms[roomID]
+	if !ok {
+		return RoomInfo{ID: roomID}, false
+	}
+	info := room.info
+	info.MemberCount = len(room.members)
+	if room.summaryCount > 0 {
+		info.MemberCount = room.summaryCount
+	}
+	return info, true
+}
+
+// JoinedRooms returns all rooms the bot is joined to, with name, topic and
+// member count taken from the sync cache. Only the room list itself is fetched
+// from the homeserver.
+func (b *Bot) JoinedRooms(ctx context.Context) ([]RoomInfo, error) {
+	resp, err := b.client.JoinedRooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to list joined rooms: %w", err)
+	}
+
+	rooms := make([]RoomInfo, 0, len(resp.JoinedRooms))
+	for _, roomID := range resp.JoinedRooms {
+		info, _ := b.rooms.get(roomID)
+		rooms = append(rooms, info)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Name != rooms[j].Name {
+			return rooms[i].Name < rooms[j].Name
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+	return rooms, nil
+}
+
+// RoomInfo returns cached metadata for a single room.
+// The second return value is false if the room hasn't been seen in sync yet.
+func (b *Bot) RoomInfo(roomID id.RoomID) (RoomInfo, bool) {
+	return b.rooms.get(roomID)
+}
@@ -0,0 +1,202 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// AccountDataRoomConfig is the room account data event holding the bot's
+// configuration of a room: a JSON object with one field per setting.
+var AccountDataRoomConfig = event.Type{Type: "com.github.eslider.matrix-bot.config", Class: event.AccountDataEventType}
+
+// RoomConfig is the typed configuration of one room, stored in the bot's room
+// account data on the homeserver. Unlike the database it survives losing the
+// bot's store and is shared by all replicas logged in as the bot user. Get one
+// with Bot.RoomConfig.
+//
+// Room settings (prefix, language, disabled modules, ... see SetRoomSetting)
+// are mirrored into it as strings, an empty one marking a removed setting.
+// Changes of those by other replicas, or found in the account data after the
+// database was lost, are applied to the room settings in the name of the bot,
+// so string values stored with Set become room settings too.
+type RoomConfig struct {
+	bot    *Bot
+	roomID id.RoomID
+}
+
+// roomConfigCache keeps the account data of rooms, updated from sync so that
+// changes made by other replicas are picked up.
+type roomConfigCache struct {
+	mu     sync.RWMutex
+	rooms  map[id.RoomID]map[string]json.RawMessage
+	writes sync.Mutex // Serializes read-modify-write cycles of Set
+}
+
+func newRoomConfigCache() *roomConfigCache {
+	return &roomConfigCache{rooms: make(map[id.RoomID]map[string]json.RawMessage)}
+}
+
+// handleEvent caches room config account data received from sync.
+func (c *roomConfigCache) handleEvent(_ context.Context, evt *event.Event) {
+	values := make(map[string]json.RawMessage)
+	if len(evt.Content.VeryRaw) > 0 {
+		if err := json.Unmarshal(evt.Content.VeryRaw, &values); err != nil {
+			return
+		}
+	}
+	c.mu.Lock()
+	c.rooms[evt.RoomID] = values
+	c.mu.Unlock()
+}
+
+// handleRoomConfigEvent caches room config account data received from sync
+// and applies the room settings in it that differ from the database.
+func (b *Bot) handleRoomConfigEvent(ctx context.Context, evt *event.Event) {
+	b.roomConfigs.handleEvent(ctx, evt)
+	if b.db == nil {
+		return
+	}
+	b.roomConfigs.mu.RLock()
+	values := b.roomConfigs.rooms[evt.RoomID]
+	b.roomConfigs.mu.RUnlock()
+	for key, raw := range values {
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			continue // Not a room setting
+		}
+		if err := b.setRoomSetting(ctx, evt.RoomID, key, value, b.client.UserID, false); err != nil {
+			b.log.Warn().Err(err).Str("room_id", evt.RoomID.String()).Str("setting", key).Msg("Failed to apply replicated room setting")
+		}
+	}
+}
+
+// mirrorRoomSetting writes a changed room setting into the RoomConfig of the
+// room. Failures are only logged, as the setting is saved in the database.
+func (b *Bot) mirrorRoomSetting(ctx context.Context, roomID id.RoomID, key, value string) {
+	if b.client == nil || b.client.UserID == "" {
+		return // Not logged in, e.g. while importing a configuration before Run
+	}
+	if err := b.RoomConfig(roomID).Set(ctx, key, value); err != nil {
+		b.log.Warn().Err(err).Str("room_id", roomID.String()).Str("setting", key).Msg("Failed to mirror room setting")
+	}
+}
+
+// RoomConfig returns the configuration of a room.
+func (b *Bot) RoomConfig(roomID id.RoomID) *RoomConfig {
+	return &RoomConfig{bot: b, roomID: roomID}
+}
+
+// values returns the settings of the room, fetching them if they aren't cached.
+func (c *RoomConfig) values(ctx context.Context) (map[string]json.RawMessage, error) {
+	cache := c.bot.roomConfigs
+	cache.mu.RLock()
+	values, ok := cache.rooms[c.roomID]
+	cache.mu.RUnlock()
+	if ok {
+		return values, nil
+	}
+	values, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	cache.rooms[c.roomID] = values
+	cache.mu.Unlock()
+	return values, nil
+}
+
+// fetch reads the settings of the room from the homeserver.
+func (c *RoomConfig) fetch(ctx context.Context) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	err := c.bot.client.GetRoomAccountData(ctx, c.roomID, AccountDataRoomConfig.Type, &values)
+	if errors.Is(err, mautrix.MNotFound) {
+		return make(map[string]json.RawMessage), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read room config: %w", err)
+	}
+	return values, nil
+}
+
+// Get decodes a setting into v and reports whether it is set.
+func (c *RoomConfig) Get(ctx context.Context, key string, v any) (bool, error) {
+	values, err := c.values(ctx)
+	if err != nil {
+		return false, err
+	}
+	raw, ok := values[key]
+	if !ok {
+		return false, nil
+	}
+	if err = json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("matrix: invalid room config %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// String returns a string setting, or def if it isn't set or isn't a string.
+func (c *RoomConfig) String(ctx context.Context, key, def string) string {
+	var value string
+	if ok, err := c.Get(ctx, key, &value); !ok || err != nil {
+		return def
+	}
+	return value
+}
+
+// Keys returns the names of all settings of the room, sorted.
+func (c *RoomConfig) Keys(ctx context.Context) ([]string, error) {
+	values, err := c.values(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Set saves a setting; a nil value removes it. The current settings are
+// re-read from the homeserver first, so changes by other replicas are kept.
+func (c *RoomConfig) Set(ctx context.Context, key string, v any) error {
+	var raw json.RawMessage
+	if v != nil {
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("matrix: invalid room config %q: %w", key, err)
+		}
+	}
+
+	cache := c.bot.roomConfigs
+	cache.writes.Lock()
+	defer cache.writes.Unlock()
+	values, err := c.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		delete(values, key)
+	} else {
+		values[key] = raw
+	}
+	if err = c.bot.client.SetRoomAccountData(ctx, c.roomID, AccountDataRoomConfig.Type, values); err != nil {
+		return fmt.Errorf("matrix: failed to save room config: %w", err)
+	}
+	cache.mu.Lock()
+	cache.rooms[c.roomID] = values
+	cache.mu.Unlock()
+	return nil
+}
+
+// Delete removes a setting.
+func (c *RoomConfig) Delete(ctx context.Context, key string) error {
+	return c.Set(ctx, key, nil)
+}
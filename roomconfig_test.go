@@ -0,0 +1,117 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// newRoomConfigBot creates a test bot whose homeserver keeps the room account
+// data of one room in *stored.
+func newRoomConfigBot(t *testing.T, mu *sync.Mutex, stored *string) *Bot {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/account_data/"+AccountDataRoomConfig.Type) {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			*stored = string(body)
+			_, _ = w.Write([]byte(`{}`))
+		} else if *stored == "" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"not found"}`))
+		} else {
+			_, _ = w.Write([]byte(*stored))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	b := newTestBot(t, Config{})
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.client = client
+	return b
+}
+
+func TestRoomSettingMirroredToRoomConfig(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var stored string
+	b := newRoomConfigBot(t, &mu, &stored)
+	const roomID = id.RoomID("!room:example.com")
+
+	for _, tt := range []struct {
+		value string
+		want  map[string]string
+	}{
+		{"de", map[string]string{LanguageSetting: "de"}},
+		{"", map[string]string{LanguageSetting: ""}}, // Removals are kept, so replicas apply them
+	} {
+		if err := b.SetRoomSetting(ctx, roomID, LanguageSetting, tt.value, "@mod:example.com"); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		var got map[string]string
+		err := json.Unmarshal([]byte(stored), &got)
+		mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) || got[LanguageSetting] != tt.want[LanguageSetting] {
+			t.Errorf("room config after setting %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHandleRoomConfigEvent(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var stored string
+	b := newRoomConfigBot(t, &mu, &stored)
+	const roomID = id.RoomID("!room:example.com")
+	for key, value := range map[string]string{LanguageSetting: "de", CommandPrefixSetting: "?"} {
+		if err := b.setRoomSetting(ctx, roomID, key, value, "@mod:example.com", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another replica changed the language, removed the prefix and stored non-setting data
+	b.handleRoomConfigEvent(ctx, &event.Event{
+		Type:    AccountDataRoomConfig,
+		RoomID:  roomID,
+		Content: event.Content{VeryRaw: json.RawMessage(`{"language":"fr","commands.prefix":"","poll":{"open":true}}`)},
+	})
+	settings, err := b.RoomSettings(ctx, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 1 || settings[LanguageSetting] != "fr" {
+		t.Errorf("settings = %v, want only language fr", settings)
+	}
+	changes, err := b.RoomSettingsHistory(ctx, roomID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].ChangedBy != "@bot:example.com" {
+		t.Errorf("latest change %v, want one by the bot", changes)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if stored != "" {
+		t.Errorf("replicated changes were mirrored back: %s", stored)
+	}
+}
@@ -0,0 +1,65 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// DisabledModulesSetting is the room setting listing the modules disabled in
+// a room, comma-separated, e.g. "ai,meet". Like !modules, only bot admins
+// may change it, see Bot.ProtectRoomSetting.
+const DisabledModulesSetting = "modules.disabled"
+
+// DisabledModules returns the modules disabled in a room.
+func (b *Bot) DisabledModules(ctx context.Context, roomID id.RoomID) ([]string, error) {
+	value, err := b.RoomSetting(ctx, roomID, DisabledModulesSetting)
+	if err != nil {
+		return nil, err
+	}
+	var disabled []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			disabled = append(disabled, name)
+		}
+	}
+	return disabled, nil
+}
+
+// DisableModule turns a module off in a room: its commands are ignored there,
+// except unmutable ones, and its message handlers don't see the room's
+// messages. Background loops of the module keep running; modules posting to
+// rooms on their own check ModuleEnabled.
+func (b *Bot) DisableModule(ctx context.Context, roomID id.RoomID, name string, disabledBy id.UserID) error {
+	if b.Module(name) == nil {
+		return fmt.Errorf("matrix: unknown module %q", name)
+	}
+	disabled, err := b.DisabledModules(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(disabled, name) {
+		return nil
+	}
+	return b.SetRoomSetting(ctx, roomID, DisabledModulesSetting, strings.Join(append(disabled, name), ","), disabledBy)
+}
+
+// EnableModule reverts DisableModule.
+func (b *Bot) EnableModule(ctx context.Context, roomID id.RoomID, name string, enabledBy id.UserID) error {
+	disabled, err := b.DisabledModules(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	return b.SetRoomSetting(ctx, roomID, DisabledModulesSetting, strings.Join(slices.DeleteFunc(disabled, func(m string) bool {
+		return m == name
+	}), ","), enabledBy)
+}
+
+// ModuleEnabled reports whether a module is enabled in a room, see DisableModule.
+func (b *Bot) ModuleEnabled(ctx context.Context, roomID id.RoomID, name string) bool {
+	disabled, err := b.DisabledModules(ctx, roomID)
+	return err != nil || !slices.Contains(disabled, name) // Without settings storage nothing can be disabled
+}
@@ -0,0 +1,163 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomTemplate describes how a room is provisioned, so rooms created by
+// different teams or modules (e.g. incident management) look the same.
+//
+// Name, Topic, Alias and Welcome are Go text/template strings rendered with
+// the data passed to CreateRoom, e.g. "incident-{{.ID}}".
+type RoomTemplate struct {
+	Name      string // Room name pattern
+	Topic     string // Room topic pattern
+	Alias     string // Optional alias localpart pattern (without '#' and server)
+	Public    bool   // Public rooms use the public_chat preset, private ones private_chat
+	Encrypted bool   // Enable end-to-end encryption from the start
+
+	// PowerLevels overrides user power levels. The bot always keeps 100.
+	PowerLevels map[id.UserID]int
+	// EventPowerLevels overrides the power level required per event type.
+	EventPowerLevels map[string]int
+
+	Invite  []id.UserID // Users invited on creation
+	Welcome string      // Markdown welcome message, sent and pinned after creation
+}
+
+// RegisterRoomTemplate stores a named template for later use with CreateRoomFromTemplate.
+func (b *Bot) RegisterRoomTemplate(name string, tmpl RoomTemplate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.roomTemplates == nil {
+		b.roomTemplates = make(map[string]RoomTemplate)
+	}
+	b.roomTemplates[name] = tmpl
+}
+
+// RoomTemplate returns a registered template by name.
+func (b *Bot) RoomTemplate(name string) (RoomTemplate, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	tmpl, ok := b.roomTemplates[name]
+	return tmpl, ok
+}
+
+// CreateRoomFromTemplate creates a room from a template registered with RegisterRoomTemplate.
+func (b *Bot) CreateRoomFromTemplate(ctx context.Context, name string, data any, invite ...id.UserID) (id.RoomID, error) {
+	tmpl, ok := b.RoomTemplate(name)
+	if !ok {
+		return "", fmt.Errorf("matrix: unknown room template %q", name)
+	}
+	return b.CreateRoom(ctx, tmpl, data, invite...)
+}
+
+// CreateRoom creates a room from the given template. Patterns are rendered with data,
+// and invite is appended to the template's own invite list.
+func (b *Bot) CreateRoom(ctx context.Context, tmpl RoomTemplate, data any, invite ...id.UserID) (id.RoomID, error) {
+	name, err := renderPattern("name", tmpl.Name, data)
+	if err != nil {
+		return "", err
+	}
+	topic, err := renderPattern("topic", tmpl.Topic, data)
+	if err != nil {
+		return "", err
+	}
+	alias, err := renderPattern("alias", tmpl.Alias, data)
+	if err != nil {
+		return "", err
+	}
+	welcome, err := renderPattern("welcome", tmpl.Welcome, data)
+	if err != nil {
+		return "", err
+	}
+
+	req := &mautrix.ReqCreateRoom{
+		Name:          name,
+		Topic:         topic,
+		RoomAliasName: alias,
+		Preset:        "private_chat",
+		Visibility:    "private",
+		Invite:        append(append([]id.UserID{}, tmpl.Invite...), invite...),
+	}
+	if tmpl.Public {
+		req.Preset = "public_chat"
+		req.Visibility = "public"
+	}
+	if tmpl.Encrypted {
+		req.InitialState = append(req.InitialState, &event.Event{
+			Type: event.StateEncryption,
+			Content: event.Content{Parsed: &event.EncryptionEventContent{
+				Algorithm: id.AlgorithmMegolmV1,
+			}},
+		})
+	}
+	if len(tmpl.PowerLevels) > 0 || len(tmpl.EventPowerLevels) > 0 {
+		users := map[id.UserID]int{b.client.UserID: 100}
+		for userID, level := range tmpl.PowerLevels {
+			if userID != b.client.UserID {
+				users[userID] = level
+			}
+		}
+		req.PowerLevelOverride = &event.PowerLevelsEventContent{
+			Users:  users,
+			Events: tmpl.EventPowerLevels,
+		}
+	}
+
+	resp, err := b.client.CreateRoom(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to create room: %w", err)
+	}
+
+	if welcome != "" {
+		if err = b.pinWelcome(ctx, resp.RoomID, welcome); err != nil {
+			return resp.RoomID, err
+		}
+	}
+	return resp.RoomID, nil
+}
+
+// pinWelcome sends the welcome message and pins it in the room.
+func (b *Bot) pinWelcome(ctx context.Context, roomID id.RoomID, md string) error {
+	eventID, err := b.SendMessage(ctx, roomID, &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          md,
+		Format:        event.FormatHTML,
+		FormattedBody: MarkdownToHTML(md),
+	})
+	if err != nil {
+		return fmt.Errorf("matrix: failed to send welcome message: %w", err)
+	}
+
+	_, err = b.client.SendStateEvent(ctx, roomID, event.StatePinnedEvents, "", &event.PinnedEventsEventContent{
+		Pinned: []id.EventID{eventID},
+	})
+	if err != nil {
+		return fmt.Errorf("matrix: failed to pin welcome message: %w", err)
+	}
+	return nil
+}
+
+// renderPattern renders a text/template pattern. Empty patterns render to an empty string.
+func renderPattern(field, pattern string, data any) (string, error) {
+	if pattern == "" {
+		return "", nil
+	}
+	t, err := template.New(field).Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return "", fmt.Errorf("matrix: invalid room %s pattern: %w", field, err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("matrix: failed to render room %s: %w", field, err)
+	}
+	return buf.String(), nil
+}
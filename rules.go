@@ -0,0 +1,169 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/id"
+)
+
+// Rule routes matching events to targets. A rule matches when every field in
+// Match equals the event field; values may be glob patterns ("prod-*") and
+// alternatives separated by "|" ("critical|page"). Rules are usually loaded
+// from the config file so routing changes don't require code changes:
+//
+//	rules:
+//	  - name: critical alerts
+//	    match: {source: alertmanager, severity: critical}
+//	    rooms: ["#ops:example.com"]
+//	    dm: ["@oncall:example.com"]
+//	  - match: {repo: frontend}
+//	    rooms: ["#frontend:example.com"]
+type Rule struct {
+	Name     string            `yaml:"name,omitempty" toml:"name"`
+	Match    map[string]string `yaml:"match,omitempty" toml:"match"`
+	Rooms    []string          `yaml:"rooms,omitempty" toml:"rooms"`       // Room IDs or aliases
+	DM       []id.UserID       `yaml:"dm,omitempty" toml:"dm"`             // Users notified in a direct message
+	Webhooks []string          `yaml:"webhooks,omitempty" toml:"webhooks"` // URLs receiving the report as JSON
+	Final    bool              `yaml:"final,omitempty" toml:"final"`       // Stop evaluating further rules after a match
+}
+
+// Matches reports whether the rule applies to an event with the given fields.
+// Field names are case-insensitive.
+func (r Rule) Matches(fields map[string]string) bool {
+	for key, want := range r.Match {
+		value, ok := fields[strings.ToLower(key)]
+		if !ok {
+			return false
+		}
+		if !matchAny(want, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny matches value against "|"-separated glob patterns, ignoring case.
+func matchAny(patterns, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range strings.Split(strings.ToLower(patterns), "|") {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Targets returns the delivery targets of the rule.
+func (r Rule) Targets() []Target {
+	var targets []Target
+	for _, room := range r.Rooms {
+		targets = append(targets, RoomTarget(room))
+	}
+	for _, user := range r.DM {
+		targets = append(targets, UserTarget(user))
+	}
+	for _, url := range r.Webhooks {
+		targets = append(targets, WebhookTarget{URL: url})
+	}
+	return targets
+}
+
+// LoadRules reads a YAML file with a top-level "rules" list, for keeping
+// routing separate from the main config.
+func LoadRules(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read rules: %w", err)
+	}
+	var doc struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("matrix: invalid rules %s: %w", file, err)
+	}
+	return doc.Rules, nil
+}
+
+// SetRules replaces the routing rules, e.g. after reloading them from disk.
+func (b *Bot) SetRules(rules []Rule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config.Rules = rules
+}
+
+// Rules returns the current routing rules.
+func (b *Bot) Rules() []Rule {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.config.Rules
+}
+
+// Route evaluates the rules for an event and delivers the report to the
+// targets of every matching rule, e.g. for an inbound webhook:
+//
+//	b.Route(ctx, map[string]string{"source": "alertmanager", "severity": "critical"}, report)
+//
+// It returns the number of matching rules; delivery errors are joined.
+func (b *Bot) Route(ctx context.Context, fields map[string]string, report *Report) (int, error) {
+	normalized := make(map[string]string, len(fields))
+	for key, value := range fields {
+		normalized[strings.ToLower(key)] = value
+	}
+
+	var errs []error
+	matched := 0
+	for _, rule := range b.Rules() {
+		if !rule.Matches(normalized) {
+			continue
+		}
+		matched++
+		b.log.Debug().Str("rule", rule.Name).Str("report", report.Title).Msg("Routing event")
+		if err := b.Deliver(ctx, report, rule.Targets()...); err != nil {
+			errs = append(errs, err)
+		}
+		if rule.Final {
+			break
+		}
+	}
+	return matched, errors.Join(errs...)
+}
+
+// routeMessage evaluates rules matching source "matrix" for a watched room message.
+// Fields are source, room, sender, msgtype and body.
+func (b *Bot) routeMessage(ctx context.Context, msg *MessageContext) {
+	if msg.Sender == b.client.UserID || !b.hasMatrixRules() {
+		return
+	}
+	fields := map[string]string{
+		"source":  "matrix",
+		"room":    msg.RoomID.String(),
+		"sender":  msg.Sender.String(),
+		"msgtype": string(msg.Message.MsgType),
+		"body":    msg.Message.Body,
+	}
+	name := msg.Room().Name
+	if name == "" {
+		name = msg.RoomID.String()
+	}
+	md := fmt.Sprintf("**%s** in %s:\n\n%s", msg.Sender, name, msg.Message.Body)
+	if _, err := b.Route(ctx, fields, &Report{Title: "Message from " + msg.Sender.String(), Markdown: md}); err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to route message")
+	}
+}
+
+// hasMatrixRules reports whether any rule watches room messages, so other
+// messages skip rule evaluation entirely.
+func (b *Bot) hasMatrixRules() bool {
+	for _, rule := range b.Rules() {
+		if source, ok := rule.Match["source"]; ok && matchAny(source, "matrix") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultScheduleCatchUp is used when Config.ScheduleCatchUp is zero.
+const DefaultScheduleCatchUp = time.Hour
+
+// scheduledJob is a function run on a cron schedule, see Bot.Schedule.
+type scheduledJob struct {
+	name     string // Spec, and room of scheduled messages
+	key      string // Store key of the time of the last run
+	schedule *CronSchedule
+	run      func(ctx context.Context)
+}
+
+// Schedule runs fn at the times of a cron expression (see ParseCron) while
+// the bot is running, e.g. "0 9 * * mon-fri" for a daily standup ping. Jobs
+// start once the first sync is done and stop with the bot. The time of the
+// last run is kept in the store: a restart doesn't repeat a run, and a run
+// missed while the bot was down is made up for on start if it was due within
+// Config.ScheduleCatchUp. Schedule may be called before or after Run.
+func (b *Bot) Schedule(spec string, fn func(ctx context.Context)) error {
+	return b.schedule(spec, spec, fn)
+}
+
+// ScheduleMessage posts the markdown returned by text to a room at the times
+// of a cron expression, e.g. a weekly digest, see Schedule. Nothing is posted
+// when text returns "".
+func (b *Bot) ScheduleMessage(spec string, roomID id.RoomID, text func(ctx context.Context) string) error {
+	return b.schedule(spec, spec+" "+roomID.String(), func(ctx context.Context) {
+		md := text(ctx)
+		if md == "" {
+			return
+		}
+		if err := b.SendHTML(ctx, roomID, md, MarkdownToHTML(md)); err != nil && !errors.Is(err, ErrQueued) {
+			b.log.Warn().Err(err).Str("room_id", roomID.String()).Str("schedule", spec).Msg("Failed to send scheduled message")
+		}
+	})
+}
+
+// schedule registers a job, and starts it if the bot is running.
+func (b *Bot) schedule(spec, name string, fn func(ctx context.Context)) error {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	job := &scheduledJob{name: name, key: "schedule." + name, schedule: schedule, run: fn}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Jobs with the same name are told apart by the order they are registered in
+	same := 0
+	for _, other := range b.schedules {
+		if other.name == name {
+			same++
+		}
+	}
+	if same > 0 {
+		job.key += "#" + strconv.Itoa(same+1)
+	}
+	b.schedules = append(b.schedules, job)
+	if ctx := b.scheduleCtx; ctx != nil && ctx.Err() == nil {
+		b.goBackground(func() { b.runScheduledJob(ctx, job) })
+	}
+	return nil
+}
+
+// runSchedules starts the jobs registered so far; later ones start right away.
+func (b *Bot) runSchedules(ctx context.Context) {
+	b.mu.Lock()
+	b.scheduleCtx = ctx
+	jobs := append([]*scheduledJob(nil), b.schedules...)
+	b.mu.Unlock()
+
+	for _, job := range jobs {
+		b.goBackground(func() { b.runScheduledJob(ctx, job) })
+	}
+}
+
+// runScheduledJob runs a job on its schedule until ctx is cancelled.
+func (b *Bot) runScheduledJob(ctx context.Context, job *scheduledJob) {
+	if !b.waitFirstSync(ctx) {
+		return
+	}
+	log := b.log.With().Str("schedule", job.name).Logger()
+
+	// Make up for the latest run missed while the bot was down
+	value, err := b.store.Get(ctx, job.key)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load the last run of a schedule")
+	}
+	if last, err := time.Parse(time.RFC3339, value); err == nil {
+		now := time.Now()
+		var missed time.Time
+		for due := job.schedule.Next(last); !due.IsZero() && !due.After(now); due = job.schedule.Next(due) {
+			missed = due
+		}
+		if !missed.IsZero() && now.Sub(missed) <= b.scheduleCatchUp() {
+			log.Info().Time("due", missed).Msg("Running a scheduled job missed while offline")
+			b.runJob(ctx, job, missed)
+		}
+	}
+
+	for {
+		due := job.schedule.Next(time.Now())
+		if due.IsZero() {
+			log.Warn().Msg("Schedule never runs")
+			return
+		}
+		// Wake up at least every minute, so that a suspended host doesn't
+		// delay the run more than necessary
+		for wait := time.Until(due); wait > 0; wait = time.Until(due) {
+			timer := time.NewTimer(min(wait, time.Minute))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		b.runJob(ctx, job, due)
+	}
+}
+
+// runJob records a run of a job and runs it, recovering from panics.
+func (b *Bot) runJob(ctx context.Context, job *scheduledJob, due time.Time) {
+	if err := b.store.Set(ctx, job.key, due.Format(time.RFC3339)); err != nil {
+		b.log.Warn().Err(err).Str("schedule", job.name).Msg("Failed to save the last run of a schedule")
+	}
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		b.log.Error().
+			Str("schedule", job.name).
+			Str("panic", fmt.Sprint(value)).
+			Bytes("stack", debug.Stack()).
+			Msg("Scheduled job panicked")
+		b.reportAdmin(ctx, fmt.Sprintf("The scheduled job %q panicked (stack trace in the logs)", job.name),
+			fmt.Errorf("%v", value))
+	}()
+	job.run(ctx)
+}
+
+// scheduleCatchUp returns Config.ScheduleCatchUp or its default.
+func (b *Bot) scheduleCatchUp() time.Duration {
+	if b.config.ScheduleCatchUp == 0 {
+		return DefaultScheduleCatchUp
+	}
+	return b.config.ScheduleCatchUp
+}
@@ -0,0 +1,127 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrUnencrypted is returned by SendSecret if the DM room isn't encrypted.
+var ErrUnencrypted = errors.New("matrix: refusing to send a secret to an unencrypted room")
+
+// secretReadGrace is how long a secret stays visible after the recipient has read it,
+// so it can still be copied.
+const secretReadGrace = time.Minute
+
+// SendSecret delivers a sensitive value, such as a token or temporary password
+// from a provisioning flow, to a user in an encrypted direct message. The message
+// is redacted one minute after the user's first read receipt, or after ttl if it
+// is never read. Only a DM room shared with nobody else is used, see
+// EnsurePrivateDM. Unencrypted DM rooms are refused with ErrUnencrypted.
+func (b *Bot) SendSecret(ctx context.Context, userID id.UserID, text string, ttl time.Duration) (id.EventID, error) {
+	roomID, err := b.secretDM(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	eventID, err := b.SendEphemeral(withoutAuditBody(ctx), roomID, text, ttl)
+	if err != nil {
+		return eventID, err
+	}
+	return eventID, b.trackSecret(ctx, roomID, eventID, userID)
+}
+
+// SendSecretFile is SendSecret for an attachment, e.g. an export including
+// passwords. The file is encrypted like the room and redacted the same way.
+func (b *Bot) SendSecretFile(ctx context.Context, userID id.UserID, fileName, contentType string, data []byte, ttl time.Duration) (id.EventID, error) {
+	roomID, err := b.secretDM(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	content, err := b.uploadFile(ctx, roomID, fileName, contentType, data, "")
+	if err != nil {
+		return "", err
+	}
+	eventID, err := b.SendMessage(withoutOutbox(withoutAuditBody(ctx)), roomID, content)
+	if err != nil {
+		return "", err
+	}
+	if err = b.RedactAfter(ctx, roomID, eventID, ttl); err != nil {
+		return eventID, err
+	}
+	return eventID, b.trackSecret(ctx, roomID, eventID, userID)
+}
+
+// secretDM returns the private, encrypted DM room secrets for a user are sent to.
+func (b *Bot) secretDM(ctx context.Context, userID id.UserID) (id.RoomID, error) {
+	if b.db == nil {
+		return "", ErrNoDatabase
+	}
+	roomID, err := b.EnsurePrivateDM(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := b.IsEncrypted(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	if !encrypted {
+		return "", ErrUnencrypted
+	}
+	return roomID, nil
+}
+
+// trackSecret remembers a secret sent to a user, so that their read receipt
+// shortens its lifetime.
+func (b *Bot) trackSecret(ctx context.Context, roomID id.RoomID, eventID id.EventID, userID id.UserID) error {
+	_, err := b.db.Exec(ctx, "INSERT INTO bot_pending_secrets (room_id, event_id, recipient) VALUES ($1, $2, $3)",
+		roomID, eventID, userID)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to track secret: %w", err)
+	}
+	b.log.Info().
+		Str("room_id", roomID.String()).
+		Str("user_id", userID.String()).
+		Msg("Delivered secret")
+	return nil
+}
+
+// handleReceipt shortens the lifetime of secrets once their recipient has read them.
+func (b *Bot) handleReceipt(ctx context.Context, evt *event.Event) {
+	if b.db == nil {
+		return
+	}
+	for eventID, receipts := range *evt.Content.AsReceipt() {
+		for _, receiptType := range []event.ReceiptType{event.ReceiptTypeRead, event.ReceiptTypeReadPrivate} {
+			for userID := range receipts[receiptType] {
+				b.secretRead(ctx, evt.RoomID, eventID, userID)
+			}
+		}
+	}
+}
+
+// secretRead reschedules the redaction of a secret read by its recipient.
+func (b *Bot) secretRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, userID id.UserID) {
+	res, err := b.db.Exec(ctx, "DELETE FROM bot_pending_secrets WHERE room_id=$1 AND event_id=$2 AND recipient=$3",
+		roomID, eventID, userID)
+	if err != nil {
+		b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to update secret")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+
+	var redactAt int64
+	err = b.db.QueryRow(ctx, "SELECT redact_at FROM bot_pending_redactions WHERE room_id=$1 AND event_id=$2",
+		roomID, eventID).Scan(&redactAt)
+	if err != nil || time.Until(time.UnixMilli(redactAt)) <= secretReadGrace {
+		return
+	}
+	if err = b.RedactAfter(ctx, roomID, eventID, secretReadGrace); err != nil {
+		b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to reschedule secret redaction")
+	}
+}
@@ -0,0 +1,130 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// scheduledSendRetry is the delay before reading the scheduled messages again
+// after the database failed.
+const scheduledSendRetry = time.Minute
+
+// SendTextAt sends a plain text message at a later time, e.g. a release
+// announcement for Friday 10:00. The message is stored in the database, so it
+// is still sent after a restart; if the bot is down at that time, it is sent
+// once the bot is back. Failed deliveries are retried like those of the
+// outbox, for up to a day after the scheduled time. It returns an ID for
+// CancelScheduledMessage.
+func (b *Bot) SendTextAt(ctx context.Context, roomID id.RoomID, text string, at time.Time) (string, error) {
+	return b.SendMessageAt(ctx, roomID, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	}, at)
+}
+
+// SendMessageAt sends a message at a later time, see SendTextAt.
+func (b *Bot) SendMessageAt(ctx context.Context, roomID id.RoomID, content *event.MessageEventContent, at time.Time) (string, error) {
+	if b.db == nil {
+		return "", ErrNoDatabase
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to encode message: %w", err)
+	}
+	scheduleID := b.client.TxnID()
+	_, err = b.db.Exec(ctx, `INSERT INTO bot_scheduled_messages (id, room_id, content, send_at, attempts, next_attempt)
+		VALUES ($1, $2, $3, $4, 0, $4)`, scheduleID, roomID, string(data), at.UnixMilli())
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to schedule message: %w", err)
+	}
+	select {
+	case b.sendAtWake <- struct{}{}:
+	default:
+	}
+	return scheduleID, nil
+}
+
+// CancelScheduledMessage drops a message scheduled with SendTextAt or
+// SendMessageAt that hasn't been sent yet.
+func (b *Bot) CancelScheduledMessage(ctx context.Context, scheduleID string) error {
+	if b.db == nil {
+		return ErrNoDatabase
+	}
+	if _, err := b.db.Exec(ctx, "DELETE FROM bot_scheduled_messages WHERE id=$1", scheduleID); err != nil {
+		return fmt.Errorf("matrix: failed to cancel scheduled message: %w", err)
+	}
+	return nil
+}
+
+// runScheduledSends sends scheduled messages when they are due until ctx is cancelled.
+func (b *Bot) runScheduledSends(ctx context.Context) {
+	for {
+		wait := b.sendDue(ctx)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-b.sendAtWake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// sendDue sends all due messages and returns how long to wait for the next one.
+func (b *Bot) sendDue(ctx context.Context) time.Duration {
+	now := time.Now()
+	rows, err := b.db.Query(ctx, `SELECT id, room_id, content, send_at, attempts FROM bot_scheduled_messages
+		WHERE next_attempt<=$1 ORDER BY next_attempt, id`, now.UnixMilli())
+	if err != nil {
+		b.log.Warn().Err(err).Msg("Failed to load scheduled messages")
+		return scheduledSendRetry
+	}
+	type scheduled struct {
+		id       string
+		roomID   id.RoomID
+		content  string
+		sendAt   int64
+		attempts int
+	}
+	var due []scheduled
+	for rows.Next() {
+		var s scheduled
+		if err = rows.Scan(&s.id, &s.roomID, &s.content, &s.sendAt, &s.attempts); err == nil {
+			due = append(due, s)
+		}
+	}
+	_ = rows.Close()
+
+	for _, s := range due {
+		log := b.log.With().Str("room_id", s.roomID.String()).Str("schedule_id", s.id).Logger()
+		var content event.MessageEventContent
+		if err = json.Unmarshal([]byte(s.content), &content); err != nil {
+			log.Warn().Err(err).Msg("Dropping invalid scheduled message")
+		} else if _, err = b.SendMessage(ctx, s.roomID, &content); err != nil && !errors.Is(err, ErrQueued) {
+			if isTransient(err) && now.Sub(time.UnixMilli(s.sendAt)) < outboxMaxAge {
+				backoff := outboxBackoff(s.attempts + 1)
+				log.Warn().Err(err).Dur("retry_in", backoff).Msg("Failed to send scheduled message")
+				_, _ = b.db.Exec(ctx, "UPDATE bot_scheduled_messages SET attempts=attempts+1, next_attempt=$1 WHERE id=$2",
+					now.Add(backoff).UnixMilli(), s.id)
+				continue
+			}
+			log.Warn().Err(err).Int("attempts", s.attempts+1).Msg("Dropping scheduled message that couldn't be sent")
+		}
+		_, _ = b.db.Exec(ctx, "DELETE FROM bot_scheduled_messages WHERE id=$1", s.id)
+	}
+
+	var next int64
+	err = b.db.QueryRow(ctx, "SELECT COALESCE(MIN(next_attempt), 0) FROM bot_scheduled_messages").Scan(&next)
+	if err != nil || next == 0 {
+		return time.Hour
+	}
+	return max(time.Until(time.UnixMilli(next)), time.Second)
+}
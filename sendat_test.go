@@ -0,0 +1,63 @@
+package matrix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestSendDueRetries(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	b := newTestBot(t, Config{})
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.com", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.client = client
+
+	sendAt := time.Now().Add(-time.Second)
+	scheduleID, err := b.SendTextAt(ctx, "!room:example.com", "hello", sendAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.sendDue(ctx)
+	if requests.Load() == 0 {
+		t.Fatal("scheduled message not sent")
+	}
+
+	var storedSendAt, nextAttempt int64
+	var attempts int
+	err = b.db.QueryRow(ctx, "SELECT send_at, attempts, next_attempt FROM bot_scheduled_messages WHERE id=$1", scheduleID).
+		Scan(&storedSendAt, &attempts, &nextAttempt)
+	if err != nil {
+		t.Fatalf("failed message not kept for a retry: %v", err)
+	}
+	if storedSendAt != sendAt.UnixMilli() {
+		t.Errorf("send_at changed from %d to %d", sendAt.UnixMilli(), storedSendAt)
+	}
+	if attempts != 1 || nextAttempt <= time.Now().UnixMilli() {
+		t.Errorf("attempts = %d, next attempt in %s", attempts, time.Until(time.UnixMilli(nextAttempt)))
+	}
+
+	// Messages due longer than outboxMaxAge ago are dropped on failure
+	_, err = b.db.Exec(ctx, "UPDATE bot_scheduled_messages SET send_at=$1, next_attempt=0", time.Now().Add(-outboxMaxAge).UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.sendDue(ctx)
+	var count int
+	if err = b.db.QueryRow(ctx, "SELECT COUNT(*) FROM bot_scheduled_messages").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expired message kept: count %d, err %v", count, err)
+	}
+}
@@ -0,0 +1,121 @@
+package matrix
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// maxRateLimitRetries bounds the retries of a send rejected with M_LIMIT_EXCEEDED.
+	maxRateLimitRetries = 10
+	// sendQueueIdle is how long an empty room queue is kept before its worker exits.
+	sendQueueIdle = time.Minute
+)
+
+// sendQueue sends to each room one request at a time, in the order they were
+// made, so that a rate-limited message isn't overtaken by later ones.
+type sendQueue struct {
+	mu    sync.Mutex
+	rooms map[id.RoomID]*roomSendQueue
+}
+
+// roomSendQueue is the queue of one room, drained by its own worker.
+type roomSendQueue struct {
+	jobs    chan *sendJob
+	pending int // Jobs enqueued or about to be, guarded by sendQueue.mu
+}
+
+type sendJob struct {
+	ctx  context.Context
+	send func(ctx context.Context) error
+	done chan error
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{rooms: make(map[id.RoomID]*roomSendQueue)}
+}
+
+// enqueueSend runs send in the room's queue and waits for it. Sends rejected
+// with M_LIMIT_EXCEEDED are retried after the delay requested by the
+// homeserver; later sends to the room wait meanwhile. Sends to other rooms
+// aren't affected.
+func (b *Bot) enqueueSend(ctx context.Context, roomID id.RoomID, send func(ctx context.Context) error) error {
+	q := b.sends
+	q.mu.Lock()
+	room, ok := q.rooms[roomID]
+	if !ok {
+		room = &roomSendQueue{jobs: make(chan *sendJob)}
+		q.rooms[roomID] = room
+		go b.runSendQueue(roomID, room)
+	}
+	room.pending++
+	q.mu.Unlock()
+
+	job := &sendJob{ctx: ctx, send: send, done: make(chan error, 1)}
+	select {
+	case room.jobs <- job:
+	case <-ctx.Done():
+		q.mu.Lock()
+		room.pending--
+		q.mu.Unlock()
+		return ctx.Err()
+	}
+	select {
+	case err := <-job.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runSendQueue runs the jobs of a room until it has been idle for a while.
+func (b *Bot) runSendQueue(roomID id.RoomID, room *roomSendQueue) {
+	q := b.sends
+	idle := time.NewTimer(sendQueueIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case job := <-room.jobs:
+			b.loadMembers(job.ctx, roomID)
+			job.done <- b.sendWithRetry(job.ctx, roomID, job.send)
+			q.mu.Lock()
+			room.pending--
+			q.mu.Unlock()
+			idle.Reset(sendQueueIdle)
+		case <-idle.C:
+			q.mu.Lock()
+			if room.pending == 0 {
+				delete(q.rooms, roomID)
+				q.mu.Unlock()
+				return
+			}
+			q.mu.Unlock()
+			idle.Reset(sendQueueIdle)
+		}
+	}
+}
+
+// sendWithRetry runs send, retrying while the homeserver rate-limits it.
+func (b *Bot) sendWithRetry(ctx context.Context, roomID id.RoomID, send func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := send(ctx)
+		delay, limited := retryAfter(err)
+		if !limited || attempt >= maxRateLimitRetries {
+			return err
+		}
+		b.log.Warn().
+			Str("room_id", roomID.String()).
+			Dur("retry_after", delay).
+			Int("attempt", attempt+1).
+			Msg("Rate limited by the homeserver, retrying send")
+		if err = sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
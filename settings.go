@@ -0,0 +1,333 @@
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// StateRoomSetting is a room state event setting a per-room bot setting.
+// The state key is the setting name, the content {"value": "..."}; an empty
+// value removes the setting. It lets room admins configure the bot with any
+// client that can send state events.
+var StateRoomSetting = event.Type{Type: "com.github.eslider.matrix-bot.setting", Class: event.StateEventType}
+
+// SettingChange is an entry of the per-room settings history.
+type SettingChange struct {
+	Version   int
+	Key       string
+	OldValue  string // Empty if the setting was added
+	NewValue  string // Empty if the setting was removed
+	ChangedBy id.UserID
+	ChangedAt time.Time
+}
+
+// String describes the change, e.g. "ai.model changed from llama3.2 to qwen by @alice:example.com".
+func (c SettingChange) String() string {
+	switch {
+	case c.OldValue == "":
+		return fmt.Sprintf("%s set to %s by %s", c.Key, c.NewValue, c.ChangedBy)
+	case c.NewValue == "":
+		return fmt.Sprintf("%s removed (was %s) by %s", c.Key, c.OldValue, c.ChangedBy)
+	default:
+		return fmt.Sprintf("%s changed from %s to %s by %s", c.Key, c.OldValue, c.NewValue, c.ChangedBy)
+	}
+}
+
+// text announces the change in a language.
+func (c SettingChange) text(b *Bot, language string) string {
+	switch {
+	case c.OldValue == "":
+		return b.Translate(language, "setting.set", c.Key, c.NewValue, c.ChangedBy)
+	case c.NewValue == "":
+		return b.Translate(language, "setting.removed", c.Key, c.OldValue, c.ChangedBy)
+	default:
+		return b.Translate(language, "setting.changed", c.Key, c.OldValue, c.NewValue, c.ChangedBy)
+	}
+}
+
+// ProtectRoomSetting restricts changes of settings by users, with !setting or
+// a StateRoomSetting event, to the bot operators in Config.Admins. Modules
+// call it in Init for the settings their admin-only commands write, e.g. the
+// model of the ai module, so room moderators can't bypass those commands.
+func (b *Bot) ProtectRoomSetting(keys ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.protectedSettings == nil {
+		b.protectedSettings = make(map[string]bool)
+	}
+	for _, key := range keys {
+		b.protectedSettings[key] = true
+	}
+}
+
+// CanChangeRoomSetting reports whether a user may change a setting: anybody
+// permitted by the caller for ordinary settings, only bot admins for
+// protected ones, see ProtectRoomSetting.
+func (b *Bot) CanChangeRoomSetting(userID id.UserID, key string) bool {
+	b.mu.RLock()
+	protected := b.protectedSettings[key]
+	b.mu.RUnlock()
+	return !protected || slices.Contains(b.config.Admins, userID)
+}
+
+// roomSettingsCache keeps the settings of rooms in memory, so dispatching a
+// message, which reads the command prefix, muted commands and disabled
+// modules, doesn't query the database. SetRoomSetting invalidates a room.
+type roomSettingsCache struct {
+	mu    sync.RWMutex
+	rooms map[id.RoomID]map[string]string
+	gen   uint64 // Incremented by invalidate, so loads racing a change aren't cached
+}
+
+func newRoomSettingsCache() *roomSettingsCache {
+	return &roomSettingsCache{rooms: make(map[id.RoomID]map[string]string)}
+}
+
+// invalidate drops the cached settings of a room.
+func (c *roomSettingsCache) invalidate(roomID id.RoomID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rooms, roomID)
+	c.gen++
+}
+
+// roomSettings returns the cached settings of a room, loading them on a miss.
+// Callers must not modify the map.
+func (b *Bot) roomSettings(ctx context.Context, roomID id.RoomID) (map[string]string, error) {
+	if b.db == nil {
+		return nil, ErrNoDatabase
+	}
+	cache := b.settings
+	cache.mu.RLock()
+	settings, ok := cache.rooms[roomID]
+	gen := cache.gen
+	cache.mu.RUnlock()
+	if ok {
+		return settings, nil
+	}
+
+	rows, err := b.db.Query(ctx, "SELECT key, value FROM bot_room_settings WHERE room_id=$1", roomID)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read room settings: %w", err)
+	}
+	defer rows.Close()
+	settings = make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("matrix: failed to read room settings: %w", err)
+		}
+		settings[key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("matrix: failed to read room settings: %w", err)
+	}
+	cache.mu.Lock()
+	if cache.gen == gen {
+		cache.rooms[roomID] = settings
+	}
+	cache.mu.Unlock()
+	return settings, nil
+}
+
+// RoomSetting returns a per-room setting, or "" if it isn't set.
+func (b *Bot) RoomSetting(ctx context.Context, roomID id.RoomID, key string) (string, error) {
+	settings, err := b.roomSettings(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	return settings[key], nil
+}
+
+// RoomSettings returns all settings of a room.
+func (b *Bot) RoomSettings(ctx context.Context, roomID id.RoomID) (map[string]string, error) {
+	settings, err := b.roomSettings(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(settings), nil
+}
+
+// SetRoomSetting changes a per-room setting and records the change in the
+// settings history; an empty value removes the setting. Setting the current
+// value again is a no-op. With Config.AnnounceSettingChanges the change is
+// posted to the room. The setting is mirrored into the room's RoomConfig, so
+// other replicas apply it too and it is restored if the database is lost.
+func (b *Bot) SetRoomSetting(ctx context.Context, roomID id.RoomID, key, value string, changedBy id.UserID) error {
+	return b.setRoomSetting(ctx, roomID, key, value, changedBy, true)
+}
+
+// setRoomSetting implements SetRoomSetting. Changes received from RoomConfig
+// are applied with local set, so they are neither mirrored back nor
+// announced again by every replica.
+func (b *Bot) setRoomSetting(ctx context.Context, roomID id.RoomID, key, value string, changedBy id.UserID, local bool) error {
+	if b.db == nil {
+		return ErrNoDatabase
+	}
+	var change *SettingChange
+	err := b.db.DoTxn(ctx, nil, func(ctx context.Context) error {
+		var old string
+		err := b.db.QueryRow(ctx, "SELECT value FROM bot_room_settings WHERE room_id=$1 AND key=$2", roomID, key).Scan(&old)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if old == value {
+			return nil
+		}
+
+		if value == "" {
+			_, err = b.db.Exec(ctx, "DELETE FROM bot_room_settings WHERE room_id=$1 AND key=$2", roomID, key)
+		} else {
+			_, err = b.db.Exec(ctx, `INSERT INTO bot_room_settings (room_id, key, value) VALUES ($1, $2, $3)
+				ON CONFLICT (room_id, key) DO UPDATE SET value=excluded.value`, roomID, key, value)
+		}
+		if err != nil {
+			return err
+		}
+
+		var version int
+		err = b.db.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) + 1 FROM bot_room_settings_history WHERE room_id=$1", roomID).Scan(&version)
+		if err != nil {
+			return err
+		}
+		change = &SettingChange{
+			Version:   version,
+			Key:       key,
+			OldValue:  old,
+			NewValue:  value,
+			ChangedBy: changedBy,
+			ChangedAt: time.Now(),
+		}
+		_, err = b.db.Exec(ctx, `INSERT INTO bot_room_settings_history
+			(room_id, version, key, old_value, new_value, changed_by, changed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			roomID, version, key, old, value, changedBy, change.ChangedAt.UnixMilli())
+		return err
+	})
+	b.settings.invalidate(roomID)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to save room setting: %w", err)
+	}
+	if change == nil {
+		return nil
+	}
+
+	b.log.Info().
+		Str("room_id", roomID.String()).
+		Str("setting", key).
+		Str("changed_by", changedBy.String()).
+		Int("version", change.Version).
+		Bool("replicated", !local).
+		Msg("Room setting changed")
+	if !local {
+		return nil
+	}
+	b.mirrorRoomSetting(ctx, roomID, key, value)
+	if b.config.AnnounceSettingChanges {
+		md := change.text(b, b.Language(ctx, roomID))
+		if err = b.SendHTML(ctx, roomID, md, MarkdownToHTML(md)); err != nil {
+			b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to announce setting change")
+		}
+	}
+	return nil
+}
+
+// RoomSettingsHistory returns the latest changes of a room's settings, newest first.
+func (b *Bot) RoomSettingsHistory(ctx context.Context, roomID id.RoomID, limit int) ([]SettingChange, error) {
+	if b.db == nil {
+		return nil, ErrNoDatabase
+	}
+	rows, err := b.db.Query(ctx, `SELECT version, key, old_value, new_value, changed_by, changed_at
+		FROM bot_room_settings_history WHERE room_id=$1 ORDER BY version DESC LIMIT $2`, roomID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("matrix: failed to read settings history: %w", err)
+	}
+	defer rows.Close()
+	var changes []SettingChange
+	for rows.Next() {
+		var change SettingChange
+		var changedAt int64
+		if err = rows.Scan(&change.Version, &change.Key, &change.OldValue, &change.NewValue, &change.ChangedBy, &changedAt); err != nil {
+			return nil, fmt.Errorf("matrix: failed to read settings history: %w", err)
+		}
+		change.ChangedAt = time.UnixMilli(changedAt)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// handleSettingEvent applies room setting state events sent by room members.
+// Replayed events are harmless because unchanged values are not recorded.
+// Protected settings are only taken from bot admins.
+func (b *Bot) handleSettingEvent(ctx context.Context, evt *event.Event) {
+	if evt.StateKey == nil || *evt.StateKey == "" || b.db == nil {
+		return
+	}
+	if !b.CanChangeRoomSetting(evt.Sender, *evt.StateKey) {
+		b.log.Warn().
+			Str("room_id", evt.RoomID.String()).
+			Str("setting", *evt.StateKey).
+			Str("user_id", evt.Sender.String()).
+			Msg("Ignoring change of a protected setting by a non-admin")
+		return
+	}
+	value, _ := evt.Content.Raw["value"].(string)
+	if err := b.SetRoomSetting(ctx, evt.RoomID, *evt.StateKey, value, evt.Sender); err != nil {
+		b.log.Warn().Err(err).Str("room_id", evt.RoomID.String()).Msg("Failed to apply setting event")
+	}
+}
+
+// roomSettingsSection exports all per-room settings in configuration bundles.
+type roomSettingsSection struct {
+	bot *Bot
+}
+
+// ExportConfig implements ConfigSection.
+func (s roomSettingsSection) ExportConfig(ctx context.Context) (any, error) {
+	if s.bot.db == nil {
+		return nil, nil
+	}
+	rows, err := s.bot.db.Query(ctx, "SELECT room_id, key, value FROM bot_room_settings ORDER BY room_id, key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rooms := make(map[id.RoomID]map[string]string)
+	for rows.Next() {
+		var roomID id.RoomID
+		var key, value string
+		if err = rows.Scan(&roomID, &key, &value); err != nil {
+			return nil, err
+		}
+		if rooms[roomID] == nil {
+			rooms[roomID] = make(map[string]string)
+		}
+		rooms[roomID][key] = value
+	}
+	return rooms, rows.Err()
+}
+
+// ImportConfig implements ConfigSection. Changes are recorded in the history like any other change.
+func (s roomSettingsSection) ImportConfig(ctx context.Context, value *yaml.Node) error {
+	var rooms map[id.RoomID]map[string]string
+	if err := value.Decode(&rooms); err != nil {
+		return err
+	}
+	for roomID, settings := range rooms {
+		for key, value := range settings {
+			if err := s.bot.SetRoomSetting(ctx, roomID, key, value, s.bot.client.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// newTestBot creates a bot with an in-memory database, without connecting.
+func newTestBot(t *testing.T, config Config) *Bot {
+	t.Helper()
+	config.Homeserver = "https://matrix.example.com"
+	config.AccessToken = "token"
+	config.Database = MemoryDatabase
+	log := zerolog.Nop()
+	config.Logger = &log
+	b, err := NewBot(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.openStore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.db.Close() })
+	return b
+}
+
+func TestCanChangeRoomSetting(t *testing.T) {
+	b := newTestBot(t, Config{Admins: []id.UserID{"@admin:example.com"}})
+	b.ProtectRoomSetting("ai.model")
+	tests := []struct {
+		user id.UserID
+		key  string
+		want bool
+	}{
+		{"@admin:example.com", "ai.model", true},
+		{"@mod:example.com", "ai.model", false},
+		{"@mod:example.com", "lang", true},
+		{"@mod:example.com", DisabledModulesSetting, false},
+		{"@admin:example.com", DisabledModulesSetting, true},
+	}
+	for _, tt := range tests {
+		if got := b.CanChangeRoomSetting(tt.user, tt.key); got != tt.want {
+			t.Errorf("CanChangeRoomSetting(%s, %s) = %v, want %v", tt.user, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestHandleSettingEventProtected(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBot(t, Config{Admins: []id.UserID{"@admin:example.com"}})
+	b.ProtectRoomSetting("ai.model")
+	const roomID = id.RoomID("!room:example.com")
+	setting := func(sender id.UserID, key, value string) {
+		b.handleSettingEvent(ctx, &event.Event{
+			Type:     StateRoomSetting,
+			RoomID:   roomID,
+			Sender:   sender,
+			StateKey: &key,
+			Content:  event.Content{Raw: map[string]any{"value": value}},
+		})
+	}
+
+	setting("@mod:example.com", "ai.model", "expensive")
+	setting("@mod:example.com", "lang", "de")
+	settings, err := b.RoomSettings(ctx, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := settings["ai.model"]; ok {
+		t.Errorf("protected setting changed by a non-admin: %v", settings)
+	}
+	if settings["lang"] != "de" {
+		t.Errorf("lang = %q, want de", settings["lang"])
+	}
+
+	setting("@admin:example.com", "ai.model", "small")
+	if value, _ := b.RoomSetting(ctx, roomID, "ai.model"); value != "small" {
+		t.Errorf("ai.model = %q, want small", value)
+	}
+}
+
+func TestRoomSettingCache(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBot(t, Config{})
+	const roomID = id.RoomID("!room:example.com")
+	get := func() string {
+		t.Helper()
+		value, err := b.RoomSetting(ctx, roomID, "lang")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	if err := b.SetRoomSetting(ctx, roomID, "lang", "de", "@mod:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "de" {
+		t.Fatalf("RoomSetting = %q, want de", got)
+	}
+	// A cached room doesn't query the database
+	if _, err := b.db.Exec(ctx, "UPDATE bot_room_settings SET value='fr' WHERE room_id=$1", roomID); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "de" {
+		t.Errorf("RoomSetting after database change = %q, want cached de", got)
+	}
+	// Callers may modify the settings returned by RoomSettings
+	settings, err := b.RoomSettings(ctx, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings["lang"] = "it"
+	if got := get(); got != "de" {
+		t.Errorf("RoomSetting after modifying RoomSettings = %q, want de", got)
+	}
+
+	if err = b.SetRoomSetting(ctx, roomID, "lang", "es", "@mod:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "es" {
+		t.Errorf("RoomSetting after SetRoomSetting = %q, want es", got)
+	}
+	if err = b.SetRoomSetting(ctx, roomID, "lang", "", "@mod:example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "" {
+		t.Errorf("RoomSetting after unset = %q, want empty", got)
+	}
+}
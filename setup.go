@@ -0,0 +1,334 @@
+package matrix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Setup is an interactive first-run assistant for operators. It asks on out
+// and in for the homeserver and the bot's credentials, logs in (creating the
+// database with the device and its encryption keys), lets the operator pick
+// or create an admin room and choose which of the given modules to enable,
+// and writes the config file to path (YAML or TOML, by extension). The
+// password isn't saved: the config uses the access token of the new session.
+func Setup(ctx context.Context, path string, in io.Reader, out io.Writer, modules ...Module) error {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	if file, ok := in.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		p.terminal = file
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml":
+	default:
+		return fmt.Errorf("matrix: unsupported config format %q", filepath.Ext(path))
+	}
+	if _, err := os.Stat(path); err == nil {
+		overwrite, err := p.confirm(fmt.Sprintf("%s already exists. Overwrite it?", path), false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return fmt.Errorf("matrix: setup cancelled, %s is unchanged", path)
+		}
+	}
+
+	fmt.Fprintln(out, "Matrix bot setup. Press Enter to accept the [default].")
+	var config Config
+	var err error
+	if config.Homeserver, err = p.ask("Homeserver URL or server name (e.g. matrix.example.com)", ""); err != nil {
+		return err
+	}
+	if config.Username, err = p.ask("Bot username (localpart or full user ID)", ""); err != nil {
+		return err
+	}
+	if config.Password, err = p.password("Bot password"); err != nil {
+		return err
+	}
+	if config.Database, err = p.ask("Database file", "matrix-bot.db"); err != nil {
+		return err
+	}
+
+	b, err := NewBot(config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Logging in and creating encryption keys...")
+	if err = b.connect(ctx); err != nil {
+		_ = b.Stop()
+		return err
+	}
+	defer b.Stop()
+	fmt.Fprintf(out, "Logged in as %s, device %s.\n", b.client.UserID, b.client.DeviceID)
+	config.Username = b.client.UserID.String()
+	config.Password = ""
+	config.AccessToken = b.client.AccessToken
+	config.DeviceID = b.client.DeviceID.String()
+
+	if config.AdminRoom, err = p.adminRoom(ctx, b); err != nil {
+		return err
+	}
+	for _, m := range modules {
+		enable, err := p.confirm(fmt.Sprintf("Enable module %s?", m.Name()), true)
+		if err != nil {
+			return err
+		}
+		if !enable {
+			config.DisabledModules = append(config.DisabledModules, m.Name())
+			continue
+		}
+		if configurable, ok := m.(Configurable); ok {
+			section, err := moduleDefaults(configurable)
+			if err != nil {
+				return fmt.Errorf("matrix: failed to encode config of module %q: %w", m.Name(), err)
+			}
+			if config.Modules == nil {
+				config.Modules = make(map[string]map[string]any)
+			}
+			config.Modules[m.Name()] = section
+		}
+	}
+
+	if err = writeConfig(path, config); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s. Start the bot with it; see -print-config for all settings.\n", path)
+	return nil
+}
+
+// connect logs in and sets up storage and encryption like Run, without syncing.
+func (b *Bot) connect(ctx context.Context) error {
+	homeserver, err := b.homeserverURL(ctx)
+	if err != nil {
+		return err
+	}
+	if b.client, err = mautrix.NewClient(homeserver, "", ""); err != nil {
+		return fmt.Errorf("matrix: failed to create client: %w", err)
+	}
+	b.client.Client = b.http
+	if _, err = b.client.Versions(ctx); err != nil {
+		return fmt.Errorf("matrix: %s doesn't look like a Matrix homeserver: %w", homeserver, err)
+	}
+	if err = b.openStore(ctx); err != nil {
+		return err
+	}
+	b.client.Store = b.store
+	b.client.StateStore = b.store.StateStore()
+	return b.setupCrypto(ctx)
+}
+
+// adminRoom asks for the room receiving operator notices: a joined room, a
+// room ID or alias, a new room, or none.
+func (p *prompter) adminRoom(ctx context.Context, b *Bot) (id.RoomID, error) {
+	joined, err := b.client.JoinedRooms(ctx)
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to list rooms: %w", err)
+	}
+	fmt.Fprintln(p.out, "Admin room for operator notices:")
+	for i, roomID := range joined.JoinedRooms {
+		var name event.RoomNameEventContent
+		_ = b.client.StateEvent(ctx, roomID, event.StateRoomName, "", &name)
+		fmt.Fprintf(p.out, "  %d) %s %s\n", i+1, roomID, name.Name)
+	}
+	fmt.Fprintln(p.out, "  new) create a room and invite you")
+	for {
+		answer, err := p.readLine("Number, room ID or #alias, \"new\", or Enter for none")
+		if err != nil || answer == "" {
+			return "", err
+		}
+		if n, convErr := strconv.Atoi(answer); convErr == nil && n >= 1 && n <= len(joined.JoinedRooms) {
+			return joined.JoinedRooms[n-1], nil
+		}
+		switch {
+		case answer == "new":
+			return p.createAdminRoom(ctx, b)
+		case strings.HasPrefix(answer, "!"):
+			return id.RoomID(answer), nil
+		case strings.HasPrefix(answer, "#"):
+			resolved, err := b.client.ResolveAlias(ctx, id.RoomAlias(answer))
+			if err == nil {
+				return resolved.RoomID, nil
+			}
+			fmt.Fprintf(p.out, "Couldn't resolve %s: %v\n", answer, err)
+		default:
+			fmt.Fprintln(p.out, "Please enter one of the choices.")
+		}
+	}
+}
+
+// createAdminRoom creates an encrypted admin room and invites the operator.
+func (p *prompter) createAdminRoom(ctx context.Context, b *Bot) (id.RoomID, error) {
+	operator, err := p.ask("Your Matrix user ID (e.g. @alice:example.com)", "")
+	if err != nil {
+		return "", err
+	}
+	resp, err := b.client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Name:   "Bot admin",
+		Topic:  "Notices for the operators of " + b.client.UserID.String(),
+		Preset: "private_chat",
+		Invite: []id.UserID{id.UserID(operator)},
+		InitialState: []*event.Event{{
+			Type:    event.StateEncryption,
+			Content: event.Content{Parsed: &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}},
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to create admin room: %w", err)
+	}
+	fmt.Fprintf(p.out, "Created %s and invited %s.\n", resp.RoomID, operator)
+	return resp.RoomID, nil
+}
+
+// moduleDefaults returns the default config section of a module.
+func moduleDefaults(m Configurable) (map[string]any, error) {
+	data, err := yaml.Marshal(m.ModuleConfig())
+	if err != nil {
+		return nil, err
+	}
+	var section map[string]any
+	if err = yaml.Unmarshal(data, &section); err != nil {
+		return nil, err
+	}
+	return section, nil
+}
+
+// writeConfig writes a config file for LoadConfig, readable by the owner only
+// as it contains the access token.
+func writeConfig(path string, config Config) error {
+	file := newFileConfig(config)
+	var buf bytes.Buffer
+	buf.WriteString("# Written by the matrix bot setup. See -print-config for all settings.\n")
+	var err error
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		err = toml.NewEncoder(&buf).Encode(file)
+	} else {
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(file); err == nil {
+			err = encoder.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("matrix: failed to encode config: %w", err)
+	}
+	if err = os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("matrix: failed to write config: %w", err)
+	}
+	return nil
+}
+
+// prompter asks questions on a line-based input.
+type prompter struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal *os.File // Set if in is a terminal, to read passwords without echo
+}
+
+// readLine asks a question and returns the trimmed answer, which may be empty.
+func (p *prompter) readLine(question string) (string, error) {
+	fmt.Fprintf(p.out, "%s: ", question)
+	line, err := p.in.ReadString('\n')
+	if errors.Is(err, io.EOF) && line == "" {
+		return "", fmt.Errorf("matrix: setup cancelled")
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ask asks a question until it gets an answer; def (if not empty) is used for empty answers.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		question = fmt.Sprintf("%s [%s]", question, def)
+	}
+	for {
+		answer, err := p.readLine(question)
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			answer = def
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
+
+// password asks for a password, without echoing it on terminals.
+func (p *prompter) password(question string) (string, error) {
+	if p.terminal == nil {
+		return p.ask(question, "")
+	}
+	for {
+		fmt.Fprintf(p.out, "%s: ", question)
+		secret, err := term.ReadPassword(int(p.terminal.Fd()))
+		fmt.Fprintln(p.out)
+		if err != nil {
+			return "", err
+		}
+		if len(secret) > 0 {
+			return string(secret), nil
+		}
+	}
+}
+
+// confirm asks a yes/no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		answer, err := p.readLine(fmt.Sprintf("%s [%s]", question, choices))
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// SetupFlag defines a -setup flag on fs (flag.CommandLine if nil) and returns
+// a function to call after parsing the flags. If the flag was set, it runs
+// Setup on the terminal, writing the config file to path, and exits:
+//
+//	setup := matrix.SetupFlag(nil)
+//	flag.Parse()
+//	setup("config.yaml", digest, meet.New())
+func SetupFlag(fs *flag.FlagSet) func(path string, modules ...Module) {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	enabled := fs.Bool("setup", false, "interactively create the config file and exit")
+	return func(path string, modules ...Module) {
+		if !*enabled {
+			return
+		}
+		if err := Setup(context.Background(), path, os.Stdin, os.Stdout, modules...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
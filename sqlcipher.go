@@ -0,0 +1,88 @@
+//go:build cgo
+
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/dbutil/litestream"
+)
+
+// errNoSQLCipher is returned when Config.DatabaseKey is set but SQLite isn't SQLCipher.
+var errNoSQLCipher = errors.New("matrix: database key set, but go-sqlite3 isn't built with SQLCipher")
+
+// sqlcipherConnector opens SQLite connections unlocked with a passphrase.
+type sqlcipherConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+// Connect implements driver.Connector.
+func (c sqlcipherConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+// Driver implements driver.Connector.
+func (c sqlcipherConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openSQLCipher opens an SQLCipher database, encrypting a new one with key.
+// The connections get the same settings as the default sqlite3-fk-wal driver.
+func openSQLCipher(dsn, key string) (*dbutil.Database, error) {
+	sqlite := &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		// The key must be set before anything else reads the database.
+		if _, err := conn.Exec("PRAGMA key = '"+strings.ReplaceAll(key, "'", "''")+"'", nil); err != nil {
+			return err
+		}
+		if err := checkSQLCipher(conn); err != nil {
+			return err
+		}
+		for name, fn := range litestream.Functions {
+			if err := conn.RegisterFunc(name, fn, true); err != nil {
+				return err
+			}
+		}
+		for _, pragma := range []string{
+			"PRAGMA foreign_keys = ON",
+			"PRAGMA journal_mode = WAL",
+			"PRAGMA synchronous = NORMAL",
+			"PRAGMA busy_timeout = 5000",
+		} {
+			if _, err := conn.Exec(pragma, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	return dbutil.NewWithDB(sql.OpenDB(sqlcipherConnector{dsn: dsn, driver: sqlite}), "sqlite3-fk-wal")
+}
+
+// checkSQLCipher fails if SQLite isn't SQLCipher, where PRAGMA key is silently
+// ignored, or if the key doesn't decrypt the database.
+func checkSQLCipher(conn *sqlite3.SQLiteConn) error {
+	rows, err := conn.Query("PRAGMA cipher_version", nil)
+	if err != nil {
+		return err
+	}
+	version := make([]driver.Value, 1)
+	err = rows.Next(version)
+	_ = rows.Close()
+	if errors.Is(err, io.EOF) {
+		return errNoSQLCipher
+	} else if err != nil {
+		return err
+	}
+	if _, err = conn.Exec("SELECT count(*) FROM sqlite_master", nil); err != nil {
+		return fmt.Errorf("matrix: wrong database key or unencrypted database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package matrix
+
+import (
+	"errors"
+
+	"go.mau.fi/util/dbutil"
+)
+
+// openSQLCipher is unavailable without cgo, as SQLite itself is.
+func openSQLCipher(string, string) (*dbutil.Database, error) {
+	return nil, errors.New("matrix: SQLCipher requires cgo")
+}
@@ -0,0 +1,202 @@
+package matrix
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/sqlstatestore"
+)
+
+// pickleKey encrypts olm and megolm sessions in the crypto store.
+var pickleKey = []byte("meow")
+
+// ErrNoDatabase is returned by features that keep their data in SQL tables
+// (room settings, scheduled redactions and messages, secrets) when Config.Store
+// isn't an SQLStore.
+var ErrNoDatabase = errors.New("matrix: this feature requires an SQL store")
+
+// Store persists everything the bot needs across restarts: the sync token,
+// the room state required for encryption, the crypto material (olm account,
+// megolm sessions, device keys) and key-value data of the bot and its modules.
+//
+// SQLStore on Config.Database (or Config.DatabaseURI) is used by default. Set
+// Config.Store to back the bot with another persistence layer; MemoryStore
+// keeps everything in memory.
+type Store interface {
+	mautrix.SyncStore
+
+	// StateStore returns the store for room state (members, encryption settings).
+	StateStore() StateStore
+	// CryptoStore returns the store for the olm account, sessions and device keys.
+	CryptoStore() crypto.Store
+
+	// Get returns a value saved with Set, or "" if the key isn't set.
+	Get(ctx context.Context, key string) (string, error)
+	// Set saves a value. Keys should be namespaced, e.g. "mymodule.last_run".
+	Set(ctx context.Context, key, value string) error
+	// Delete removes a key.
+	Delete(ctx context.Context, key string) error
+}
+
+// StateStore is a room state store usable for both the client and encryption.
+type StateStore interface {
+	mautrix.StateStore
+	crypto.StateStore
+}
+
+// SQLStore is the default Store on an SQLite or PostgreSQL database. Its
+// database also holds the bot's own tables, so all features are available.
+type SQLStore struct {
+	DB *dbutil.Database
+
+	state  *sqlstatestore.SQLStateStore
+	crypto *crypto.SQLCryptoStore
+}
+
+// NewSQLStore creates a Store on an open database. Tables are created when the bot starts.
+func NewSQLStore(db *dbutil.Database) *SQLStore {
+	return &SQLStore{
+		DB:     db,
+		state:  sqlstatestore.NewSQLStateStore(db, db.Log, false),
+		crypto: crypto.NewSQLCryptoStore(db, db.Log, "", "", pickleKey),
+	}
+}
+
+// Upgrade creates or upgrades the tables of the store and the bot.
+func (s *SQLStore) Upgrade(ctx context.Context) error {
+	if err := s.state.Upgrade(ctx); err != nil {
+		return fmt.Errorf("matrix: failed to upgrade state store: %w", err)
+	}
+	if err := s.crypto.DB.Upgrade(ctx); err != nil {
+		return fmt.Errorf("matrix: failed to upgrade crypto store: %w", err)
+	}
+	for _, stmt := range schema {
+		if _, err := s.DB.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("matrix: failed to create tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// StateStore implements Store.
+func (s *SQLStore) StateStore() StateStore {
+	return s.state
+}
+
+// CryptoStore implements Store.
+func (s *SQLStore) CryptoStore() crypto.Store {
+	return s.crypto
+}
+
+// SaveFilterID implements mautrix.SyncStore.
+func (s *SQLStore) SaveFilterID(ctx context.Context, userID id.UserID, filterID string) error {
+	return s.crypto.SaveFilterID(ctx, userID, filterID)
+}
+
+// LoadFilterID implements mautrix.SyncStore.
+func (s *SQLStore) LoadFilterID(ctx context.Context, userID id.UserID) (string, error) {
+	return s.crypto.LoadFilterID(ctx, userID)
+}
+
+// SaveNextBatch implements mautrix.SyncStore.
+func (s *SQLStore) SaveNextBatch(ctx context.Context, userID id.UserID, nextBatchToken string) error {
+	return s.crypto.SaveNextBatch(ctx, userID, nextBatchToken)
+}
+
+// LoadNextBatch implements mautrix.SyncStore.
+func (s *SQLStore) LoadNextBatch(ctx context.Context, userID id.UserID) (string, error) {
+	return s.crypto.LoadNextBatch(ctx, userID)
+}
+
+// Get implements Store.
+func (s *SQLStore) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.DB.QueryRow(ctx, "SELECT value FROM bot_kv WHERE key=$1", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Set implements Store.
+func (s *SQLStore) Set(ctx context.Context, key, value string) error {
+	_, err := s.DB.Exec(ctx, `INSERT INTO bot_kv (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value=excluded.value`, key, value)
+	return err
+}
+
+// Delete implements Store.
+func (s *SQLStore) Delete(ctx context.Context, key string) error {
+	_, err := s.DB.Exec(ctx, "DELETE FROM bot_kv WHERE key=$1", key)
+	return err
+}
+
+// Close closes the database.
+func (s *SQLStore) Close() error {
+	return s.DB.Close()
+}
+
+// MemoryStore is a Store that keeps everything in memory, for tests and
+// short-lived bots. Encryption keys are lost when the process exits: a
+// password login creates a new device on every start, while with
+// Config.AccessToken the token's device is reused with new keys. Features that
+// need SQL tables return ErrNoDatabase.
+type MemoryStore struct {
+	*mautrix.MemorySyncStore
+
+	state  *mautrix.MemoryStateStore
+	crypto *crypto.MemoryStore
+
+	mu sync.RWMutex
+	kv map[string]string
+}
+
+// NewMemoryStore creates an empty in-memory Store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		MemorySyncStore: mautrix.NewMemorySyncStore(),
+		state:           mautrix.NewMemoryStateStore().(*mautrix.MemoryStateStore),
+		crypto:          crypto.NewMemoryStore(nil),
+		kv:              make(map[string]string),
+	}
+}
+
+// StateStore implements Store.
+func (s *MemoryStore) StateStore() StateStore {
+	return s.state
+}
+
+// CryptoStore implements Store.
+func (s *MemoryStore) CryptoStore() crypto.Store {
+	return s.crypto
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.kv[key], nil
+}
+
+// Set implements Store.
+func (s *MemoryStore) Set(_ context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv[key] = value
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.kv, key)
+	return nil
+}
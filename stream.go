@@ -0,0 +1,112 @@
+package matrix
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// streamEditInterval is how often a streamed message is edited at most.
+	streamEditInterval = time.Second
+	// streamCursor marks a streamed message as still growing.
+	streamCursor = " ▌"
+)
+
+// StreamReply posts text arriving in pieces, e.g. the tokens of an AI answer,
+// as a message that grows while they arrive: it is sent with the first piece
+// and edited at most once a second with the text so far, until tokens is
+// closed or ctx is cancelled. A final edit shows the complete text. Nothing is
+// sent if no text arrives. The text is markdown.
+func (b *Bot) StreamReply(ctx context.Context, roomID id.RoomID, tokens <-chan string) (id.EventID, error) {
+	return b.streamMessage(ctx, roomID, nil, tokens)
+}
+
+// StreamReply posts streamed text as a reply to the message, see Bot.StreamReply.
+func (m *MessageContext) StreamReply(ctx context.Context, tokens <-chan string) (id.EventID, error) {
+	if m.capture != nil {
+		var text strings.Builder
+		for {
+			select {
+			case token, ok := <-tokens:
+				if !ok {
+					m.capture.add(text.String())
+					return "", nil
+				}
+				text.WriteString(token)
+			case <-ctx.Done():
+				m.capture.add(text.String())
+				return "", ctx.Err()
+			}
+		}
+	}
+	return m.Bot.streamMessage(ctx, m.RoomID, m, tokens)
+}
+
+// streamMessage implements StreamReply, replying to msg if it isn't nil.
+func (b *Bot) streamMessage(ctx context.Context, roomID id.RoomID, msg *MessageContext, tokens <-chan string) (id.EventID, error) {
+	var (
+		text    strings.Builder
+		eventID id.EventID
+		shown   string // Text of the last edit
+		err     error
+	)
+	update := func(ctx context.Context, final bool) error {
+		md := text.String()
+		if md == "" || md == shown && !final {
+			return nil
+		}
+		if !final {
+			md += streamCursor
+		}
+		if eventID == "" {
+			content := &event.MessageEventContent{
+				MsgType:       event.MsgText,
+				Body:          md,
+				Format:        event.FormatHTML,
+				FormattedBody: MarkdownToHTML(md),
+			}
+			if msg != nil && msg.InThread() {
+				content.SetThread(msg.Event)
+				content.Mentions = &event.Mentions{UserIDs: []id.UserID{msg.Sender}}
+			} else if msg != nil {
+				content.SetReply(msg.Event)
+			}
+			// The event ID is needed for the edits, so the message bypasses the outbox
+			eventID, err = b.SendMessage(withoutOutbox(ctx), roomID, content)
+		} else {
+			err = b.EditMessage(ctx, roomID, eventID, md, MarkdownToHTML(md))
+		}
+		shown = text.String()
+		return err
+	}
+
+	ticker := time.NewTicker(streamEditInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case token, ok := <-tokens:
+			if !ok {
+				return eventID, update(ctx, true)
+			}
+			first := text.Len() == 0
+			text.WriteString(token)
+			if first {
+				if err = update(ctx, false); err != nil {
+					return eventID, err
+				}
+			}
+		case <-ticker.C:
+			if err = update(ctx, false); err != nil {
+				b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to update streamed message")
+			}
+		case <-ctx.Done():
+			// Show what arrived so far without the cursor
+			_ = update(context.WithoutCancel(ctx), true)
+			return eventID, ctx.Err()
+		}
+	}
+}
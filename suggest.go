@@ -0,0 +1,109 @@
+package matrix
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+)
+
+// maxSuggestions is the number of commands suggested for an unknown one.
+const maxSuggestions = 3
+
+// unknownCommand handles a command name that matches no command: with
+// Config.AutoCorrectCommands it returns the only command within one typo,
+// otherwise, with Config.SuggestCommands, it replies with the closest ones.
+func (b *Bot) unknownCommand(ctx context.Context, msg *MessageContext, name string) *Command {
+	if !b.config.SuggestCommands && !b.config.AutoCorrectCommands {
+		return nil
+	}
+	suggestions := b.similarCommands(ctx, msg, name)
+	if len(suggestions) == 0 {
+		return nil
+	}
+	if b.config.AutoCorrectCommands && suggestions[0].distance == 1 &&
+		(len(suggestions) == 1 || suggestions[1].distance > 1) {
+		msg.Log.Debug().Str("command", name).Str("corrected", suggestions[0].cmd.Name).Msg("Correcting mistyped command")
+		return suggestions[0].cmd
+	}
+	if !b.config.SuggestCommands {
+		return nil
+	}
+
+	prefix := b.CommandPrefixes(ctx, msg.RoomID)[0]
+	names := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		names[i] = "`" + prefix + s.name + "`"
+	}
+	language := b.Language(ctx, msg.RoomID)
+	reply := b.Translate(language, "command.suggest", prefix+name, b.joinOr(language, names))
+	if err := msg.Reply(ctx, reply); err != nil {
+		msg.Log.Warn().Err(err).Msg("Failed to suggest commands")
+	}
+	return nil
+}
+
+// suggestion is a command similar to a mistyped name.
+type suggestion struct {
+	cmd      *Command
+	name     string // The command name or alias that is similar
+	distance int
+}
+
+// similarCommands returns the commands the user can run whose name or an
+// alias is within a few typos of name, closest first.
+func (b *Bot) similarCommands(ctx context.Context, msg *MessageContext, name string) []suggestion {
+	maxDistance := max(1, len([]rune(name))/3)
+	var suggestions []suggestion
+	for _, cmd := range b.Commands() {
+		best := suggestion{cmd: cmd, distance: maxDistance + 1}
+		for _, candidate := range append([]string{cmd.Name}, cmd.Aliases...) {
+			if d := levenshtein(name, candidate); d < best.distance {
+				best.name, best.distance = candidate, d
+			}
+		}
+		if best.distance > maxDistance || b.commandMuted(ctx, msg.RoomID, cmd) || b.CanRun(ctx, cmd, msg.RoomID, msg.Sender) != nil {
+			continue
+		}
+		suggestions = append(suggestions, best)
+	}
+	slices.SortFunc(suggestions, func(a, b suggestion) int {
+		return cmp.Or(cmp.Compare(a.distance, b.distance), cmp.Compare(a.name, b.name))
+	})
+	return suggestions[:min(len(suggestions), maxSuggestions)]
+}
+
+// levenshtein returns the edit distance between two strings, counting a
+// swap of adjacent characters ("hlep") as one edit like a typo.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// joinOr joins items as "a, b or c" in a language.
+func (b *Bot) joinOr(language string, items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return b.Translate(language, "list.or", strings.Join(items[:len(items)-1], ", "), items[len(items)-1])
+}
@@ -0,0 +1,283 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SyncMode decides where syncing starts after a restart.
+type SyncMode string
+
+const (
+	// SyncResume continues from the last sync token saved in the store, so
+	// events sent while the bot was offline are seen (default). Whether old
+	// messages reach handlers is decided by Config.MessageCutoff.
+	SyncResume SyncMode = "resume"
+	// SyncLatest discards the saved token and starts at the current state,
+	// so messages sent while the bot was offline are ignored.
+	SyncLatest SyncMode = "latest"
+)
+
+// syncFilterKey is the store key of the sync filter the saved filter ID was created from.
+const syncFilterKey = "matrix.sync_filter"
+
+// DefaultSyncFilter returns the sync filter used when Config.SyncFilter is
+// nil. It keeps syncs small on accounts in large rooms: timelines are limited
+// to 50 events, members are loaded lazily (only the senders of the timeline
+// events are included) and presence is dropped.
+func DefaultSyncFilter() *mautrix.Filter {
+	return &mautrix.Filter{
+		Presence: &mautrix.FilterPart{NotTypes: []event.Type{event.EphemeralEventPresence}},
+		Room: &mautrix.RoomFilter{
+			State:    &mautrix.FilterPart{LazyLoadMembers: true},
+			Timeline: &mautrix.FilterPart{Limit: 50, LazyLoadMembers: true},
+		},
+	}
+}
+
+// syncFilter returns the configured or default sync filter.
+func (b *Bot) syncFilter() *mautrix.Filter {
+	if b.config.SyncFilter != nil {
+		return b.config.SyncFilter
+	}
+	return DefaultSyncFilter()
+}
+
+// lazyLoadsMembers reports whether a sync filter leaves out room members.
+func lazyLoadsMembers(filter *mautrix.Filter) bool {
+	room := filter.Room
+	return room != nil && (room.State != nil && room.State.LazyLoadMembers ||
+		room.Timeline != nil && room.Timeline.LazyLoadMembers)
+}
+
+// applySyncFilter sets the sync filter. The client reuses the filter saved on
+// the homeserver, so it is forgotten when the config changed.
+func (b *Bot) applySyncFilter(ctx context.Context) error {
+	filter := b.syncFilter()
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return fmt.Errorf("matrix: invalid sync filter: %w", err)
+	}
+	b.client.Syncer.(*botSyncer).FilterJSON = filter
+	saved, err := b.store.Get(ctx, syncFilterKey)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to load sync filter: %w", err)
+	}
+	if saved == string(data) {
+		return nil
+	}
+	if err = b.store.SaveFilterID(ctx, b.client.UserID, ""); err != nil {
+		return fmt.Errorf("matrix: failed to reset sync filter: %w", err)
+	}
+	if err = b.store.Set(ctx, syncFilterKey, string(data)); err != nil {
+		return fmt.Errorf("matrix: failed to save sync filter: %w", err)
+	}
+	if saved != "" {
+		b.log.Info().Msg("Sync filter changed, creating a new one")
+	}
+	return nil
+}
+
+// loadMembers fetches the full member list of an encrypted room before the
+// bot sends to it, if the sync filter loads members lazily: the room keys are
+// shared with the members known to the state store, and lazy loading only
+// tells it about those who spoke recently. Later membership changes arrive
+// with the sync, so the list is fetched once per room.
+func (b *Bot) loadMembers(ctx context.Context, roomID id.RoomID) {
+	if b.crypto == nil || !lazyLoadsMembers(b.syncFilter()) {
+		return
+	}
+	stateStore := b.client.StateStore
+	if encrypted, err := stateStore.IsEncrypted(ctx, roomID); err != nil || !encrypted {
+		return
+	}
+	if fetched, err := stateStore.HasFetchedMembers(ctx, roomID); err != nil || fetched {
+		return
+	}
+	if _, err := b.client.Members(ctx, roomID); err != nil {
+		b.log.Warn().Err(err).Str("room_id", roomID.String()).Msg("Failed to load room members")
+	}
+}
+
+// prepareSync applies Config.SyncFilter, and Config.SyncMode to the saved
+// sync token, before syncing starts.
+func (b *Bot) prepareSync(ctx context.Context) error {
+	if err := b.applySyncFilter(ctx); err != nil {
+		return err
+	}
+	token, err := b.store.LoadNextBatch(ctx, b.client.UserID)
+	if err != nil {
+		return fmt.Errorf("matrix: failed to load sync token: %w", err)
+	}
+	switch {
+	case token == "":
+		b.log.Info().Msg("No saved sync token, starting with an initial sync")
+	case b.config.SyncMode == SyncLatest:
+		if err = b.store.SaveNextBatch(ctx, b.client.UserID, ""); err != nil {
+			return fmt.Errorf("matrix: failed to reset sync token: %w", err)
+		}
+		b.log.Info().Msg("Skipping events sent while the bot was offline")
+	default:
+		b.log.Info().Str("since", token).Msg("Resuming sync from saved token")
+	}
+	return nil
+}
+
+// trackInitialSync marks initial syncs, whose timelines contain old history
+// rather than new messages. It runs before the events of a sync are dispatched.
+func (b *Bot) trackInitialSync(_ context.Context, _ *mautrix.RespSync, since string) bool {
+	b.initialSync.Store(since == "")
+	return true
+}
+
+// isBacklog reports whether an event was sent too long ago to act on, see Config.MessageCutoff.
+func (b *Bot) isBacklog(evt *event.Event) bool {
+	sentAt := time.UnixMilli(evt.Timestamp)
+	switch cutoff := b.config.MessageCutoff; {
+	case cutoff < 0:
+		return false
+	case cutoff == 0:
+		return sentAt.Before(b.startedAt.Add(-b.clockSkewTolerance()))
+	default:
+		return time.Since(sentAt) > cutoff+b.clockSkewTolerance()
+	}
+}
+
+// DefaultClockSkewTolerance is used when Config.ClockSkewTolerance is zero.
+const DefaultClockSkewTolerance = 5 * time.Minute
+
+// clockSkewTolerance returns the configured or default clock skew tolerance.
+func (b *Bot) clockSkewTolerance() time.Duration {
+	if b.config.ClockSkewTolerance > 0 {
+		return b.config.ClockSkewTolerance
+	}
+	return DefaultClockSkewTolerance
+}
+
+// DefaultSyncStallTimeout is used when Config.SyncStallTimeout is zero.
+const DefaultSyncStallTimeout = 5 * time.Minute
+
+// MinSyncStallTimeout is the shortest Config.SyncStallTimeout. Idle sync
+// requests are held by the homeserver for 30 seconds, so shorter timeouts
+// would restart healthy sync loops.
+const MinSyncStallTimeout = time.Minute
+
+// SyncStallHandler is called when the sync watchdog restarts a stalled sync loop.
+type SyncStallHandler func(ctx context.Context, stalledFor time.Duration)
+
+// SyncStats describes the health of the sync loop, for monitoring.
+type SyncStats struct {
+	LastSync time.Time // When the last sync response was processed
+	Restarts int       // Sync loops restarted by the watchdog since start
+}
+
+// OnSyncStall registers a handler that is called when the watchdog restarts a
+// stalled sync loop, e.g. to alert an admin room or bump a metric.
+func (b *Bot) OnSyncStall(handler SyncStallHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stallHandlers = append(b.stallHandlers, handler)
+}
+
+// SyncStats returns the sync loop health.
+func (b *Bot) SyncStats() SyncStats {
+	return SyncStats{
+		LastSync: time.Unix(0, b.lastSync.Load()),
+		Restarts: int(b.syncRestarts.Load()),
+	}
+}
+
+// markSynced records that a sync response arrived, for the watchdog.
+func (b *Bot) markSynced(_ context.Context, _ *mautrix.RespSync, _ string) bool {
+	b.lastSync.Store(time.Now().UnixNano())
+	b.syncCount.Add(1)
+	return true
+}
+
+// waitFirstSync blocks until the events of the first sync response have been
+// processed, so that the room state is known. It returns false if ctx ends first.
+func (b *Bot) waitFirstSync(ctx context.Context) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	// OnSync handlers run before the events of a response are dispatched,
+	// so the first response is done once the second one arrives.
+	for b.syncCount.Load() < 2 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// runSync syncs until ctx is cancelled. The watchdog cancels a single sync
+// loop through restartSync when it stalls, after which syncing starts over
+// from the saved token on a fresh connection.
+func (b *Bot) runSync(ctx context.Context) {
+	for {
+		loopCtx, cancel := context.WithCancel(ctx)
+		b.mu.Lock()
+		b.restartSync = cancel
+		b.mu.Unlock()
+		b.lastSync.Store(time.Now().UnixNano())
+
+		err := b.client.SyncWithContext(loopCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			b.log.Error().Err(err).Msg("Sync error")
+			return
+		}
+		// Drop connections that may be wedged, e.g. a broken HTTP/2 connection.
+		b.client.Client.CloseIdleConnections()
+		b.log.Info().Msg("Restarting sync loop")
+	}
+}
+
+// runSyncWatchdog restarts the sync loop when no sync response has been
+// processed for Config.SyncStallTimeout, until ctx, the context of the sync
+// loop, is cancelled.
+func (b *Bot) runSyncWatchdog(ctx context.Context) {
+	timeout := b.config.SyncStallTimeout
+	if timeout == 0 {
+		timeout = DefaultSyncStallTimeout
+	}
+	timeout = max(timeout, MinSyncStallTimeout)
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stalledFor := time.Since(time.Unix(0, b.lastSync.Load()))
+		if stalledFor < timeout {
+			continue
+		}
+
+		b.log.Error().Dur("stalled_for", stalledFor).Msg("Sync loop stalled, restarting it")
+		b.syncRestarts.Add(1)
+		b.mu.Lock()
+		restart := b.restartSync
+		handlers := append([]SyncStallHandler(nil), b.stallHandlers...)
+		b.mu.Unlock()
+		b.lastSync.Store(time.Now().UnixNano()) // Give the new loop a full timeout
+		if restart != nil {
+			restart()
+		}
+		for _, handler := range handlers {
+			handler(ctx, stalledFor)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package matrix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateSyncStallTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		timeout time.Duration
+		valid   bool
+	}{
+		{0, true},
+		{-1, true},
+		{MinSyncStallTimeout, true},
+		{time.Nanosecond, false},
+		{3 * time.Second, false},
+	} {
+		config := Config{Homeserver: "https://matrix.example.com", AccessToken: "token", SyncStallTimeout: tt.timeout}
+		if err := config.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate() with SyncStallTimeout %s = %v, want valid %v", tt.timeout, err, tt.valid)
+		}
+	}
+}
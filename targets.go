@@ -0,0 +1,203 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eslider/go-matrix-bot/email"
+	"maunium.net/go/mautrix/id"
+)
+
+// Report is a piece of content delivered to one or more targets,
+// e.g. a weekly analytics summary.
+type Report struct {
+	Title    string
+	Markdown string
+	Data     any // Optional structured payload, included in webhook deliveries
+}
+
+// ReportFunc builds a report on demand. Returning a nil report skips delivery.
+type ReportFunc func(ctx context.Context) (*Report, error)
+
+// Target is a destination for reports: a Matrix room, a webhook, an email address, ...
+type Target interface {
+	Deliver(ctx context.Context, b *Bot, report *Report) error
+}
+
+// ResolveRoom returns the ID of a room given by ID or alias (#room:server).
+func (b *Bot) ResolveRoom(ctx context.Context, room string) (id.RoomID, error) {
+	if !strings.HasPrefix(room, "#") {
+		return id.RoomID(room), nil
+	}
+	resp, err := b.client.ResolveAlias(ctx, id.RoomAlias(room))
+	if err != nil {
+		return "", fmt.Errorf("matrix: failed to resolve %s: %w", room, err)
+	}
+	return resp.RoomID, nil
+}
+
+// RoomTarget posts reports into a Matrix room, given by ID or alias (#room:server).
+type RoomTarget id.RoomID
+
+// Deliver implements Target.
+func (t RoomTarget) Deliver(ctx context.Context, b *Bot, report *Report) error {
+	roomID, err := b.ResolveRoom(ctx, string(t))
+	if err != nil {
+		return err
+	}
+	md := reportMarkdown(report)
+	return b.SendHTML(ctx, roomID, md, MarkdownToHTML(md))
+}
+
+// UserTarget sends reports to a user in a direct message, see Bot.EnsureDM.
+type UserTarget id.UserID
+
+// Deliver implements Target.
+func (t UserTarget) Deliver(ctx context.Context, b *Bot, report *Report) error {
+	roomID, err := b.EnsureDM(ctx, id.UserID(t))
+	if err != nil {
+		return err
+	}
+	md := reportMarkdown(report)
+	return b.SendHTML(ctx, roomID, md, MarkdownToHTML(md))
+}
+
+// reportMarkdown renders a report for a Matrix message, with the title in bold.
+func reportMarkdown(report *Report) string {
+	if report.Title == "" {
+		return report.Markdown
+	}
+	return "**" + report.Title + "**\n\n" + report.Markdown
+}
+
+// WebhookTarget POSTs reports as JSON to an HTTP endpoint, e.g. for archiving:
+//
+//	{"title": "...", "markdown": "...", "html": "...", "data": ..., "sent_at": "..."}
+type WebhookTarget struct {
+	URL     string
+	Headers map[string]string // e.g. Authorization
+}
+
+// Deliver implements Target.
+func (t WebhookTarget) Deliver(ctx context.Context, b *Bot, report *Report) error {
+	payload, err := json.Marshal(map[string]any{
+		"title":    report.Title,
+		"markdown": report.Markdown,
+		"html":     MarkdownToHTML(report.Markdown),
+		"data":     report.Data,
+		"sent_at":  time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("matrix: failed to encode report: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("matrix: invalid webhook target: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := b.HTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("matrix: webhook delivery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("matrix: webhook delivery failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailTarget mails reports through an email.Sender.
+type EmailTarget struct {
+	Sender email.Sender
+	To     []string
+}
+
+// Deliver implements Target.
+func (t EmailTarget) Deliver(ctx context.Context, _ *Bot, report *Report) error {
+	return t.Sender.Send(ctx, &email.Message{
+		To:      t.To,
+		Subject: report.Title,
+		Text:    report.Markdown,
+		HTML:    MarkdownToHTML(report.Markdown),
+	})
+}
+
+// Deliver sends a report to all targets. Delivery continues past failing
+// targets; the returned error joins all failures.
+func (b *Bot) Deliver(ctx context.Context, report *Report, targets ...Target) error {
+	var errs []error
+	for _, target := range targets {
+		if err := target.Deliver(ctx, b, report); err != nil {
+			b.ReportError(ctx, fmt.Sprintf("Delivery of report %q failed", report.Title), err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ScheduleReport builds and delivers a report every interval while the bot is running,
+// e.g. a weekly room analytics report posted to Matrix and archived via a webhook.
+// Must be called before Run. The interval must be positive.
+func (b *Bot) ScheduleReport(interval time.Duration, build ReportFunc, targets ...Target) error {
+	if interval <= 0 {
+		return fmt.Errorf("matrix: invalid report interval %s", interval)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reports = append(b.reports, scheduledReport{interval: interval, build: build, targets: targets})
+	return nil
+}
+
+type scheduledReport struct {
+	interval time.Duration
+	build    ReportFunc
+	targets  []Target
+}
+
+// runReports starts the loops of all scheduled reports.
+func (b *Bot) runReports(ctx context.Context) {
+	b.mu.RLock()
+	reports := append([]scheduledReport(nil), b.reports...)
+	b.mu.RUnlock()
+
+	for _, r := range reports {
+		r := r
+		b.goBackground(func() {
+			ticker := time.NewTicker(r.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				report, err := r.build(ctx)
+				if err != nil {
+					b.ReportError(ctx, "Building a scheduled report failed", err)
+					continue
+				}
+				if report != nil {
+					_ = b.Deliver(ctx, report, r.targets...)
+				}
+			}
+		})
+	}
+}
+
+// HTTPClient returns the HTTP client for outbound non-Matrix requests, e.g.
+// of modules calling APIs. It is the same client as for the homeserver, see
+// Config.HTTPClient.
+func (b *Bot) HTTPClient() *http.Client {
+	return b.http
+}
@@ -0,0 +1,23 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduleReportInterval(t *testing.T) {
+	b := &Bot{}
+	build := func(ctx context.Context) (*Report, error) { return nil, nil }
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		if err := b.ScheduleReport(interval, build); err == nil {
+			t.Errorf("ScheduleReport(%s) = nil, want an error", interval)
+		}
+	}
+	if err := b.ScheduleReport(time.Hour, build); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.reports) != 1 {
+		t.Errorf("%d reports scheduled, want 1", len(b.reports))
+	}
+}
@@ -0,0 +1,79 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// timezonesAccountDataType stores per-user time zone preferences in the bot's account data,
+// so they survive restarts without a separate database.
+const timezonesAccountDataType = "com.github.eslider.matrix-bot.timezones"
+
+// UserTimezone returns the preferred time zone of a user, or time.Local if none is set.
+func (b *Bot) UserTimezone(ctx context.Context, userID id.UserID) *time.Location {
+	if err := b.loadTimezones(ctx); err != nil {
+		b.log.Warn().Err(err).Msg("Failed to load time zone preferences")
+	}
+	b.mu.RLock()
+	name := b.timezones[userID]
+	b.mu.RUnlock()
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// SetUserTimezone stores the preferred time zone of a user, given as an IANA name like "Europe/Berlin".
+func (b *Bot) SetUserTimezone(ctx context.Context, userID id.UserID, name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("matrix: unknown time zone %q", name)
+	}
+	if err := b.loadTimezones(ctx); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.timezones[userID] = name
+	snapshot := make(map[id.UserID]string, len(b.timezones))
+	for k, v := range b.timezones {
+		snapshot[k] = v
+	}
+	b.mu.Unlock()
+
+	if err := b.client.SetAccountData(ctx, timezonesAccountDataType, snapshot); err != nil {
+		return fmt.Errorf("matrix: failed to save time zone preferences: %w", err)
+	}
+	return nil
+}
+
+// loadTimezones fetches the preferences from account data once.
+func (b *Bot) loadTimezones(ctx context.Context) error {
+	b.mu.RLock()
+	loaded := b.timezones != nil
+	b.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	prefs := make(map[id.UserID]string)
+	err := b.client.GetAccountData(ctx, timezonesAccountDataType, &prefs)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.timezones == nil {
+		b.timezones = prefs
+	}
+	b.mu.Unlock()
+	return nil
+}
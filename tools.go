@@ -0,0 +1,115 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AsTool lets language models run the command, e.g. the ai module when a
+// model calls it as a tool, see Bot.RunCommand. The description and usage are
+// all the model knows about the command, so make them precise.
+func (c *Command) AsTool() *Command {
+	c.Tool = true
+	return c
+}
+
+// ToolCommands returns the commands marked with AsTool, sorted by name.
+func (b *Bot) ToolCommands() []*Command {
+	var tools []*Command
+	for _, cmd := range b.Commands() {
+		if cmd.Tool {
+			tools = append(tools, cmd)
+		}
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// RunCommand runs a command on behalf of the sender of msg, as if they had
+// typed it, and returns its replies instead of sending them, e.g. to feed the
+// result of a tool call back to a language model. Only commands marked with
+// AsTool can be run. The command must be available to the sender as usual:
+// muted, disabled and denied commands fail with an error wrapping
+// ErrAccessDenied, rate limits apply.
+//
+// Replies, reactions and streamed replies through the CommandContext are
+// captured; messages the handler sends to rooms otherwise are sent as usual.
+func (b *Bot) RunCommand(ctx context.Context, msg *MessageContext, name, args string) (string, error) {
+	cmd := b.resolveCommand(ctx, msg.RoomID, strings.ToLower(name))
+	if cmd == nil || !cmd.Tool {
+		return "", fmt.Errorf("matrix: unknown command %q", name)
+	}
+	if b.commandMuted(ctx, msg.RoomID, cmd) {
+		return "", fmt.Errorf("%w: %s is muted in this room", ErrAccessDenied, cmd.Name)
+	}
+	if err := b.CanRun(ctx, cmd, msg.RoomID, msg.Sender); err != nil {
+		return "", err
+	}
+	if !slices.Contains(b.config.Admins, msg.Sender) {
+		if wait, _ := b.cooldowns.take(cmd.Name, b.commandLimit(cmd), msg.RoomID, msg.Sender, time.Now()); wait > 0 {
+			return "", fmt.Errorf("matrix: %s is rate-limited, try again in %s", cmd.Name, formatWait(wait))
+		}
+	}
+
+	msg.Log.Debug().Str("command", cmd.Name).Msg("Running command as a tool")
+	b.audit(ctx, AuditEntry{
+		Direction: AuditInbound,
+		RoomID:    msg.RoomID,
+		EventID:   msg.EventID(),
+		Sender:    msg.Sender,
+		Command:   cmd.Name,
+		Body:      strings.TrimSpace(b.commandPrefix() + cmd.Name + " " + args),
+		Time:      time.Now(),
+	})
+	run := *msg
+	run.capture = &capturedOutput{}
+	timeout := b.config.HandlerTimeout
+	if cmd.Timeout != 0 {
+		timeout = cmd.Timeout
+	}
+	b.timedHandle(ctx, &run, timeout, true, func(ctx context.Context, msg *MessageContext) {
+		cmd.Handler(ctx, &CommandContext{
+			MessageContext: msg,
+			Name:           cmd.Name,
+			Args:           args,
+			Command:        cmd,
+		})
+	})
+	return run.capture.String(), nil
+}
+
+// capturedOutput collects the replies of a command run by RunCommand.
+type capturedOutput struct {
+	mu    sync.Mutex
+	parts []string
+}
+
+// add appends a reply.
+func (c *capturedOutput) add(md string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parts = append(c.parts, md)
+}
+
+// replaceLast replaces the last reply, e.g. for an edit, or appends one.
+func (c *capturedOutput) replaceLast(md string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.parts) == 0 {
+		c.parts = append(c.parts, md)
+	} else {
+		c.parts[len(c.parts)-1] = md
+	}
+}
+
+// String returns the replies so far, separated by blank lines.
+func (c *capturedOutput) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strings.Join(c.parts, "\n\n")
+}
@@ -0,0 +1,125 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// topicSeparator separates the sections of a structured room topic.
+const topicSeparator = " | "
+
+// topicSection is one "|"-separated part of a room topic. Parts without a
+// key are free text written by humans and are kept as they are.
+type topicSection struct {
+	raw   string
+	key   string
+	sep   string // ": " or "=", as written
+	value string
+}
+
+// parseTopicSections splits a topic like "On-call: @alice | Next release: Friday".
+func parseTopicSections(topic string) []topicSection {
+	var sections []topicSection
+	for _, part := range strings.Split(topic, "|") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		section := topicSection{raw: part}
+		colon, equals := strings.Index(part, ":"), strings.Index(part, "=")
+		switch {
+		case colon > 0 && (equals < 0 || colon < equals):
+			section.key, section.sep, section.value = part[:colon], ": ", part[colon+1:]
+		case equals > 0:
+			section.key, section.sep, section.value = part[:equals], "=", part[equals+1:]
+		}
+		section.key = strings.TrimSpace(section.key)
+		section.value = strings.TrimSpace(section.value)
+		if strings.HasPrefix(section.value, "//") {
+			section = topicSection{raw: part} // Free text with a URL, e.g. "See https://..."
+		}
+		sections = append(sections, section)
+	}
+	return sections
+}
+
+// ParseTopic extracts the key/value sections of a room topic, e.g.
+// "On-call: @alice | Next release: Friday | build=green" yields the keys
+// "On-call", "Next release" and "build". Free-text sections are ignored.
+func ParseTopic(topic string) map[string]string {
+	values := make(map[string]string)
+	for _, section := range parseTopicSections(topic) {
+		if section.key != "" {
+			values[section.key] = section.value
+		}
+	}
+	return values
+}
+
+// RoomTopic returns the current topic of a room, read from the homeserver
+// rather than the sync cache so that recent human edits are included.
+func (b *Bot) RoomTopic(ctx context.Context, roomID id.RoomID) (string, error) {
+	var content event.TopicEventContent
+	err := b.client.StateEvent(ctx, roomID, event.StateTopic, "", &content)
+	if errors.Is(err, mautrix.MNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("matrix: failed to get room topic: %w", err)
+	}
+	return content.Topic, nil
+}
+
+// TopicValues returns the key/value sections of a room's topic, see ParseTopic.
+func (b *Bot) TopicValues(ctx context.Context, roomID id.RoomID) (map[string]string, error) {
+	topic, err := b.RoomTopic(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTopic(topic), nil
+}
+
+// SetTopicValue updates one section of a room's topic, e.g. "On-call", and
+// leaves everything else - free text and sections edited by humans - as it
+// is. The key is matched case-insensitively; a missing section is appended
+// and an empty value removes it. The topic is re-read right before writing
+// and only sent if the value actually changes.
+func (b *Bot) SetTopicValue(ctx context.Context, roomID id.RoomID, key, value string) error {
+	topic, err := b.RoomTopic(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	sections := parseTopicSections(topic)
+	parts := make([]string, 0, len(sections)+1)
+	found, changed := false, false
+	for _, section := range sections {
+		if section.key == "" || !strings.EqualFold(section.key, key) {
+			parts = append(parts, section.raw)
+			continue
+		}
+		found = true
+		changed = changed || section.value != value
+		if value != "" {
+			parts = append(parts, section.key+section.sep+value)
+		}
+	}
+	if !found && value != "" {
+		parts = append(parts, key+": "+value)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	updated := strings.Join(parts, topicSeparator)
+	_, err = b.client.SendStateEvent(ctx, roomID, event.StateTopic, "", &event.TopicEventContent{Topic: updated})
+	if err != nil {
+		return fmt.Errorf("matrix: failed to update room topic: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package matrix
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseWhen parses a human time expression at the start of fields, relative to now
+// and in now's time zone. It returns the time and the number of fields consumed.
+//
+// Supported forms:
+//
+//	in 2h | in 1h30m                  relative duration
+//	today | tomorrow | monday | mon   day, optionally followed by HH:MM
+//	2026-03-01                        date, optionally followed by HH:MM
+//	15:00                             today, or tomorrow if already past
+//
+// Days without a time default to 09:00.
+func ParseWhen(fields []string, now time.Time) (time.Time, int, error) {
+	if len(fields) == 0 {
+		return time.Time{}, 0, fmt.Errorf("missing time")
+	}
+	first := strings.ToLower(fields[0])
+
+	if first == "in" {
+		if len(fields) < 2 {
+			return time.Time{}, 0, fmt.Errorf("missing duration after \"in\"")
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return time.Time{}, 0, fmt.Errorf("invalid duration %q", fields[1])
+		}
+		return now.Add(d), 2, nil
+	}
+
+	if hour, minute, ok := parseClock(first); ok {
+		t := atClock(now, hour, minute)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, 1, nil
+	}
+
+	day, ok := parseDay(first, now)
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("unrecognized time %q", fields[0])
+	}
+	if len(fields) > 1 {
+		if hour, minute, ok := parseClock(fields[1]); ok {
+			return atClock(day, hour, minute), 2, nil
+		}
+	}
+	return atClock(day, 9, 0), 1, nil
+}
+
+// parseDay resolves a day word or ISO date to midnight of that day.
+func parseDay(word string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch word {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", word, now.Location()); err == nil {
+		return t, true
+	}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := strings.ToLower(wd.String())
+		if word == name || word == name[:3] {
+			ahead := (int(wd) - int(now.Weekday()) + 7) % 7
+			if ahead == 0 {
+				ahead = 7 // "monday" on a Monday means next week
+			}
+			return today.AddDate(0, 0, ahead), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseClock parses "15:00" or "9:30".
+func parseClock(s string) (hour, minute int, ok bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, false
+	}
+	return t.Hour(), t.Minute(), true
+}
+
+func atClock(day time.Time, hour, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
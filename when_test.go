@@ -0,0 +1,52 @@
+package matrix
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, berlin) // A Wednesday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, berlin)
+	}
+	tests := []struct {
+		input string
+		want  time.Time
+		n     int
+	}{
+		{"in 2h rest", now.Add(2 * time.Hour), 2},
+		{"in 1h30m", now.Add(90 * time.Minute), 2},
+		{"15:00 review", at(14, 15, 0), 1},
+		{"9:30", at(15, 9, 30), 1}, // Already past today
+		{"10:30", at(15, 10, 30), 1},
+		{"today", at(14, 9, 0), 1},
+		{"Tomorrow 14:15 sync", at(15, 14, 15), 2},
+		{"friday", at(16, 9, 0), 1},
+		{"mon 8:00", at(19, 8, 0), 2},
+		{"wednesday", at(21, 9, 0), 1}, // Today's weekday means next week
+		{"2026-11-02 16:45", time.Date(2026, 11, 2, 16, 45, 0, 0, berlin), 2},
+		{"2026-11-02 standup", time.Date(2026, 11, 2, 9, 0, 0, 0, berlin), 1},
+	}
+	for _, tt := range tests {
+		got, n, err := ParseWhen(strings.Fields(tt.input), now)
+		if err != nil {
+			t.Errorf("ParseWhen(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.want) || n != tt.n {
+			t.Errorf("ParseWhen(%q) = %s, %d; want %s, %d", tt.input, got, n, tt.want, tt.n)
+		}
+	}
+
+	for _, input := range []string{"", "in", "in -1h", "in soon", "someday", "25:00", "2026-13-01"} {
+		if got, _, err := ParseWhen(strings.Fields(input), now); err == nil {
+			t.Errorf("ParseWhen(%q) = %s, want error", input, got)
+		}
+	}
+}